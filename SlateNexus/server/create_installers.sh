@@ -25,6 +25,10 @@ Write-Host "Enter the server FQDN: "
 \$remotelyUrl = "https://remotely." + \$serverUrl
 \$serverUrl = "https://api." + \$serverUrl
 
+# Prompt for the client enrollment key (leave empty for the default client)
+Write-Host "Enter the client enrollment key: "
+\$enrollmentKey = Read-Host
+
 # Create the directory structure
 \$installPath = "C:\Program Files\SlateNexus"
 \$remotelyPath = "\$installPath\Remotely"
@@ -43,6 +47,7 @@ Set-Location \$installPath
     "server_url" = "\$serverUrl"
     "host_id" = 0
     "api_key" = "$API_KEY"
+    "enrollment_key" = "\$enrollmentKey"
 }
 
 # Convert to JSON and save to file using UTF-8
@@ -0,0 +1,39 @@
+-- Create the organizations (clients) Table
+CREATE TABLE IF NOT EXISTS organizations (
+    org_id SERIAL PRIMARY KEY,
+    org_name VARCHAR(255) UNIQUE NOT NULL,
+    enrollment_key VARCHAR(64) UNIQUE NOT NULL,
+    settings JSONB NOT NULL DEFAULT '{}'
+);
+
+-- Create the sites Table
+CREATE TABLE IF NOT EXISTS sites (
+    site_id SERIAL PRIMARY KEY,
+    org_id INT NOT NULL,
+    site_name VARCHAR(255) NOT NULL,
+    settings JSONB NOT NULL DEFAULT '{}',
+    UNIQUE (org_id, site_name),
+    FOREIGN KEY (org_id) REFERENCES organizations(org_id)
+);
+
+-- Seed the default organization and site for existing hosts and unkeyed agents
+INSERT INTO organizations (org_name, enrollment_key)
+SELECT 'Default', md5(random()::text)
+WHERE NOT EXISTS (SELECT 1 FROM organizations);
+
+INSERT INTO sites (org_id, site_name)
+SELECT MIN(org_id), 'Default Site' FROM organizations
+WHERE NOT EXISTS (SELECT 1 FROM sites);
+
+-- Every host belongs to exactly one site
+ALTER TABLE agents ADD COLUMN IF NOT EXISTS site_id INT REFERENCES sites(site_id);
+UPDATE agents SET site_id = (SELECT MIN(site_id) FROM sites) WHERE site_id IS NULL;
+ALTER TABLE agents ALTER COLUMN site_id SET NOT NULL;
+
+-- Groups belong to a site and their names are unique within it
+ALTER TABLE device_groups ADD COLUMN IF NOT EXISTS site_id INT REFERENCES sites(site_id);
+UPDATE device_groups SET site_id = (SELECT MIN(site_id) FROM sites) WHERE site_id IS NULL;
+ALTER TABLE device_groups ALTER COLUMN site_id SET NOT NULL;
+ALTER TABLE device_groups DROP CONSTRAINT IF EXISTS device_groups_group_name_key;
+ALTER TABLE device_groups DROP CONSTRAINT IF EXISTS device_groups_site_id_group_name_key;
+ALTER TABLE device_groups ADD CONSTRAINT device_groups_site_id_group_name_key UNIQUE (site_id, group_name);
@@ -0,0 +1,32 @@
+-- Create the saved scripts Table
+CREATE TABLE IF NOT EXISTS scripts (
+    script_id SERIAL PRIMARY KEY,
+    script_name VARCHAR(255) UNIQUE NOT NULL,
+    shell VARCHAR(20) NOT NULL DEFAULT 'powershell',
+    content TEXT NOT NULL
+);
+
+-- Create the agent_jobs Table holding work queued for agents
+CREATE TABLE IF NOT EXISTS agent_jobs (
+    job_id SERIAL PRIMARY KEY,
+    host_id INT NOT NULL,
+    job_type VARCHAR(50) NOT NULL,
+    payload JSONB NOT NULL DEFAULT '{}',
+    status VARCHAR(20) NOT NULL DEFAULT 'pending',
+    exit_code INT,
+    output TEXT,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (host_id) REFERENCES agents(host_id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS agent_jobs_host_status_idx ON agent_jobs (host_id, status);
+
+-- Create the agent_tags Table
+CREATE TABLE IF NOT EXISTS agent_tags (
+    host_id INT NOT NULL,
+    tag_key VARCHAR(255) NOT NULL,
+    tag_value VARCHAR(255) NOT NULL DEFAULT '',
+    PRIMARY KEY (host_id, tag_key),
+    FOREIGN KEY (host_id) REFERENCES agents(host_id) ON DELETE CASCADE
+);
@@ -0,0 +1,28 @@
+-- Create the custom_field_definitions Table for admin-defined device fields
+CREATE TABLE IF NOT EXISTS custom_field_definitions (
+    field_id SERIAL PRIMARY KEY,
+    field_key VARCHAR(100) UNIQUE NOT NULL,
+    label VARCHAR(255) NOT NULL,
+    field_type VARCHAR(20) NOT NULL CHECK (field_type IN ('text', 'number', 'date', 'enum', 'boolean')),
+    options JSONB NOT NULL DEFAULT '[]'
+);
+
+-- Create the agent_custom_fields Table holding the values set on each device
+CREATE TABLE IF NOT EXISTS agent_custom_fields (
+    host_id INT NOT NULL,
+    field_id INT NOT NULL,
+    field_value TEXT NOT NULL,
+    PRIMARY KEY (host_id, field_id),
+    FOREIGN KEY (host_id) REFERENCES agents(host_id) ON DELETE CASCADE,
+    FOREIGN KEY (field_id) REFERENCES custom_field_definitions(field_id) ON DELETE CASCADE
+);
+
+-- Seed the fields Nexus does not collect itself
+INSERT INTO custom_field_definitions (field_key, label, field_type, options) VALUES
+('asset_tag', 'Asset Tag', 'text', '[]'),
+('purchase_date', 'Purchase Date', 'date', '[]'),
+('warranty_end', 'Warranty End', 'date', '[]'),
+('location', 'Location', 'text', '[]'),
+('owner', 'Owner', 'text', '[]'),
+('contract_level', 'Contract Level', 'enum', '["Bronze", "Silver", "Gold"]')
+ON CONFLICT (field_key) DO NOTHING;
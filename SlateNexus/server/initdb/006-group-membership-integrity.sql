@@ -0,0 +1,8 @@
+-- Memberships go away with their host; deleting a group that still has members is refused
+ALTER TABLE device_group_members DROP CONSTRAINT IF EXISTS device_group_members_host_id_fkey;
+ALTER TABLE device_group_members ADD CONSTRAINT device_group_members_host_id_fkey
+    FOREIGN KEY (host_id) REFERENCES agents(host_id) ON DELETE CASCADE;
+
+ALTER TABLE device_group_members DROP CONSTRAINT IF EXISTS device_group_members_group_id_fkey;
+ALTER TABLE device_group_members ADD CONSTRAINT device_group_members_group_id_fkey
+    FOREIGN KEY (group_id) REFERENCES device_groups(group_id) ON DELETE RESTRICT;
@@ -0,0 +1,3 @@
+-- Agent protocol version last used by each host. Agents that predate the
+-- X-Nexus-Agent-Protocol header speak version 1.
+ALTER TABLE agents ADD COLUMN IF NOT EXISTS protocol_version INT NOT NULL DEFAULT 1;
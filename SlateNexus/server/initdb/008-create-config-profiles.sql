@@ -0,0 +1,10 @@
+-- Agent configuration profiles, assigned to groups or directly to hosts.
+-- A host profile overrides the profiles of its groups, which override the defaults.
+CREATE TABLE IF NOT EXISTS config_profiles (
+    profile_id SERIAL PRIMARY KEY,
+    profile_name VARCHAR(255) NOT NULL UNIQUE,
+    config JSONB NOT NULL DEFAULT '{}'
+);
+
+ALTER TABLE device_groups ADD COLUMN IF NOT EXISTS profile_id INT REFERENCES config_profiles(profile_id) ON DELETE SET NULL;
+ALTER TABLE agents ADD COLUMN IF NOT EXISTS profile_id INT REFERENCES config_profiles(profile_id) ON DELETE SET NULL;
@@ -0,0 +1,22 @@
+-- Name shown in the dashboard instead of the hostname reported by the agent
+ALTER TABLE agents ADD COLUMN IF NOT EXISTS display_name VARCHAR(255);
+
+-- Create the agent_heartbeats Table recording every time a host checked in.
+-- The server prunes rows older than its heartbeat retention.
+CREATE TABLE IF NOT EXISTS agent_heartbeats (
+    host_id INT NOT NULL,
+    seen_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (host_id) REFERENCES agents(host_id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS agent_heartbeats_host_seen_idx ON agent_heartbeats (host_id, seen_at);
+
+-- Create the agent_user_history Table recording each change of the logged-in user
+CREATE TABLE IF NOT EXISTS agent_user_history (
+    host_id INT NOT NULL,
+    username VARCHAR(255) NOT NULL,
+    seen_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (host_id) REFERENCES agents(host_id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS agent_user_history_host_seen_idx ON agent_user_history (host_id, seen_at);
@@ -0,0 +1,30 @@
+-- Create the remote_access_policies Table deciding who may start remote control.
+-- A user may control a host when a policy names one of their roles, or '*' for everyone,
+-- and names no group or a group the host is a member of. The roles of a user are their
+-- authentik groups and the role of their entry in the users Table.
+CREATE TABLE IF NOT EXISTS remote_access_policies (
+    policy_id SERIAL PRIMARY KEY,
+    role VARCHAR(255) NOT NULL,
+    group_id INT,
+    FOREIGN KEY (group_id) REFERENCES device_groups(group_id) ON DELETE CASCADE
+);
+
+-- Everyone keeps the access they had before policies existed until an admin narrows it
+INSERT INTO remote_access_policies (role)
+SELECT '*' WHERE NOT EXISTS (SELECT 1 FROM remote_access_policies);
+
+-- Create the remote_sessions Table recording every attempt to start remote control.
+-- The hostname is copied so the record outlives the host.
+CREATE TABLE IF NOT EXISTS remote_sessions (
+    session_id SERIAL PRIMARY KEY,
+    host_id INT,
+    hostname VARCHAR(255) NOT NULL,
+    username VARCHAR(255) NOT NULL,
+    provider VARCHAR(50) NOT NULL,
+    outcome VARCHAR(20) NOT NULL,
+    error TEXT NOT NULL DEFAULT '',
+    started_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (host_id) REFERENCES agents(host_id) ON DELETE SET NULL
+);
+
+CREATE INDEX IF NOT EXISTS remote_sessions_host_started_idx ON remote_sessions (host_id, started_at);
@@ -0,0 +1,6 @@
+-- Device IDs of the remote-access providers installed on each host, keyed by provider.
+-- remotely_id is kept for the agents and API clients that predate it.
+ALTER TABLE agents ADD COLUMN IF NOT EXISTS remote_ids JSONB NOT NULL DEFAULT '{}';
+
+UPDATE agents SET remote_ids = jsonb_build_object('remotely', remotely_id)
+WHERE remotely_id IS NOT NULL AND remotely_id <> '' AND remote_ids = '{}';
@@ -0,0 +1,17 @@
+-- Remote shell sessions are recorded in remote_sessions with the provider 'shell', and
+-- end when the shell exits, the technician closes it or it stays idle too long.
+ALTER TABLE remote_sessions ADD COLUMN IF NOT EXISTS ended_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE remote_sessions ADD COLUMN IF NOT EXISTS end_reason VARCHAR(255) NOT NULL DEFAULT '';
+
+-- Create the shell_recordings Table holding what was typed in and written by each remote
+-- shell, in order. Direction is 'input' or 'output'.
+CREATE TABLE IF NOT EXISTS shell_recordings (
+    chunk_id BIGSERIAL PRIMARY KEY,
+    session_id INT NOT NULL,
+    recorded_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    direction VARCHAR(10) NOT NULL,
+    data BYTEA NOT NULL,
+    FOREIGN KEY (session_id) REFERENCES remote_sessions(session_id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS shell_recordings_session_idx ON shell_recordings (session_id, chunk_id);
@@ -0,0 +1,16 @@
+-- File sessions are recorded in remote_sessions with the provider 'files'. Create the
+-- file_operations Table auditing the directories listed and the files downloaded and
+-- uploaded during each session. Bytes is how much of the file was transferred.
+CREATE TABLE IF NOT EXISTS file_operations (
+    operation_id BIGSERIAL PRIMARY KEY,
+    session_id INT NOT NULL,
+    operation VARCHAR(10) NOT NULL,
+    path TEXT NOT NULL,
+    bytes BIGINT NOT NULL DEFAULT 0,
+    outcome VARCHAR(10) NOT NULL,
+    error TEXT NOT NULL DEFAULT '',
+    performed_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (session_id) REFERENCES remote_sessions(session_id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS file_operations_session_idx ON file_operations (session_id, operation_id);
@@ -0,0 +1,10 @@
+-- Create the agent_snapshots Table holding the services and processes last reported by each
+-- agent on request. Kind is 'services' or 'processes'; only the latest snapshot is kept.
+CREATE TABLE IF NOT EXISTS agent_snapshots (
+    host_id INT NOT NULL,
+    kind VARCHAR(20) NOT NULL,
+    collected_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    data JSONB NOT NULL,
+    PRIMARY KEY (host_id, kind),
+    FOREIGN KEY (host_id) REFERENCES agents(host_id) ON DELETE CASCADE
+);
@@ -0,0 +1,43 @@
+-- Monitoring checks, assigned to groups and run by the agents of their members. Params holds
+-- the settings of the check type, such as the drive of a disk check and its thresholds.
+CREATE TABLE IF NOT EXISTS checks (
+    check_id SERIAL PRIMARY KEY,
+    group_id INT NOT NULL,
+    check_name VARCHAR(255) NOT NULL,
+    check_type VARCHAR(20) NOT NULL,
+    params JSONB NOT NULL DEFAULT '{}',
+    interval_seconds INT NOT NULL DEFAULT 300,
+    enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    UNIQUE (group_id, check_name),
+    FOREIGN KEY (group_id) REFERENCES device_groups(group_id) ON DELETE CASCADE
+);
+
+-- Every result reported for a check on a host, kept for the check history
+CREATE TABLE IF NOT EXISTS check_results (
+    result_id BIGSERIAL PRIMARY KEY,
+    check_id INT NOT NULL,
+    host_id INT NOT NULL,
+    status VARCHAR(10) NOT NULL,
+    output TEXT NOT NULL DEFAULT '',
+    perfdata JSONB NOT NULL DEFAULT '[]',
+    checked_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    FOREIGN KEY (check_id) REFERENCES checks(check_id) ON DELETE CASCADE,
+    FOREIGN KEY (host_id) REFERENCES agents(host_id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS check_results_host_idx ON check_results (host_id, check_id, checked_at DESC);
+CREATE INDEX IF NOT EXISTS check_results_checked_at_idx ON check_results (checked_at);
+
+-- The latest result of each check on each host. Since is when the check entered its status.
+CREATE TABLE IF NOT EXISTS check_states (
+    host_id INT NOT NULL,
+    check_id INT NOT NULL,
+    status VARCHAR(10) NOT NULL,
+    output TEXT NOT NULL DEFAULT '',
+    perfdata JSONB NOT NULL DEFAULT '[]',
+    checked_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    since TIMESTAMP WITH TIME ZONE NOT NULL,
+    PRIMARY KEY (host_id, check_id),
+    FOREIGN KEY (check_id) REFERENCES checks(check_id) ON DELETE CASCADE,
+    FOREIGN KEY (host_id) REFERENCES agents(host_id) ON DELETE CASCADE
+);
@@ -0,0 +1,20 @@
+-- Entries of the Windows event logs, journald and syslog files shipped by the agents. Source
+-- is the channel, journald or file they were read from and record_key their position in it,
+-- which with logged_at keeps an entry replayed by an agent from being stored twice.
+CREATE TABLE IF NOT EXISTS event_log_entries (
+    entry_id BIGSERIAL PRIMARY KEY,
+    host_id INT NOT NULL,
+    source VARCHAR(255) NOT NULL,
+    record_key VARCHAR(255) NOT NULL,
+    provider VARCHAR(255) NOT NULL DEFAULT '',
+    event_code INT,
+    level VARCHAR(20) NOT NULL,
+    message TEXT NOT NULL DEFAULT '',
+    logged_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    received_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE (host_id, source, record_key, logged_at),
+    FOREIGN KEY (host_id) REFERENCES agents(host_id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS event_log_entries_host_idx ON event_log_entries (host_id, logged_at DESC);
+CREATE INDEX IF NOT EXISTS event_log_entries_logged_at_idx ON event_log_entries (logged_at);
@@ -0,0 +1,211 @@
+// Package checks runs the monitoring checks the server assigns to the groups of the host.
+// Each type of check implements Check and registers itself under its type; the Scheduler
+// runs every check on its interval and hands the results over in batches for the server.
+package checks
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Statuses of the results. A check that could not run is unknown.
+const (
+	OK       = "ok"
+	Warning  = "warning"
+	Critical = "critical"
+	Unknown  = "unknown"
+)
+
+// defaultTimeout bounds a check that does not set its timeout
+const defaultTimeout = 10 * time.Second
+
+// maxOutput limits the output of a result, as the server does
+const maxOutput = 4096
+
+// Params are the settings of a check; each type uses some of them. Warning and Critical are
+// thresholds on the percentage of a disk used, on the response time in milliseconds of the
+// tcp, http and ping checks, and on the number of events an eventlog check matched.
+type Params struct {
+	Path         string   `json:"path"`
+	Service      string   `json:"service"`
+	Process      string   `json:"process"`
+	Host         string   `json:"host"`
+	Port         int      `json:"port"`
+	URL          string   `json:"url"`
+	ExpectStatus int      `json:"expect_status"`
+	Log          string   `json:"log"`
+	Pattern      string   `json:"pattern"`
+	Shell        string   `json:"shell"`
+	Script       string   `json:"script"`
+	Command      string   `json:"command"`
+	Arguments    []string `json:"arguments"`
+	Warning      *float64 `json:"warning"`
+	Critical     *float64 `json:"critical"`
+	// Timeout is in seconds
+	Timeout int `json:"timeout"`
+}
+
+// Definition is a check the server assigned to the host. Interval is in seconds.
+type Definition struct {
+	CheckID   int32  `json:"check_id"`
+	CheckName string `json:"check_name"`
+	CheckType string `json:"check_type"`
+	Params    Params `json:"params"`
+	Interval  int    `json:"interval"`
+}
+
+// PerfData is a measurement reported with a result. Warning and Critical are the thresholds
+// it was compared to, as Nagios ranges.
+type PerfData struct {
+	Label    string   `json:"label"`
+	Value    float64  `json:"value"`
+	Unit     string   `json:"unit,omitempty"`
+	Warning  string   `json:"warning,omitempty"`
+	Critical string   `json:"critical,omitempty"`
+	Min      *float64 `json:"min,omitempty"`
+	Max      *float64 `json:"max,omitempty"`
+}
+
+// Result is the outcome of a run of a check
+type Result struct {
+	CheckID   int32      `json:"check_id"`
+	Status    string     `json:"status"`
+	Output    string     `json:"output"`
+	Perfdata  []PerfData `json:"perfdata,omitempty"`
+	CheckedAt time.Time  `json:"checked_at"`
+}
+
+// Check is implemented by each type of check
+type Check interface {
+	// Run runs the check once. ctx is cancelled once the timeout of the check runs out.
+	Run(ctx context.Context, def Definition) Result
+}
+
+// CheckFunc adapts a function to the Check interface
+type CheckFunc func(ctx context.Context, def Definition) Result
+
+// Run calls f
+func (f CheckFunc) Run(ctx context.Context, def Definition) Result {
+	return f(ctx, def)
+}
+
+// registry holds the types of checks, registered from the init functions of this package
+var registry = map[string]Check{}
+
+// Register makes a type of check available. It is meant to be called from init.
+func Register(checkType string, check Check) {
+	registry[checkType] = check
+}
+
+// Run runs a check once within its timeout and returns its result. A check that does not
+// return in time, panics or has a type the agent does not know is unknown.
+func Run(def Definition) Result {
+	start := time.Now()
+	result := run(def)
+	result.CheckID = def.CheckID
+	result.CheckedAt = start
+	if len(result.Output) > maxOutput {
+		result.Output = strings.ToValidUTF8(result.Output[:maxOutput], "")
+	}
+	return result
+}
+
+func run(def Definition) Result {
+	check, ok := registry[def.CheckType]
+	if !ok {
+		return Unknownf("unsupported check type: %s", def.CheckType)
+	}
+
+	timeout := defaultTimeout
+	if def.Params.Timeout > 0 {
+		timeout = time.Duration(def.Params.Timeout) * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	// Checks that block in a system call are abandoned once they time out
+	done := make(chan Result, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				done <- Unknownf("check failed: %v", r)
+			}
+		}()
+		done <- check.Run(ctx, def)
+	}()
+	select {
+	case result := <-done:
+		return result
+	case <-ctx.Done():
+		return Unknownf("check timed out after %s", timeout)
+	}
+}
+
+// Unknownf returns the result of a check that could not run
+func Unknownf(format string, args ...interface{}) Result {
+	return Result{Status: Unknown, Output: fmt.Sprintf(format, args...)}
+}
+
+// statusFromExitCode sets the status of a result from the exit code of a script or plugin, as
+// Nagios does: 0 is ok, 1 warning, 2 critical and any other unknown. An unknown result
+// without output says which command exited with which code.
+func statusFromExitCode(result *Result, command string, code int) {
+	switch code {
+	case 0:
+		result.Status = OK
+	case 1:
+		result.Status = Warning
+	case 2:
+		result.Status = Critical
+	default:
+		result.Status = Unknown
+		if result.Output == "" {
+			result.Output = fmt.Sprintf("%s exited with code %d", command, code)
+		}
+	}
+}
+
+// Threshold returns the status of a value compared to the warning and critical thresholds,
+// higher values being worse. Unset thresholds are not compared to.
+func Threshold(value float64, warning, critical *float64) string {
+	switch {
+	case critical != nil && value >= *critical:
+		return Critical
+	case warning != nil && value >= *warning:
+		return Warning
+	default:
+		return OK
+	}
+}
+
+// orDefault returns threshold, or value if it is unset
+func orDefault(threshold *float64, value float64) *float64 {
+	if threshold != nil {
+		return threshold
+	}
+	return &value
+}
+
+// perf returns a measurement compared to the warning and critical thresholds, rounded to
+// two decimals
+func perf(label string, value float64, unit string, warning, critical *float64) PerfData {
+	return PerfData{
+		Label:    label,
+		Value:    math.Round(value*100) / 100,
+		Unit:     unit,
+		Warning:  formatThreshold(warning),
+		Critical: formatThreshold(critical),
+	}
+}
+
+// formatThreshold formats a threshold as a Nagios range, which alerts above it
+func formatThreshold(threshold *float64) string {
+	if threshold == nil {
+		return ""
+	}
+	return strconv.FormatFloat(*threshold, 'f', -1, 64)
+}
@@ -0,0 +1,77 @@
+package checks
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strings"
+)
+
+// maxEvents limits the events an eventlog check reads at each run
+const maxEvents = 1000
+
+// eventStart matches the first line of each event in the text output of wevtutil
+var eventStart = regexp.MustCompile(`(?m)^Event\[\d+\]:?\s*$`)
+
+func init() {
+	Register("eventlog", CheckFunc(checkEventLog))
+}
+
+// checkEventLog counts the events written to a log since the previous run that match the
+// pattern, and compares the count to the thresholds. A single event is a warning unless the
+// check sets its thresholds.
+func checkEventLog(ctx context.Context, def Definition) Result {
+	pattern, err := regexp.Compile(def.Params.Pattern)
+	if err != nil {
+		return Unknownf("invalid pattern: %v", err)
+	}
+
+	since := interval(def).Milliseconds()
+	query := fmt.Sprintf("*[System[TimeCreated[timediff(@SystemTime) <= %d]]]", since)
+	cmd := exec.CommandContext(ctx, "wevtutil", "qe", def.Params.Log, "/q:"+query, "/f:text", "/rd:true", fmt.Sprintf("/c:%d", maxEvents))
+	output, err := cmd.Output()
+	if err != nil {
+		return Unknownf("cannot read the %s log: %v", def.Params.Log, err)
+	}
+
+	count, first := matchEvents(string(output), pattern)
+	warning := orDefault(def.Params.Warning, 1)
+	message := fmt.Sprintf("%d events in the %s log matched %q", count, def.Params.Log, def.Params.Pattern)
+	if first != "" {
+		message += ", latest: " + first
+	}
+	return Result{
+		Status:   Threshold(float64(count), warning, def.Params.Critical),
+		Output:   message,
+		Perfdata: []PerfData{perf("events", float64(count), "", warning, def.Params.Critical)},
+	}
+}
+
+// matchEvents counts the events in the text output of wevtutil that match the pattern, and
+// returns the first matching line of the first of them. The output before the first event,
+// empty when there is one, is not an event.
+func matchEvents(output string, pattern *regexp.Regexp) (int, string) {
+	count := 0
+	var first string
+	for _, event := range eventStart.Split(output, -1) {
+		if strings.TrimSpace(event) == "" || !pattern.MatchString(event) {
+			continue
+		}
+		count++
+		if first == "" {
+			first = matchingLine(pattern, event)
+		}
+	}
+	return count, first
+}
+
+// matchingLine returns the first line of an event that matches the pattern, blank lines aside
+func matchingLine(pattern *regexp.Regexp, event string) string {
+	for _, line := range strings.Split(event, "\n") {
+		if line = strings.TrimSpace(line); line != "" && pattern.MatchString(line) {
+			return line
+		}
+	}
+	return ""
+}
@@ -0,0 +1,45 @@
+package checks
+
+import (
+	"regexp"
+	"testing"
+)
+
+const twoEvents = `Event[0]:
+  Log Name: System
+  Source: Service Control Manager
+  Event ID: 7036
+  Level: Information
+  Description: The Windows Update service entered the stopped state.
+
+Event[1]:
+  Log Name: System
+  Source: disk
+  Event ID: 7
+  Level: Error
+  Description: The device, \Device\Harddisk0\DR0, has a bad block.
+`
+
+func TestMatchEvents(t *testing.T) {
+	tests := []struct {
+		name    string
+		output  string
+		pattern string
+		count   int
+		first   string
+	}{
+		{"empty output", "", ".*", 0, ""},
+		{"blank output", "\r\n", ".*", 0, ""},
+		{"any event", twoEvents, ".*", 2, "Log Name: System"},
+		{"one event", twoEvents, "bad block", 1, "Description: The device, \\Device\\Harddisk0\\DR0, has a bad block."},
+		{"no event", twoEvents, "Event ID: 41$", 0, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			count, first := matchEvents(test.output, regexp.MustCompile(test.pattern))
+			if count != test.count || first != test.first {
+				t.Errorf("matchEvents() = %d, %q, want %d, %q", count, first, test.count, test.first)
+			}
+		})
+	}
+}
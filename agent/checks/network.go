@@ -0,0 +1,223 @@
+package checks
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// Settings of the ping check
+const (
+	// pingCount is how many echo requests a ping check sends
+	pingCount = 3
+	// pingTimeout bounds the wait for each echo reply, in milliseconds
+	pingTimeout = 1000
+	// pingSize is the size of the data sent with each echo request
+	pingSize = 32
+)
+
+var (
+	iphlpapi         = windows.NewLazySystemDLL("iphlpapi.dll")
+	procIcmpCreate   = iphlpapi.NewProc("IcmpCreateFile")
+	procIcmpSendEcho = iphlpapi.NewProc("IcmpSendEcho")
+	procIcmpClose    = iphlpapi.NewProc("IcmpCloseHandle")
+)
+
+// icmpEchoReply is the ICMP_ECHO_REPLY structure IcmpSendEcho writes the reply to
+type icmpEchoReply struct {
+	Address       uint32
+	Status        uint32
+	RoundTripTime uint32
+	DataSize      uint16
+	Reserved      uint16
+	Data          uintptr
+	Options       struct {
+		TTL         uint8
+		Tos         uint8
+		Flags       uint8
+		OptionsSize uint8
+		OptionsData uintptr
+	}
+}
+
+// httpClient requests the URLs of the http checks, which time out with their context
+var httpClient = &http.Client{}
+
+func init() {
+	Register("tcp", CheckFunc(checkTCP))
+	Register("http", CheckFunc(checkHTTP))
+	Register("ping", CheckFunc(checkPing))
+}
+
+// milliseconds returns a duration in milliseconds
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// checkTCP connects to a port, critical if the connection is refused and compared to the
+// thresholds on the time it took otherwise
+func checkTCP(ctx context.Context, def Definition) Result {
+	address := net.JoinHostPort(def.Params.Host, strconv.Itoa(def.Params.Port))
+	var dialer net.Dialer
+	start := time.Now()
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return Result{Status: Critical, Output: fmt.Sprintf("cannot connect to %s: %v", address, err)}
+	}
+	elapsed := milliseconds(time.Since(start))
+	conn.Close()
+
+	return Result{
+		Status:   Threshold(elapsed, def.Params.Warning, def.Params.Critical),
+		Output:   fmt.Sprintf("connected to %s in %.0f ms", address, elapsed),
+		Perfdata: []PerfData{perf("time", elapsed, "ms", def.Params.Warning, def.Params.Critical)},
+	}
+}
+
+// checkHTTP requests a URL, critical unless it answers with the expected status and compared
+// to the thresholds on the time it took otherwise
+func checkHTTP(ctx context.Context, def Definition) Result {
+	expect := def.Params.ExpectStatus
+	if expect == 0 {
+		expect = http.StatusOK
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, def.Params.URL, nil)
+	if err != nil {
+		return Unknownf("invalid url: %v", err)
+	}
+	start := time.Now()
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return Result{Status: Critical, Output: fmt.Sprintf("request failed: %v", err)}
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<20))
+	resp.Body.Close()
+	elapsed := milliseconds(time.Since(start))
+
+	perfdata := []PerfData{perf("time", elapsed, "ms", def.Params.Warning, def.Params.Critical)}
+	if resp.StatusCode != expect {
+		return Result{
+			Status:   Critical,
+			Output:   fmt.Sprintf("%s answered %s, expected %d", def.Params.URL, resp.Status, expect),
+			Perfdata: perfdata,
+		}
+	}
+	return Result{
+		Status:   Threshold(elapsed, def.Params.Warning, def.Params.Critical),
+		Output:   fmt.Sprintf("%s answered %s in %.0f ms", def.Params.URL, resp.Status, elapsed),
+		Perfdata: perfdata,
+	}
+}
+
+// checkPing sends echo requests to the host, or to the default gateway without one. It is
+// critical when no reply comes back, warning when some are lost and compared to the
+// thresholds on the average round trip time otherwise.
+func checkPing(ctx context.Context, def Definition) Result {
+	target := def.Params.Host
+	var ip net.IP
+	if target == "" {
+		gateway, err := defaultGateway()
+		if err != nil {
+			return Unknownf("cannot find the default gateway: %v", err)
+		}
+		ip, target = gateway, gateway.String()
+	} else {
+		addrs, err := net.DefaultResolver.LookupIPAddr(ctx, target)
+		if err != nil {
+			return Unknownf("cannot resolve %s: %v", target, err)
+		}
+		for _, addr := range addrs {
+			if ip4 := addr.IP.To4(); ip4 != nil {
+				ip = ip4
+				break
+			}
+		}
+		if ip == nil {
+			return Unknownf("%s has no IPv4 address", target)
+		}
+	}
+
+	handle, _, err := procIcmpCreate.Call()
+	if windows.Handle(handle) == windows.InvalidHandle {
+		return Unknownf("cannot open an ICMP handle: %v", err)
+	}
+	defer procIcmpClose.Call(handle)
+
+	data := make([]byte, pingSize)
+	reply := make([]byte, unsafe.Sizeof(icmpEchoReply{})+pingSize+8)
+	// IcmpSendEcho takes the address in network byte order
+	address := binary.LittleEndian.Uint32(ip.To4())
+	received := 0
+	var total time.Duration
+	for i := 0; i < pingCount && ctx.Err() == nil; i++ {
+		n, _, _ := procIcmpSendEcho.Call(handle, uintptr(address),
+			uintptr(unsafe.Pointer(&data[0])), uintptr(len(data)), 0,
+			uintptr(unsafe.Pointer(&reply[0])), uintptr(len(reply)), pingTimeout)
+		echo := (*icmpEchoReply)(unsafe.Pointer(&reply[0]))
+		if n == 0 || echo.Status != 0 {
+			continue
+		}
+		received++
+		total += time.Duration(echo.RoundTripTime) * time.Millisecond
+	}
+
+	loss := float64(pingCount-received) * 100 / pingCount
+	if received == 0 {
+		return Result{
+			Status:   Critical,
+			Output:   fmt.Sprintf("%s did not reply to %d echo requests", target, pingCount),
+			Perfdata: []PerfData{perf("loss", loss, "%", nil, nil)},
+		}
+	}
+
+	rta := milliseconds(total / time.Duration(received))
+	status := Threshold(rta, def.Params.Warning, def.Params.Critical)
+	if status == OK && received < pingCount {
+		status = Warning
+	}
+	return Result{
+		Status: status,
+		Output: fmt.Sprintf("%s replied to %d of %d echo requests, %.0f ms on average", target, received, pingCount, rta),
+		Perfdata: []PerfData{
+			perf("rta", rta, "ms", def.Params.Warning, def.Params.Critical),
+			perf("loss", loss, "%", nil, nil),
+		},
+	}
+}
+
+// defaultGateway returns the IPv4 default gateway of the first network adapter that is up
+func defaultGateway() (net.IP, error) {
+	size := uint32(15000)
+	var buf []byte
+	for {
+		buf = make([]byte, size)
+		err := windows.GetAdaptersAddresses(windows.AF_INET, windows.GAA_FLAG_INCLUDE_GATEWAYS, 0,
+			(*windows.IpAdapterAddresses)(unsafe.Pointer(&buf[0])), &size)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, windows.ERROR_BUFFER_OVERFLOW) {
+			return nil, err
+		}
+	}
+
+	for aa := (*windows.IpAdapterAddresses)(unsafe.Pointer(&buf[0])); aa != nil; aa = aa.Next {
+		if aa.OperStatus != windows.IfOperStatusUp || aa.FirstGatewayAddress == nil {
+			continue
+		}
+		if ip := aa.FirstGatewayAddress.Address.IP().To4(); ip != nil {
+			return ip, nil
+		}
+	}
+	return nil, errors.New("no network adapter has a default gateway")
+}
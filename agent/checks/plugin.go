@@ -0,0 +1,167 @@
+package checks
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// pluginDir is where the commands of the plugin checks are looked up first
+const pluginDir = "C:\\Program Files\\SlateNexus\\plugins"
+
+// perfValue splits the value of a measurement from its unit, e.g. 72.5% or 0,25s
+var perfValue = regexp.MustCompile(`^([-+]?(?:\d+(?:[.,]\d*)?|[.,]\d+)(?:[eE][-+]?\d+)?)([^\d.,;]*)$`)
+
+func init() {
+	Register("plugin", CheckFunc(checkPlugin))
+}
+
+// checkPlugin runs a Nagios plugin. Exit code 0 is ok, 1 warning, 2 critical and any other
+// unknown, and the output is read as Nagios reads it: the text of the first line and the
+// lines after it, each part optionally followed by performance data after a pipe.
+func checkPlugin(ctx context.Context, def Definition) Result {
+	command := def.Params.Command
+	if filepath.Base(command) == command {
+		if path, err := exec.LookPath(filepath.Join(pluginDir, command)); err == nil {
+			command = path
+		}
+	}
+
+	cmd := exec.CommandContext(ctx, command, def.Params.Arguments...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	// Output is not read from children of the plugin left running once it exits
+	cmd.WaitDelay = time.Second
+
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		return Unknownf("cannot run %s: %v", def.Params.Command, err)
+	}
+
+	output := stdout.String()
+	if strings.TrimSpace(output) == "" {
+		output = stderr.String()
+	}
+	text, perfdata := parsePluginOutput(output)
+	result := Result{Output: text, Perfdata: perfdata}
+	statusFromExitCode(&result, def.Params.Command, cmd.ProcessState.ExitCode())
+	return result
+}
+
+// parsePluginOutput splits the output of a Nagios plugin into its text and its performance
+// data. The first line may end with performance data after a pipe, and so may the long text
+// on the lines after it, its performance data running to the end of the output.
+func parsePluginOutput(output string) (string, []PerfData) {
+	output = strings.ReplaceAll(output, "\r\n", "\n")
+	first, long, _ := strings.Cut(output, "\n")
+
+	text, perf, _ := strings.Cut(first, "|")
+	long, longPerf, _ := strings.Cut(long, "|")
+
+	text = strings.TrimSpace(text)
+	if long = strings.TrimSpace(long); long != "" {
+		text += "\n" + long
+	}
+	return text, append(parsePerfData(perf), parsePerfData(longPerf)...)
+}
+
+// parsePerfData parses Nagios performance data: space separated 'label'=value[unit];warn;crit;min;max
+// with the trailing fields optional. Labels with spaces are quoted, a quote in them doubled.
+// Malformed measurements and undetermined values (U) are skipped.
+func parsePerfData(s string) []PerfData {
+	var perfdata []PerfData
+	for {
+		s = strings.TrimLeft(s, " \t\n")
+		if s == "" {
+			return perfdata
+		}
+
+		var label string
+		if s[0] == '\'' {
+			var ok bool
+			label, s, ok = cutQuoted(s[1:])
+			if !ok {
+				return perfdata
+			}
+		} else {
+			end := strings.IndexAny(s, "= \t\n")
+			if end < 0 {
+				return perfdata
+			}
+			label, s = s[:end], s[end:]
+		}
+
+		// The fields run to the next whitespace
+		end := strings.IndexAny(s, " \t\n")
+		if end < 0 {
+			end = len(s)
+		}
+		fields := s[:end]
+		s = s[end:]
+		if !strings.HasPrefix(fields, "=") || label == "" {
+			continue
+		}
+		if p, ok := parseMeasurement(label, fields[1:]); ok {
+			perfdata = append(perfdata, p)
+		}
+	}
+}
+
+// cutQuoted returns the label up to the closing quote and what follows it. A doubled quote
+// is a quote in the label.
+func cutQuoted(s string) (label, rest string, ok bool) {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\'' {
+			b.WriteByte(s[i])
+			continue
+		}
+		if i+1 < len(s) && s[i+1] == '\'' {
+			b.WriteByte('\'')
+			i++
+			continue
+		}
+		return b.String(), s[i+1:], true
+	}
+	return "", "", false
+}
+
+// parseMeasurement parses value[unit];warn;crit;min;max
+func parseMeasurement(label, fields string) (PerfData, bool) {
+	parts := strings.Split(fields, ";")
+	match := perfValue.FindStringSubmatch(parts[0])
+	if match == nil {
+		return PerfData{}, false
+	}
+	value, err := strconv.ParseFloat(strings.Replace(match[1], ",", ".", 1), 64)
+	if err != nil {
+		return PerfData{}, false
+	}
+
+	p := PerfData{Label: label, Value: value, Unit: match[2]}
+	field := func(i int) string {
+		if i < len(parts) {
+			return parts[i]
+		}
+		return ""
+	}
+	p.Warning, p.Critical = field(1), field(2)
+	p.Min, p.Max = parseLimit(field(3)), parseLimit(field(4))
+	return p, true
+}
+
+// parseLimit parses the minimum or maximum of a measurement, nil if it is unset or malformed
+func parseLimit(s string) *float64 {
+	value, err := strconv.ParseFloat(strings.Replace(s, ",", ".", 1), 64)
+	if err != nil {
+		return nil
+	}
+	return &value
+}
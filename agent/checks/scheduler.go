@@ -0,0 +1,151 @@
+package checks
+
+import (
+	"math/rand"
+	"reflect"
+	"slate-nexus-agent/logger"
+	"sync"
+	"time"
+)
+
+// Limits of the scheduler
+const (
+	// minInterval bounds how often a check runs, whatever the server asks for
+	minInterval = 30 * time.Second
+	// maxStartDelay spreads the first runs of the checks assigned at once
+	maxStartDelay = 30 * time.Second
+	// flushInterval is how long results are collected before being handed over
+	flushInterval = 10 * time.Second
+	// maxBatch limits the results handed over at once
+	maxBatch = 100
+	// maxPending limits the results kept while they are not taken; the oldest are dropped
+	maxPending = 1000
+)
+
+// Scheduler runs each check on its interval and hands the results over in batches
+type Scheduler struct {
+	results chan Result
+	batches chan []Result
+
+	mu      sync.Mutex
+	running map[int32]*scheduled
+}
+
+// scheduled is a check running on its interval until stop is closed
+type scheduled struct {
+	def  Definition
+	stop chan struct{}
+}
+
+// NewScheduler returns a scheduler running no checks
+func NewScheduler() *Scheduler {
+	s := &Scheduler{
+		results: make(chan Result),
+		batches: make(chan []Result),
+		running: map[int32]*scheduled{},
+	}
+	go s.batch()
+	return s
+}
+
+// Batches delivers the results of the checks, a batch at a time
+func (s *Scheduler) Batches() <-chan []Result {
+	return s.batches
+}
+
+// Update runs the checks of defs from now on: new checks start, checks whose definition
+// changed start over and checks no longer listed stop
+func (s *Scheduler) Update(defs []Definition) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	listed := make(map[int32]bool, len(defs))
+	for _, def := range defs {
+		listed[def.CheckID] = true
+		if current, ok := s.running[def.CheckID]; ok {
+			if reflect.DeepEqual(current.def, def) {
+				continue
+			}
+			close(current.stop)
+		}
+		check := &scheduled{def: def, stop: make(chan struct{})}
+		s.running[def.CheckID] = check
+		go s.loop(check)
+		logger.LogInfo("Check %d (%s) scheduled every %s", def.CheckID, def.CheckName, interval(def))
+	}
+	for id, check := range s.running {
+		if !listed[id] {
+			close(check.stop)
+			delete(s.running, id)
+			logger.LogInfo("Check %d (%s) removed", id, check.def.CheckName)
+		}
+	}
+}
+
+// Stop stops every check
+func (s *Scheduler) Stop() {
+	s.Update(nil)
+}
+
+// interval returns how often a check runs
+func interval(def Definition) time.Duration {
+	return max(time.Duration(def.Interval)*time.Second, minInterval)
+}
+
+// loop runs a check on its interval until it is stopped. The first run is delayed at random
+// so that the checks assigned at once do not all run together.
+func (s *Scheduler) loop(check *scheduled) {
+	every := interval(check.def)
+	timer := time.NewTimer(time.Duration(rand.Int63n(int64(min(every, maxStartDelay)))))
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-check.stop:
+			return
+		case <-timer.C:
+		}
+
+		result := Run(check.def)
+		logger.LogDebug("Check %d (%s): %s %s", check.def.CheckID, check.def.CheckName, result.Status, result.Output)
+		select {
+		case s.results <- result:
+		case <-check.stop:
+			return
+		}
+		timer.Reset(every)
+	}
+}
+
+// batch collects the results and hands them over every flushInterval, or as soon as a batch
+// is full
+func (s *Scheduler) batch() {
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	var pending []Result
+	var ready bool
+	for {
+		// Batches are only offered once ready
+		var out chan []Result
+		var next []Result
+		if ready {
+			out = s.batches
+			next = pending[:min(len(pending), maxBatch)]
+		}
+
+		select {
+		case result := <-s.results:
+			pending = append(pending, result)
+			if len(pending) > maxPending {
+				pending = pending[len(pending)-maxPending:]
+			}
+			ready = ready || len(pending) >= maxBatch
+		case <-ticker.C:
+			ready = len(pending) > 0
+		case out <- next:
+			pending = pending[len(next):]
+			ready = len(pending) >= maxBatch
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package checks
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+func init() {
+	Register("script", CheckFunc(checkScript))
+}
+
+// checkScript runs a script with its shell. Exit code 0 is ok, 1 warning, 2 critical and any
+// other unknown, and the output of the script is the output of the result.
+func checkScript(ctx context.Context, def Definition) Result {
+	var cmd *exec.Cmd
+	switch def.Params.Shell {
+	case "cmd":
+		cmd = exec.CommandContext(ctx, "cmd", "/C", def.Params.Script)
+	case "powershell", "":
+		cmd = exec.CommandContext(ctx, "Powershell", "-NoProfile", "-NonInteractive", "-Command", def.Params.Script)
+	default:
+		return Unknownf("unsupported shell: %s", def.Params.Shell)
+	}
+	// Output is not read from children of the script left running once it exits
+	cmd.WaitDelay = time.Second
+
+	start := time.Now()
+	output, err := cmd.CombinedOutput()
+	elapsed := milliseconds(time.Since(start))
+	text := strings.TrimSpace(string(output))
+
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		return Unknownf("cannot run the script: %v", err)
+	}
+	result := Result{Output: text, Perfdata: []PerfData{perf("time", elapsed, "ms", nil, nil)}}
+	statusFromExitCode(&result, "script", cmd.ProcessState.ExitCode())
+	return result
+}
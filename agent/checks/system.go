@@ -0,0 +1,92 @@
+package checks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slate-nexus-agent/collectors"
+	"strings"
+
+	"github.com/shirou/gopsutil/disk"
+	"github.com/shirou/gopsutil/process"
+	"golang.org/x/sys/windows"
+)
+
+// Thresholds of the disk check unless it sets its own, in percent used
+const (
+	diskWarning  = 80
+	diskCritical = 90
+)
+
+func init() {
+	Register("disk", CheckFunc(checkDisk))
+	Register("service", CheckFunc(checkService))
+	Register("process", CheckFunc(checkProcess))
+}
+
+// checkDisk compares the percentage of a drive used to the thresholds
+func checkDisk(ctx context.Context, def Definition) Result {
+	path := def.Params.Path
+	// C: is the current directory of the drive, C:\ its root
+	if strings.HasSuffix(path, ":") {
+		path += `\`
+	}
+	usage, err := disk.UsageWithContext(ctx, path)
+	if err != nil {
+		return Unknownf("cannot read the usage of %s: %v", def.Params.Path, err)
+	}
+
+	warning := orDefault(def.Params.Warning, diskWarning)
+	critical := orDefault(def.Params.Critical, diskCritical)
+	used := perf("used", usage.UsedPercent, "%", warning, critical)
+	zero, hundred := 0.0, 100.0
+	used.Min, used.Max = &zero, &hundred
+	return Result{
+		Status: Threshold(usage.UsedPercent, warning, critical),
+		Output: fmt.Sprintf("%s %.1f%% used, %.1f GB free of %.1f GB", def.Params.Path, usage.UsedPercent,
+			float64(usage.Free)/(1<<30), float64(usage.Total)/(1<<30)),
+		Perfdata: []PerfData{used},
+	}
+}
+
+// checkService is critical unless the service is running
+func checkService(ctx context.Context, def Definition) Result {
+	service, err := collectors.QueryService(def.Params.Service)
+	if errors.Is(err, windows.ERROR_SERVICE_DOES_NOT_EXIST) {
+		return Result{Status: Critical, Output: fmt.Sprintf("service %s is not installed", def.Params.Service)}
+	}
+	if err != nil {
+		return Unknownf("cannot query service %s: %v", def.Params.Service, err)
+	}
+
+	if service.State != "running" {
+		return Result{Status: Critical, Output: fmt.Sprintf("service %s is %s", service.DisplayName, service.State)}
+	}
+	return Result{Status: OK, Output: fmt.Sprintf("service %s is running", service.DisplayName)}
+}
+
+// checkProcess is critical unless a process runs the executable, compared without case
+func checkProcess(ctx context.Context, def Definition) Result {
+	procs, err := process.ProcessesWithContext(ctx)
+	if err != nil {
+		return Unknownf("cannot list processes: %v", err)
+	}
+
+	count := 0
+	for _, p := range procs {
+		name, err := p.NameWithContext(ctx)
+		if err == nil && strings.EqualFold(name, def.Params.Process) {
+			count++
+		}
+	}
+
+	status := OK
+	if count == 0 {
+		status = Critical
+	}
+	return Result{
+		Status:   status,
+		Output:   fmt.Sprintf("%d %s processes running", count, def.Params.Process),
+		Perfdata: []PerfData{perf("processes", float64(count), "", nil, nil)},
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"slate-nexus-agent/collectors"
+	"slate-nexus-agent/server"
+	"slate-nexus-agent/status"
+	"time"
+)
+
+const usage = `Usage: slate-rmm-agent <command> [flags]
+
+Without a command the binary runs as the SlateNexusAgent service.
+
+Commands:
+  status            show the state of the agent service, or of the local config if it is not running
+  register          register this host with the server and save its host ID
+  test-connection   check that the server can be reached with the configured API key
+  collect [--print] collect the inventory and send it to the server, or only print it
+  unregister        delete this host on the server and clear its host ID
+`
+
+// runCommand runs a CLI subcommand and returns the process exit code
+func runCommand(args []string) int {
+	commands := map[string]func([]string) error{
+		"status":          statusCommand,
+		"register":        registerCommand,
+		"test-connection": testConnectionCommand,
+		"collect":         collectCommand,
+		"unregister":      unregisterCommand,
+	}
+
+	command, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprint(os.Stderr, usage)
+		return 2
+	}
+	if err := command(args[1:]); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", args[0], err)
+		return 1
+	}
+	return 0
+}
+
+// printJSON prints v as indented JSON
+func printJSON(v interface{}) error {
+	out, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(out))
+	return nil
+}
+
+func statusCommand(args []string) error {
+	s, err := status.Fetch()
+	if err == nil {
+		fmt.Println("Service: running")
+		return printJSON(s)
+	}
+
+	// Standalone: report what the local config says
+	fmt.Println("Service: not running")
+	config, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	return printJSON(status.Status{
+		Registered:      config.HostID != 0,
+		HostID:          config.HostID,
+		ServerURL:       config.ServerURL,
+		AgentVersion:    collectors.AgentVersion,
+		ProtocolVersion: server.ProtocolVersion,
+	})
+}
+
+func registerCommand(args []string) error {
+	flags := flag.NewFlagSet("register", flag.ExitOnError)
+	force := flags.Bool("force", false, "register again even if a host ID is already saved")
+	flags.Parse(args)
+
+	config, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	if config.HostID != 0 && !*force {
+		return fmt.Errorf("already registered as host %d, use --force to register again", config.HostID)
+	}
+
+	HostID, _, err := register(config)
+	if err != nil {
+		return err
+	}
+	if HostID == 0 {
+		return fmt.Errorf("server returned no host ID")
+	}
+
+	config.HostID = HostID
+	if err := saveConfig(config); err != nil {
+		return err
+	}
+	fmt.Printf("Registered as host %d. Restart the SlateNexusAgent service to use it.\n", HostID)
+	return nil
+}
+
+func testConnectionCommand(args []string) error {
+	config, err := loadConfig()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Connecting to %s...\n", config.ServerURL)
+	elapsed, err := server.TestConnection(config.HostID, config.ServerURL, config.APIKey)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("OK (%s)\n", elapsed.Round(time.Millisecond))
+	return nil
+}
+
+func collectCommand(args []string) error {
+	flags := flag.NewFlagSet("collect", flag.ExitOnError)
+	printOnly := flags.Bool("print", false, "print the inventory instead of sending it")
+	flags.Parse(args)
+
+	if *printOnly {
+		data, err := collectors.CollectData()
+		if err != nil {
+			return err
+		}
+		return printJSON(data)
+	}
+
+	config, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	if config.HostID == 0 {
+		return fmt.Errorf("not registered, run the register command first")
+	}
+
+	var inventory collectors.Inventory
+	changed, err := inventory.Changes(true)
+	if err != nil {
+		return err
+	}
+	item, err := server.InventoryReport(config.HostID, changed)
+	if err != nil {
+		return err
+	}
+	if err := server.Send(item, config.ServerURL, config.APIKey); err != nil {
+		return err
+	}
+	fmt.Printf("Inventory sent (%d fields)\n", len(changed))
+	return nil
+}
+
+func unregisterCommand(args []string) error {
+	flags := flag.NewFlagSet("unregister", flag.ExitOnError)
+	local := flags.Bool("local", false, "only clear the saved host ID, without contacting the server")
+	flags.Parse(args)
+
+	config, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	if config.HostID == 0 {
+		return fmt.Errorf("not registered")
+	}
+
+	if !*local {
+		if err := server.Unregister(config.HostID, config.ServerURL, config.APIKey); err != nil {
+			return fmt.Errorf("%w (use --local to only clear the saved host ID)", err)
+		}
+	}
+
+	hostID := config.HostID
+	config.HostID = 0
+	if err := saveConfig(config); err != nil {
+		return err
+	}
+	fmt.Printf("Unregistered host %d. Stop the SlateNexusAgent service or it will register again on restart.\n", hostID)
+	return nil
+}
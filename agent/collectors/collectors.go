@@ -1,15 +1,14 @@
 package collectors
 
 import (
-	"encoding/json"
 	"net"
 	"os"
 	"os/exec"
-	"path/filepath"
 	"runtime"
 	"slate-nexus-agent/logger"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/shirou/gopsutil/cpu"
@@ -18,6 +17,35 @@ import (
 	"github.com/shirou/gopsutil/mem"
 )
 
+// AgentVersion is the version of the agent reported to the server
+const AgentVersion = "1.0.0"
+
+// enabled holds the collectors turned off or on by the server configuration.
+// Collectors missing from it are enabled.
+var (
+	enabledMu sync.RWMutex
+	enabled   = map[string]bool{}
+)
+
+// Configure turns collectors on or off: storage, last_user, services, processes, event_logs and the
+// remote-access providers
+func Configure(collectors map[string]bool) {
+	enabledMu.Lock()
+	defer enabledMu.Unlock()
+	enabled = make(map[string]bool, len(collectors))
+	for name, on := range collectors {
+		enabled[name] = on
+	}
+}
+
+// Enabled reports whether a collector, or the collection of the event logs, should run
+func Enabled(name string) bool {
+	enabledMu.RLock()
+	defer enabledMu.RUnlock()
+	on, ok := enabled[name]
+	return !ok || on
+}
+
 type Hardware struct {
 	CPU       string `json:"cpu"`
 	Memory    string `json:"memory"`
@@ -28,7 +56,7 @@ type Hardware struct {
 }
 
 type AgentData struct {
-	ID            int32     `json:"id"`
+	ID            int32     `json:"host_id"`
 	Hostname      string    `json:"hostname"`
 	IPAddress     string    `json:"ip_address"`
 	OS            string    `json:"os"`
@@ -36,9 +64,12 @@ type AgentData struct {
 	HardwareSpecs Hardware  `json:"hardware_specs"`
 	AgentVersion  string    `json:"agent_version"`
 	LastSeen      time.Time `json:"last_seen"`
-	LastUser      string    `json:"last_user"`
+	LastUser      string    `json:"last_user,omitempty"`
 	Token         string    `json:"token"`
-	RemotelyID    string    `json:"remotely_id"`
+	// RemotelyID is reported for servers that predate RemoteIDs
+	RemotelyID    string            `json:"remotely_id,omitempty"`
+	RemoteIDs     map[string]string `json:"remote_ids"`
+	EnrollmentKey string            `json:"enrollment_key,omitempty"`
 }
 
 func CollectData() (AgentData, error) {
@@ -50,16 +81,16 @@ func CollectData() (AgentData, error) {
 		return AgentData{}, err
 	}
 
-	// Get Remotely ID
-	remotelyID, err := getRemotelyID()
-	if err != nil {
-		logger.LogError("could not get Remotely ID: %v", err) // Continue with empty Remotely ID if an error occurs
-	}
+	// Get the IDs of the remote-access providers
+	remoteIDs := collectRemoteIDs()
 
 	// Get current user
-	user, err := getCurrentUser()
-	if err != nil {
-		return AgentData{}, err
+	var user string
+	if Enabled("last_user") {
+		user, err = getCurrentUser()
+		if err != nil {
+			return AgentData{}, err
+		}
 	}
 
 	agentData := AgentData{
@@ -68,9 +99,10 @@ func CollectData() (AgentData, error) {
 		OS:            hardware.OS,
 		OSVersion:     hardware.OSVersion,
 		HardwareSpecs: hardware,
-		AgentVersion:  "1.0.0",
+		AgentVersion:  AgentVersion,
 		LastSeen:      time.Now(),
-		RemotelyID:    remotelyID,
+		RemotelyID:    remoteIDs["remotely"],
+		RemoteIDs:     remoteIDs,
 	}
 
 	// Only update LastUser if user is not empty
@@ -126,8 +158,10 @@ func getHardwareSpecs() (Hardware, error) {
 	hardware.Memory = strconv.FormatUint(memInfo.Total/1024/1024, 10) + "MB"
 
 	// Get disk info (total storage)
-	switch runtime.GOOS {
-	case "windows":
+	switch {
+	case !Enabled("storage"):
+		// Enumerating partitions is slow on hosts with many drives
+	case runtime.GOOS == "windows":
 		partitions, err := disk.Partitions(false)
 		if err != nil {
 			logger.LogError("Error getting disk partitions: %v", err)
@@ -175,22 +209,3 @@ func getCurrentUser() (string, error) {
 
 	return strings.TrimSpace(string(output)), nil
 }
-
-func getRemotelyID() (string, error) {
-	filePath := filepath.Join(os.Getenv("ProgramFiles"), "Remotely\\ConnectionInfo.json")
-	data, err := os.ReadFile(filePath)
-	if err != nil {
-		return "", err
-	}
-
-	var connectionInfo struct {
-		DeviceID string `json:"DeviceID"`
-	}
-
-	err = json.Unmarshal(data, &connectionInfo)
-	if err != nil {
-		return "", err
-	}
-
-	return connectionInfo.DeviceID, nil
-}
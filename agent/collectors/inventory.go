@@ -0,0 +1,85 @@
+package collectors
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"time"
+)
+
+// volatileFields are not part of the inventory: they change on every collection
+// or are only meaningful at registration
+var volatileFields = []string{"host_id", "last_seen", "token", "enrollment_key"}
+
+// Inventory remembers the inventory last accepted by the server so that the agent
+// only collects and sends what changed
+type Inventory struct {
+	reported   map[string]json.RawMessage
+	hash       [sha256.Size]byte
+	reportedAt time.Time
+}
+
+// Changes collects the inventory and returns the fields that differ from the last report.
+// Every field is returned when full is true or nothing has been reported yet, and
+// nothing is returned when the inventory hash is unchanged.
+func (inv *Inventory) Changes(full bool) (map[string]json.RawMessage, error) {
+	data, err := CollectData()
+	if err != nil {
+		return nil, err
+	}
+
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return nil, err
+	}
+	for _, name := range volatileFields {
+		delete(fields, name)
+	}
+
+	// Maps are marshalled with sorted keys, so equal inventories hash the same
+	canonical, err := json.Marshal(fields)
+	if err != nil {
+		return nil, err
+	}
+	hash := sha256.Sum256(canonical)
+
+	if full || inv.reported == nil {
+		return fields, nil
+	}
+	if hash == inv.hash {
+		return nil, nil
+	}
+
+	changed := make(map[string]json.RawMessage)
+	for name, value := range fields {
+		if previous, ok := inv.reported[name]; !ok || string(previous) != string(value) {
+			changed[name] = value
+		}
+	}
+	if len(changed) == 0 {
+		return nil, nil
+	}
+	return changed, nil
+}
+
+// Reported records that the server accepted fields
+func (inv *Inventory) Reported(fields map[string]json.RawMessage) {
+	if inv.reported == nil {
+		inv.reported = make(map[string]json.RawMessage)
+	}
+	for name, value := range fields {
+		inv.reported[name] = value
+	}
+
+	canonical, _ := json.Marshal(inv.reported)
+	inv.hash = sha256.Sum256(canonical)
+	inv.reportedAt = time.Now()
+}
+
+// ReportedAt returns when the server last accepted an inventory report
+func (inv *Inventory) ReportedAt() time.Time {
+	return inv.reportedAt
+}
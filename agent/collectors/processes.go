@@ -0,0 +1,67 @@
+package collectors
+
+import (
+	"math"
+	"runtime"
+	"sort"
+	"time"
+
+	"github.com/shirou/gopsutil/process"
+)
+
+// processSampleInterval is how long the CPU time of the processes is measured for
+const processSampleInterval = time.Second
+
+// Process is a process running on the host. CPU is the share of all the processors it used
+// while sampled, in percent; Memory is its working set in bytes.
+type Process struct {
+	PID    int32   `json:"pid"`
+	Name   string  `json:"name"`
+	CPU    float64 `json:"cpu_percent"`
+	Memory uint64  `json:"memory_bytes"`
+	User   string  `json:"user,omitempty"`
+}
+
+// CollectProcesses returns the processes running on the host sorted by PID. It takes
+// processSampleInterval to measure their CPU usage. Processes that exit meanwhile are
+// skipped, and the user or usage of a process that cannot be read is left empty.
+func CollectProcesses() ([]Process, error) {
+	if !Enabled("processes") {
+		return nil, ErrDisabled
+	}
+
+	procs, err := process.Processes()
+	if err != nil {
+		return nil, err
+	}
+	before := make(map[int32]float64, len(procs))
+	for _, p := range procs {
+		if times, err := p.Times(); err == nil {
+			before[p.Pid] = times.User + times.System
+		}
+	}
+	start := time.Now()
+	time.Sleep(processSampleInterval)
+	capacity := time.Since(start).Seconds() * float64(runtime.NumCPU())
+
+	processes := make([]Process, 0, len(procs))
+	for _, p := range procs {
+		name, err := p.Name()
+		if err != nil {
+			continue
+		}
+		proc := Process{PID: p.Pid, Name: name}
+		if times, err := p.Times(); err == nil {
+			if used, ok := before[p.Pid]; ok {
+				proc.CPU = math.Round((times.User+times.System-used)/capacity*1000) / 10
+			}
+		}
+		if memory, err := p.MemoryInfo(); err == nil {
+			proc.Memory = memory.RSS
+		}
+		proc.User, _ = p.Username()
+		processes = append(processes, proc)
+	}
+	sort.Slice(processes, func(i, j int) bool { return processes[i].PID < processes[j].PID })
+	return processes, nil
+}
@@ -0,0 +1,71 @@
+package collectors
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slate-nexus-agent/logger"
+)
+
+// RemoteAccessCollector finds the ID a remote-access provider installed on the host knows
+// it by. Each collector is named after its provider, which is also the name that turns it
+// off in a configuration profile.
+type RemoteAccessCollector interface {
+	// Provider is the name of the provider, matching its name on the server
+	Provider() string
+	// DeviceID returns the ID of the host, or "" if the provider is not installed
+	DeviceID() (string, error)
+}
+
+// remoteAccessCollectors are the providers the agent looks for
+var remoteAccessCollectors = []RemoteAccessCollector{
+	remotelyCollector{},
+}
+
+// collectRemoteIDs returns the device IDs of the enabled remote-access providers found on
+// the host, keyed by provider
+func collectRemoteIDs() map[string]string {
+	ids := map[string]string{}
+	for _, collector := range remoteAccessCollectors {
+		if !Enabled(collector.Provider()) {
+			continue
+		}
+		id, err := collector.DeviceID()
+		if err != nil {
+			// Continue without the ID if an error occurs
+			logger.LogError("could not get %s device ID: %v", collector.Provider(), err)
+			continue
+		}
+		if id != "" {
+			ids[collector.Provider()] = id
+		}
+	}
+	return ids
+}
+
+// remotelyCollector reads the device ID from the connection info of the Remotely agent
+type remotelyCollector struct{}
+
+func (remotelyCollector) Provider() string { return "remotely" }
+
+func (remotelyCollector) DeviceID() (string, error) {
+	filePath := filepath.Join(os.Getenv("ProgramFiles"), "Remotely\\ConnectionInfo.json")
+	data, err := os.ReadFile(filePath)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	var connectionInfo struct {
+		DeviceID string `json:"DeviceID"`
+	}
+
+	err = json.Unmarshal(data, &connectionInfo)
+	if err != nil {
+		return "", err
+	}
+
+	return connectionInfo.DeviceID, nil
+}
@@ -0,0 +1,121 @@
+package collectors
+
+import (
+	"errors"
+	"sort"
+
+	"golang.org/x/sys/windows"
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+// ErrDisabled is returned by the collectors run on demand when the configuration profile
+// turns them off
+var ErrDisabled = errors.New("collector is turned off by the configuration profile")
+
+// Service is a Windows service installed on the host
+type Service struct {
+	Name        string `json:"name"`
+	DisplayName string `json:"display_name"`
+	State       string `json:"state"`
+	StartType   string `json:"start_type"`
+	PID         uint32 `json:"pid,omitempty"`
+}
+
+// serviceStates names the states of the services
+var serviceStates = map[svc.State]string{
+	svc.Stopped:         "stopped",
+	svc.StartPending:    "start_pending",
+	svc.StopPending:     "stop_pending",
+	svc.Running:         "running",
+	svc.ContinuePending: "continue_pending",
+	svc.PausePending:    "pause_pending",
+	svc.Paused:          "paused",
+}
+
+// CollectServices returns the services of the host sorted by name. Services that cannot be
+// queried are skipped.
+func CollectServices() ([]Service, error) {
+	if !Enabled("services") {
+		return nil, ErrDisabled
+	}
+
+	m, err := mgr.Connect()
+	if err != nil {
+		return nil, err
+	}
+	defer m.Disconnect()
+
+	names, err := m.ListServices()
+	if err != nil {
+		return nil, err
+	}
+	services := make([]Service, 0, len(names))
+	for _, name := range names {
+		service, err := queryService(m, name)
+		if err != nil {
+			continue
+		}
+		services = append(services, service)
+	}
+	sort.Slice(services, func(i, j int) bool { return services[i].Name < services[j].Name })
+	return services, nil
+}
+
+// QueryService returns a single service of the host. It is not turned off by the
+// configuration profile, as the service checks rely on it.
+func QueryService(name string) (Service, error) {
+	m, err := mgr.Connect()
+	if err != nil {
+		return Service{}, err
+	}
+	defer m.Disconnect()
+
+	return queryService(m, name)
+}
+
+// queryService returns the state and configuration of a service
+func queryService(m *mgr.Mgr, name string) (Service, error) {
+	s, err := m.OpenService(name)
+	if err != nil {
+		return Service{}, err
+	}
+	defer s.Close()
+
+	status, err := s.Query()
+	if err != nil {
+		return Service{}, err
+	}
+	config, err := s.Config()
+	if err != nil {
+		return Service{}, err
+	}
+	return Service{
+		Name:        name,
+		DisplayName: config.DisplayName,
+		State:       serviceStates[status.State],
+		StartType:   startType(config),
+		PID:         status.ProcessId,
+	}, nil
+}
+
+// startType names how a service is started
+func startType(config mgr.Config) string {
+	switch config.StartType {
+	case mgr.StartAutomatic:
+		if config.DelayedAutoStart {
+			return "automatic_delayed"
+		}
+		return "automatic"
+	case mgr.StartManual:
+		return "manual"
+	case mgr.StartDisabled:
+		return "disabled"
+	case windows.SERVICE_BOOT_START:
+		return "boot"
+	case windows.SERVICE_SYSTEM_START:
+		return "system"
+	default:
+		return "unknown"
+	}
+}
@@ -0,0 +1,156 @@
+// Package eventlog collects the entries of the event logs of the host: the Windows event log
+// channels, and the journal and syslog files on Linux. A bookmark per log, saved once the
+// entries are handed over, keeps an entry from being collected twice, including across
+// restarts of the agent. The first collection of a log starts at its end.
+package eventlog
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"slate-nexus-agent/logger"
+	"strings"
+	"time"
+)
+
+// Levels of the entries, from the most severe
+const (
+	Critical    = "critical"
+	Error       = "error"
+	Warning     = "warning"
+	Information = "information"
+	Verbose     = "verbose"
+)
+
+// maxMessage limits the message of an entry, as the server does
+const maxMessage = 8192
+
+// Source is a log to collect entries from: the Windows event log channel named by Name
+// (type windows), the journal of the systemd unit named by Name or of every unit without
+// one (type journald), or the syslog file at the path Name (type file). Only the entries with
+// one of Levels and one of EventIDs are collected when they are set.
+type Source struct {
+	Type     string   `json:"type"`
+	Name     string   `json:"name"`
+	Levels   []string `json:"levels"`
+	EventIDs []int    `json:"event_ids"`
+}
+
+// key identifies the bookmark of the source
+func (s Source) key() string {
+	return s.Type + ":" + s.Name
+}
+
+// Entry is an entry of a log. Source is the channel, journal or file it was read from and
+// RecordKey its position there. EventCode is the event ID of Windows entries.
+type Entry struct {
+	Source    string    `json:"source"`
+	RecordKey string    `json:"record_key"`
+	Provider  string    `json:"provider"`
+	EventCode *int      `json:"event_code,omitempty"`
+	Level     string    `json:"level"`
+	Message   string    `json:"message"`
+	LoggedAt  time.Time `json:"logged_at"`
+}
+
+// reader reads at most max entries of a source logged after a bookmark, and returns them with
+// the bookmark to read from next. Without a bookmark it returns no entries and the bookmark
+// of the end of the log.
+type reader func(source Source, bookmark string, max int) ([]Entry, string, error)
+
+// readers holds the types of sources supported on this system, registered from the init
+// functions of this package
+var readers = map[string]reader{}
+
+// Collector collects the entries of the configured sources after their bookmarks
+type Collector struct {
+	path      string
+	sources   []Source
+	bookmarks map[string]string
+	// pending holds the bookmarks after the entries collected but not committed yet
+	pending map[string]string
+}
+
+// NewCollector returns a collector keeping its bookmarks in the file at path. A missing or
+// unreadable file starts every log over from its end.
+func NewCollector(path string) *Collector {
+	c := &Collector{path: path, bookmarks: map[string]string{}, pending: map[string]string{}}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return c
+	}
+	if err == nil {
+		err = json.Unmarshal(data, &c.bookmarks)
+	}
+	if err != nil {
+		logger.LogWarn("could not read event log bookmarks, collecting from now on: %v", err)
+		c.bookmarks = map[string]string{}
+	}
+	return c
+}
+
+// Configure sets the sources to collect. Sources of a type this system does not support are
+// skipped, and the bookmarks of the sources no longer collected are dropped.
+func (c *Collector) Configure(sources []Source) {
+	c.sources = c.sources[:0]
+	keys := map[string]bool{}
+	for _, source := range sources {
+		if _, ok := readers[source.Type]; !ok {
+			continue
+		}
+		c.sources = append(c.sources, source)
+		keys[source.key()] = true
+	}
+	for key := range c.bookmarks {
+		if !keys[key] {
+			delete(c.bookmarks, key)
+		}
+	}
+}
+
+// Collect returns at most max entries logged after the bookmarks. The bookmarks only move on
+// once Commit is called, after the entries are handed over; until then the entries are
+// collected again. A source that cannot be read is logged and skipped.
+func (c *Collector) Collect(max int) []Entry {
+	c.pending = map[string]string{}
+	var entries []Entry
+	for _, source := range c.sources {
+		if len(entries) >= max {
+			break
+		}
+		key := source.key()
+		bookmark := c.bookmarks[key]
+
+		read, next, err := readers[source.Type](source, bookmark, max-len(entries))
+		if err != nil {
+			logger.LogError("could not read the %s event log %s: %v", source.Type, source.Name, err)
+		}
+		for i := range read {
+			if len(read[i].Message) > maxMessage {
+				read[i].Message = strings.ToValidUTF8(read[i].Message[:maxMessage], "")
+			}
+		}
+		entries = append(entries, read...)
+		if next != bookmark {
+			c.pending[key] = next
+		}
+	}
+	return entries
+}
+
+// Commit moves the bookmarks past the entries collected and saves them
+func (c *Collector) Commit() error {
+	if len(c.pending) == 0 {
+		return nil
+	}
+	for key, bookmark := range c.pending {
+		c.bookmarks[key] = bookmark
+	}
+	c.pending = map[string]string{}
+
+	data, err := json.Marshal(c.bookmarks)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(c.path, data, 0600)
+}
@@ -0,0 +1,179 @@
+package eventlog
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// queryTimeout bounds a query of wevtutil
+const queryTimeout = time.Minute
+
+// windowsLevels are the values of the Level element of the events of each level. Events
+// logged with level 0 (LogAlways) are shown as information by the Event Viewer.
+var windowsLevels = map[string][]int{
+	Critical:    {1},
+	Error:       {2},
+	Warning:     {3},
+	Information: {0, 4},
+	Verbose:     {5},
+}
+
+// levelNames names the values of the Level element
+var levelNames = map[int]string{0: Information, 1: Critical, 2: Error, 3: Warning, 4: Information, 5: Verbose}
+
+// event is an event as rendered by wevtutil with /f:RenderedXml
+type event struct {
+	System struct {
+		Provider struct {
+			Name string `xml:"Name,attr"`
+		} `xml:"Provider"`
+		EventID     int `xml:"EventID"`
+		Level       int `xml:"Level"`
+		TimeCreated struct {
+			SystemTime string `xml:"SystemTime,attr"`
+		} `xml:"TimeCreated"`
+		EventRecordID uint64 `xml:"EventRecordID"`
+	} `xml:"System"`
+	EventData struct {
+		Data []string `xml:"Data"`
+	} `xml:"EventData"`
+	RenderingInfo struct {
+		Message string `xml:"Message"`
+	} `xml:"RenderingInfo"`
+}
+
+func init() {
+	readers["windows"] = readChannel
+}
+
+// readChannel reads the events of a Windows event log channel. The bookmark is the record ID
+// of the last event read; when the channel was cleared since, it starts over.
+func readChannel(source Source, bookmark string, max int) ([]Entry, string, error) {
+	newest, err := newestRecord(source.Name)
+	if err != nil {
+		return nil, bookmark, err
+	}
+	after, err := strconv.ParseUint(bookmark, 10, 64)
+	if err != nil {
+		return nil, strconv.FormatUint(newest, 10), nil
+	}
+	if newest < after {
+		// The channel was cleared: every event in it is new
+		after = 0
+	}
+	if newest == after {
+		return nil, bookmark, nil
+	}
+
+	// Events logged after newest are left to the next read, which starts at newest unless
+	// the read stops short of it
+	events, err := queryEvents(source.Name, channelQuery(source, after, newest), max)
+	if err != nil {
+		return nil, bookmark, err
+	}
+	next := newest
+	if len(events) == max {
+		next = events[len(events)-1].System.EventRecordID
+	}
+
+	entries := make([]Entry, 0, len(events))
+	for _, ev := range events {
+		code := ev.System.EventID
+		message := strings.TrimSpace(ev.RenderingInfo.Message)
+		if message == "" {
+			// Events of providers without their message resources installed
+			message = strings.Join(ev.EventData.Data, "; ")
+		}
+		loggedAt, err := time.Parse(time.RFC3339, ev.System.TimeCreated.SystemTime)
+		if err != nil {
+			loggedAt = time.Now()
+		}
+		level, ok := levelNames[ev.System.Level]
+		if !ok {
+			level = Verbose
+		}
+		entries = append(entries, Entry{
+			Source:    source.Name,
+			RecordKey: strconv.FormatUint(ev.System.EventRecordID, 10),
+			Provider:  ev.System.Provider.Name,
+			EventCode: &code,
+			Level:     level,
+			Message:   message,
+			LoggedAt:  loggedAt,
+		})
+	}
+	return entries, strconv.FormatUint(next, 10), nil
+}
+
+// channelQuery returns the XPath query selecting the events of a source with a record ID in
+// (after, upTo]
+func channelQuery(source Source, after, upTo uint64) string {
+	conditions := []string{fmt.Sprintf("EventRecordID > %d and EventRecordID <= %d", after, upTo)}
+	if len(source.Levels) > 0 {
+		var levels []string
+		for _, name := range source.Levels {
+			for _, level := range windowsLevels[name] {
+				levels = append(levels, fmt.Sprintf("Level=%d", level))
+			}
+		}
+		conditions = append(conditions, "("+strings.Join(levels, " or ")+")")
+	}
+	if len(source.EventIDs) > 0 {
+		ids := make([]string, 0, len(source.EventIDs))
+		for _, id := range source.EventIDs {
+			ids = append(ids, fmt.Sprintf("EventID=%d", id))
+		}
+		conditions = append(conditions, "("+strings.Join(ids, " or ")+")")
+	}
+	return "*[System[" + strings.Join(conditions, " and ") + "]]"
+}
+
+// newestRecord returns the record ID of the newest event of a channel, 0 if it is empty
+func newestRecord(channel string) (uint64, error) {
+	events, err := wevtutil("qe", channel, "/rd:true", "/c:1", "/f:xml")
+	if err != nil || len(events) == 0 {
+		return 0, err
+	}
+	return events[0].System.EventRecordID, nil
+}
+
+// queryEvents returns at most max events of a channel selected by an XPath query, oldest first
+func queryEvents(channel, query string, max int) ([]event, error) {
+	return wevtutil("qe", channel, "/q:"+query, "/f:RenderedXml", fmt.Sprintf("/c:%d", max))
+}
+
+// wevtutil runs wevtutil and parses the events it writes
+func wevtutil(args ...string) ([]event, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+	defer cancel()
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "wevtutil", args...)
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	// Messages may not be valid UTF-8 in the code page wevtutil writes with
+	decoder := xml.NewDecoder(strings.NewReader(strings.ToValidUTF8(string(output), "�")))
+	var events []event
+	for {
+		var ev event
+		err := decoder.Decode(&ev)
+		if err == io.EOF {
+			return events, nil
+		}
+		if err != nil {
+			return events, err
+		}
+		events = append(events, ev)
+	}
+}
@@ -0,0 +1,119 @@
+package eventlog
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// maxLine limits the lines read from a syslog file; longer lines are cut
+const maxLine = 64 * 1024
+
+// bsdTimestamp is the timestamp of the traditional syslog format, without a year
+const bsdTimestamp = "Jan _2 15:04:05"
+
+func init() {
+	readers["file"] = readFile
+}
+
+// readFile reads the lines of a syslog file. The bookmark is the inode of the file and the
+// offset after the last line read. A file replaced by its rotation or truncated is read from
+// its start; lines written to the rotated file after the last read are not collected.
+func readFile(source Source, bookmark string, max int) ([]Entry, string, error) {
+	f, err := os.Open(source.Name)
+	if err != nil {
+		return nil, bookmark, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, bookmark, err
+	}
+	var inode uint64
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		inode = stat.Ino
+	}
+
+	var bookmarkInode uint64
+	var offset int64
+	if _, err := fmt.Sscanf(bookmark, "%d:%d", &bookmarkInode, &offset); err != nil {
+		return nil, fmt.Sprintf("%d:%d", inode, info.Size()), nil
+	}
+	if bookmarkInode != inode || info.Size() < offset {
+		offset = 0
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return nil, bookmark, err
+	}
+
+	var entries []Entry
+	r := bufio.NewReaderSize(f, maxLine)
+	for len(entries) < max {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			// A line without its end is still being written and read next time
+			break
+		}
+		start := offset
+		offset += int64(len(line))
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			continue
+		}
+
+		loggedAt, provider, message := parseSyslogLine(line)
+		entries = append(entries, Entry{
+			Source:    source.Name,
+			RecordKey: fmt.Sprintf("%d:%d", inode, start),
+			Provider:  provider,
+			Level:     Information,
+			Message:   message,
+			LoggedAt:  loggedAt,
+		})
+	}
+	return entries, fmt.Sprintf("%d:%d", inode, offset), nil
+}
+
+// parseSyslogLine splits a line of a syslog file into its time, tag and message. Lines start
+// with an RFC 3339 or a traditional timestamp and the hostname, followed by the tag, e.g.
+// "Oct 19 14:03:51 host sshd[812]: Accepted publickey for root". Lines in another format
+// are kept whole, logged now.
+func parseSyslogLine(line string) (time.Time, string, string) {
+	now := time.Now()
+	var loggedAt time.Time
+	var rest string
+	if first, after, ok := strings.Cut(line, " "); ok {
+		if t, err := time.Parse(time.RFC3339, first); err == nil {
+			loggedAt, rest = t, after
+		}
+	}
+	if loggedAt.IsZero() && len(line) > len(bsdTimestamp) {
+		if t, err := time.ParseInLocation(bsdTimestamp, line[:len(bsdTimestamp)], time.Local); err == nil {
+			// The year is the current one, unless that puts the line in the future
+			loggedAt = t.AddDate(now.Year(), 0, 0)
+			if loggedAt.After(now.Add(24 * time.Hour)) {
+				loggedAt = loggedAt.AddDate(-1, 0, 0)
+			}
+			rest = line[len(bsdTimestamp)+1:]
+		}
+	}
+	if loggedAt.IsZero() {
+		return now, "", line
+	}
+
+	// The hostname, then the tag up to its colon
+	_, rest, _ = strings.Cut(rest, " ")
+	tag, message, ok := strings.Cut(rest, ": ")
+	if !ok || strings.Contains(tag, " ") {
+		return loggedAt, "", rest
+	}
+	if i := strings.IndexByte(tag, '['); i > 0 {
+		tag = tag[:i]
+	}
+	return loggedAt, tag, message
+}
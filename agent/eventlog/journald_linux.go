@@ -0,0 +1,151 @@
+package eventlog
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// journalLevels are the syslog priorities of the entries of each level
+var journalLevels = map[string][]int{
+	Critical:    {0, 1, 2},
+	Error:       {3},
+	Warning:     {4},
+	Information: {5, 6},
+	Verbose:     {7},
+}
+
+// priorityNames names the syslog priorities
+var priorityNames = map[int]string{0: Critical, 1: Critical, 2: Critical, 3: Error, 4: Warning, 5: Information, 6: Information, 7: Verbose}
+
+func init() {
+	readers["journald"] = readJournal
+}
+
+// readJournal reads the entries of the journal, of a single unit if the source names one.
+// The bookmark is the cursor of the last entry read; a cursor the journal no longer has,
+// once it was vacuumed, starts over from its end.
+func readJournal(source Source, bookmark string, max int) ([]Entry, string, error) {
+	if bookmark == "" {
+		cursor, err := journalEnd()
+		return nil, cursor, err
+	}
+
+	args := []string{"--output=json", "--no-pager", "--after-cursor=" + bookmark}
+	if source.Name != "" {
+		args = append(args, "--unit="+source.Name)
+	}
+	// Only the entries at least as severe as the least severe level are read
+	priorities := map[int]bool{}
+	for _, level := range source.Levels {
+		for _, priority := range journalLevels[level] {
+			priorities[priority] = true
+		}
+	}
+	if len(priorities) > 0 {
+		least := 0
+		for priority := range priorities {
+			if priority > least {
+				least = priority
+			}
+		}
+		args = append(args, fmt.Sprintf("--priority=%d", least))
+	}
+
+	cmd := exec.Command("journalctl", args...)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, bookmark, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, bookmark, err
+	}
+
+	label := "journald"
+	if source.Name != "" {
+		label += "/" + source.Name
+	}
+	var entries []Entry
+	next := bookmark
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for len(entries) < max && scanner.Scan() {
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(scanner.Bytes(), &fields); err != nil {
+			continue
+		}
+		next = journalString(fields["__CURSOR"])
+		priority, err := strconv.Atoi(journalString(fields["PRIORITY"]))
+		if err != nil {
+			priority = 6
+		}
+		if len(priorities) > 0 && !priorities[priority] {
+			continue
+		}
+
+		provider := journalString(fields["SYSLOG_IDENTIFIER"])
+		if provider == "" {
+			provider = journalString(fields["_COMM"])
+		}
+		loggedAt := time.Now()
+		if usec, err := strconv.ParseInt(journalString(fields["__REALTIME_TIMESTAMP"]), 10, 64); err == nil {
+			loggedAt = time.UnixMicro(usec)
+		}
+		entries = append(entries, Entry{
+			Source:    label,
+			RecordKey: next,
+			Provider:  provider,
+			Level:     priorityNames[priority],
+			Message:   journalString(fields["MESSAGE"]),
+			LoggedAt:  loggedAt,
+		})
+	}
+
+	// journalctl is stopped once enough entries are read
+	cmd.Process.Kill()
+	if err := cmd.Wait(); err != nil && next == bookmark && cmd.ProcessState.ExitCode() > 0 {
+		// The cursor is gone: start over from the end of the journal
+		cursor, endErr := journalEnd()
+		if endErr != nil {
+			return nil, bookmark, endErr
+		}
+		return nil, cursor, fmt.Errorf("journalctl failed, collecting from now on: %v", err)
+	}
+	return entries, next, nil
+}
+
+// journalEnd returns the cursor of the last entry of the journal
+func journalEnd() (string, error) {
+	output, err := exec.Command("journalctl", "--output=json", "--no-pager", "--lines=1").Output()
+	if err != nil || len(bytes.TrimSpace(output)) == 0 {
+		return "", err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(output, &fields); err != nil {
+		return "", err
+	}
+	return journalString(fields["__CURSOR"]), nil
+}
+
+// journalString returns a field of a journal entry. Fields that are not valid UTF-8 are
+// written by journalctl as arrays of bytes.
+func journalString(raw json.RawMessage) string {
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return s
+	}
+	var b []int
+	if err := json.Unmarshal(raw, &b); err == nil {
+		buf := make([]byte, len(b))
+		for i, c := range b {
+			buf[i] = byte(c)
+		}
+		return strings.ToValidUTF8(string(buf), "�")
+	}
+	return ""
+}
@@ -0,0 +1,286 @@
+// Package files serves the file browser of the dashboard. The agent connects back to the
+// server for each file session and answers its requests: listing directories, reading the
+// chunks of the files downloaded and writing the chunks of the files uploaded, each with
+// its SHA-256 checksum.
+package files
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slate-nexus-agent/logger"
+	"time"
+
+	"golang.org/x/net/websocket"
+	"golang.org/x/sys/windows"
+)
+
+// Limits of the transfers, which the server keeps within as well
+const (
+	// maxChunkSize limits the chunks read or written with one request
+	maxChunkSize = 1024 * 1024
+	// maxUploadSize limits the size of the files uploaded
+	maxUploadSize = 2 << 30
+	// maxMessageSize limits the requests received from the server, which carry a chunk
+	// encoded in base64
+	maxMessageSize = 2 * maxChunkSize
+	// maxListEntries limits the entries of a directory listed with one request, so that the
+	// listing fits in the messages the server receives
+	maxListEntries = 1000
+)
+
+// partialSuffix names the file an upload is written to until it completes, so that an
+// interrupted upload never leaves a truncated file in place and can resume
+const partialSuffix = ".nexus-upload"
+
+// Operations requested by the server
+const (
+	opList     = "list"
+	opStat     = "stat"
+	opRead     = "read"
+	opWrite    = "write"
+	opUploaded = "uploaded"
+	opPing     = "ping"
+)
+
+// request is received from the server over the file connection
+type request struct {
+	ID     int64  `json:"id"`
+	Op     string `json:"op"`
+	Path   string `json:"path"`
+	Offset int64  `json:"offset,omitempty"`
+	Length int    `json:"length,omitempty"`
+	Size   int64  `json:"size,omitempty"`
+	Data   []byte `json:"data,omitempty"`
+	SHA256 string `json:"sha256,omitempty"`
+}
+
+// response answers the request of the same ID
+type response struct {
+	ID      int64   `json:"id"`
+	Error   string  `json:"error,omitempty"`
+	Entries []entry `json:"entries,omitempty"`
+	Total   int     `json:"total,omitempty"`
+	Entry   *entry  `json:"entry,omitempty"`
+	Data    []byte  `json:"data,omitempty"`
+	SHA256  string  `json:"sha256,omitempty"`
+	Size    int64   `json:"size,omitempty"`
+}
+
+// entry is a file, directory or drive
+type entry struct {
+	Name    string    `json:"name"`
+	Path    string    `json:"path"`
+	Dir     bool      `json:"dir"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+}
+
+// Serve answers the requests of the server over conn until the server closes it
+func Serve(conn *websocket.Conn) {
+	defer conn.Close()
+	conn.MaxPayloadBytes = maxMessageSize
+
+	for {
+		var req request
+		if err := websocket.JSON.Receive(conn, &req); err != nil {
+			return
+		}
+		if req.Op == opPing {
+			continue
+		}
+
+		resp, err := handle(req)
+		resp.ID = req.ID
+		if err != nil {
+			resp.Error = err.Error()
+		}
+		if err := websocket.JSON.Send(conn, resp); err != nil {
+			logger.LogError("could not answer file request: %v", err)
+			return
+		}
+	}
+}
+
+// handle runs a request
+func handle(req request) (response, error) {
+	switch req.Op {
+	case opList:
+		if req.Path == "" {
+			entries, err := listDrives()
+			return response{Entries: entries, Total: len(entries)}, err
+		}
+		entries, total, err := listDir(req.Path, int(req.Offset), req.Length)
+		return response{Entries: entries, Total: total}, err
+	case opStat:
+		info, err := os.Stat(req.Path)
+		if err != nil {
+			return response{}, err
+		}
+		e := newEntry(req.Path, info)
+		return response{Entry: &e}, nil
+	case opRead:
+		data, err := readChunk(req.Path, req.Offset, req.Length)
+		return response{Data: data, SHA256: checksum(data)}, err
+	case opWrite:
+		received, err := writeChunk(req)
+		return response{Size: received}, err
+	case opUploaded:
+		received, err := uploaded(req.Path, req.Size)
+		return response{Size: received}, err
+	default:
+		return response{}, fmt.Errorf("unsupported file operation: %s", req.Op)
+	}
+}
+
+// listDrives returns the drives of the host
+func listDrives() ([]entry, error) {
+	mask, err := windows.GetLogicalDrives()
+	if err != nil {
+		return nil, err
+	}
+	var entries []entry
+	for i := 0; i < 26; i++ {
+		if mask&(1<<i) == 0 {
+			continue
+		}
+		name := string(rune('A'+i)) + `:\`
+		entries = append(entries, entry{Name: name, Path: name, Dir: true})
+	}
+	return entries, nil
+}
+
+// listDir returns at most limit entries of a directory, sorted by name, from the entry at
+// offset, and the number of entries of the directory. Entries that cannot be read are listed
+// without their size.
+func listDir(path string, offset, limit int) ([]entry, int, error) {
+	dirEntries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	total := len(dirEntries)
+	if limit <= 0 || limit > maxListEntries {
+		limit = maxListEntries
+	}
+	offset = min(max(offset, 0), total)
+	dirEntries = dirEntries[offset:min(offset+limit, total)]
+
+	entries := make([]entry, 0, len(dirEntries))
+	for _, dirEntry := range dirEntries {
+		entryPath := filepath.Join(path, dirEntry.Name())
+		info, err := dirEntry.Info()
+		if err != nil {
+			entries = append(entries, entry{Name: dirEntry.Name(), Path: entryPath, Dir: dirEntry.IsDir()})
+			continue
+		}
+		entries = append(entries, newEntry(entryPath, info))
+	}
+	return entries, total, nil
+}
+
+// newEntry returns the entry of a file
+func newEntry(path string, info os.FileInfo) entry {
+	return entry{
+		Name:    info.Name(),
+		Path:    path,
+		Dir:     info.IsDir(),
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+	}
+}
+
+// readChunk reads up to length bytes of a file from offset
+func readChunk(path string, offset int64, length int) ([]byte, error) {
+	if offset < 0 || length <= 0 || length > maxChunkSize {
+		return nil, fmt.Errorf("invalid chunk of %d bytes at %d", length, offset)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	data := make([]byte, length)
+	n, err := file.ReadAt(data, offset)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	return data[:n], nil
+}
+
+// writeChunk writes a chunk of an upload to its partial file, which must hold everything
+// before the chunk, and moves the file in place once the upload is complete. It returns
+// how much of the upload has been received.
+func writeChunk(req request) (int64, error) {
+	if req.Size < 0 || req.Size > maxUploadSize {
+		return 0, fmt.Errorf("uploads are limited to %d bytes", maxUploadSize)
+	}
+	if len(req.Data) > maxChunkSize || req.Offset < 0 || req.Offset+int64(len(req.Data)) > req.Size {
+		return 0, errors.New("the chunk does not fit the upload")
+	}
+	if checksum(req.Data) != req.SHA256 {
+		return 0, errors.New("the checksum of the chunk does not match")
+	}
+
+	partial := req.Path + partialSuffix
+	flags := os.O_WRONLY | os.O_CREATE
+	if req.Offset == 0 {
+		flags |= os.O_TRUNC
+	}
+	file, err := os.OpenFile(partial, flags, 0o644)
+	if err != nil {
+		return 0, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return 0, err
+	}
+	if info.Size() != req.Offset {
+		file.Close()
+		return info.Size(), fmt.Errorf("the upload continues at %d, not %d", info.Size(), req.Offset)
+	}
+	if _, err := file.WriteAt(req.Data, req.Offset); err != nil {
+		file.Close()
+		return req.Offset, err
+	}
+	if err := file.Close(); err != nil {
+		return req.Offset, err
+	}
+
+	received := req.Offset + int64(len(req.Data))
+	if received == req.Size {
+		if err := os.Rename(partial, req.Path); err != nil {
+			return received, err
+		}
+		logger.LogInfo("Uploaded %s (%d bytes)", req.Path, req.Size)
+	}
+	return received, nil
+}
+
+// uploaded returns how much of the upload of a file of size bytes has been received. A
+// partial file as large as the upload belongs to another file, or could not be moved in
+// place, and starts over.
+func uploaded(path string, size int64) (int64, error) {
+	info, err := os.Stat(path + partialSuffix)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if info.Size() >= size {
+		return 0, nil
+	}
+	return info.Size(), nil
+}
+
+// checksum returns the hex SHA-256 digest of a chunk
+func checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
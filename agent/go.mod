@@ -2,7 +2,10 @@ module slate-nexus-agent
 
 go 1.23.0
 
-require github.com/shirou/gopsutil v3.21.11+incompatible
+require (
+	github.com/shirou/gopsutil v3.21.11+incompatible
+	golang.org/x/net v0.30.0
+)
 
 require (
 	github.com/go-ole/go-ole v1.2.6 // indirect
@@ -10,5 +13,5 @@ require (
 	github.com/tklauser/go-sysconf v0.3.13 // indirect
 	github.com/tklauser/numcpus v0.7.0 // indirect
 	github.com/yusufpapurcu/wmi v1.2.4 // indirect
-	golang.org/x/sys v0.17.0 // direct
+	golang.org/x/sys v0.26.0 // direct
 )
@@ -0,0 +1,165 @@
+package jobs
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/shirou/gopsutil/process"
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+// Actions of a control_service job
+const (
+	ServiceStart   = "start"
+	ServiceStop    = "stop"
+	ServiceRestart = "restart"
+)
+
+// AgentService is the service the agent runs as. It is not stopped or restarted: the agent
+// would stop with it, before starting it again or reporting the job.
+const AgentService = "SlateNexusAgent"
+
+// serviceTimeout bounds how long a service may take to start or stop
+const serviceTimeout = 30 * time.Second
+
+// criticalProcesses are not terminated: Windows stops with a blue screen without them
+var criticalProcesses = []string{"csrss.exe", "lsass.exe", "services.exe", "smss.exe", "wininit.exe"}
+
+// ServiceAction is the payload of a control_service job
+type ServiceAction struct {
+	Name   string `json:"name"`
+	Action string `json:"action"`
+}
+
+// ProcessTermination is the payload of a terminate_process job. The process is only
+// terminated if it still has the name it was listed with, in case its PID was reused.
+type ProcessTermination struct {
+	PID  int32  `json:"pid"`
+	Name string `json:"name"`
+}
+
+// ControlServiceJob starts, stops or restarts the service of a control_service job and
+// waits for it to reach its new state
+func ControlServiceJob(job Job) Result {
+	var action ServiceAction
+	if err := json.Unmarshal(job.Payload, &action); err != nil {
+		return Failed(fmt.Errorf("invalid service payload: %w", err))
+	}
+	if action.Action != ServiceStart && strings.EqualFold(action.Name, AgentService) {
+		return Failed(fmt.Errorf("the %s service cannot be stopped or restarted", AgentService))
+	}
+
+	m, err := mgr.Connect()
+	if err != nil {
+		return Failed(err)
+	}
+	defer m.Disconnect()
+	s, err := m.OpenService(action.Name)
+	if err != nil {
+		return Failed(fmt.Errorf("could not open service %s: %w", action.Name, err))
+	}
+	defer s.Close()
+
+	switch action.Action {
+	case ServiceStart:
+		err = startService(s)
+	case ServiceStop:
+		err = stopService(s)
+	case ServiceRestart:
+		if err = stopService(s); err == nil {
+			err = startService(s)
+		}
+	default:
+		err = fmt.Errorf("unsupported service action: %s", action.Action)
+	}
+	if err != nil {
+		return Failed(fmt.Errorf("could not %s service %s: %w", action.Action, action.Name, err))
+	}
+	return Completed(fmt.Sprintf("service %s: %s done", action.Name, action.Action))
+}
+
+// startService starts a service unless it is running already
+func startService(s *mgr.Service) error {
+	status, err := s.Query()
+	if err != nil {
+		return err
+	}
+	if status.State == svc.Running {
+		return nil
+	}
+	if err := s.Start(); err != nil {
+		return err
+	}
+	return waitService(s, svc.Running)
+}
+
+// stopService stops a service unless it is stopped already
+func stopService(s *mgr.Service) error {
+	status, err := s.Query()
+	if err != nil {
+		return err
+	}
+	if status.State == svc.Stopped {
+		return nil
+	}
+	if _, err := s.Control(svc.Stop); err != nil {
+		return err
+	}
+	return waitService(s, svc.Stopped)
+}
+
+// waitService waits for a service to reach a state within serviceTimeout
+func waitService(s *mgr.Service, state svc.State) error {
+	deadline := time.Now().Add(serviceTimeout)
+	for {
+		status, err := s.Query()
+		if err != nil {
+			return err
+		}
+		if status.State == state {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return errors.New("timed out waiting for the service")
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+}
+
+// TerminateProcessJob terminates the process of a terminate_process job. The agent and the
+// processes Windows cannot run without are refused.
+func TerminateProcessJob(job Job) Result {
+	var target ProcessTermination
+	if err := json.Unmarshal(job.Payload, &target); err != nil {
+		return Failed(fmt.Errorf("invalid process payload: %w", err))
+	}
+	if target.PID <= 4 || int(target.PID) == os.Getpid() {
+		return Failed(fmt.Errorf("process %d cannot be terminated", target.PID))
+	}
+
+	p, err := process.NewProcess(target.PID)
+	if err != nil {
+		return Failed(fmt.Errorf("process %d is not running: %w", target.PID, err))
+	}
+	name, err := p.Name()
+	if err != nil {
+		return Failed(fmt.Errorf("could not get the name of process %d: %w", target.PID, err))
+	}
+	if target.Name != "" && !strings.EqualFold(name, target.Name) {
+		return Failed(fmt.Errorf("process %d is now %s, not %s", target.PID, name, target.Name))
+	}
+	if slices.Contains(criticalProcesses, strings.ToLower(name)) {
+		return Failed(fmt.Errorf("%s is critical to Windows and cannot be terminated", name))
+	}
+
+	if err := p.Kill(); err != nil {
+		return Failed(fmt.Errorf("could not terminate process %d: %w", target.PID, err))
+	}
+	return Completed(fmt.Sprintf("process %d (%s) terminated", target.PID, name))
+}
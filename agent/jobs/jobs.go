@@ -0,0 +1,103 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"time"
+)
+
+// Job types sent by the server
+const (
+	RunScript        = "run_script"
+	RefreshInventory = "refresh_inventory"
+	FetchLogs        = "fetch_logs"
+	OpenShell        = "open_shell"
+	OpenFiles        = "open_files"
+	ListServices     = "list_services"
+	ControlService   = "control_service"
+	ListProcesses    = "list_processes"
+	TerminateProcess = "terminate_process"
+)
+
+// scriptTimeout bounds how long a script job may run
+const scriptTimeout = 10 * time.Minute
+
+// Job is a unit of work queued for the agent by the server
+type Job struct {
+	JobID   int32           `json:"job_id"`
+	JobType string          `json:"job_type"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+// Result is reported back to the server once a job has run
+type Result struct {
+	Status   string `json:"status"`
+	ExitCode int    `json:"exit_code"`
+	Output   string `json:"output"`
+}
+
+// Script is the payload of a run_script job
+type Script struct {
+	ScriptName string `json:"script_name"`
+	Shell      string `json:"shell"`
+	Content    string `json:"content"`
+}
+
+// SessionRequest is the payload of the open_shell and open_files jobs. The agent connects
+// back to the server for the session with the token. The size of the terminal is only set
+// for shells.
+type SessionRequest struct {
+	SessionID int32  `json:"session_id"`
+	Token     string `json:"token"`
+	Cols      int    `json:"cols"`
+	Rows      int    `json:"rows"`
+}
+
+// Completed returns a successful result
+func Completed(output string) Result {
+	return Result{Status: "completed", Output: output}
+}
+
+// Failed returns a failed result for an error
+func Failed(err error) Result {
+	return Result{Status: "failed", ExitCode: -1, Output: err.Error()}
+}
+
+// RunScriptJob runs the script carried by a run_script job
+func RunScriptJob(job Job) Result {
+	var script Script
+	if err := json.Unmarshal(job.Payload, &script); err != nil {
+		return Failed(fmt.Errorf("invalid script payload: %w", err))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), scriptTimeout)
+	defer cancel()
+
+	var cmd *exec.Cmd
+	switch script.Shell {
+	case "cmd":
+		cmd = exec.CommandContext(ctx, "cmd", "/C", script.Content)
+	case "powershell", "":
+		cmd = exec.CommandContext(ctx, "Powershell", "-NoProfile", "-NonInteractive", "-Command", script.Content)
+	default:
+		return Failed(fmt.Errorf("unsupported shell: %s", script.Shell))
+	}
+
+	output, err := cmd.CombinedOutput()
+	if ctx.Err() == context.DeadlineExceeded {
+		return Result{Status: "failed", ExitCode: -1, Output: string(output) + "\nscript timed out"}
+	}
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return Result{Status: "failed", ExitCode: exitErr.ExitCode(), Output: string(output)}
+	}
+	if err != nil {
+		return Failed(err)
+	}
+
+	return Completed(string(output))
+}
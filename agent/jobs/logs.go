@@ -0,0 +1,36 @@
+package jobs
+
+import (
+	"encoding/json"
+	"fmt"
+	"slate-nexus-agent/logger"
+	"strings"
+)
+
+// maxLogOutput keeps the log lines returned by a fetch_logs job under the output limit of the server
+const maxLogOutput = 60 * 1024
+
+// FetchLogsJob returns the log lines selected by the logger.Query payload of a fetch_logs job,
+// one JSON entry per line. The oldest lines are dropped if they do not all fit.
+func FetchLogsJob(job Job) Result {
+	var query logger.Query
+	if len(job.Payload) > 0 {
+		if err := json.Unmarshal(job.Payload, &query); err != nil {
+			return Failed(fmt.Errorf("invalid log query payload: %w", err))
+		}
+	}
+
+	lines, err := logger.Read(query)
+	if err != nil {
+		return Failed(err)
+	}
+
+	size := 0
+	first := len(lines)
+	for first > 0 && size+len(lines[first-1])+1 <= maxLogOutput {
+		first--
+		size += len(lines[first]) + 1
+	}
+
+	return Completed(strings.Join(lines[first:], "\n"))
+}
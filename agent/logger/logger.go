@@ -1,15 +1,58 @@
 package logger
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"log"
+	"log/slog"
 	"os"
 	"path/filepath"
+	"runtime"
+	"strings"
 	"time"
 )
 
-var LogFile *os.File
+// Rotation limits of the agent log
+const (
+	maxLogSize    = 10 * 1024 * 1024
+	maxLogBackups = 5
+	maxLogAge     = 14 * 24 * time.Hour
+)
+
+// LogFile is the rotating file the log is written to, nil until SetupLogger is called
+var LogFile *RotatingFile
+
+// levels maps the configurable level names to slog levels
+var levels = map[string]slog.Level{
+	"DEBUG": slog.LevelDebug,
+	"INFO":  slog.LevelInfo,
+	"WARN":  slog.LevelWarn,
+	"ERROR": slog.LevelError,
+}
+
+// minLevel is the least severe level written to the log
+var minLevel = new(slog.LevelVar)
+
+// logger writes to stderr until SetupLogger is called, which is what the CLI commands want
+var logger = newLogger(os.Stderr)
+
+func newLogger(w io.Writer) *slog.Logger {
+	return slog.New(slog.NewJSONHandler(w, &slog.HandlerOptions{AddSource: true, Level: minLevel}))
+}
 
+// SetLevel changes the least severe level written to the log: debug, info, warn or error
+func SetLevel(level string) error {
+	value, ok := levels[strings.ToUpper(level)]
+	if !ok {
+		return fmt.Errorf("unknown log level %q", level)
+	}
+	minLevel.Set(value)
+	return nil
+}
+
+// SetupLogger writes the log as JSON lines to agent.log next to the executable, rotated by
+// size and age. The standard log package is redirected to it too.
 func SetupLogger() error {
 	exe, err := os.Executable()
 	if err != nil {
@@ -17,26 +60,38 @@ func SetupLogger() error {
 	}
 	dir := filepath.Dir(exe)
 
-	// Define the path to the log file
-	logFilePath := filepath.Join(dir, "agent.log")
-	LogFile, err = os.OpenFile(logFilePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	LogFile, err = OpenRotating(filepath.Join(dir, "agent.log"), maxLogSize, maxLogBackups, maxLogAge)
 	if err != nil {
 		return fmt.Errorf("could not open log file: %w", err)
 	}
 
-	log.SetOutput(LogFile)
-	log.SetFlags(log.Ldate | log.Ltime | log.Lshortfile)
+	logger = newLogger(LogFile)
+	slog.SetDefault(logger)
+	log.SetFlags(0)
 
 	return nil
 }
 
+// CustomLog writes a message at level: DEBUG, INFO, WARN or ERROR
 func CustomLog(level, format string, v ...interface{}) {
-	message := fmt.Sprintf(format, v...)
-	timestamp := time.Now().Format("2006-01-02 15:04:05")
-	logMessage := fmt.Sprintf("[%s] [%s] %s\n", timestamp, level, message)
+	value, ok := levels[level]
+	if !ok {
+		value = slog.LevelInfo
+	}
+	ctx := context.Background()
+	if !logger.Enabled(ctx, value) {
+		return
+	}
+
+	// Report the caller of the Log function rather than this file
+	var pcs [1]uintptr
+	runtime.Callers(3, pcs[:])
+	record := slog.NewRecord(time.Now(), value, fmt.Sprintf(format, v...), pcs[0])
+	logger.Handler().Handle(ctx, record)
+}
 
-	// Write the log message to the log file
-	log.Print(logMessage)
+func LogDebug(format string, v ...interface{}) {
+	CustomLog("DEBUG", format, v...)
 }
 
 func LogInfo(format string, v ...interface{}) {
@@ -0,0 +1,113 @@
+package logger
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+)
+
+// maxQueryLines bounds how many entries a single query can return
+const maxQueryLines = 1000
+
+// Query selects entries of the log. Zero fields are not filtered on.
+type Query struct {
+	// Lines is the number of most recent matching entries returned, 100 when unset
+	Lines int       `json:"lines"`
+	Since time.Time `json:"since"`
+	Until time.Time `json:"until"`
+	// Level is the least severe level returned
+	Level string `json:"level"`
+}
+
+// entry holds the fields of a log line used for filtering
+type entry struct {
+	Time  time.Time `json:"time"`
+	Level string    `json:"level"`
+}
+
+// Read returns the most recent log lines matching the query, oldest first,
+// searching the rotated files as far back as needed
+func Read(query Query) ([]string, error) {
+	if LogFile == nil {
+		return nil, fmt.Errorf("the log is not written to a file")
+	}
+	if query.Lines <= 0 {
+		query.Lines = 100
+	}
+	if query.Lines > maxQueryLines {
+		query.Lines = maxQueryLines
+	}
+	minLevel := levels["DEBUG"]
+	if query.Level != "" {
+		value, ok := levels[strings.ToUpper(query.Level)]
+		if !ok {
+			return nil, fmt.Errorf("unknown log level %q", query.Level)
+		}
+		minLevel = value
+	}
+
+	// Files are read newest first, and each one oldest first, so matches are prepended per file
+	var lines []string
+	for _, path := range LogFile.Files() {
+		matched, older, err := readFile(path, query, minLevel)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		lines = append(matched, lines...)
+		if len(lines) >= query.Lines || older {
+			break
+		}
+	}
+
+	if len(lines) > query.Lines {
+		lines = lines[len(lines)-query.Lines:]
+	}
+	return lines, nil
+}
+
+// readFile returns the lines of a log file matching the query, and whether the file
+// reaches back before query.Since so that older files need not be read
+func readFile(path string, query Query, minLevel slog.Level) ([]string, bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, false, err
+	}
+	defer file.Close()
+
+	var lines []string
+	older := false
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var e entry
+		// Lines written before the log was structured are skipped
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			continue
+		}
+		if !query.Since.IsZero() && e.Time.Before(query.Since) {
+			older = true
+			continue
+		}
+		if !query.Until.IsZero() && e.Time.After(query.Until) {
+			continue
+		}
+		if level, ok := levels[e.Level]; ok && level < minLevel {
+			continue
+		}
+
+		lines = append(lines, scanner.Text())
+		// Only the most recent lines are kept
+		if len(lines) >= 2*query.Lines {
+			lines = append(lines[:0], lines[len(lines)-query.Lines:]...)
+		}
+	}
+
+	return lines, older, scanner.Err()
+}
@@ -0,0 +1,131 @@
+package logger
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// backupTimeFormat names rotated files, e.g. agent-20240102T150405.000.log
+const backupTimeFormat = "20060102T150405.000"
+
+// RotatingFile is an append-only log file that is rotated once it grows past maxSize.
+// Rotated files are kept next to it, up to maxBackups of them and none older than maxAge.
+type RotatingFile struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+	maxAge     time.Duration
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+// OpenRotating opens the log file at path for appending, rotating it first if it is already full
+func OpenRotating(path string, maxSize int64, maxBackups int, maxAge time.Duration) (*RotatingFile, error) {
+	r := &RotatingFile{path: path, maxSize: maxSize, maxBackups: maxBackups, maxAge: maxAge}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	if r.size >= maxSize {
+		if err := r.rotate(); err != nil {
+			return nil, err
+		}
+	}
+	r.prune()
+	return r, nil
+}
+
+func (r *RotatingFile) open() error {
+	file, err := os.OpenFile(r.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	r.file = file
+	r.size = info.Size()
+	return nil
+}
+
+// Write appends p to the log, rotating the file first if p would not fit
+func (r *RotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.size > 0 && r.size+int64(len(p)) > r.maxSize {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// Close closes the current log file
+func (r *RotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.file.Close()
+}
+
+// rotate renames the current file to a timestamped backup and starts a new one
+func (r *RotatingFile) rotate() error {
+	if err := r.file.Close(); err != nil {
+		return err
+	}
+
+	ext := filepath.Ext(r.path)
+	backup := fmt.Sprintf("%s-%s%s", strings.TrimSuffix(r.path, ext), time.Now().Format(backupTimeFormat), ext)
+	if err := os.Rename(r.path, backup); err != nil {
+		// Keep writing to the current file rather than losing the log
+		if openErr := r.open(); openErr != nil {
+			return openErr
+		}
+		return fmt.Errorf("could not rotate log file: %w", err)
+	}
+
+	if err := r.open(); err != nil {
+		return err
+	}
+	r.prune()
+	return nil
+}
+
+// prune deletes the backups beyond maxBackups or older than maxAge
+func (r *RotatingFile) prune() {
+	backups := r.backups()
+	for i, backup := range backups {
+		info, err := os.Stat(backup)
+		if err != nil {
+			continue
+		}
+		if i >= r.maxBackups || time.Since(info.ModTime()) > r.maxAge {
+			os.Remove(backup)
+		}
+	}
+}
+
+// backups returns the rotated files, newest first
+func (r *RotatingFile) backups() []string {
+	ext := filepath.Ext(r.path)
+	matches, _ := filepath.Glob(strings.TrimSuffix(r.path, ext) + "-*" + ext)
+	// The timestamp in the name sorts chronologically
+	sort.Sort(sort.Reverse(sort.StringSlice(matches)))
+	return matches
+}
+
+// Files returns the current log file followed by its backups, newest first
+func (r *RotatingFile) Files() []string {
+	return append([]string{r.path}, r.backups()...)
+}
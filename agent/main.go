@@ -8,9 +8,16 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"slate-nexus-agent/checks"
 	"slate-nexus-agent/collectors"
+	"slate-nexus-agent/eventlog"
+	"slate-nexus-agent/files"
+	"slate-nexus-agent/jobs"
 	"slate-nexus-agent/logger"
+	"slate-nexus-agent/queue"
 	"slate-nexus-agent/server"
+	"slate-nexus-agent/shell"
+	"slate-nexus-agent/status"
 	"time"
 
 	"golang.org/x/sys/windows/svc"
@@ -44,14 +51,46 @@ func (s *Service) Execute(args []string, r <-chan svc.ChangeRequest, changes cha
 	}
 }
 
+const (
+	// heartbeatInterval is how often the agent tells the server it is alive
+	heartbeatInterval = 1 * time.Minute
+	// inventoryInterval is how often the inventory is collected and compared to the last report
+	inventoryInterval = 15 * time.Minute
+	// fullInventoryInterval is how often the whole inventory is sent even if nothing changed
+	fullInventoryInterval = 24 * time.Hour
+	// eventLogInterval is how often the entries of the event logs are collected
+	eventLogInterval = 1 * time.Minute
+)
+
+// Batches of the event log entries: each report carries at most eventLogBatch entries, and
+// at most maxEventLogBatches are sent at once, the rest waiting for the next collection
+const (
+	eventLogBatch      = 500
+	maxEventLogBatches = 10
+)
+
+// eventLogBookmarks is where the position of the collection in each event log is stored
+const eventLogBookmarks = "C:\\Program Files\\SlateNexus\\eventlog-bookmarks.json"
+
+// configFile is where the agent configuration is stored
+const configFile = "C:\\Program Files\\SlateNexus\\config.json"
+
 // Config represents the configuration for the agent
 type Config struct {
-	ServerURL string `json:"server_url"`
-	HostID    int32  `json:"host_id"`
-	APIKey    string `json:"api_key"`
+	ServerURL     string `json:"server_url"`
+	HostID        int32  `json:"host_id"`
+	APIKey        string `json:"api_key"`
+	EnrollmentKey string `json:"enrollment_key"`
+	// LogLevel is used until the server delivers a configuration
+	LogLevel string `json:"log_level,omitempty"`
 }
 
 func main() {
+	// Subcommands are run by technicians from a console
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(os.Args[1:]))
+	}
+
 	err := logger.SetupLogger()
 	if err != nil {
 		log.Fatalf("could not setup logger: %v", err)
@@ -61,7 +100,7 @@ func main() {
 	logger.LogInfo("Starting SlateNexusAgent...")
 
 	// Run as a service
-	err = svc.Run("SlateNexusAgent", &Service{})
+	err = svc.Run(jobs.AgentService, &Service{})
 	if err != nil {
 		logger.LogError("Service failed: %v", err)
 	}
@@ -77,16 +116,30 @@ func runAgent(stop <-chan struct{}) {
 
 	logger.LogInfo("Agent starting...")
 
+	// Serve the agent status to technicians on the box
+	go func() {
+		if err := status.Serve(); err != nil {
+			logger.LogError("could not serve status endpoint: %v", err)
+		}
+	}()
+
 	config, err := loadConfig()
 	if err != nil {
 		logger.LogError("could not load config: %v", err)
+		status.Error(err)
 		return
 	}
+	publishConfig(config)
+	if config.LogLevel != "" {
+		if err := logger.SetLevel(config.LogLevel); err != nil {
+			logger.LogError("could not set log level: %v", err)
+		}
+	}
 
 	// If HostID is 0, run agentSetup and reload config
+	var remote *server.RemoteConfig
 	if config.HostID == 0 {
-		configFile := "C:\\Program Files\\SlateNexus\\config.json"
-		err = agentSetup(config, configFile)
+		remote, err = agentSetup(config, configFile, stop)
 		if err != nil {
 			logger.LogError("could not setup agent: %v", err)
 			return
@@ -97,20 +150,79 @@ func runAgent(stop <-chan struct{}) {
 			logger.LogError("could not reload config after setup: %v", err)
 			return
 		}
+		publishConfig(config)
+	}
+
+	// Reports the server does not receive are queued on disk and replayed in order
+	reports, err := newReporter(&config)
+	if err != nil {
+		logger.LogError("could not open report queue: %v", err)
+		return
+	}
+
+	// Send a heartbeat every minute and check the inventory for changes on a slower schedule.
+	// Both intervals can be changed by the server configuration.
+	heartbeat := time.NewTicker(heartbeatInterval)
+	defer heartbeat.Stop()
+	inventoryCheck := time.NewTicker(inventoryInterval)
+	defer inventoryCheck.Stop()
+	eventLogCheck := time.NewTicker(eventLogInterval)
+	defer eventLogCheck.Stop()
+
+	// The event logs to collect are set by the server configuration
+	logs := eventlog.NewCollector(eventLogBookmarks)
+
+	var current server.RemoteConfig
+	if remote != nil {
+		applyRemoteConfig(&config, &current, *remote, heartbeat, inventoryCheck, logs)
 	}
 
-	// Send a heartbeat every minute
-	ticker := time.NewTicker(1 * time.Minute)
-	defer ticker.Stop()
+	var inventory collectors.Inventory
+	reportInventory(config, reports, &inventory, true)
+
+	// The checks assigned by the server run on their own schedules; their results are
+	// reported in batches
+	scheduler := checks.NewScheduler()
+	defer scheduler.Stop()
 
 	for {
 		select {
-		case <-ticker.C:
-			if err := server.Heartbeat(config.HostID, config.ServerURL, config.APIKey); err != nil {
+		case <-heartbeat.C:
+			reports.flush()
+			if !reports.online() {
+				// Only the latest heartbeat matters once the server is back
+				reports.deliver(server.HeartbeatReport(config.HostID), true)
+				continue
+			}
+
+			resp, err := server.Heartbeat(config.HostID, config.ServerURL, config.APIKey)
+			if err != nil {
 				logger.LogError("could not send heartbeat: %v", err)
-			} else {
-				logger.LogInfo("Heartbeat sent successfully")
+				status.Error(err)
+				if server.Retryable(err) {
+					reports.failed(err)
+					reports.deliver(server.HeartbeatReport(config.HostID), true)
+				}
+				continue
+			}
+			logger.LogDebug("Heartbeat sent successfully")
+			sentAt := time.Now()
+			status.Update(func(s *status.Status) { s.LastHeartbeat = &sentAt })
+			if resp.Config != nil {
+				applyRemoteConfig(&config, &current, *resp.Config, heartbeat, inventoryCheck, logs)
 			}
+			if resp.Checks != nil {
+				scheduler.Update(resp.Checks)
+			}
+			processJobs(config, reports, &inventory)
+		case results := <-scheduler.Batches():
+			reportCheckResults(config, reports, results)
+		case <-eventLogCheck.C:
+			reportEventLogs(config, reports, logs)
+		case <-inventoryCheck.C:
+			// The full inventory is resent now and then in case the server lost it
+			full := time.Since(inventory.ReportedAt()) > fullInventoryInterval
+			reportInventory(config, reports, &inventory, full)
 		case <-stop:
 			logger.LogInfo("Agent stopping...")
 			return
@@ -118,12 +230,248 @@ func runAgent(stop <-chan struct{}) {
 	}
 }
 
+// publishConfig shows the local configuration on the status endpoint
+func publishConfig(config Config) {
+	status.Update(func(s *status.Status) {
+		s.Registered = config.HostID != 0
+		s.HostID = config.HostID
+		s.ServerURL = config.ServerURL
+		s.AgentVersion = collectors.AgentVersion
+		s.ProtocolVersion = server.ProtocolVersion
+	})
+}
+
+// applyRemoteConfig applies the configuration delivered by the server without restarting the service.
+// current is the configuration applied so far; only the fields that changed are applied again.
+func applyRemoteConfig(config *Config, current *server.RemoteConfig, remote server.RemoteConfig, heartbeat, inventoryCheck *time.Ticker, logs *eventlog.Collector) {
+	if remote.HeartbeatInterval > 0 && remote.HeartbeatInterval != current.HeartbeatInterval {
+		heartbeat.Reset(time.Duration(remote.HeartbeatInterval) * time.Second)
+		logger.LogInfo("Heartbeat interval set to %ds", remote.HeartbeatInterval)
+	}
+	if remote.InventoryInterval > 0 && remote.InventoryInterval != current.InventoryInterval {
+		inventoryCheck.Reset(time.Duration(remote.InventoryInterval) * time.Second)
+		logger.LogInfo("Inventory interval set to %ds", remote.InventoryInterval)
+	}
+	if remote.LogLevel != "" && remote.LogLevel != current.LogLevel {
+		if err := logger.SetLevel(remote.LogLevel); err != nil {
+			logger.LogError("could not set log level: %v", err)
+		}
+	}
+	collectors.Configure(remote.Collectors)
+	if remote.EventLogs != nil {
+		logs.Configure(remote.EventLogs)
+	}
+
+	// A new server URL is saved so that the agent still reaches the server after a restart
+	if remote.ServerURL != "" && remote.ServerURL != config.ServerURL {
+		logger.LogInfo("Server URL changed from %s to %s", config.ServerURL, remote.ServerURL)
+		config.ServerURL = remote.ServerURL
+		if err := saveConfig(*config); err != nil {
+			logger.LogError("could not save config: %v", err)
+		}
+		publishConfig(*config)
+	}
+
+	*current = remote
+}
+
+// reportInventory collects the inventory and sends the fields that changed since the last report.
+// A report queued while the server is unreachable counts as sent: it is delivered on reconnect.
+func reportInventory(config Config, reports *reporter, inventory *collectors.Inventory, full bool) error {
+	changed, err := inventory.Changes(full)
+	if err != nil {
+		logger.LogError("could not collect inventory: %v", err)
+		return err
+	}
+	if len(changed) == 0 {
+		return nil
+	}
+
+	item, err := server.InventoryReport(config.HostID, changed)
+	if err != nil {
+		return err
+	}
+	if err := reports.deliver(item, false); err != nil {
+		logger.LogError("could not send inventory: %v", err)
+		return err
+	}
+	inventory.Reported(changed)
+	reportedAt := time.Now()
+	status.Update(func(s *status.Status) { s.LastInventory = &reportedAt })
+	logger.LogInfo("Inventory reported (%d fields)", len(changed))
+	return nil
+}
+
+// reportEventLogs ships the entries logged since the last collection in batches. The
+// bookmarks move past each batch once it is sent or queued, or rejected by the server, so
+// that no entry is shipped twice.
+func reportEventLogs(config Config, reports *reporter, logs *eventlog.Collector) {
+	if !collectors.Enabled("event_logs") {
+		return
+	}
+
+	for i := 0; i < maxEventLogBatches; i++ {
+		entries := logs.Collect(eventLogBatch)
+		if len(entries) > 0 {
+			item, err := server.EventLogReport(config.HostID, entries)
+			if err != nil {
+				logger.LogError("could not encode event log entries: %v", err)
+				return
+			}
+			if err := reports.deliver(item, false); err != nil {
+				logger.LogError("could not send event log entries: %v", err)
+			} else {
+				logger.LogDebug("Event log entries reported (%d entries)", len(entries))
+			}
+		}
+		if err := logs.Commit(); err != nil {
+			logger.LogError("could not save event log bookmarks: %v", err)
+		}
+		if len(entries) < eventLogBatch {
+			return
+		}
+	}
+}
+
+// reportCheckResults sends a batch of check results. Results queued while the server is
+// unreachable are delivered on reconnect.
+func reportCheckResults(config Config, reports *reporter, results []checks.Result) {
+	item, err := server.CheckResultsReport(config.HostID, results)
+	if err != nil {
+		logger.LogError("could not encode check results: %v", err)
+		return
+	}
+	if err := reports.deliver(item, false); err != nil {
+		logger.LogError("could not send check results: %v", err)
+		return
+	}
+	logger.LogDebug("Check results reported (%d results)", len(results))
+}
+
+// processJobs runs the jobs queued by the server and reports their results
+func processJobs(config Config, reports *reporter, inventory *collectors.Inventory) {
+	pending, err := server.FetchJobs(config.HostID, config.ServerURL, config.APIKey)
+	if err != nil {
+		logger.LogError("could not fetch jobs: %v", err)
+		return
+	}
+
+	for _, job := range pending {
+		logger.LogInfo("Running job %d (%s)", job.JobID, job.JobType)
+
+		var result jobs.Result
+		switch job.JobType {
+		case jobs.RunScript:
+			result = jobs.RunScriptJob(job)
+		case jobs.RefreshInventory:
+			if err := reportInventory(config, reports, inventory, true); err != nil {
+				result = jobs.Failed(err)
+			} else {
+				result = jobs.Completed("inventory refreshed")
+			}
+		case jobs.FetchLogs:
+			result = jobs.FetchLogsJob(job)
+		case jobs.OpenShell:
+			result = openShell(config, job)
+		case jobs.OpenFiles:
+			result = openFiles(config, job)
+		case jobs.ListServices:
+			result = reportServices(config, reports)
+		case jobs.ControlService:
+			result = jobs.ControlServiceJob(job)
+		case jobs.ListProcesses:
+			result = reportProcesses(config, reports)
+		case jobs.TerminateProcess:
+			result = jobs.TerminateProcessJob(job)
+		default:
+			result = jobs.Failed(fmt.Errorf("unsupported job type: %s", job.JobType))
+		}
+
+		item, err := server.JobResultReport(config.HostID, job.JobID, result)
+		if err == nil {
+			err = reports.deliver(item, false)
+		}
+		if err != nil {
+			logger.LogError("could not report result of job %d: %v", job.JobID, err)
+		}
+	}
+}
+
+// reportServices collects the services of the host for a list_services job and reports them
+func reportServices(config Config, reports *reporter) jobs.Result {
+	services, err := collectors.CollectServices()
+	if err != nil {
+		return jobs.Failed(fmt.Errorf("could not collect services: %w", err))
+	}
+	if err := reportSnapshot(config, reports, server.SnapshotServices, services); err != nil {
+		return jobs.Failed(err)
+	}
+	return jobs.Completed(fmt.Sprintf("%d services reported", len(services)))
+}
+
+// reportProcesses collects the processes of the host for a list_processes job and reports
+// them
+func reportProcesses(config Config, reports *reporter) jobs.Result {
+	processes, err := collectors.CollectProcesses()
+	if err != nil {
+		return jobs.Failed(fmt.Errorf("could not collect processes: %w", err))
+	}
+	if err := reportSnapshot(config, reports, server.SnapshotProcesses, processes); err != nil {
+		return jobs.Failed(err)
+	}
+	return jobs.Completed(fmt.Sprintf("%d processes reported", len(processes)))
+}
+
+// reportSnapshot sends a snapshot to the server. Only the latest snapshot of a kind is kept
+// while the server is unreachable.
+func reportSnapshot(config Config, reports *reporter, kind string, snapshot interface{}) error {
+	item, err := server.SnapshotReport(config.HostID, kind, snapshot)
+	if err == nil {
+		err = reports.deliver(item, true)
+	}
+	if err != nil {
+		return fmt.Errorf("could not report %s: %w", kind, err)
+	}
+	return nil
+}
+
+// openShell connects back to the server for the remote shell requested by an open_shell
+// job and serves it in the background. The job completes once the shell is connected.
+func openShell(config Config, job jobs.Job) jobs.Result {
+	var request jobs.SessionRequest
+	if err := json.Unmarshal(job.Payload, &request); err != nil {
+		return jobs.Failed(fmt.Errorf("invalid shell payload: %w", err))
+	}
+
+	conn, err := server.DialSession(config.HostID, config.ServerURL, config.APIKey, server.SessionShell, request)
+	if err != nil {
+		return jobs.Failed(fmt.Errorf("could not connect the shell: %w", err))
+	}
+	logger.LogInfo("Remote shell session %d opened", request.SessionID)
+	go shell.Serve(conn, request.Cols, request.Rows)
+	return jobs.Completed(fmt.Sprintf("shell session %d opened", request.SessionID))
+}
+
+// openFiles connects back to the server for the file session requested by an open_files
+// job and serves it in the background. The job completes once the session is connected.
+func openFiles(config Config, job jobs.Job) jobs.Result {
+	var request jobs.SessionRequest
+	if err := json.Unmarshal(job.Payload, &request); err != nil {
+		return jobs.Failed(fmt.Errorf("invalid file session payload: %w", err))
+	}
+
+	conn, err := server.DialSession(config.HostID, config.ServerURL, config.APIKey, server.SessionFiles, request)
+	if err != nil {
+		return jobs.Failed(fmt.Errorf("could not connect the file session: %w", err))
+	}
+	logger.LogInfo("File session %d opened", request.SessionID)
+	go files.Serve(conn)
+	return jobs.Completed(fmt.Sprintf("file session %d opened", request.SessionID))
+}
+
 func loadConfig() (Config, error) {
 	var config Config
 
-	// Define the path to the config file
-	configFile := "C:\\Program Files\\SlateNexus\\config.json"
-
 	// Read the config file
 	data, err := os.ReadFile(configFile)
 	if err != nil {
@@ -143,40 +491,69 @@ func loadConfig() (Config, error) {
 	return config, nil
 }
 
-func agentSetup(config Config, configPath string) error {
-
-	// Collect data
-	fmt.Println("Collecting system data...")
-	data, err := collectors.CollectData()
+// saveConfig writes the agent configuration to the config file
+func saveConfig(config Config) error {
+	configBytes, err := json.Marshal(config)
 	if err != nil {
-		logger.LogError("could not collect data: %v", err)
 		return err
 	}
+	return os.WriteFile(configFile, configBytes, 0644)
+}
 
-	// Register the agent and get the host ID
-	fmt.Println("Registering agent...")
-	HostID, err := server.Register(data, config.ServerURL, config.APIKey)
-	if err != nil {
-		logger.LogError("could not register with the server: %v", err)
-	} else {
-		logger.LogInfo("Registered with the server with HostID: %d\n", HostID)
-	}
+// agentSetup registers the agent and saves its host ID, retrying with backoff until the
+// server accepts the registration or the service is stopped. It returns the configuration
+// the server assigned to the agent, if any.
+func agentSetup(config Config, configPath string, stop <-chan struct{}) (*server.RemoteConfig, error) {
+	backoff := queue.Backoff{Base: 10 * time.Second, Max: 15 * time.Minute}
 
-	// Save the HostID in the config
-	config.HostID = HostID
+	for {
+		HostID, remote, err := register(config)
+		if err == nil && HostID != 0 {
+			logger.LogInfo("Registered with the server with HostID: %d\n", HostID)
 
-	// Save the config in the config file
-	configBytes, err := json.Marshal(config)
-	if err != nil {
-		logger.LogError("could not marshal config: %v", err)
+			// Save the HostID in the config
+			config.HostID = HostID
+
+			// Save the config in the config file
+			configBytes, err := json.Marshal(config)
+			if err != nil {
+				return nil, err
+			}
+			if err := os.WriteFile(configPath, configBytes, 0644); err != nil {
+				return nil, err
+			}
+			return remote, nil
+		}
+		if err == nil {
+			err = fmt.Errorf("server returned no host ID")
+		}
+
+		status.Error(err)
+		delay := backoff.Failed()
+		logger.LogError("could not register with the server: %v, retrying in %s", err, delay.Round(time.Second))
+		select {
+		case <-time.After(delay):
+		case <-stop:
+			return nil, fmt.Errorf("stopped before registration succeeded")
+		}
 	}
+}
 
-	err = os.WriteFile(configPath, configBytes, 0644)
+// register collects the system data and registers the agent once
+func register(config Config) (int32, *server.RemoteConfig, error) {
+	// Collect data
+	fmt.Println("Collecting system data...")
+	data, err := collectors.CollectData()
 	if err != nil {
-		logger.LogError("could not write config file: %v", err)
+		return 0, nil, fmt.Errorf("could not collect data: %w", err)
 	}
 
-	return nil
+	// The enrollment key ties the host to its client on the server
+	data.EnrollmentKey = config.EnrollmentKey
+
+	// Register the agent and get the host ID
+	fmt.Println("Registering agent...")
+	return server.Register(data, config.ServerURL, config.APIKey)
 }
 
 // downloadFile downloads a file from the given URL and saves it to the given path
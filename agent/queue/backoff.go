@@ -0,0 +1,43 @@
+package queue
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Backoff spaces out retries exponentially from Base up to Max, with jitter so that
+// agents cut off by the same outage do not all come back at the same moment
+type Backoff struct {
+	Base time.Duration
+	Max  time.Duration
+
+	attempt int
+	next    time.Time
+}
+
+// Failed records a failed attempt and returns how long to wait before the next one
+func (b *Backoff) Failed() time.Duration {
+	delay := b.Max
+	if b.attempt < 30 {
+		if d := b.Base << b.attempt; d > 0 && d < b.Max {
+			delay = d
+		}
+	}
+	b.attempt++
+
+	// Wait between half and all of the delay
+	delay = delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+	b.next = time.Now().Add(delay)
+	return delay
+}
+
+// Succeeded resets the backoff after a successful attempt
+func (b *Backoff) Succeeded() {
+	b.attempt = 0
+	b.next = time.Time{}
+}
+
+// Ready reports whether the wait after the last failure is over
+func (b *Backoff) Ready() bool {
+	return !time.Now().Before(b.next)
+}
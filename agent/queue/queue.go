@@ -0,0 +1,211 @@
+// Package queue implements the durable on-disk queue the agent keeps its reports in
+// while the server is unreachable, so that they are delivered in order once it is back.
+package queue
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slate-nexus-agent/logger"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Item is a report waiting to be sent to the server
+type Item struct {
+	// Kind groups items for coalescing: heartbeat, inventory, job_result or event
+	Kind      string          `json:"kind"`
+	Method    string          `json:"method"`
+	Path      string          `json:"path"`
+	Body      json.RawMessage `json:"body,omitempty"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// entry is an item stored on disk, named <seq>-<kind>.json
+type entry struct {
+	seq  uint64
+	kind string
+	size int64
+}
+
+func (e entry) name() string {
+	return fmt.Sprintf("%020d-%s.json", e.seq, e.kind)
+}
+
+// Queue is a FIFO of items persisted one file per item. When it grows past its caps
+// the oldest items are dropped.
+type Queue struct {
+	dir      string
+	maxItems int
+	maxBytes int64
+
+	mu      sync.Mutex
+	entries []entry
+	bytes   int64
+	next    uint64
+}
+
+// dropError marks a send error as permanent
+type dropError struct {
+	err error
+}
+
+func (e dropError) Error() string { return e.err.Error() }
+func (e dropError) Unwrap() error { return e.err }
+
+// Drop wraps the error a send function returns for an item that will never be accepted,
+// so that Replay discards it instead of retrying it forever
+func Drop(err error) error {
+	return dropError{err}
+}
+
+// Open opens the queue stored in dir, creating it if needed
+func Open(dir string, maxItems int, maxBytes int64) (*Queue, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	q := &Queue{dir: dir, maxItems: maxItems, maxBytes: maxBytes, next: 1}
+	for _, file := range files {
+		name := file.Name()
+		if file.IsDir() || !strings.HasSuffix(name, ".json") {
+			continue
+		}
+		seqPart, kind, ok := strings.Cut(strings.TrimSuffix(name, ".json"), "-")
+		seq, err := strconv.ParseUint(seqPart, 10, 64)
+		if !ok || err != nil {
+			logger.LogWarn("Ignoring unexpected file in queue: %s", name)
+			continue
+		}
+		info, err := file.Info()
+		if err != nil {
+			return nil, err
+		}
+
+		q.entries = append(q.entries, entry{seq: seq, kind: kind, size: info.Size()})
+		q.bytes += info.Size()
+		if seq >= q.next {
+			q.next = seq + 1
+		}
+	}
+	sort.Slice(q.entries, func(i, j int) bool { return q.entries[i].seq < q.entries[j].seq })
+
+	return q, nil
+}
+
+// Len returns the number of items waiting to be sent
+func (q *Queue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.entries)
+}
+
+// Push appends an item to the queue. With coalesce, queued items of the same kind are
+// replaced, for reports where only the latest one matters.
+func (q *Queue) Push(item Item, coalesce bool) error {
+	if item.CreatedAt.IsZero() {
+		item.CreatedAt = time.Now()
+	}
+	data, err := json.Marshal(item)
+	if err != nil {
+		return err
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if coalesce {
+		kept := q.entries[:0]
+		for _, e := range q.entries {
+			if e.kind == item.Kind {
+				q.remove(e)
+				continue
+			}
+			kept = append(kept, e)
+		}
+		q.entries = kept
+	}
+
+	e := entry{seq: q.next, kind: item.Kind, size: int64(len(data))}
+	q.next++
+
+	// Write to a temporary file first so that a crash never leaves a partial item behind
+	path := filepath.Join(q.dir, e.name())
+	if err := os.WriteFile(path+".tmp", data, 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return err
+	}
+	q.entries = append(q.entries, e)
+	q.bytes += e.size
+
+	// Enforce the caps by dropping the oldest items
+	for len(q.entries) > 1 && (len(q.entries) > q.maxItems || q.bytes > q.maxBytes) {
+		logger.LogWarn("Report queue is full, dropping %s", q.entries[0].name())
+		q.remove(q.entries[0])
+		q.entries = q.entries[1:]
+	}
+
+	return nil
+}
+
+// remove deletes the file of an entry; the caller removes it from q.entries
+func (q *Queue) remove(e entry) {
+	if err := os.Remove(filepath.Join(q.dir, e.name())); err != nil && !errors.Is(err, os.ErrNotExist) {
+		logger.LogError("could not remove queued report %s: %v", e.name(), err)
+	}
+	q.bytes -= e.size
+}
+
+// Replay sends the queued items in order, removing each one the server accepted.
+// It stops at the first failure so that the order is kept, unless the error was
+// wrapped with Drop. It returns the number of items sent.
+func (q *Queue) Replay(send func(Item) error) (int, error) {
+	sent := 0
+	for {
+		q.mu.Lock()
+		if len(q.entries) == 0 {
+			q.mu.Unlock()
+			return sent, nil
+		}
+		e := q.entries[0]
+		q.mu.Unlock()
+
+		var item Item
+		data, err := os.ReadFile(filepath.Join(q.dir, e.name()))
+		if err == nil {
+			err = json.Unmarshal(data, &item)
+		}
+		if err != nil {
+			// A corrupt item can never be sent
+			logger.LogError("dropping unreadable queued report %s: %v", e.name(), err)
+		} else if err := send(item); err != nil {
+			var drop dropError
+			if !errors.As(err, &drop) {
+				return sent, err
+			}
+			logger.LogError("server rejected queued report %s, dropping it: %v", e.name(), err)
+		} else {
+			sent++
+		}
+
+		q.mu.Lock()
+		// Push may have coalesced the entry away while it was being sent
+		if len(q.entries) > 0 && q.entries[0].seq == e.seq {
+			q.remove(e)
+			q.entries = q.entries[1:]
+		}
+		q.mu.Unlock()
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"slate-nexus-agent/logger"
+	"slate-nexus-agent/queue"
+	"slate-nexus-agent/server"
+	"slate-nexus-agent/status"
+	"time"
+)
+
+// queueDir holds the reports the server has not received yet
+const queueDir = "C:\\Program Files\\SlateNexus\\queue"
+
+// Caps of the report queue; the oldest reports are dropped beyond them
+const (
+	maxQueuedReports = 5000
+	maxQueuedBytes   = 50 * 1024 * 1024
+)
+
+// reporter delivers reports to the server, keeping them in the durable queue while the
+// server is unreachable and replaying them in order once it is back
+type reporter struct {
+	config  *Config
+	queue   *queue.Queue
+	backoff queue.Backoff
+}
+
+func newReporter(config *Config) (*reporter, error) {
+	q, err := queue.Open(queueDir, maxQueuedReports, maxQueuedBytes)
+	if err != nil {
+		return nil, err
+	}
+	r := &reporter{
+		config:  config,
+		queue:   q,
+		backoff: queue.Backoff{Base: 5 * time.Second, Max: 10 * time.Minute},
+	}
+	r.updateStatus()
+	return r, nil
+}
+
+// online reports whether the server should be contacted now, rather than waiting
+// for the backoff after a failure to run out
+func (r *reporter) online() bool {
+	return r.backoff.Ready()
+}
+
+// failed records that the server could not be reached
+func (r *reporter) failed(err error) {
+	status.Error(err)
+	delay := r.backoff.Failed()
+	logger.LogWarn("Server unreachable (%v), retrying in %s", err, delay.Round(time.Second))
+}
+
+// deliver sends a report, or queues it if the server is unreachable or older reports are
+// still waiting. An error is only returned if the server rejected the report.
+func (r *reporter) deliver(item queue.Item, coalesce bool) error {
+	if r.queue.Len() == 0 && r.online() {
+		err := server.Send(item, r.config.ServerURL, r.config.APIKey)
+		if err == nil || !server.Retryable(err) {
+			return err
+		}
+		r.failed(err)
+	}
+
+	if err := r.queue.Push(item, coalesce); err != nil {
+		logger.LogError("could not queue %s report: %v", item.Kind, err)
+	}
+	r.updateStatus()
+	return nil
+}
+
+// updateStatus publishes the number of queued reports on the status endpoint
+func (r *reporter) updateStatus() {
+	queued := r.queue.Len()
+	status.Update(func(s *status.Status) { s.QueuedReports = queued })
+}
+
+// flush replays the queued reports in order once the server can be reached
+func (r *reporter) flush() {
+	if r.queue.Len() == 0 || !r.online() {
+		return
+	}
+
+	defer r.updateStatus()
+	sent, err := r.queue.Replay(func(item queue.Item) error {
+		err := server.Send(item, r.config.ServerURL, r.config.APIKey)
+		if err != nil && !server.Retryable(err) {
+			return queue.Drop(err)
+		}
+		return err
+	})
+	if sent > 0 {
+		logger.LogInfo("Replayed %d queued reports", sent)
+	}
+	if err != nil {
+		r.failed(err)
+		return
+	}
+	r.backoff.Succeeded()
+}
@@ -3,31 +3,130 @@ package server
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"net/url"
+	"slate-nexus-agent/checks"
 	"slate-nexus-agent/collectors"
+	"slate-nexus-agent/eventlog"
+	"slate-nexus-agent/jobs"
 	"slate-nexus-agent/logger"
+	"slate-nexus-agent/queue"
+	"strings"
+	"time"
+
+	"golang.org/x/net/websocket"
 )
 
-// Register sends a POST request to the server to register the agent
-func Register(data collectors.AgentData, ServerURL string, apiKey string) (int32, error) {
-	url := ServerURL + "/agents/register"
+// ProtocolVersion is the agent protocol version spoken by this agent.
+// Version 2 identifies the host as "host_id" in the inventory payload.
+const ProtocolVersion = 2
+
+// dialTimeout bounds how long connecting the WebSocket of a session may take
+const dialTimeout = 30 * time.Second
+
+// sessionTokenHeader carries the token of an open_shell or open_files job on the
+// connection of the session
+const sessionTokenHeader = "X-Nexus-Session-Token"
+
+// protocolHeader carries the agent protocol version on requests and responses
+const protocolHeader = "X-Nexus-Agent-Protocol"
+
+// apiVersion is the path prefix of the server API the agent talks to
+const apiVersion = "/v1"
+
+// RemoteConfig is the configuration the server delivers on registration and on every heartbeat.
+// Zero fields mean the server has no opinion and the agent keeps its current value.
+type RemoteConfig struct {
+	HeartbeatInterval int               `json:"heartbeat_interval"`
+	InventoryInterval int               `json:"inventory_interval"`
+	LogLevel          string            `json:"log_level"`
+	ServerURL         string            `json:"server_url"`
+	Collectors        map[string]bool   `json:"collectors"`
+	EventLogs         []eventlog.Source `json:"event_logs"`
+}
+
+// StatusError is returned when the server answers with an unexpected status code. Message
+// explains the status when the server gave a reason for it.
+type StatusError struct {
+	Code    int
+	Status  string
+	Message string
+}
+
+func (e *StatusError) Error() string {
+	if e.Message != "" {
+		return "unexpected status code: " + e.Status + ": " + e.Message
+	}
+	return "unexpected status code: " + e.Status
+}
+
+// Retryable reports whether a request that failed with err may succeed later: the server
+// was unreachable or failed, as opposed to rejecting the request itself
+func Retryable(err error) bool {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.Code >= 500 || statusErr.Code == http.StatusRequestTimeout || statusErr.Code == http.StatusTooManyRequests
+	}
+	return true
+}
+
+// newRequest creates an authenticated request to the versioned server API
+func newRequest(method string, ServerURL string, path string, apiKey string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequest(method, ServerURL+apiVersion+path, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+apiKey)
+	req.Header.Set(protocolHeader, fmt.Sprint(ProtocolVersion))
+	return req, nil
+}
+
+// do sends a request and warns when the server negotiated an older protocol version
+func do(req *http.Request) (*http.Response, error) {
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if negotiated := resp.Header.Get(protocolHeader); negotiated != "" && negotiated != fmt.Sprint(ProtocolVersion) {
+		logger.LogWarn("Server downgraded agent protocol %d to %s", ProtocolVersion, negotiated)
+	}
+	// The agent is rejected until it is updated, so its requests are not retried
+	if resp.StatusCode == http.StatusUpgradeRequired {
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		return nil, &StatusError{
+			Code:    resp.StatusCode,
+			Status:  resp.Status,
+			Message: "server no longer supports this agent: " + strings.TrimSpace(string(body)),
+		}
+	}
+
+	return resp, nil
+}
+
+// Register sends a POST request to the server to register the agent.
+// It returns the host ID and the configuration assigned to the agent.
+func Register(data collectors.AgentData, ServerURL string, apiKey string) (int32, *RemoteConfig, error) {
 	// Convert data to JSON
 	jsonData, err := json.Marshal(data)
 	if err != nil {
-		return 0, err
+		return 0, nil, err
 	}
 
 	// Send a POST request to the AgentRegister endpoint
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(jsonData))
+	req, err := newRequest("POST", ServerURL, "/agents/register", apiKey, bytes.NewBuffer(jsonData))
 	if err != nil {
-		return 0, err
+		return 0, nil, err
 	}
-	req.Header.Set("Authorization", "Bearer "+apiKey)
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := do(req)
 	if err != nil {
-		return 0, err
+		return 0, nil, err
 	}
 
 	defer resp.Body.Close()
@@ -36,61 +135,139 @@ func Register(data collectors.AgentData, ServerURL string, apiKey string) (int32
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
 		logger.LogError("error reading response body: %v", err)
-		return 0, err
+		return 0, nil, err
 	}
 
 	// Decode the response and get the host ID
 	var result struct {
 		HostID int32 `json:"host_id"`
+		Config *struct {
+			Config RemoteConfig `json:"config"`
+		} `json:"config"`
 	}
 
 	err = json.Unmarshal(body, &result)
 	if err != nil {
 		logger.LogError("Error decoding response: %v", err)
-		return 0, err
+		return 0, nil, err
 	}
 
 	// Check the response status code
 	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
 		logger.LogError("Unexpected status code: %d", resp.StatusCode)
-		return 0, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+		return 0, nil, &StatusError{Code: resp.StatusCode, Status: resp.Status}
 	}
 
-	return result.HostID, nil
+	// Servers that predate configuration profiles do not send one
+	if result.Config == nil {
+		return result.HostID, nil, nil
+	}
+	return result.HostID, &result.Config.Config, nil
 }
 
-// Heartbeat sends a POST request to the server with updated agent information
-func Heartbeat(hostID int32, ServerURL string, apiKey string) error {
-	// Collect agent data
-	data, err := collectors.CollectData()
+// HeartbeatResponse is what the server answers a heartbeat with. Config is nil if the server
+// does not deliver a configuration, and Checks nil if it does not assign checks.
+type HeartbeatResponse struct {
+	Config *RemoteConfig       `json:"config"`
+	Checks []checks.Definition `json:"checks"`
+}
+
+// Heartbeat tells the server the agent is alive. It carries no inventory; the server answers
+// with the current configuration of the agent and the checks it runs.
+func Heartbeat(hostID int32, ServerURL string, apiKey string) (*HeartbeatResponse, error) {
+	req, err := newRequest("POST", ServerURL, "/agents/"+fmt.Sprint(hostID)+"/heartbeat", apiKey, nil)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	url := ServerURL + "/agents/" + fmt.Sprint(hostID)
+	resp, err := do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
 
-	// Convert data to JSON
-	jsonData, err := json.Marshal(data)
+	if resp.StatusCode != http.StatusOK {
+		return nil, &StatusError{Code: resp.StatusCode, Status: resp.Status}
+	}
+
+	var result HeartbeatResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// InventoryReport builds the report of the inventory fields that changed.
+// Fields that are not sent keep their value on the server.
+func InventoryReport(hostID int32, fields map[string]json.RawMessage) (queue.Item, error) {
+	jsonData, err := json.Marshal(fields)
 	if err != nil {
-		return err
+		return queue.Item{}, err
 	}
 
 	// Debug: Print the JSON data
 	// fmt.Printf("Sending JSON data: %s\n", jsonData)
 
-	// Send a PUT request to the AgentHeartbeat endpoint
-	req, err := http.NewRequest("PUT", url, bytes.NewBuffer(jsonData))
+	return queue.Item{Kind: "inventory", Method: "PATCH", Path: "/agents/" + fmt.Sprint(hostID), Body: jsonData}, nil
+}
+
+// Kinds of the snapshots reported on demand, which are also their paths on the server
+const (
+	SnapshotServices  = "services"
+	SnapshotProcesses = "processes"
+)
+
+// SnapshotReport builds the report of a snapshot of a kind, such as the services of the host,
+// which replaces the previous one on the server
+func SnapshotReport(hostID int32, kind string, snapshot interface{}) (queue.Item, error) {
+	jsonData, err := json.Marshal(snapshot)
 	if err != nil {
-		return err
+		return queue.Item{}, err
 	}
 
-	// Set the headers
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+apiKey)
+	return queue.Item{Kind: kind, Method: "PUT", Path: "/agents/" + fmt.Sprint(hostID) + "/" + kind, Body: jsonData}, nil
+}
+
+// CheckResultsReport builds the report of the results of checks
+func CheckResultsReport(hostID int32, results []checks.Result) (queue.Item, error) {
+	jsonData, err := json.Marshal(results)
+	if err != nil {
+		return queue.Item{}, err
+	}
+
+	return queue.Item{Kind: "check_results", Method: "POST", Path: "/agents/" + fmt.Sprint(hostID) + "/checks/results", Body: jsonData}, nil
+}
+
+// EventLogReport builds the report of entries of the event logs of the host
+func EventLogReport(hostID int32, entries []eventlog.Entry) (queue.Item, error) {
+	jsonData, err := json.Marshal(entries)
+	if err != nil {
+		return queue.Item{}, err
+	}
+
+	return queue.Item{Kind: "event_logs", Method: "POST", Path: "/agents/" + fmt.Sprint(hostID) + "/event-logs", Body: jsonData}, nil
+}
+
+// HeartbeatReport builds a heartbeat to queue while the server is unreachable
+func HeartbeatReport(hostID int32) queue.Item {
+	return queue.Item{Kind: "heartbeat", Method: "POST", Path: "/agents/" + fmt.Sprint(hostID) + "/heartbeat"}
+}
+
+// Send sends a report to the server
+func Send(item queue.Item, ServerURL string, apiKey string) error {
+	var body io.Reader
+	if len(item.Body) > 0 {
+		body = bytes.NewReader(item.Body)
+	}
+
+	req, err := newRequest(item.Method, ServerURL, item.Path, apiKey, body)
+	if err != nil {
+		return err
+	}
 
 	// Send the request
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := do(req)
 	if err != nil {
 		return err
 	}
@@ -98,10 +275,114 @@ func Heartbeat(hostID int32, ServerURL string, apiKey string) error {
 
 	// Check the response status code
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("unexpected status code: %s", resp.Status)
-	} else {
-		fmt.Println("Successful Heartbeat")
+		return &StatusError{Code: resp.StatusCode, Status: resp.Status}
+	}
+
+	return nil
+}
+
+// FetchJobs retrieves the jobs the server has queued for the agent
+func FetchJobs(hostID int32, ServerURL string, apiKey string) ([]jobs.Job, error) {
+	req, err := newRequest("GET", ServerURL, "/agents/"+fmt.Sprint(hostID)+"/jobs", apiKey, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, &StatusError{Code: resp.StatusCode, Status: resp.Status}
+	}
+
+	var pending []jobs.Job
+	if err := json.NewDecoder(resp.Body).Decode(&pending); err != nil {
+		return nil, err
+	}
+
+	return pending, nil
+}
+
+// JobResultReport builds the report of the result of a job
+func JobResultReport(hostID int32, jobID int32, result jobs.Result) (queue.Item, error) {
+	jsonData, err := json.Marshal(result)
+	if err != nil {
+		return queue.Item{}, err
 	}
 
+	return queue.Item{Kind: "job_result", Method: "POST", Path: "/agents/" + fmt.Sprint(hostID) + "/jobs/" + fmt.Sprint(jobID), Body: jsonData}, nil
+}
+
+// Kinds of the sessions the agent connects back to the server for
+const (
+	SessionShell = "shell"
+	SessionFiles = "files"
+)
+
+// DialSession opens the WebSocket connection serving a session of a kind, such as the
+// remote shell requested by an open_shell job
+func DialSession(hostID int32, ServerURL string, apiKey string, kind string, request jobs.SessionRequest) (*websocket.Conn, error) {
+	location, err := url.Parse(ServerURL + apiVersion + "/agents/" + fmt.Sprint(hostID) + "/" + kind + "/" + fmt.Sprint(request.SessionID))
+	if err != nil {
+		return nil, err
+	}
+	origin := *location
+	origin.Path = ""
+	location.Scheme = strings.Replace(location.Scheme, "http", "ws", 1)
+
+	config, err := websocket.NewConfig(location.String(), origin.String())
+	if err != nil {
+		return nil, err
+	}
+	config.Header.Set("Authorization", "Bearer "+apiKey)
+	config.Header.Set(protocolHeader, fmt.Sprint(ProtocolVersion))
+	config.Header.Set(sessionTokenHeader, request.Token)
+	config.Dialer = &net.Dialer{Timeout: dialTimeout}
+	return websocket.DialConfig(config)
+}
+
+// TestConnection checks that the server can be reached with the API key and returns how long
+// it took. A registered agent sends a heartbeat, which also checks that its host still exists.
+func TestConnection(hostID int32, ServerURL string, apiKey string) (time.Duration, error) {
+	start := time.Now()
+	if hostID != 0 {
+		_, err := Heartbeat(hostID, ServerURL, apiKey)
+		return time.Since(start), err
+	}
+
+	req, err := newRequest("GET", ServerURL, "/openapi.json", apiKey, nil)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := do(req)
+	if err != nil {
+		return time.Since(start), err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return time.Since(start), &StatusError{Code: resp.StatusCode, Status: resp.Status}
+	}
+	return time.Since(start), nil
+}
+
+// Unregister deletes the host of the agent on the server
+func Unregister(hostID int32, ServerURL string, apiKey string) error {
+	req, err := newRequest("DELETE", ServerURL, "/agents/"+fmt.Sprint(hostID), apiKey, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		return &StatusError{Code: resp.StatusCode, Status: resp.Status}
+	}
 	return nil
 }
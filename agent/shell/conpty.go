@@ -0,0 +1,157 @@
+package shell
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"sync"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// pseudoConsole is a process attached to a Windows pseudo console (ConPTY). What is
+// written to input is typed in the console, and output reads what the console displays
+// as a VT sequence stream.
+type pseudoConsole struct {
+	console windows.Handle
+	process windows.Handle
+	input   *os.File
+	output  *os.File
+
+	// mu guards the handles, which are invalid once closed
+	mu     sync.Mutex
+	closed bool
+}
+
+// startPseudoConsole starts commandLine in a new pseudo console of cols by rows
+func startPseudoConsole(commandLine string, cols, rows int) (*pseudoConsole, error) {
+	var inRead, inWrite, outRead, outWrite windows.Handle
+	if err := windows.CreatePipe(&inRead, &inWrite, nil, 0); err != nil {
+		return nil, fmt.Errorf("could not create input pipe: %w", err)
+	}
+	if err := windows.CreatePipe(&outRead, &outWrite, nil, 0); err != nil {
+		windows.CloseHandle(inRead)
+		windows.CloseHandle(inWrite)
+		return nil, fmt.Errorf("could not create output pipe: %w", err)
+	}
+
+	var console windows.Handle
+	err := windows.CreatePseudoConsole(consoleSize(cols, rows), inRead, outWrite, 0, &console)
+	// The pseudo console keeps its own handles to its ends of the pipes
+	windows.CloseHandle(inRead)
+	windows.CloseHandle(outWrite)
+	if err != nil {
+		windows.CloseHandle(inWrite)
+		windows.CloseHandle(outRead)
+		return nil, fmt.Errorf("could not create pseudo console: %w", err)
+	}
+
+	pc := &pseudoConsole{
+		console: console,
+		input:   os.NewFile(uintptr(inWrite), "conpty-input"),
+		output:  os.NewFile(uintptr(outRead), "conpty-output"),
+	}
+	if err := pc.start(commandLine); err != nil {
+		pc.Close()
+		pc.output.Close()
+		return nil, err
+	}
+	return pc, nil
+}
+
+// start creates the process attached to the pseudo console
+func (pc *pseudoConsole) start(commandLine string) error {
+	attrs, err := windows.NewProcThreadAttributeList(1)
+	if err != nil {
+		return err
+	}
+	defer attrs.Delete()
+	// The attribute value is the pseudo console handle itself, not a pointer to it
+	if err := attrs.Update(windows.PROC_THREAD_ATTRIBUTE_PSEUDOCONSOLE, *(*unsafe.Pointer)(unsafe.Pointer(&pc.console)), unsafe.Sizeof(pc.console)); err != nil {
+		return fmt.Errorf("could not attach pseudo console: %w", err)
+	}
+
+	var si windows.StartupInfoEx
+	si.Cb = uint32(unsafe.Sizeof(si))
+	// No standard handles, so that the process uses the pseudo console rather than the
+	// handles of the service
+	si.Flags = windows.STARTF_USESTDHANDLES
+	si.ProcThreadAttributeList = attrs.List()
+
+	cmdLine, err := windows.UTF16PtrFromString(commandLine)
+	if err != nil {
+		return err
+	}
+	var pi windows.ProcessInformation
+	err = windows.CreateProcess(nil, cmdLine, nil, nil, false,
+		windows.EXTENDED_STARTUPINFO_PRESENT|windows.CREATE_UNICODE_ENVIRONMENT, nil, nil, &si.StartupInfo, &pi)
+	if err != nil {
+		return fmt.Errorf("could not start %s: %w", commandLine, err)
+	}
+	windows.CloseHandle(pi.Thread)
+	pc.process = pi.Process
+	return nil
+}
+
+// consoleSize returns the size of a pseudo console
+func consoleSize(cols, rows int) windows.Coord {
+	return windows.Coord{X: int16(min(cols, math.MaxInt16)), Y: int16(min(rows, math.MaxInt16))}
+}
+
+// Read reads what the console displays
+func (pc *pseudoConsole) Read(p []byte) (int, error) {
+	return pc.output.Read(p)
+}
+
+// Write types in the console
+func (pc *pseudoConsole) Write(p []byte) (int, error) {
+	return pc.input.Write(p)
+}
+
+// Resize changes the size of the console, unless it is closed already
+func (pc *pseudoConsole) Resize(cols, rows int) error {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	if pc.closed {
+		return nil
+	}
+	return windows.ResizePseudoConsole(pc.console, consoleSize(cols, rows))
+}
+
+// Wait waits for the process to exit and returns its exit code
+func (pc *pseudoConsole) Wait() (uint32, error) {
+	if _, err := windows.WaitForSingleObject(pc.process, windows.INFINITE); err != nil {
+		return 0, err
+	}
+	var exitCode uint32
+	err := windows.GetExitCodeProcess(pc.process, &exitCode)
+	return exitCode, err
+}
+
+// Kill terminates the process, unless the console is closed already
+func (pc *pseudoConsole) Kill() error {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	if pc.closed {
+		return nil
+	}
+	return windows.TerminateProcess(pc.process, 1)
+}
+
+// Close closes the console, which ends the output once what is left has been read, and
+// releases the process. The process must have exited or been killed. The output is closed
+// by its reader once it ends.
+func (pc *pseudoConsole) Close() {
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	if pc.closed {
+		return
+	}
+	pc.closed = true
+	windows.ClosePseudoConsole(pc.console)
+	pc.input.Close()
+	if pc.process != 0 {
+		windows.CloseHandle(pc.process)
+	}
+}
@@ -0,0 +1,97 @@
+// Package shell serves the remote shells opened from the dashboard. The agent connects
+// back to the server for each session and runs PowerShell in a pseudo console, relaying
+// its terminal over the connection.
+package shell
+
+import (
+	"fmt"
+	"slate-nexus-agent/logger"
+
+	"golang.org/x/net/websocket"
+)
+
+// command is the shell started for a session
+const command = "powershell.exe -NoLogo"
+
+// outputBuffer is how much console output is sent in one message
+const outputBuffer = 16 * 1024
+
+// Types of the messages exchanged with the server
+const (
+	typeInput  = "input"
+	typeOutput = "output"
+	typeResize = "resize"
+	typeExit   = "exit"
+	typeError  = "error"
+)
+
+// message is exchanged with the server over the shell connection
+type message struct {
+	Type string `json:"type"`
+	Data []byte `json:"data,omitempty"`
+	Cols int    `json:"cols,omitempty"`
+	Rows int    `json:"rows,omitempty"`
+	Text string `json:"text,omitempty"`
+}
+
+// Serve runs a shell in a pseudo console of cols by rows and relays it over conn until
+// the shell exits or the server closes the connection
+func Serve(conn *websocket.Conn, cols, rows int) {
+	defer conn.Close()
+
+	pc, err := startPseudoConsole(command, cols, rows)
+	if err != nil {
+		logger.LogError("could not start shell: %v", err)
+		websocket.JSON.Send(conn, message{Type: typeError, Text: err.Error()})
+		return
+	}
+
+	// Output is sent until the console is closed
+	outputDone := make(chan struct{})
+	go func() {
+		defer close(outputDone)
+		defer pc.output.Close()
+		buf := make([]byte, outputBuffer)
+		for {
+			n, err := pc.Read(buf)
+			if n > 0 {
+				if sendErr := websocket.JSON.Send(conn, message{Type: typeOutput, Data: buf[:n]}); sendErr != nil {
+					return
+				}
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	// Input is typed until the server closes the connection, which ends the shell
+	go func() {
+		for {
+			var msg message
+			if err := websocket.JSON.Receive(conn, &msg); err != nil {
+				pc.Kill()
+				return
+			}
+			switch msg.Type {
+			case typeInput:
+				pc.Write(msg.Data)
+			case typeResize:
+				if msg.Cols > 0 && msg.Rows > 0 {
+					pc.Resize(msg.Cols, msg.Rows)
+				}
+			}
+		}
+	}()
+
+	exitCode, err := pc.Wait()
+	pc.Close()
+	<-outputDone
+	if err != nil {
+		logger.LogError("could not wait for shell: %v", err)
+		websocket.JSON.Send(conn, message{Type: typeError, Text: err.Error()})
+		return
+	}
+	logger.LogInfo("Shell exited with code %d", exitCode)
+	websocket.JSON.Send(conn, message{Type: typeExit, Text: "The shell exited with code " + fmt.Sprint(exitCode) + "."})
+}
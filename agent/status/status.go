@@ -0,0 +1,91 @@
+// Package status keeps what the running agent knows about itself and serves it on a
+// loopback-only HTTP endpoint for technicians and the agent CLI.
+package status
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Addr is where the status endpoint listens. It is bound to loopback only.
+const Addr = "127.0.0.1:47321"
+
+// Status is a snapshot of the agent state. The times of the last heartbeat, inventory and
+// error are unset until the agent has sent one or run into one.
+type Status struct {
+	Registered      bool       `json:"registered"`
+	HostID          int32      `json:"host_id"`
+	ServerURL       string     `json:"server_url"`
+	AgentVersion    string     `json:"agent_version"`
+	ProtocolVersion int        `json:"protocol_version"`
+	StartedAt       time.Time  `json:"started_at"`
+	LastHeartbeat   *time.Time `json:"last_heartbeat,omitempty"`
+	LastInventory   *time.Time `json:"last_inventory,omitempty"`
+	QueuedReports   int        `json:"queued_reports"`
+	LastError       string     `json:"last_error,omitempty"`
+	LastErrorAt     *time.Time `json:"last_error_at,omitempty"`
+}
+
+var (
+	mu      sync.RWMutex
+	current = Status{StartedAt: time.Now()}
+)
+
+// Update changes the status of the agent
+func Update(change func(s *Status)) {
+	mu.Lock()
+	defer mu.Unlock()
+	change(&current)
+}
+
+// Error records the last error the agent ran into
+func Error(err error) {
+	now := time.Now()
+	Update(func(s *Status) {
+		s.LastError = err.Error()
+		s.LastErrorAt = &now
+	})
+}
+
+// Get returns a snapshot of the status of the agent
+func Get() Status {
+	mu.RLock()
+	defer mu.RUnlock()
+	return current
+}
+
+// Serve serves the status on Addr until the listener fails
+func Serve() error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /status", func(w http.ResponseWriter, r *http.Request) {
+		// The listener is bound to loopback, this guards against it ever being changed
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if ip := net.ParseIP(host); err != nil || ip == nil || !ip.IsLoopback() {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(Get())
+	})
+
+	srv := &http.Server{Addr: Addr, Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+	return srv.ListenAndServe()
+}
+
+// Fetch reads the status of the running agent service from its endpoint
+func Fetch() (Status, error) {
+	var s Status
+	client := http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Get("http://" + Addr + "/status")
+	if err != nil {
+		return s, err
+	}
+	defer resp.Body.Close()
+
+	err = json.NewDecoder(resp.Body).Decode(&s)
+	return s, err
+}
@@ -0,0 +1,154 @@
+// Package agentconn pairs the sessions the dashboard opens on an agent, such as remote
+// shells and file browsers, with the WebSocket the agent opens back to the server for them.
+// The agent is asked to connect with a job carrying the ID and token of the session.
+package agentconn
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// AgentTimeout is how long the agent has to connect once a session is requested. Agents
+// fetch their jobs with their heartbeat, every minute by default.
+const AgentTimeout = 3 * time.Minute
+
+// Reasons a session ends, recorded with it
+const (
+	ReasonClosed         = "closed by the technician"
+	ReasonIdle           = "idle timeout"
+	ReasonAgentLost      = "agent disconnected"
+	ReasonAgentTimeout   = "agent did not connect"
+	ReasonServerStopping = "server stopping"
+)
+
+// Errors returned while waiting for the agent
+var (
+	// ErrAgentTimeout is returned when the agent does not connect in time
+	ErrAgentTimeout = errors.New("the agent did not connect in time")
+	// ErrStopping is returned once the server is shutting down
+	ErrStopping = errors.New("the server is stopping")
+	// ErrClosed is returned once the session is closed
+	ErrClosed = errors.New("the session is closed")
+)
+
+// stopping is closed by Shutdown. The connections of the sessions are hijacked, so the
+// HTTP server does not close them itself.
+var (
+	stopping     = make(chan struct{})
+	stoppingOnce sync.Once
+)
+
+// Shutdown ends every session so the server can shut down
+func Shutdown() {
+	stoppingOnce.Do(func() { close(stopping) })
+}
+
+// Stopping is closed once the server is shutting down
+func Stopping() <-chan struct{} {
+	return stopping
+}
+
+// Session is a session requested from the dashboard, waiting for or attached to the
+// connection of its agent
+type Session struct {
+	// Kind is what the session serves, such as "shell" or "files", which the agent names
+	// when it connects
+	Kind   string
+	ID     int32
+	HostID int32
+	// Token authenticates the agent connection of the session
+	Token string
+
+	// maxMessageSize limits the messages received from the agent
+	maxMessageSize int
+
+	agent chan *websocket.Conn
+	done  chan struct{}
+	once  sync.Once
+}
+
+// sessions holds the sessions waiting for their agent
+var sessions struct {
+	sync.Mutex
+	waiting map[int32]*Session
+}
+
+// New creates a session of a kind waiting for the agent of a host to connect. Messages
+// larger than maxMessageSize are refused on the agent connection.
+func New(kind string, id, hostID int32, maxMessageSize int) (*Session, error) {
+	token := make([]byte, 32)
+	if _, err := rand.Read(token); err != nil {
+		return nil, err
+	}
+	session := &Session{
+		Kind:           kind,
+		ID:             id,
+		HostID:         hostID,
+		Token:          hex.EncodeToString(token),
+		maxMessageSize: maxMessageSize,
+		agent:          make(chan *websocket.Conn, 1),
+		done:           make(chan struct{}),
+	}
+
+	sessions.Lock()
+	defer sessions.Unlock()
+	if sessions.waiting == nil {
+		sessions.waiting = map[int32]*Session{}
+	}
+	sessions.waiting[id] = session
+	return session, nil
+}
+
+// Claim returns the session of a kind waiting for the agent of a host with the token, or
+// nil. A session can only be claimed once.
+func Claim(kind string, id, hostID int32, token string) *Session {
+	sessions.Lock()
+	defer sessions.Unlock()
+	session, ok := sessions.waiting[id]
+	if !ok || session.Kind != kind || session.HostID != hostID || subtle.ConstantTimeCompare([]byte(session.Token), []byte(token)) != 1 {
+		return nil
+	}
+	delete(sessions.waiting, id)
+	return session
+}
+
+// Attach hands the connection of the agent to the session and returns once the session
+// is over
+func (s *Session) Attach(conn *websocket.Conn) {
+	conn.MaxPayloadBytes = s.maxMessageSize
+	s.agent <- conn
+	<-s.done
+}
+
+// WaitAgent returns the connection of the agent once it attaches, or ErrAgentTimeout. It
+// returns ErrClosed if the session is closed first.
+func (s *Session) WaitAgent() (*websocket.Conn, error) {
+	timer := time.NewTimer(AgentTimeout)
+	defer timer.Stop()
+	select {
+	case conn := <-s.agent:
+		return conn, nil
+	case <-timer.C:
+		return nil, ErrAgentTimeout
+	case <-stopping:
+		return nil, ErrStopping
+	case <-s.done:
+		return nil, ErrClosed
+	}
+}
+
+// Close ends the session, releasing the agent connection
+func (s *Session) Close() {
+	sessions.Lock()
+	if sessions.waiting[s.ID] == s {
+		delete(sessions.waiting, s.ID)
+	}
+	sessions.Unlock()
+	s.once.Do(func() { close(s.done) })
+}
@@ -3,17 +3,52 @@ package main
 import (
 	"net/http"
 	"slate-rmm/api_handlers"
+	"slate-rmm/database"
+	"slate-rmm/openapi"
+	"slate-rmm/telemetry"
 
 	"github.com/gorilla/mux"
 )
 
-// NewGateway creates a new router and defines the routes for the microservices
+// APIVersion is the path prefix of the current version of the API
+const APIVersion = "/v1"
+
+// NewGateway creates a new router and defines the routes for the microservices.
+// Every route is served under the versioned prefix and, for agents and integrations
+// that predate it, at the unversioned legacy path.
 func NewGateway() *mux.Router {
 	router := mux.NewRouter().StrictSlash(true)
+	router.NotFoundHandler = http.HandlerFunc(api_handlers.NotFound)
+	router.MethodNotAllowedHandler = http.HandlerFunc(api_handlers.MethodNotAllowed)
+
+	// Trace requests and record their route for the request log and metrics
+	telemetry.TraceRouter(router)
+	router.Use(telemetry.RouteMiddleware)
+
+	versioned := router.PathPrefix(APIVersion).Subrouter()
+	apiRoutes(versioned, versioned)
+	apiRoutes(router, versioned)
+
+	// Prometheus scrapes the metrics with the API key like any other client
+	router.Handle("/metrics", telemetry.MetricsHandler()).Methods("GET")
+
+	return router
+}
 
+// apiRoutes defines the routes for the microservices on router. The OpenAPI document
+// is generated from the versioned router.
+func apiRoutes(router *mux.Router, versioned *mux.Router) {
 	// Define routes for each microservice
 	agentRoutes(router.PathPrefix("/agents").Subrouter())
 	groupRoutes(router.PathPrefix("/groups").Subrouter())
+	organizationRoutes(router.PathPrefix("/organizations").Subrouter())
+	siteRoutes(router.PathPrefix("/sites").Subrouter())
+	scriptRoutes(router.PathPrefix("/scripts").Subrouter())
+	jobRoutes(router.PathPrefix("/jobs").Subrouter())
+	customFieldRoutes(router.PathPrefix("/custom-fields").Subrouter())
+	configProfileRoutes(router.PathPrefix("/config-profiles").Subrouter())
+	remoteAccessRoutes(router.PathPrefix("/remote-access").Subrouter())
+	checkRoutes(router.PathPrefix("/checks").Subrouter())
 
 	// Serve the agent executable
 	router.HandleFunc("/download/agent", func(w http.ResponseWriter, r *http.Request) {
@@ -27,19 +62,49 @@ func NewGateway() *mux.Router {
 		http.ServeFile(w, r, "../agent/Install-Remotely.ps1")
 	})
 
-	return router
-
+	// Serve the OpenAPI document generated from the routes above
+	router.HandleFunc("/openapi.json", openapi.Handler(versioned, apiSpec)).Methods("GET")
 }
 
 // agentRoutes defines the routes for the agent database microservice
 func agentRoutes(router *mux.Router) {
-	router.HandleFunc("/register", api_handlers.AgentRegistration).Methods("POST")
+	router.HandleFunc("/register", api_handlers.AgentProtocol(api_handlers.AgentRegistration)).Methods("POST")
 	router.HandleFunc("", api_handlers.GetAllAgents).Methods("GET")
+	router.HandleFunc("/protocols", api_handlers.GetProtocolVersions).Methods("GET")
 	router.HandleFunc("/{id}", api_handlers.GetAgent).Methods("GET")
-	router.HandleFunc("/secret", api_handlers.VerifyAgentToken).Methods("POST")
-	router.HandleFunc("/{id}", api_handlers.UpdateAgent).Methods("PUT")
+	router.HandleFunc("/secret", api_handlers.AgentProtocol(api_handlers.VerifyAgentToken)).Methods("POST")
+	router.HandleFunc("/{id}", api_handlers.AgentProtocol(api_handlers.UpdateAgent)).Methods("PUT", "PATCH")
 	router.HandleFunc("/{id}", api_handlers.DeleteAgent).Methods("DELETE")
-	router.HandleFunc("/{id}/heartbeat", api_handlers.AgentHeartbeat).Methods("POST")
+	router.HandleFunc("/{id}/heartbeat", api_handlers.AgentProtocol(api_handlers.AgentHeartbeat)).Methods("POST")
+	router.HandleFunc("/{id}/jobs", api_handlers.AgentProtocol(api_handlers.GetPendingJobs)).Methods("GET")
+	router.HandleFunc("/{id}/jobs/{job_id}", api_handlers.AgentProtocol(api_handlers.ReportJobResult)).Methods("POST")
+	router.HandleFunc("/bulk/{action}", api_handlers.BulkAction).Methods("POST")
+	router.HandleFunc("/{id}/tags", api_handlers.SetAgentTags).Methods("PUT")
+	router.HandleFunc("/{id}/tags/{key}", api_handlers.SetAgentTag).Methods("PUT")
+	router.HandleFunc("/{id}/tags/{key}", api_handlers.DeleteAgentTag).Methods("DELETE")
+	router.HandleFunc("/{id}/custom-fields", api_handlers.SetAgentCustomFields).Methods("PUT")
+	router.HandleFunc("/{id}/profile", api_handlers.SetAgentProfile).Methods("PUT")
+	router.HandleFunc("/{id}/name", api_handlers.SetAgentName).Methods("PUT")
+	router.HandleFunc("/{id}/logs", api_handlers.RequestAgentLogs).Methods("POST")
+	router.HandleFunc("/{id}/logs/{job_id}", api_handlers.GetAgentLogs).Methods("GET")
+	router.HandleFunc("/{id}/remote-sessions", api_handlers.GetRemoteSessions).Methods("GET")
+	router.HandleFunc("/{id}/remote-sessions/{session_id}/recording", api_handlers.GetShellRecording).Methods("GET")
+	router.HandleFunc("/{id}/remote-sessions/{session_id}/files", api_handlers.GetFileOperations).Methods("GET")
+	router.HandleFunc("/{id}/shell/{session_id}", api_handlers.AgentProtocol(api_handlers.AgentSession(database.ShellProvider))).Methods("GET")
+	router.HandleFunc("/{id}/files/{session_id}", api_handlers.AgentProtocol(api_handlers.AgentSession(database.FilesProvider))).Methods("GET")
+	router.HandleFunc("/{id}/services", api_handlers.GetServices).Methods("GET")
+	router.HandleFunc("/{id}/services", api_handlers.AgentProtocol(api_handlers.ReportServices)).Methods("PUT")
+	router.HandleFunc("/{id}/services/refresh", api_handlers.RefreshServices).Methods("POST")
+	router.HandleFunc("/{id}/services/{name}/{action}", api_handlers.ControlService).Methods("POST")
+	router.HandleFunc("/{id}/processes", api_handlers.GetProcesses).Methods("GET")
+	router.HandleFunc("/{id}/processes", api_handlers.AgentProtocol(api_handlers.ReportProcesses)).Methods("PUT")
+	router.HandleFunc("/{id}/processes/refresh", api_handlers.RefreshProcesses).Methods("POST")
+	router.HandleFunc("/{id}/processes/{pid}/terminate", api_handlers.TerminateProcess).Methods("POST")
+	router.HandleFunc("/{id}/checks", api_handlers.GetAgentChecks).Methods("GET")
+	router.HandleFunc("/{id}/checks/results", api_handlers.AgentProtocol(api_handlers.ReportCheckResults)).Methods("POST")
+	router.HandleFunc("/{id}/checks/{check_id}/results", api_handlers.GetCheckHistory).Methods("GET")
+	router.HandleFunc("/{id}/event-logs", api_handlers.GetEventLogs).Methods("GET")
+	router.HandleFunc("/{id}/event-logs", api_handlers.AgentProtocol(api_handlers.ReportEventLogs)).Methods("POST")
 }
 
 // groupRoutes defines the routes for the group database microservice
@@ -53,4 +118,71 @@ func groupRoutes(router *mux.Router) {
 	router.HandleFunc("/{group_id}/add/{host_id}", api_handlers.AddHostToGroup).Methods("POST")
 	router.HandleFunc("/{group_id}/remove/{host_id}", api_handlers.RemoveHostFromGroup).Methods("DELETE")
 	router.HandleFunc("/{group_id}/move/{host_id}", api_handlers.MoveHostToGroup).Methods("PUT")
+	router.HandleFunc("/{group_id}/profile", api_handlers.SetGroupProfile).Methods("PUT")
+	router.HandleFunc("/{group_id}/checks", api_handlers.GetGroupChecks).Methods("GET")
+	router.HandleFunc("/{group_id}/checks", api_handlers.CreateCheck).Methods("POST")
+}
+
+// checkRoutes defines the routes for the monitoring check database microservice. Checks are
+// created in their group.
+func checkRoutes(router *mux.Router) {
+	router.HandleFunc("/status", api_handlers.GetCheckStatus).Methods("GET")
+	router.HandleFunc("/{check_id}", api_handlers.GetCheck).Methods("GET")
+	router.HandleFunc("/{check_id}", api_handlers.UpdateCheck).Methods("PUT")
+	router.HandleFunc("/{check_id}", api_handlers.DeleteCheck).Methods("DELETE")
+}
+
+// organizationRoutes defines the routes for the organization (client) database microservice
+func organizationRoutes(router *mux.Router) {
+	router.HandleFunc("", api_handlers.GetAllOrganizations).Methods("GET")
+	router.HandleFunc("", api_handlers.CreateOrganization).Methods("POST")
+	router.HandleFunc("/{org_id}", api_handlers.GetOrganization).Methods("GET")
+	router.HandleFunc("/{org_id}", api_handlers.UpdateOrganization).Methods("PUT")
+	router.HandleFunc("/{org_id}/sites", api_handlers.GetSites).Methods("GET")
+	router.HandleFunc("/{org_id}/sites", api_handlers.CreateSite).Methods("POST")
+}
+
+// siteRoutes defines the routes for the site database microservice
+func siteRoutes(router *mux.Router) {
+	router.HandleFunc("/{site_id}", api_handlers.GetSite).Methods("GET")
+	router.HandleFunc("/{site_id}", api_handlers.UpdateSite).Methods("PUT")
+	router.HandleFunc("/{site_id}/hosts/{host_id}", api_handlers.MoveHostToSite).Methods("PUT")
+}
+
+// scriptRoutes defines the routes for the saved script database microservice
+func scriptRoutes(router *mux.Router) {
+	router.HandleFunc("", api_handlers.GetAllScripts).Methods("GET")
+	router.HandleFunc("", api_handlers.CreateScript).Methods("POST")
+	router.HandleFunc("/{script_id}", api_handlers.GetScript).Methods("GET")
+	router.HandleFunc("/{script_id}", api_handlers.DeleteScript).Methods("DELETE")
+}
+
+// jobRoutes defines the routes for the agent job microservice
+func jobRoutes(router *mux.Router) {
+	router.HandleFunc("/{job_id}", api_handlers.GetJob).Methods("GET")
+}
+
+// customFieldRoutes defines the routes for the custom field definition microservice
+func customFieldRoutes(router *mux.Router) {
+	router.HandleFunc("", api_handlers.GetAllCustomFields).Methods("GET")
+	router.HandleFunc("", api_handlers.CreateCustomField).Methods("POST")
+	router.HandleFunc("/{field_id}", api_handlers.UpdateCustomField).Methods("PUT")
+	router.HandleFunc("/{field_id}", api_handlers.DeleteCustomField).Methods("DELETE")
+}
+
+// configProfileRoutes defines the routes for the agent configuration profile microservice
+func configProfileRoutes(router *mux.Router) {
+	router.HandleFunc("", api_handlers.GetAllConfigProfiles).Methods("GET")
+	router.HandleFunc("", api_handlers.CreateConfigProfile).Methods("POST")
+	router.HandleFunc("/{profile_id}", api_handlers.GetConfigProfile).Methods("GET")
+	router.HandleFunc("/{profile_id}", api_handlers.UpdateConfigProfile).Methods("PUT")
+	router.HandleFunc("/{profile_id}", api_handlers.DeleteConfigProfile).Methods("DELETE")
+}
+
+// remoteAccessRoutes defines the routes for the remote access microservice
+func remoteAccessRoutes(router *mux.Router) {
+	router.HandleFunc("/providers", api_handlers.GetRemoteAccessProviders).Methods("GET")
+	router.HandleFunc("/policies", api_handlers.GetRemoteAccessPolicies).Methods("GET")
+	router.HandleFunc("/policies", api_handlers.CreateRemoteAccessPolicy).Methods("POST")
+	router.HandleFunc("/policies/{policy_id}", api_handlers.DeleteRemoteAccessPolicy).Methods("DELETE")
 }
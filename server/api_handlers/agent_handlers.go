@@ -1,11 +1,12 @@
 package api_handlers
 
 import (
-	"encoding/json"
 	"log"
 	"net/http"
 	"slate-rmm/database"
+	"slate-rmm/events"
 	"slate-rmm/models"
+	"strings"
 	"time"
 
 	"github.com/gorilla/mux"
@@ -13,28 +14,48 @@ import (
 
 var agentTokens = make(map[string]string)
 
-// AgentRegistration handles the registration of a new agent
+// AgentRegistration handles the registration of a new agent.
+// Part of the agent protocol: the registered agent is returned without the response envelope.
 func AgentRegistration(w http.ResponseWriter, r *http.Request) {
 	var newAgent models.Agent
 	// Decode the incoming JSON to the newAgent struct
-	if err := json.NewDecoder(r.Body).Decode(&newAgent); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+	if !decodeAgent(w, r, &newAgent) {
 		return
 	}
+	if newAgent.Hostname == "" {
+		writeValidationError(w, map[string]string{"hostname": "hostname is required"})
+		return
+	}
+
+	// Enroll the agent into the site of the client its enrollment key belongs to
+	siteID, err := database.ResolveEnrollment(newAgent.EnrollmentKey, newAgent.SiteID)
+	if err != nil {
+		if err == database.ErrInvalidEnrollmentKey || err == database.ErrNotFound {
+			WriteError(w, http.StatusForbidden, CodeForbidden, "invalid enrollment key or site")
+			return
+		}
+		writeDatabaseError(w, err, "")
+		return
+	}
+	newAgent.SiteID = siteID
+	newAgent.EnrollmentKey = ""
 
 	if err := database.RegisterNewAgent(&newAgent); err != nil {
-		http.Error(w, "error registering agent", http.StatusInternalServerError)
-		// Print the error message
-		log.Printf("error registering agent: %v\n", err)
+		writeDatabaseError(w, err, "")
 		return
 	}
 
-	// Convert the agent ID to a string
-	// agentIDStr := strconv.Itoa(int(newAgent.ID))
+	// Deliver the configuration the agent starts with
+	newAgent.Config, err = database.GetEffectiveConfig(itoa(int(newAgent.ID)))
+	if err != nil {
+		writeDatabaseError(w, err, "")
+		return
+	}
 
 	// Respond with the registered agent
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(newAgent)
+	writeRaw(w, http.StatusCreated, newAgent)
+
+	events.Publish(events.Event{Type: events.AgentRegistered, HostID: newAgent.ID})
 
 	// Sleep for 5 seconds to allow host creation to complete
 	time.Sleep(5 * time.Second)
@@ -45,105 +66,174 @@ func VerifyAgentToken(w http.ResponseWriter, r *http.Request) {
 	log.Println("Received token for API secret request")
 	// Decode the incoming JSON to get the token and agent ID
 	var data map[string]string
-	err := json.NewDecoder(r.Body).Decode(&data)
-	if err != nil {
-		log.Printf("could not decode request body: %v\n", err)
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+	if !decodeBody(w, r, &data) {
 		return
 	}
 
 	agentID, ok := data["agent_id"]
 	if !ok {
-		log.Printf("Agent ID not provided\n")
-		http.Error(w, "Agent ID not provided", http.StatusBadRequest)
+		writeValidationError(w, map[string]string{"agent_id": "agent_id is required"})
 		return
 	}
 
 	// Respond with the host_ID
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	response := map[string]string{"host_id": agentID}
-	err = json.NewEncoder(w).Encode(response)
-	if err != nil {
-		log.Printf("could not encode response: %v\n", err)
-		http.Error(w, "could not encode response", http.StatusInternalServerError)
-		return
-	}
-
-	// Log the response that was sent
-	// log.Printf("Response: %v\n", response)
+	writeRaw(w, http.StatusOK, map[string]string{"host_id": agentID})
 }
 
 // GetAllAgents returns all the agents in the database
 func GetAllAgents(w http.ResponseWriter, r *http.Request) {
-	agents, err := database.GetAllAgents()
+	orgID, ok := scopeFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	agents, err := database.GetAllAgents(orgID, models.ParseAgentFilter(r.URL.Query()))
 	if err != nil {
-		log.Printf("error getting agents: %v", err)
-		http.Error(w, "error getting agents", http.StatusInternalServerError)
+		writeDatabaseError(w, err, "")
 		return
 	}
 
-	json.NewEncoder(w).Encode(agents)
+	writeJSON(w, http.StatusOK, agents)
 }
 
 // GetAgent returns a single agent from the database
 func GetAgent(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	id := vars["id"]
+	id, ok := intVar(w, mux.Vars(r), "id")
+	if !ok {
+		return
+	}
+
+	orgID, ok := scopeFromRequest(w, r)
+	if !ok {
+		return
+	}
 
-	agent, err := database.GetAgent(id)
+	agent, err := database.GetAgent(itoa(id), orgID)
 	if err != nil {
-		log.Printf("error getting agent: %v", err)
-		http.Error(w, "error getting agent", http.StatusInternalServerError)
+		writeDatabaseError(w, err, "")
+		return
+	}
+	if agent == nil {
+		WriteError(w, http.StatusNotFound, CodeNotFound, "agent not found")
 		return
 	}
 
-	json.NewEncoder(w).Encode(agent)
+	agent.Config, err = database.GetEffectiveConfig(itoa(id))
+	if err != nil {
+		writeDatabaseError(w, err, "")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, agent)
 }
 
-// UpdateAgent updates an agent in the database
+// UpdateAgent handles the PUT and PATCH /api/agents/{id} routes where agents report their inventory.
+// Only the fields present in the body are updated, so agents can send just what changed.
+// Part of the agent protocol: the agent only checks the status code.
 func UpdateAgent(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	id := vars["id"]
+	id, ok := intVar(w, mux.Vars(r), "id")
+	if !ok {
+		return
+	}
 
-	var updatedAgent models.Agent
-	err := json.NewDecoder(r.Body).Decode(&updatedAgent)
-	if err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+	var update models.InventoryUpdate
+	if !decodeBody(w, r, &update) {
 		return
 	}
 
-	err = database.UpdateAgent(id, &updatedAgent)
+	change, err := database.UpdateAgent(itoa(id), update, protocolVersion(r))
 	if err != nil {
-		http.Error(w, "error updating agent", http.StatusInternalServerError)
+		writeDatabaseError(w, err, "agent not found")
 		return
 	}
 
 	w.WriteHeader(http.StatusOK)
+
+	publishStatusChange(change)
+	events.Publish(events.Event{Type: events.AgentUpdated, HostID: int32(id)})
 }
 
-// DeleteAgent deletes an agent from the database
+// DeleteAgent deletes an agent from the database along with its group memberships
 func DeleteAgent(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	id := vars["id"]
+	id, ok := intVar(w, mux.Vars(r), "id")
+	if !ok {
+		return
+	}
+
+	orgID, ok := scopeFromRequest(w, r)
+	if !ok {
+		return
+	}
 
-	err := database.DeleteAgent(id)
+	err := database.DeleteAgent(itoa(id), orgID)
 	if err != nil {
-		http.Error(w, "error deleting agent", http.StatusInternalServerError)
+		writeDatabaseError(w, err, "agent not found")
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
+	w.WriteHeader(http.StatusNoContent)
+
+	events.Publish(events.Event{Type: events.AgentDeleted, HostID: int32(id)})
 }
 
-// AgentHeartbeat updates tha agent data in the database
+// SetAgentName handles the PUT /api/agents/{id}/name route, setting the name shown instead
+// of the hostname. An empty name shows the hostname again.
+func SetAgentName(w http.ResponseWriter, r *http.Request) {
+	hostID, orgID, ok := hostVars(w, r)
+	if !ok {
+		return
+	}
+
+	var payload struct {
+		DisplayName string `json:"display_name"`
+	}
+	if !decodeBody(w, r, &payload) {
+		return
+	}
+	payload.DisplayName = strings.TrimSpace(payload.DisplayName)
+	if len(payload.DisplayName) > 255 {
+		writeValidationError(w, map[string]string{"display_name": "display_name must be at most 255 characters"})
+		return
+	}
+
+	if err := database.RenameAgent(hostID, payload.DisplayName, orgID); err != nil {
+		writeDatabaseError(w, err, "agent not found")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+
+	events.Publish(events.Event{Type: events.AgentUpdated, HostID: int32(hostID)})
+}
+
+// AgentHeartbeat handles the POST /api/agents/{id}/heartbeat route, the lightweight liveness
+// check agents send between inventory reports. It only updates last_seen and answers with
+// the current configuration of the agent and the checks it runs, so that profile and check
+// changes are applied live.
 func AgentHeartbeat(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	id := vars["id"]
+	id, ok := intVar(w, mux.Vars(r), "id")
+	if !ok {
+		return
+	}
 
-	err := database.AgentHeartbeat(id)
+	change, err := database.AgentHeartbeat(itoa(id), protocolVersion(r))
 	if err != nil {
-		http.Error(w, "error updating agent", http.StatusInternalServerError)
+		writeDatabaseError(w, err, "agent not found")
 		return
 	}
+	publishStatusChange(change)
+
+	effective, err := database.GetEffectiveConfig(itoa(id))
+	if err != nil {
+		writeDatabaseError(w, err, "")
+		return
+	}
+
+	checks, err := database.GetAgentChecks(itoa(id))
+	if err != nil {
+		writeDatabaseError(w, err, "")
+		return
+	}
+
+	writeRaw(w, http.StatusOK, models.HeartbeatResponse{Config: effective.Config, Checks: checks})
 }
@@ -0,0 +1,34 @@
+package api_handlers
+
+import (
+	"net/http"
+	"slate-rmm/database"
+	"slate-rmm/events"
+	"slate-rmm/models"
+
+	"github.com/gorilla/mux"
+)
+
+// BulkAction handles the POST /api/agents/bulk/{action} route
+func BulkAction(w http.ResponseWriter, r *http.Request) {
+	var req models.BulkRequest
+	if !decodeBody(w, r, &req) {
+		return
+	}
+	req.Action = mux.Vars(r)["action"]
+
+	orgID, ok := scopeFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	results, err := database.RunBulkAction(req, orgID)
+	if err != nil {
+		writeDatabaseError(w, err, "")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, results)
+
+	events.PublishBulk(results, req.Action == database.BulkDelete)
+}
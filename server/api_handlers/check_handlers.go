@@ -0,0 +1,372 @@
+package api_handlers
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"slate-rmm/database"
+	"slate-rmm/events"
+	"slate-rmm/models"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Limits of the check results
+const (
+	// maxCheckReport limits the results an agent reports at once
+	maxCheckReport = 1024 * 1024
+	// maxCheckOutput limits the output stored with a result; longer output is truncated
+	maxCheckOutput = 4096
+	// defaultCheckHistory and maxCheckHistory limit the results returned by the history
+	defaultCheckHistory = 100
+	maxCheckHistory     = 1000
+	// staleCheckIntervals is how many intervals a check may go without reporting before the
+	// status export reports it as unknown
+	staleCheckIntervals = 3
+)
+
+// Formats of the check status export
+const (
+	// statusFormatCheckMK is the output of a CheckMK agent: a piggyback section per host with
+	// a local check per check, for a datasource program that fetches it
+	statusFormatCheckMK = "checkmk"
+	// statusFormatNagios is a PROCESS_SERVICE_CHECK_RESULT external command per check, for
+	// the command file of Nagios or compatible cores
+	statusFormatNagios = "nagios"
+)
+
+// checkStatuses are the statuses agents report checks with
+var checkStatuses = []string{models.CheckOK, models.CheckWarning, models.CheckCritical, models.CheckUnknown}
+
+// validateCheck checks the name and settings of a check
+func validateCheck(w http.ResponseWriter, check models.Check) bool {
+	fields := check.Validate()
+	validateName(fields, "check_name", check.CheckName)
+	if len(fields) > 0 {
+		writeValidationError(w, fields)
+		return false
+	}
+	return true
+}
+
+// GetGroupChecks handles the GET /api/groups/{group_id}/checks route
+func GetGroupChecks(w http.ResponseWriter, r *http.Request) {
+	groupID, ok := intVar(w, mux.Vars(r), "group_id")
+	if !ok {
+		return
+	}
+	orgID, ok := scopeFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	checks, err := database.GetGroupChecks(groupID, orgID)
+	if err != nil {
+		writeDatabaseError(w, err, "group not found")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, checks)
+}
+
+// CreateCheck handles the POST /api/groups/{group_id}/checks route. The agents of the hosts
+// in the group start running the check on their next heartbeat.
+func CreateCheck(w http.ResponseWriter, r *http.Request) {
+	groupID, ok := intVar(w, mux.Vars(r), "group_id")
+	if !ok {
+		return
+	}
+	orgID, ok := scopeFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	check := models.Check{Interval: models.DefaultCheckInterval, Enabled: true}
+	if !decodeBody(w, r, &check) {
+		return
+	}
+	if !validateCheck(w, check) {
+		return
+	}
+	check.GroupID = int32(groupID)
+
+	if err := database.CreateCheck(&check, orgID); err != nil {
+		writeDatabaseError(w, err, "group not found")
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, check)
+}
+
+// GetCheck handles the GET /api/checks/{check_id} route
+func GetCheck(w http.ResponseWriter, r *http.Request) {
+	checkID, ok := intVar(w, mux.Vars(r), "check_id")
+	if !ok {
+		return
+	}
+	orgID, ok := scopeFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	check, err := database.GetCheck(checkID, orgID)
+	if err != nil {
+		writeDatabaseError(w, err, "")
+		return
+	}
+	if check == nil {
+		WriteError(w, http.StatusNotFound, CodeNotFound, "check not found")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, check)
+}
+
+// UpdateCheck handles the PUT /api/checks/{check_id} route. The check stays in its group.
+func UpdateCheck(w http.ResponseWriter, r *http.Request) {
+	checkID, ok := intVar(w, mux.Vars(r), "check_id")
+	if !ok {
+		return
+	}
+	orgID, ok := scopeFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	check := models.Check{Interval: models.DefaultCheckInterval, Enabled: true}
+	if !decodeBody(w, r, &check) {
+		return
+	}
+	if !validateCheck(w, check) {
+		return
+	}
+	check.CheckID = int32(checkID)
+
+	if err := database.UpdateCheck(&check, orgID); err != nil {
+		writeDatabaseError(w, err, "check not found")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, check)
+}
+
+// DeleteCheck handles the DELETE /api/checks/{check_id} route, deleting the check along with
+// its results
+func DeleteCheck(w http.ResponseWriter, r *http.Request) {
+	checkID, ok := intVar(w, mux.Vars(r), "check_id")
+	if !ok {
+		return
+	}
+	orgID, ok := scopeFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	if err := database.DeleteCheck(checkID, orgID); err != nil {
+		writeDatabaseError(w, err, "check not found")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetAgentChecks handles the GET /api/agents/{id}/checks route, returning the latest result
+// of every check the agent runs
+func GetAgentChecks(w http.ResponseWriter, r *http.Request) {
+	hostID, orgID, ok := hostVars(w, r)
+	if !ok {
+		return
+	}
+
+	states, err := database.GetCheckStates(hostID, orgID)
+	if err != nil {
+		writeDatabaseError(w, err, "agent not found")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, states)
+}
+
+// GetCheckHistory handles the GET /api/agents/{id}/checks/{check_id}/results route, returning
+// the results of a check on the agent newest first. The since query parameter, an RFC 3339
+// time, and the limit query parameter select the results.
+func GetCheckHistory(w http.ResponseWriter, r *http.Request) {
+	hostID, orgID, ok := hostVars(w, r)
+	if !ok {
+		return
+	}
+	checkID, ok := intVar(w, mux.Vars(r), "check_id")
+	if !ok {
+		return
+	}
+
+	query := r.URL.Query()
+	since := time.Now().Add(-database.CheckResultRetention)
+	if value := query.Get("since"); value != "" {
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			writeValidationError(w, map[string]string{"since": "since must be an RFC 3339 time"})
+			return
+		}
+		since = parsed
+	}
+	limit := defaultCheckHistory
+	if value := query.Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > maxCheckHistory {
+			writeValidationError(w, map[string]string{"limit": "limit must be between 1 and " + strconv.Itoa(maxCheckHistory)})
+			return
+		}
+		limit = parsed
+	}
+
+	results, err := database.GetCheckHistory(hostID, checkID, since, limit, orgID)
+	if err != nil {
+		writeDatabaseError(w, err, "agent not found")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, results)
+}
+
+// ReportCheckResults handles the POST /api/agents/{id}/checks/results route the agent reports
+// the results of its checks to. An alert is raised for every check that changed status.
+func ReportCheckResults(w http.ResponseWriter, r *http.Request) {
+	hostID, ok := intVar(w, mux.Vars(r), "id")
+	if !ok {
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxCheckReport)
+	var results []models.CheckResult
+	if !decodeBody(w, r, &results) {
+		return
+	}
+	for i := range results {
+		if !slices.Contains(checkStatuses, results[i].Status) {
+			results[i].Status = models.CheckUnknown
+		}
+		if len(results[i].Output) > maxCheckOutput {
+			results[i].Output = strings.ToValidUTF8(results[i].Output[:maxCheckOutput], "")
+		}
+		if results[i].CheckedAt.IsZero() {
+			results[i].CheckedAt = time.Now()
+		}
+	}
+
+	changes, err := database.RecordCheckResults(hostID, results)
+	if err != nil {
+		writeDatabaseError(w, err, "agent not found")
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+
+	publishCheckChanges(changes)
+	events.Publish(events.Event{Type: events.ChecksReported, HostID: int32(hostID)})
+}
+
+// GetCheckStatus handles the GET /api/checks/status route, exporting the latest result of
+// every check on every host for other monitoring systems to scrape. The format query
+// parameter picks the format, checkmk unless set. A check that has not reported for
+// staleCheckIntervals of its interval is exported as unknown.
+func GetCheckStatus(w http.ResponseWriter, r *http.Request) {
+	orgID, ok := scopeFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = statusFormatCheckMK
+	}
+	if format != statusFormatCheckMK && format != statusFormatNagios {
+		writeValidationError(w, map[string]string{"format": "format must be checkmk or nagios"})
+		return
+	}
+
+	states, err := database.GetHostCheckStates(orgID)
+	if err != nil {
+		writeDatabaseError(w, err, "")
+		return
+	}
+	now := time.Now()
+	for i := range states {
+		stale := time.Duration(states[i].Interval*staleCheckIntervals) * time.Second
+		if states[i].CheckedAt != nil && now.Sub(*states[i].CheckedAt) > stale {
+			states[i].Status = models.CheckUnknown
+			states[i].Output = "no result since " + states[i].CheckedAt.UTC().Format(time.RFC3339) + ", last: " + states[i].Output
+		}
+	}
+
+	var b strings.Builder
+	if format == statusFormatNagios {
+		writeNagiosStatus(&b, states)
+	} else {
+		writeCheckMKStatus(&b, states)
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	io.WriteString(w, b.String())
+}
+
+// writeCheckMKStatus writes the states as CheckMK piggyback data: a section per host holding
+// a local check per check, e.g. 0 "Disk C" used=72.5;80;90;0;100 C: 72.5% used
+func writeCheckMKStatus(b *strings.Builder, states []models.HostCheckState) {
+	host := ""
+	for _, state := range states {
+		if state.Hostname != host {
+			if host != "" {
+				b.WriteString("<<<<>>>>\n")
+			}
+			host = state.Hostname
+			fmt.Fprintf(b, "<<<<%s>>>>\n<<<local:sep(0)>>>\n", host)
+		}
+
+		name := strings.ReplaceAll(state.CheckName, `"`, "'")
+		metrics := make([]string, 0, len(state.Perfdata))
+		for _, p := range state.Perfdata {
+			// CheckMK metrics have no unit and no spaces in their name
+			p.Unit = ""
+			p.Label = strings.Join(strings.Fields(p.Label), "_")
+			metrics = append(metrics, p.String())
+		}
+		perfdata := strings.Join(metrics, "|")
+		if perfdata == "" {
+			perfdata = "-"
+		}
+		fmt.Fprintf(b, "%d \"%s\" %s %s\n", models.CheckStatusCode(state.Status), name, perfdata, escapeNewlines(state.Output))
+	}
+	if host != "" {
+		b.WriteString("<<<<>>>>\n")
+	}
+}
+
+// writeNagiosStatus writes the states as Nagios external commands, e.g.
+// [1760880000] PROCESS_SERVICE_CHECK_RESULT;host;Disk C;0;C: 72.5% used|used=72.5%;80;90;0;100
+func writeNagiosStatus(b *strings.Builder, states []models.HostCheckState) {
+	for _, state := range states {
+		// A pipe would start the performance data
+		output := strings.ReplaceAll(escapeNewlines(state.Output), "|", "/")
+		if len(state.Perfdata) > 0 {
+			perfdata := make([]string, 0, len(state.Perfdata))
+			for _, p := range state.Perfdata {
+				perfdata = append(perfdata, p.String())
+			}
+			output += "|" + strings.Join(perfdata, " ")
+		}
+		fmt.Fprintf(b, "[%d] PROCESS_SERVICE_CHECK_RESULT;%s;%s;%d;%s\n", state.CheckedAt.Unix(), state.Hostname,
+			strings.ReplaceAll(state.CheckName, ";", ","), models.CheckStatusCode(state.Status), output)
+	}
+}
+
+// escapeNewlines writes the line breaks of the output of a check as \n, which CheckMK and
+// Nagios turn back into line breaks
+func escapeNewlines(output string) string {
+	output = strings.ReplaceAll(strings.TrimSpace(output), "\r\n", "\n")
+	return strings.ReplaceAll(output, "\n", `\n`)
+}
@@ -0,0 +1,166 @@
+package api_handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"reflect"
+	"slate-rmm/database"
+	"strconv"
+)
+
+// clientHeader selects the organization (client) an API request is scoped to
+const clientHeader = "X-Nexus-Client"
+
+// clientScope returns the organization selected by the X-Nexus-Client header or the
+// client_id query parameter. 0 means the request is not limited to a single client.
+func clientScope(r *http.Request) (int, error) {
+	value := r.Header.Get(clientHeader)
+	if value == "" {
+		value = r.URL.Query().Get("client_id")
+	}
+	if value == "" {
+		return 0, nil
+	}
+
+	orgID, err := strconv.Atoi(value)
+	if err != nil || orgID < 0 {
+		return 0, errors.New("invalid client ID")
+	}
+	return orgID, nil
+}
+
+// Error codes returned in the error envelope. They are part of the API contract
+// and must not change once published.
+const (
+	CodeInvalidRequest   = "invalid_request"
+	CodeValidationFailed = "validation_failed"
+	CodeUnauthorized     = "unauthorized"
+	CodeForbidden        = "forbidden"
+	CodeNotFound         = "not_found"
+	CodeMethodNotAllowed = "method_not_allowed"
+	CodeConflict         = "conflict"
+	CodeGroupNotEmpty    = "group_not_empty"
+	CodeInternal         = "internal_error"
+)
+
+// Envelope is the JSON body of every management API response.
+// Data is null when the request failed and Error describes why.
+type Envelope struct {
+	Data  interface{} `json:"data"`
+	Error *APIError   `json:"error,omitempty"`
+}
+
+// APIError describes why a request failed
+type APIError struct {
+	Code    string            `json:"code"`
+	Message string            `json:"message"`
+	Fields  map[string]string `json:"fields,omitempty"`
+}
+
+// writeJSON responds with data wrapped in the response envelope
+func writeJSON(w http.ResponseWriter, status int, data interface{}) {
+	// Empty lists are returned as [] rather than null
+	if v := reflect.ValueOf(data); v.Kind() == reflect.Slice && v.IsNil() {
+		data = reflect.MakeSlice(v.Type(), 0, 0).Interface()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(Envelope{Data: data}); err != nil {
+		log.Printf("could not encode response: %v", err)
+	}
+}
+
+// writeRaw responds with a bare JSON payload. It is only used by the agent protocol
+// endpoints, whose response shape is fixed by the agents already deployed.
+func writeRaw(w http.ResponseWriter, status int, payload interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(payload); err != nil {
+		log.Printf("could not encode response: %v", err)
+	}
+}
+
+// WriteError responds with an error envelope
+func WriteError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(Envelope{Error: &APIError{Code: code, Message: message}})
+}
+
+// writeValidationError responds with 422 and the reason each field was rejected
+func writeValidationError(w http.ResponseWriter, fields map[string]string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnprocessableEntity)
+	json.NewEncoder(w).Encode(Envelope{Error: &APIError{
+		Code:    CodeValidationFailed,
+		Message: "request validation failed",
+		Fields:  fields,
+	}})
+}
+
+// decodeBody decodes a JSON request body, responding with 400 if it is malformed
+func decodeBody(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		WriteError(w, http.StatusBadRequest, CodeInvalidRequest, "request body is not valid JSON")
+		return false
+	}
+	return true
+}
+
+// scopeFromRequest returns the selected client, responding with 400 if it is malformed
+func scopeFromRequest(w http.ResponseWriter, r *http.Request) (int, bool) {
+	orgID, err := clientScope(r)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, CodeInvalidRequest, err.Error())
+		return 0, false
+	}
+	return orgID, true
+}
+
+// intVar parses an integer route variable, responding with 400 if it is malformed
+func intVar(w http.ResponseWriter, vars map[string]string, name string) (int, bool) {
+	value, err := strconv.Atoi(vars[name])
+	if err != nil || value <= 0 {
+		WriteError(w, http.StatusBadRequest, CodeInvalidRequest, "invalid "+name)
+		return 0, false
+	}
+	return value, true
+}
+
+// writeDatabaseError responds to an error returned by the database package without leaking its details
+func writeDatabaseError(w http.ResponseWriter, err error, notFound string) {
+	var fieldErr *database.FieldError
+	switch {
+	case errors.Is(err, database.ErrNotFound):
+		WriteError(w, http.StatusNotFound, CodeNotFound, notFound)
+	case errors.Is(err, database.ErrConflict):
+		WriteError(w, http.StatusConflict, CodeConflict, "a record with these values already exists")
+	case errors.Is(err, database.ErrGroupNotEmpty):
+		WriteError(w, http.StatusConflict, CodeGroupNotEmpty, "group still has members, remove them first or delete with force=true")
+	case errors.As(err, &fieldErr):
+		writeValidationError(w, map[string]string{fieldErr.Field: fieldErr.Reason})
+	case errors.Is(err, database.ErrInvalidBulkRequest):
+		WriteError(w, http.StatusUnprocessableEntity, CodeValidationFailed, err.Error())
+	default:
+		log.Printf("database error: %v", err)
+		WriteError(w, http.StatusInternalServerError, CodeInternal, "internal server error")
+	}
+}
+
+// NotFound responds to requests for routes that do not exist
+func NotFound(w http.ResponseWriter, r *http.Request) {
+	WriteError(w, http.StatusNotFound, CodeNotFound, "route not found")
+}
+
+// MethodNotAllowed responds to requests using a method a route does not support
+func MethodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	WriteError(w, http.StatusMethodNotAllowed, CodeMethodNotAllowed, "method not allowed")
+}
+
+// itoa formats a parsed route ID for the database functions that take string IDs
+func itoa(id int) string {
+	return strconv.Itoa(id)
+}
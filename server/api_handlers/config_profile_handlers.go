@@ -0,0 +1,158 @@
+package api_handlers
+
+import (
+	"net/http"
+	"slate-rmm/database"
+	"slate-rmm/models"
+
+	"github.com/gorilla/mux"
+)
+
+// validateProfile checks the name and configuration of a profile
+func validateProfile(w http.ResponseWriter, profile models.ConfigProfile) bool {
+	fields := profile.Config.Validate()
+	validateName(fields, "profile_name", profile.ProfileName)
+	if len(fields) > 0 {
+		writeValidationError(w, fields)
+		return false
+	}
+	return true
+}
+
+// GetAllConfigProfiles handles the GET /api/config-profiles route
+func GetAllConfigProfiles(w http.ResponseWriter, r *http.Request) {
+	profiles, err := database.GetAllConfigProfiles()
+	if err != nil {
+		writeDatabaseError(w, err, "")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, profiles)
+}
+
+// GetConfigProfile handles the GET /api/config-profiles/{profile_id} route
+func GetConfigProfile(w http.ResponseWriter, r *http.Request) {
+	profileID, ok := intVar(w, mux.Vars(r), "profile_id")
+	if !ok {
+		return
+	}
+
+	profile, err := database.GetConfigProfile(profileID)
+	if err != nil {
+		writeDatabaseError(w, err, "")
+		return
+	}
+	if profile == nil {
+		WriteError(w, http.StatusNotFound, CodeNotFound, "configuration profile not found")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, profile)
+}
+
+// CreateConfigProfile handles the POST /api/config-profiles route
+func CreateConfigProfile(w http.ResponseWriter, r *http.Request) {
+	var profile models.ConfigProfile
+	if !decodeBody(w, r, &profile) {
+		return
+	}
+	if !validateProfile(w, profile) {
+		return
+	}
+
+	if err := database.CreateConfigProfile(&profile); err != nil {
+		writeDatabaseError(w, err, "")
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, profile)
+}
+
+// UpdateConfigProfile handles the PUT /api/config-profiles/{profile_id} route
+func UpdateConfigProfile(w http.ResponseWriter, r *http.Request) {
+	profileID, ok := intVar(w, mux.Vars(r), "profile_id")
+	if !ok {
+		return
+	}
+
+	var profile models.ConfigProfile
+	if !decodeBody(w, r, &profile) {
+		return
+	}
+	if !validateProfile(w, profile) {
+		return
+	}
+	profile.ProfileID = int32(profileID)
+
+	if err := database.UpdateConfigProfile(&profile); err != nil {
+		writeDatabaseError(w, err, "configuration profile not found")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, profile)
+}
+
+// DeleteConfigProfile handles the DELETE /api/config-profiles/{profile_id} route
+func DeleteConfigProfile(w http.ResponseWriter, r *http.Request) {
+	profileID, ok := intVar(w, mux.Vars(r), "profile_id")
+	if !ok {
+		return
+	}
+
+	if err := database.DeleteConfigProfile(profileID); err != nil {
+		writeDatabaseError(w, err, "configuration profile not found")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ProfileAssignment is the body of the profile assignment routes. A null profile_id clears the assignment.
+type ProfileAssignment struct {
+	ProfileID *int `json:"profile_id"`
+}
+
+// SetGroupProfile handles the PUT /api/groups/{group_id}/profile route
+func SetGroupProfile(w http.ResponseWriter, r *http.Request) {
+	groupID, ok := intVar(w, mux.Vars(r), "group_id")
+	if !ok {
+		return
+	}
+
+	orgID, ok := scopeFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	var payload ProfileAssignment
+	if !decodeBody(w, r, &payload) {
+		return
+	}
+
+	if err := database.SetGroupProfile(groupID, payload.ProfileID, orgID); err != nil {
+		writeDatabaseError(w, err, "group or configuration profile not found")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// SetAgentProfile handles the PUT /api/agents/{id}/profile route
+func SetAgentProfile(w http.ResponseWriter, r *http.Request) {
+	hostID, orgID, ok := hostVars(w, r)
+	if !ok {
+		return
+	}
+
+	var payload ProfileAssignment
+	if !decodeBody(w, r, &payload) {
+		return
+	}
+
+	if err := database.SetAgentProfile(hostID, payload.ProfileID, orgID); err != nil {
+		writeDatabaseError(w, err, "agent or configuration profile not found")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,171 @@
+package api_handlers
+
+import (
+	"net/http"
+	"slate-rmm/database"
+	"slate-rmm/models"
+
+	"github.com/gorilla/mux"
+)
+
+// GetAllCustomFields handles the GET /api/custom-fields route
+func GetAllCustomFields(w http.ResponseWriter, r *http.Request) {
+	fields, err := database.GetAllCustomFields()
+	if err != nil {
+		writeDatabaseError(w, err, "")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, fields)
+}
+
+// CreateCustomField handles the POST /api/custom-fields route
+func CreateCustomField(w http.ResponseWriter, r *http.Request) {
+	var field models.CustomField
+	if !decodeBody(w, r, &field) {
+		return
+	}
+	if err := field.ValidateDefinition(); err != nil {
+		writeValidationError(w, map[string]string{"field_key": err.Error()})
+		return
+	}
+
+	if err := database.CreateCustomField(&field); err != nil {
+		writeDatabaseError(w, err, "")
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, field)
+}
+
+// UpdateCustomField handles the PUT /api/custom-fields/{field_id} route
+func UpdateCustomField(w http.ResponseWriter, r *http.Request) {
+	fieldID, ok := intVar(w, mux.Vars(r), "field_id")
+	if !ok {
+		return
+	}
+
+	var payload struct {
+		Label   string   `json:"label"`
+		Options []string `json:"options"`
+	}
+	if !decodeBody(w, r, &payload) {
+		return
+	}
+	if payload.Label == "" {
+		writeValidationError(w, map[string]string{"label": "label is required"})
+		return
+	}
+
+	err := database.UpdateCustomField(fieldID, payload.Label, payload.Options)
+	if err != nil {
+		writeDatabaseError(w, err, "custom field not found")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// DeleteCustomField handles the DELETE /api/custom-fields/{field_id} route
+func DeleteCustomField(w http.ResponseWriter, r *http.Request) {
+	fieldID, ok := intVar(w, mux.Vars(r), "field_id")
+	if !ok {
+		return
+	}
+
+	err := database.DeleteCustomField(fieldID)
+	if err != nil {
+		writeDatabaseError(w, err, "custom field not found")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// hostVars parses the {id} route variable and the selected client
+func hostVars(w http.ResponseWriter, r *http.Request) (hostID, orgID int, ok bool) {
+	if hostID, ok = intVar(w, mux.Vars(r), "id"); !ok {
+		return
+	}
+	orgID, ok = scopeFromRequest(w, r)
+	return
+}
+
+// SetAgentCustomFields handles the PUT /api/agents/{id}/custom-fields route.
+// Only the fields present in the body are changed; an empty value clears a field.
+func SetAgentCustomFields(w http.ResponseWriter, r *http.Request) {
+	hostID, orgID, ok := hostVars(w, r)
+	if !ok {
+		return
+	}
+
+	var values map[string]string
+	if !decodeBody(w, r, &values) {
+		return
+	}
+
+	if err := database.SetAgentCustomFields(hostID, values, orgID); err != nil {
+		writeDatabaseError(w, err, "agent not found")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// SetAgentTags handles the PUT /api/agents/{id}/tags route, replacing every tag of the agent
+func SetAgentTags(w http.ResponseWriter, r *http.Request) {
+	hostID, orgID, ok := hostVars(w, r)
+	if !ok {
+		return
+	}
+
+	var tags map[string]string
+	if !decodeBody(w, r, &tags) {
+		return
+	}
+
+	if err := database.SetAgentTags(hostID, tags, orgID); err != nil {
+		writeDatabaseError(w, err, "agent not found")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// SetAgentTag handles the PUT /api/agents/{id}/tags/{key} route
+func SetAgentTag(w http.ResponseWriter, r *http.Request) {
+	hostID, orgID, ok := hostVars(w, r)
+	if !ok {
+		return
+	}
+
+	var payload struct {
+		Value string `json:"value"`
+	}
+	if !decodeBody(w, r, &payload) {
+		return
+	}
+
+	if err := database.SetAgentTag(hostID, mux.Vars(r)["key"], payload.Value, orgID); err != nil {
+		writeDatabaseError(w, err, "agent not found")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// DeleteAgentTag handles the DELETE /api/agents/{id}/tags/{key} route
+func DeleteAgentTag(w http.ResponseWriter, r *http.Request) {
+	hostID, orgID, ok := hostVars(w, r)
+	if !ok {
+		return
+	}
+
+	err := database.DeleteAgentTag(hostID, mux.Vars(r)["key"], orgID)
+	if err != nil {
+		writeDatabaseError(w, err, "tag not found")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,93 @@
+package api_handlers
+
+import (
+	"net/http"
+	"slate-rmm/database"
+	"slate-rmm/events"
+	"slate-rmm/models"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Limits of the event log entries shipped by the agents
+const (
+	// maxEventLogReport limits the entries an agent ships at once
+	maxEventLogReport = 4 * 1024 * 1024
+	// maxEventLogMessage limits the message stored with an entry; longer messages are truncated
+	maxEventLogMessage = 8192
+)
+
+// truncate cuts s to at most max bytes without splitting a character
+func truncate(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	return strings.ToValidUTF8(s[:max], "")
+}
+
+// ReportEventLogs handles the POST /api/agents/{id}/event-logs route the agent ships the
+// entries of its event logs to. Entries already shipped are skipped.
+func ReportEventLogs(w http.ResponseWriter, r *http.Request) {
+	hostID, ok := intVar(w, mux.Vars(r), "id")
+	if !ok {
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxEventLogReport)
+	var entries []models.EventLogEntry
+	if !decodeBody(w, r, &entries) {
+		return
+	}
+	for i := range entries {
+		e := &entries[i]
+		if !slices.Contains(models.EventLevels, e.Level) {
+			e.Level = "information"
+		}
+		e.Source = truncate(e.Source, maxNameLength)
+		e.RecordKey = truncate(e.RecordKey, maxNameLength)
+		e.Provider = truncate(e.Provider, maxNameLength)
+		e.Message = truncate(strings.ToValidUTF8(e.Message, "�"), maxEventLogMessage)
+		if e.LoggedAt.IsZero() {
+			e.LoggedAt = time.Now()
+		}
+	}
+
+	inserted, err := database.RecordEventLogEntries(hostID, entries)
+	if err != nil {
+		writeDatabaseError(w, err, "agent not found")
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+
+	if inserted > 0 {
+		events.Publish(events.Event{Type: events.EventLogsReported, HostID: int32(hostID)})
+	}
+}
+
+// GetEventLogs handles the GET /api/agents/{id}/event-logs route, searching the event log
+// entries of the agent newest first. The source, level, q, since, until and limit query
+// parameters select the entries.
+func GetEventLogs(w http.ResponseWriter, r *http.Request) {
+	hostID, orgID, ok := hostVars(w, r)
+	if !ok {
+		return
+	}
+
+	filter, fields := models.ParseEventLogFilter(r.URL.Query())
+	if len(fields) > 0 {
+		writeValidationError(w, fields)
+		return
+	}
+
+	entries, err := database.GetEventLogEntries(hostID, filter, orgID)
+	if err != nil {
+		writeDatabaseError(w, err, "agent not found")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, entries)
+}
@@ -0,0 +1,41 @@
+package api_handlers
+
+import (
+	"fmt"
+	"slate-rmm/database"
+	"slate-rmm/events"
+	"slate-rmm/models"
+)
+
+// publishStatusChange publishes that an agent came online or went offline, if its status changed
+func publishStatusChange(change *database.StatusChange) {
+	if change == nil {
+		return
+	}
+	eventType := events.AgentOffline
+	if change.Online {
+		eventType = events.AgentOnline
+	}
+	events.Publish(events.Event{Type: eventType, HostID: change.HostID})
+}
+
+// publishJob publishes the progress an agent made on one of its jobs
+func publishJob(hostID int32, jobID int32, status string) {
+	events.Publish(events.Event{Type: events.JobUpdated, HostID: hostID, JobID: jobID, Status: status})
+}
+
+// publishCheckChanges raises an alert for every check that changed status, unless it is
+// the first result of a check and the check is ok
+func publishCheckChanges(changes []database.CheckChange) {
+	for _, change := range changes {
+		result := change.Result
+		message := fmt.Sprintf("check %q is %s: %s", change.CheckName, result.Status, result.Output)
+		if result.Status == models.CheckOK {
+			if change.Previous == "" {
+				continue
+			}
+			message = fmt.Sprintf("check %q recovered: %s", change.CheckName, result.Output)
+		}
+		events.Publish(events.Event{Type: events.Alert, HostID: change.HostID, OrgID: change.OrgID, Status: result.Status, Message: message, At: result.CheckedAt})
+	}
+}
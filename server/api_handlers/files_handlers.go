@@ -0,0 +1,30 @@
+package api_handlers
+
+import (
+	"net/http"
+	"slate-rmm/database"
+
+	"github.com/gorilla/mux"
+)
+
+// GetFileOperations handles the GET /api/agents/{id}/remote-sessions/{session_id}/files
+// route, returning the directories listed and the files transferred during a file session
+// in order
+func GetFileOperations(w http.ResponseWriter, r *http.Request) {
+	hostID, orgID, ok := hostVars(w, r)
+	if !ok {
+		return
+	}
+	sessionID, ok := intVar(w, mux.Vars(r), "session_id")
+	if !ok {
+		return
+	}
+
+	operations, err := database.GetFileOperations(sessionID, hostID, orgID)
+	if err != nil {
+		writeDatabaseError(w, err, "file session not found")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, operations)
+}
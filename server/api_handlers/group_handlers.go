@@ -1,180 +1,292 @@
 package api_handlers
 
 import (
-	"encoding/json"
 	"net/http"
 	"slate-rmm/database"
+	"slate-rmm/models"
 	"strconv"
 
 	"github.com/gorilla/mux"
 )
 
+// maxNameLength is the longest name accepted for groups, sites and organizations
+const maxNameLength = 255
+
+// validateName checks a required name field and returns the reason it is rejected, if any
+func validateName(fields map[string]string, field, value string) {
+	switch {
+	case value == "":
+		fields[field] = field + " is required"
+	case len(value) > maxNameLength:
+		fields[field] = field + " must be at most 255 characters"
+	}
+}
+
+// membershipVars parses the {group_id} and {host_id} route variables and the selected client
+func membershipVars(w http.ResponseWriter, r *http.Request) (groupID, hostID, orgID int, ok bool) {
+	vars := mux.Vars(r)
+	if groupID, ok = intVar(w, vars, "group_id"); !ok {
+		return
+	}
+	if hostID, ok = intVar(w, vars, "host_id"); !ok {
+		return
+	}
+	orgID, ok = scopeFromRequest(w, r)
+	return
+}
+
 // GetAllGroups handles the GET /api/groups route
 func GetAllGroups(w http.ResponseWriter, r *http.Request) {
-	groups, err := database.GetAllGroups()
+	orgID, ok := scopeFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	groups, err := database.GetAllGroups(orgID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeDatabaseError(w, err, "")
 		return
 	}
 
-	json.NewEncoder(w).Encode(groups)
+	writeJSON(w, http.StatusOK, groups)
 }
 
 // GetGroup handles the GET /api/groups/{group_id} route
 func GetGroup(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	groupID := vars["group_id"]
+	groupID, ok := intVar(w, mux.Vars(r), "group_id")
+	if !ok {
+		return
+	}
 
-	group, err := database.GetGroup(groupID)
+	orgID, ok := scopeFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	group, err := database.GetGroup(itoa(groupID), orgID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeDatabaseError(w, err, "")
+		return
+	}
+	if group == nil {
+		WriteError(w, http.StatusNotFound, CodeNotFound, "group not found")
 		return
 	}
 
-	json.NewEncoder(w).Encode(group)
+	writeJSON(w, http.StatusOK, group)
 }
 
 // CreateGroup handles the POST /api/groups route
 func CreateGroup(w http.ResponseWriter, r *http.Request) {
 	var payload struct {
 		GroupName string `json:"group_name"`
+		SiteID    int    `json:"site_id"`
+	}
+	if !decodeBody(w, r, &payload) {
+		return
+	}
+
+	fields := map[string]string{}
+	validateName(fields, "group_name", payload.GroupName)
+	if payload.SiteID < 0 {
+		fields["site_id"] = "site_id must be positive"
+	}
+	if len(fields) > 0 {
+		writeValidationError(w, fields)
+		return
+	}
+
+	orgID, ok := scopeFromRequest(w, r)
+	if !ok {
+		return
 	}
 
-	err := json.NewDecoder(r.Body).Decode(&payload)
+	// Without a site, the group is created in the default site of the selected client
+	var err error
+	if payload.SiteID == 0 {
+		payload.SiteID, err = database.DefaultSite(orgID)
+	} else if site, siteErr := database.GetSite(payload.SiteID, orgID); siteErr != nil {
+		err = siteErr
+	} else if site == nil {
+		err = database.ErrNotFound
+	}
 	if err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		writeDatabaseError(w, err, "site not found")
 		return
 	}
 
-	err = database.CreateGroup(payload.GroupName)
+	groupID, err := database.CreateGroup(payload.GroupName, payload.SiteID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeDatabaseError(w, err, "site not found")
 		return
 	}
 
-	w.Write([]byte("Group created"))
+	writeJSON(w, http.StatusCreated, models.Group{GroupID: groupID, GroupName: payload.GroupName, SiteID: int32(payload.SiteID)})
 }
 
 // UpdateGroup handles the PUT /api/groups/{group_id} route
 func UpdateGroup(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	groupID := vars["group_id"]
+	groupID, ok := intVar(w, mux.Vars(r), "group_id")
+	if !ok {
+		return
+	}
 
 	var payload struct {
 		GroupName string `json:"group_name"`
 	}
+	if !decodeBody(w, r, &payload) {
+		return
+	}
+
+	fields := map[string]string{}
+	validateName(fields, "group_name", payload.GroupName)
+	if len(fields) > 0 {
+		writeValidationError(w, fields)
+		return
+	}
+
+	orgID, ok := scopeFromRequest(w, r)
+	if !ok {
+		return
+	}
 
-	err := json.NewDecoder(r.Body).Decode(&payload)
+	err := database.UpdateGroup(itoa(groupID), payload.GroupName, orgID)
 	if err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		writeDatabaseError(w, err, "group not found")
 		return
 	}
 
-	err = database.UpdateGroup(groupID, payload.GroupName)
+	group, err := database.GetGroup(itoa(groupID), orgID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeDatabaseError(w, err, "group not found")
 		return
 	}
 
-	w.Write([]byte("Group " + groupID + " updated"))
+	writeJSON(w, http.StatusOK, group)
 }
 
-// DeleteGroup handles the DELETE /api/groups/{group_id} route
+// DeleteGroup handles the DELETE /api/groups/{group_id} route.
+// A group with members is only deleted when force=true is passed.
 func DeleteGroup(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	groupID := vars["group_id"]
+	groupID, ok := intVar(w, mux.Vars(r), "group_id")
+	if !ok {
+		return
+	}
 
-	err := database.DeleteGroup(groupID)
+	orgID, ok := scopeFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	force := r.URL.Query().Get("force") == "true"
+	err := database.DeleteGroup(itoa(groupID), orgID, force)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeDatabaseError(w, err, "group not found")
 		return
 	}
 
-	w.Write([]byte("Group " + groupID + " deleted"))
+	w.WriteHeader(http.StatusNoContent)
 }
 
 // GetHostsInGroup handles the GET /api/groups/{group_id}/hosts route
 func GetHostsInGroup(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	groupID, _ := strconv.Atoi(vars["group_id"])
-
-	hosts, err := database.GetHostsInGroup(groupID)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+	groupID, ok := intVar(w, mux.Vars(r), "group_id")
+	if !ok {
 		return
 	}
 
-	json.NewEncoder(w).Encode(hosts)
-}
-
-// AddHostToGroup handles the POST /api/groups/{group_id}/add/{host_id} route
-func AddHostToGroup(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	groupID, err := strconv.Atoi(vars["group_id"])
-	if err != nil {
-		http.Error(w, "Invalid group ID", http.StatusBadRequest)
+	orgID, ok := scopeFromRequest(w, r)
+	if !ok {
 		return
 	}
 
-	hostID, err := strconv.Atoi(vars["host_id"])
+	group, err := database.GetGroup(itoa(groupID), orgID)
 	if err != nil {
-		http.Error(w, "Invalid host ID", http.StatusBadRequest)
+		writeDatabaseError(w, err, "")
+		return
+	}
+	if group == nil {
+		WriteError(w, http.StatusNotFound, CodeNotFound, "group not found")
 		return
 	}
 
-	err = database.AddHostToGroup(hostID, groupID)
+	hosts, err := database.GetHostsInGroup(groupID, orgID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeDatabaseError(w, err, "group not found")
 		return
 	}
 
-	w.Write([]byte("Host added to group" + vars["group_id"]))
+	writeJSON(w, http.StatusOK, hosts)
 }
 
-// RemoveHostFromGroup handles the DELETE /api/groups/{group_id}/remove/{host_id} route
-func RemoveHostFromGroup(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	groupID, err := strconv.Atoi(vars["group_id"])
-	if err != nil {
-		http.Error(w, "Invalid group ID", http.StatusBadRequest)
+// AddHostToGroup handles the POST /api/groups/{group_id}/add/{host_id} route
+func AddHostToGroup(w http.ResponseWriter, r *http.Request) {
+	groupID, hostID, orgID, ok := membershipVars(w, r)
+	if !ok {
 		return
 	}
 
-	hostID, err := strconv.Atoi(vars["host_id"])
+	err := database.AddHostToGroup(hostID, groupID, orgID)
 	if err != nil {
-		http.Error(w, "Invalid host ID", http.StatusBadRequest)
+		writeDatabaseError(w, err, "host or group not found in this client")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RemoveHostFromGroup handles the DELETE /api/groups/{group_id}/remove/{host_id} route
+func RemoveHostFromGroup(w http.ResponseWriter, r *http.Request) {
+	groupID, hostID, orgID, ok := membershipVars(w, r)
+	if !ok {
 		return
 	}
 
-	err = database.RemoveHostFromGroup(hostID, groupID)
+	err := database.RemoveHostFromGroup(hostID, groupID, orgID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeDatabaseError(w, err, "host is not a member of this group")
 		return
 	}
 
-	w.Write([]byte("Host removed from group" + vars["group_id"]))
+	w.WriteHeader(http.StatusNoContent)
 }
 
-// MoveHostToGroup handles the POST /api/groups/{group_id}/move/{host_id} route
+// MoveHostToGroup handles the PUT /api/groups/{group_id}/move/{host_id} route.
+// The group the host leaves is given as from_group_id in the body or the from query parameter.
 func MoveHostToGroup(w http.ResponseWriter, r *http.Request) {
-	vars := mux.Vars(r)
-	groupID, err := strconv.Atoi(vars["group_id"])
-	if err != nil {
-		http.Error(w, "Invalid group ID", http.StatusBadRequest)
+	groupID, hostID, orgID, ok := membershipVars(w, r)
+	if !ok {
 		return
 	}
 
-	hostID, err := strconv.Atoi(vars["host_id"])
-	if err != nil {
-		http.Error(w, "Invalid host ID", http.StatusBadRequest)
+	var payload struct {
+		FromGroupID int `json:"from_group_id"`
+	}
+	if from := r.URL.Query().Get("from"); from != "" {
+		fromGroupID, err := strconv.Atoi(from)
+		if err != nil {
+			WriteError(w, http.StatusBadRequest, CodeInvalidRequest, "invalid from")
+			return
+		}
+		payload.FromGroupID = fromGroupID
+	} else if !decodeBody(w, r, &payload) {
+		return
+	}
+
+	switch {
+	case payload.FromGroupID <= 0:
+		writeValidationError(w, map[string]string{"from_group_id": "from_group_id is required"})
+		return
+	case payload.FromGroupID == groupID:
+		writeValidationError(w, map[string]string{"from_group_id": "source and destination groups are the same"})
 		return
 	}
 
-	err = database.MoveHostToGroup(hostID, groupID)
+	err := database.MoveHostToGroup(hostID, payload.FromGroupID, groupID, orgID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeDatabaseError(w, err, "host is not a member of the source group, or a group is not in this client")
 		return
 	}
 
-	w.Write([]byte("Host moved to group" + vars["group_id"]))
+	w.WriteHeader(http.StatusNoContent)
 }
@@ -0,0 +1,158 @@
+package api_handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"slate-rmm/database"
+	"slate-rmm/models"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// maxJobOutput limits how much output an agent can store for a single job
+const maxJobOutput = 64 * 1024
+
+// GetJob handles the GET /api/jobs/{job_id} route
+func GetJob(w http.ResponseWriter, r *http.Request) {
+	jobID, ok := intVar(w, mux.Vars(r), "job_id")
+	if !ok {
+		return
+	}
+
+	orgID, ok := scopeFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	job, err := database.GetJob(jobID, orgID)
+	if err != nil {
+		writeDatabaseError(w, err, "")
+		return
+	}
+	if job == nil {
+		WriteError(w, http.StatusNotFound, CodeNotFound, "job not found")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, job)
+}
+
+// GetPendingJobs handles the GET /api/agents/{id}/jobs route polled by the agent
+func GetPendingJobs(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	jobs, err := database.DispatchPendingJobs(id)
+	if err != nil {
+		writeDatabaseError(w, err, "")
+		return
+	}
+
+	writeRaw(w, http.StatusOK, jobs)
+
+	for _, job := range jobs {
+		publishJob(job.HostID, job.JobID, job.Status)
+	}
+}
+
+// ReportJobResult handles the POST /api/agents/{id}/jobs/{job_id} route
+func ReportJobResult(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	hostID, ok := intVar(w, vars, "id")
+	if !ok {
+		return
+	}
+	jobID, ok := intVar(w, vars, "job_id")
+	if !ok {
+		return
+	}
+
+	var payload struct {
+		Status   string `json:"status"`
+		ExitCode int    `json:"exit_code"`
+		Output   string `json:"output"`
+	}
+	if !decodeBody(w, r, &payload) {
+		return
+	}
+	if payload.Status != database.JobCompleted && payload.Status != database.JobFailed {
+		writeValidationError(w, map[string]string{"status": "status must be completed or failed"})
+		return
+	}
+	if len(payload.Output) > maxJobOutput {
+		payload.Output = payload.Output[:maxJobOutput]
+	}
+
+	err := database.CompleteJob(itoa(hostID), jobID, payload.Status, payload.ExitCode, payload.Output)
+	if err != nil {
+		writeDatabaseError(w, err, "job not found")
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+
+	publishJob(int32(hostID), int32(jobID), payload.Status)
+}
+
+// RequestAgentLogs handles the POST /api/agents/{id}/logs route. The agent log is retrieved
+// by a fetch_logs job the next time the agent polls; the job is returned to follow it with
+// GET /api/agents/{id}/logs/{job_id}.
+func RequestAgentLogs(w http.ResponseWriter, r *http.Request) {
+	hostID, orgID, ok := hostVars(w, r)
+	if !ok {
+		return
+	}
+
+	var request models.LogRequest
+	if r.ContentLength != 0 && !decodeBody(w, r, &request) {
+		return
+	}
+	if fields := request.Validate(); len(fields) > 0 {
+		writeValidationError(w, fields)
+		return
+	}
+
+	jobID, err := database.CreateJob(hostID, database.JobFetchLogs, request, orgID)
+	if err != nil {
+		writeDatabaseError(w, err, "agent not found")
+		return
+	}
+	writeQueuedJob(w, jobID, orgID)
+}
+
+// GetAgentLogs handles the GET /api/agents/{id}/logs/{job_id} route, returning the log
+// entries retrieved by a fetch_logs job once the agent has reported them
+func GetAgentLogs(w http.ResponseWriter, r *http.Request) {
+	hostID, orgID, ok := hostVars(w, r)
+	if !ok {
+		return
+	}
+	jobID, ok := intVar(w, mux.Vars(r), "job_id")
+	if !ok {
+		return
+	}
+
+	job, err := database.GetJob(jobID, orgID)
+	if err != nil {
+		writeDatabaseError(w, err, "")
+		return
+	}
+	if job == nil || int(job.HostID) != hostID || job.JobType != database.JobFetchLogs {
+		WriteError(w, http.StatusNotFound, CodeNotFound, "log request not found")
+		return
+	}
+
+	log := models.AgentLog{JobID: job.JobID, HostID: job.HostID, Status: job.Status, Entries: []json.RawMessage{}}
+	switch job.Status {
+	case database.JobCompleted:
+		for _, line := range strings.Split(job.Output, "\n") {
+			if json.Valid([]byte(line)) {
+				log.Entries = append(log.Entries, json.RawMessage(line))
+			}
+		}
+	case database.JobFailed:
+		log.Error = job.Output
+	}
+
+	writeJSON(w, http.StatusOK, log)
+}
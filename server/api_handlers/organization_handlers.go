@@ -0,0 +1,277 @@
+package api_handlers
+
+import (
+	"net/http"
+	"slate-rmm/database"
+	"slate-rmm/models"
+
+	"github.com/gorilla/mux"
+)
+
+// orgFromRequest parses the {org_id} route variable and checks it against the selected client
+func orgFromRequest(w http.ResponseWriter, r *http.Request) (int, bool) {
+	orgID, ok := intVar(w, mux.Vars(r), "org_id")
+	if !ok {
+		return 0, false
+	}
+
+	scope, ok := scopeFromRequest(w, r)
+	if !ok {
+		return 0, false
+	}
+	if scope != 0 && scope != orgID {
+		WriteError(w, http.StatusNotFound, CodeNotFound, "organization not found")
+		return 0, false
+	}
+
+	return orgID, true
+}
+
+// GetAllOrganizations handles the GET /api/organizations route
+func GetAllOrganizations(w http.ResponseWriter, r *http.Request) {
+	scope, ok := scopeFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	orgs, err := database.GetAllOrganizations()
+	if err != nil {
+		writeDatabaseError(w, err, "")
+		return
+	}
+
+	// A scoped request only sees its own client
+	if scope != 0 {
+		var scoped []models.Organization
+		for _, org := range orgs {
+			if int(org.OrgID) == scope {
+				scoped = append(scoped, org)
+			}
+		}
+		orgs = scoped
+	}
+
+	writeJSON(w, http.StatusOK, orgs)
+}
+
+// GetOrganization handles the GET /api/organizations/{org_id} route
+func GetOrganization(w http.ResponseWriter, r *http.Request) {
+	orgID, ok := orgFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	org, err := database.GetOrganization(orgID)
+	if err != nil {
+		writeDatabaseError(w, err, "")
+		return
+	}
+	if org == nil {
+		WriteError(w, http.StatusNotFound, CodeNotFound, "organization not found")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, org)
+}
+
+// CreateOrganization handles the POST /api/organizations route
+func CreateOrganization(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		OrgName  string          `json:"org_name"`
+		Settings models.Settings `json:"settings"`
+	}
+	if !decodeBody(w, r, &payload) {
+		return
+	}
+
+	fields := map[string]string{}
+	validateName(fields, "org_name", payload.OrgName)
+	if len(fields) > 0 {
+		writeValidationError(w, fields)
+		return
+	}
+
+	org := models.Organization{OrgName: payload.OrgName, Settings: payload.Settings}
+	if err := database.CreateOrganization(&org); err != nil {
+		writeDatabaseError(w, err, "")
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, org)
+}
+
+// UpdateOrganization handles the PUT /api/organizations/{org_id} route
+func UpdateOrganization(w http.ResponseWriter, r *http.Request) {
+	orgID, ok := orgFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	var payload struct {
+		OrgName  string          `json:"org_name"`
+		Settings models.Settings `json:"settings"`
+	}
+	if !decodeBody(w, r, &payload) {
+		return
+	}
+
+	fields := map[string]string{}
+	validateName(fields, "org_name", payload.OrgName)
+	if len(fields) > 0 {
+		writeValidationError(w, fields)
+		return
+	}
+
+	err := database.UpdateOrganization(orgID, payload.OrgName, payload.Settings)
+	if err != nil {
+		writeDatabaseError(w, err, "organization not found")
+		return
+	}
+
+	org, err := database.GetOrganization(orgID)
+	if err != nil {
+		writeDatabaseError(w, err, "organization not found")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, org)
+}
+
+// GetSites handles the GET /api/organizations/{org_id}/sites route
+func GetSites(w http.ResponseWriter, r *http.Request) {
+	orgID, ok := orgFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	sites, err := database.GetSites(orgID)
+	if err != nil {
+		writeDatabaseError(w, err, "")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, sites)
+}
+
+// CreateSite handles the POST /api/organizations/{org_id}/sites route
+func CreateSite(w http.ResponseWriter, r *http.Request) {
+	orgID, ok := orgFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	var site models.Site
+	if !decodeBody(w, r, &site) {
+		return
+	}
+
+	fields := map[string]string{}
+	validateName(fields, "site_name", site.SiteName)
+	if len(fields) > 0 {
+		writeValidationError(w, fields)
+		return
+	}
+	site.OrgID = int32(orgID)
+	site.EffectiveSettings = nil
+
+	if err := database.CreateSite(&site); err != nil {
+		writeDatabaseError(w, err, "organization not found")
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, site)
+}
+
+// GetSite handles the GET /api/sites/{site_id} route
+func GetSite(w http.ResponseWriter, r *http.Request) {
+	siteID, ok := intVar(w, mux.Vars(r), "site_id")
+	if !ok {
+		return
+	}
+
+	orgID, ok := scopeFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	site, err := database.GetSite(siteID, orgID)
+	if err != nil {
+		writeDatabaseError(w, err, "")
+		return
+	}
+	if site == nil {
+		WriteError(w, http.StatusNotFound, CodeNotFound, "site not found")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, site)
+}
+
+// UpdateSite handles the PUT /api/sites/{site_id} route
+func UpdateSite(w http.ResponseWriter, r *http.Request) {
+	siteID, ok := intVar(w, mux.Vars(r), "site_id")
+	if !ok {
+		return
+	}
+
+	orgID, ok := scopeFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	var payload struct {
+		SiteName string          `json:"site_name"`
+		Settings models.Settings `json:"settings"`
+	}
+	if !decodeBody(w, r, &payload) {
+		return
+	}
+
+	fields := map[string]string{}
+	validateName(fields, "site_name", payload.SiteName)
+	if len(fields) > 0 {
+		writeValidationError(w, fields)
+		return
+	}
+
+	err := database.UpdateSite(siteID, payload.SiteName, payload.Settings, orgID)
+	if err != nil {
+		writeDatabaseError(w, err, "site not found")
+		return
+	}
+
+	site, err := database.GetSite(siteID, orgID)
+	if err != nil {
+		writeDatabaseError(w, err, "site not found")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, site)
+}
+
+// MoveHostToSite handles the PUT /api/sites/{site_id}/hosts/{host_id} route
+func MoveHostToSite(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	siteID, ok := intVar(w, vars, "site_id")
+	if !ok {
+		return
+	}
+
+	hostID, ok := intVar(w, vars, "host_id")
+	if !ok {
+		return
+	}
+
+	orgID, ok := scopeFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	err := database.MoveHostToSite(hostID, siteID, orgID)
+	if err != nil {
+		writeDatabaseError(w, err, "host or site not found in this client")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,118 @@
+package api_handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slate-rmm/database"
+	"slate-rmm/models"
+	"slate-rmm/telemetry"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// AgentProtocolHeader carries the agent protocol version on agent requests and responses
+const AgentProtocolHeader = "X-Nexus-Agent-Protocol"
+
+// Agent protocol versions understood by the server.
+//
+//	1: agents without the protocol header; the inventory payload identifies the host as "id"
+//	2: the inventory payload identifies the host as "host_id", like models.Agent
+const (
+	MinAgentProtocol     = 1
+	CurrentAgentProtocol = 2
+)
+
+// CodeUnsupportedProtocol is returned to agents too old to be served
+const CodeUnsupportedProtocol = "unsupported_protocol"
+
+type protocolKey struct{}
+
+// AgentProtocol negotiates the protocol version of an agent request. Requests without the
+// header are legacy agents speaking version 1, versions newer than the server are downgraded
+// to the current one, and versions older than the minimum are rejected with 426.
+// The negotiated version is echoed back in the response header.
+func AgentProtocol(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		version := MinAgentProtocol
+		if value := r.Header.Get(AgentProtocolHeader); value != "" {
+			requested, err := strconv.Atoi(value)
+			if err != nil {
+				WriteError(w, http.StatusBadRequest, CodeInvalidRequest, "invalid "+AgentProtocolHeader+" header")
+				return
+			}
+			version = requested
+		}
+
+		if version < MinAgentProtocol {
+			WriteError(w, http.StatusUpgradeRequired, CodeUnsupportedProtocol,
+				fmt.Sprintf("agent protocol version %d is no longer supported, the minimum is %d: update the agent", version, MinAgentProtocol))
+			return
+		}
+		if version > CurrentAgentProtocol {
+			version = CurrentAgentProtocol
+		}
+
+		actor := "agent"
+		if id := mux.Vars(r)["id"]; id != "" {
+			actor += ":" + id
+		}
+		telemetry.SetActor(r, actor)
+
+		w.Header().Set(AgentProtocolHeader, strconv.Itoa(version))
+		next(w, r.WithContext(context.WithValue(r.Context(), protocolKey{}, version)))
+	}
+}
+
+// protocolVersion returns the version negotiated by AgentProtocol for the request
+func protocolVersion(r *http.Request) int {
+	if version, ok := r.Context().Value(protocolKey{}).(int); ok {
+		return version
+	}
+	return MinAgentProtocol
+}
+
+// decodeAgent decodes the inventory payload of an agent in the shape of its protocol version
+func decodeAgent(w http.ResponseWriter, r *http.Request, agent *models.Agent) bool {
+	version := protocolVersion(r)
+	if version == 1 {
+		// Version 1 agents send collectors.AgentData, which names the host ID "id"
+		var legacy struct {
+			models.Agent
+			LegacyID int32 `json:"id"`
+		}
+		if !decodeBody(w, r, &legacy) {
+			return false
+		}
+		*agent = legacy.Agent
+		if agent.ID == 0 {
+			agent.ID = legacy.LegacyID
+		}
+	} else if !decodeBody(w, r, agent) {
+		return false
+	}
+
+	agent.ProtocolVersion = version
+	return true
+}
+
+// GetProtocolVersions handles the GET /api/agents/protocols route, reporting how many hosts
+// speak each agent protocol version so that old versions can be retired
+func GetProtocolVersions(w http.ResponseWriter, r *http.Request) {
+	orgID, ok := scopeFromRequest(w, r)
+	if !ok {
+		return
+	}
+
+	usage, err := database.GetProtocolVersions(orgID)
+	if err != nil {
+		writeDatabaseError(w, err, "")
+		return
+	}
+	for i := range usage {
+		usage[i].Supported = usage[i].ProtocolVersion >= MinAgentProtocol && usage[i].ProtocolVersion <= CurrentAgentProtocol
+	}
+
+	writeJSON(w, http.StatusOK, usage)
+}
@@ -0,0 +1,81 @@
+package api_handlers
+
+import (
+	"net/http"
+	"slate-rmm/database"
+	"slate-rmm/models"
+	"slate-rmm/remoteaccess"
+
+	"github.com/gorilla/mux"
+)
+
+// remoteSessionLimit is how many remote sessions of an agent are returned
+const remoteSessionLimit = 100
+
+// GetRemoteAccessPolicies handles the GET /api/remote-access/policies route
+func GetRemoteAccessPolicies(w http.ResponseWriter, r *http.Request) {
+	policies, err := database.GetRemoteAccessPolicies()
+	if err != nil {
+		writeDatabaseError(w, err, "")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, policies)
+}
+
+// CreateRemoteAccessPolicy handles the POST /api/remote-access/policies route
+func CreateRemoteAccessPolicy(w http.ResponseWriter, r *http.Request) {
+	var policy models.RemoteAccessPolicy
+	if !decodeBody(w, r, &policy) {
+		return
+	}
+	if fields := policy.Validate(); len(fields) > 0 {
+		writeValidationError(w, fields)
+		return
+	}
+
+	if err := database.CreateRemoteAccessPolicy(&policy); err != nil {
+		writeDatabaseError(w, err, "group not found")
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, policy)
+}
+
+// DeleteRemoteAccessPolicy handles the DELETE /api/remote-access/policies/{policy_id} route
+func DeleteRemoteAccessPolicy(w http.ResponseWriter, r *http.Request) {
+	policyID, ok := intVar(w, mux.Vars(r), "policy_id")
+	if !ok {
+		return
+	}
+
+	if err := database.DeleteRemoteAccessPolicy(policyID); err != nil {
+		writeDatabaseError(w, err, "remote access policy not found")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetRemoteAccessProviders handles the GET /api/remote-access/providers route, reporting
+// the health of each configured provider
+func GetRemoteAccessProviders(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, remoteaccess.Status(r.Context()))
+}
+
+// GetRemoteSessions handles the GET /api/agents/{id}/remote-sessions route, listing who
+// started remote control of the agent, newest first
+func GetRemoteSessions(w http.ResponseWriter, r *http.Request) {
+	hostID, orgID, ok := hostVars(w, r)
+	if !ok {
+		return
+	}
+
+	sessions, err := database.GetRemoteSessions(hostID, orgID, remoteSessionLimit)
+	if err != nil {
+		writeDatabaseError(w, err, "")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, sessions)
+}
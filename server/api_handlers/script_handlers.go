@@ -0,0 +1,88 @@
+package api_handlers
+
+import (
+	"net/http"
+	"slate-rmm/database"
+	"slate-rmm/models"
+
+	"github.com/gorilla/mux"
+)
+
+// GetAllScripts handles the GET /api/scripts route
+func GetAllScripts(w http.ResponseWriter, r *http.Request) {
+	scripts, err := database.GetAllScripts()
+	if err != nil {
+		writeDatabaseError(w, err, "")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, scripts)
+}
+
+// GetScript handles the GET /api/scripts/{script_id} route
+func GetScript(w http.ResponseWriter, r *http.Request) {
+	scriptID, ok := intVar(w, mux.Vars(r), "script_id")
+	if !ok {
+		return
+	}
+
+	script, err := database.GetScript(scriptID)
+	if err != nil {
+		writeDatabaseError(w, err, "")
+		return
+	}
+	if script == nil {
+		WriteError(w, http.StatusNotFound, CodeNotFound, "script not found")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, script)
+}
+
+// CreateScript handles the POST /api/scripts route
+func CreateScript(w http.ResponseWriter, r *http.Request) {
+	var script models.Script
+	if !decodeBody(w, r, &script) {
+		return
+	}
+
+	fields := map[string]string{}
+	validateName(fields, "script_name", script.ScriptName)
+	if script.Content == "" {
+		fields["content"] = "content is required"
+	}
+	switch script.Shell {
+	case "":
+		script.Shell = "powershell"
+	case "powershell", "cmd":
+	default:
+		fields["shell"] = "shell must be powershell or cmd"
+	}
+	if len(fields) > 0 {
+		writeValidationError(w, fields)
+		return
+	}
+
+	if err := database.CreateScript(&script); err != nil {
+		writeDatabaseError(w, err, "")
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, script)
+}
+
+// DeleteScript handles the DELETE /api/scripts/{script_id} route
+func DeleteScript(w http.ResponseWriter, r *http.Request) {
+	scriptID, ok := intVar(w, mux.Vars(r), "script_id")
+	if !ok {
+		return
+	}
+
+	err := database.DeleteScript(scriptID)
+	if err != nil {
+		writeDatabaseError(w, err, "script not found")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
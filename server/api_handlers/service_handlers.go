@@ -0,0 +1,175 @@
+package api_handlers
+
+import (
+	"net/http"
+	"slate-rmm/database"
+	"slate-rmm/events"
+	"slate-rmm/models"
+
+	"github.com/gorilla/mux"
+)
+
+// maxSnapshotSize limits the services and processes an agent reports at once
+const maxSnapshotSize = 4 * 1024 * 1024
+
+// GetServices handles the GET /api/agents/{id}/services route, returning the services the
+// agent last reported. POST /api/agents/{id}/services/refresh asks it for new ones.
+func GetServices(w http.ResponseWriter, r *http.Request) {
+	hostID, orgID, ok := hostVars(w, r)
+	if !ok {
+		return
+	}
+
+	snapshot, err := database.GetServices(hostID, orgID)
+	if err != nil {
+		writeDatabaseError(w, err, "agent not found")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, snapshot)
+}
+
+// GetProcesses handles the GET /api/agents/{id}/processes route, returning the processes the
+// agent last reported. POST /api/agents/{id}/processes/refresh asks it for new ones.
+func GetProcesses(w http.ResponseWriter, r *http.Request) {
+	hostID, orgID, ok := hostVars(w, r)
+	if !ok {
+		return
+	}
+
+	snapshot, err := database.GetProcesses(hostID, orgID)
+	if err != nil {
+		writeDatabaseError(w, err, "agent not found")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, snapshot)
+}
+
+// ReportServices handles the PUT /api/agents/{id}/services route the agent reports the
+// services of its host to, replacing the ones reported before
+func ReportServices(w http.ResponseWriter, r *http.Request) {
+	var services []models.Service
+	reportSnapshot(w, r, database.SnapshotServices, &services, events.ServicesReported)
+}
+
+// ReportProcesses handles the PUT /api/agents/{id}/processes route the agent reports the
+// processes of its host to, replacing the ones reported before
+func ReportProcesses(w http.ResponseWriter, r *http.Request) {
+	var processes []models.Process
+	reportSnapshot(w, r, database.SnapshotProcesses, &processes, events.ProcessesReported)
+}
+
+// reportSnapshot stores the snapshot of a kind an agent reported and publishes eventType,
+// reloading the dashboard tabs showing it
+func reportSnapshot(w http.ResponseWriter, r *http.Request, kind string, snapshot interface{}, eventType string) {
+	hostID, ok := intVar(w, mux.Vars(r), "id")
+	if !ok {
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxSnapshotSize)
+	if !decodeBody(w, r, snapshot) {
+		return
+	}
+
+	if err := database.SaveSnapshot(hostID, kind, snapshot); err != nil {
+		writeDatabaseError(w, err, "agent not found")
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+
+	events.Publish(events.Event{Type: eventType, HostID: int32(hostID)})
+}
+
+// RefreshServices handles the POST /api/agents/{id}/services/refresh route. The services are
+// listed by a list_services job the next time the agent polls; the job is returned to
+// follow it.
+func RefreshServices(w http.ResponseWriter, r *http.Request) {
+	queueAgentJob(w, r, database.JobListServices, struct{}{})
+}
+
+// RefreshProcesses handles the POST /api/agents/{id}/processes/refresh route. The processes
+// are listed by a list_processes job the next time the agent polls; the job is returned to
+// follow it.
+func RefreshProcesses(w http.ResponseWriter, r *http.Request) {
+	queueAgentJob(w, r, database.JobListProcesses, struct{}{})
+}
+
+// ControlService handles the POST /api/agents/{id}/services/{name}/{action} route, starting,
+// stopping or restarting a service with a control_service job. The services are listed
+// again right after, and the control_service job is returned to follow it.
+func ControlService(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	action := models.ServiceAction{Name: vars["name"], Action: vars["action"]}
+	if fields := action.Validate(); len(fields) > 0 {
+		writeValidationError(w, fields)
+		return
+	}
+	queueAgentJobWithRefresh(w, r, database.JobControlService, action, database.JobListServices)
+}
+
+// TerminateProcess handles the POST /api/agents/{id}/processes/{pid}/terminate route,
+// terminating a process with a terminate_process job. The optional name in the body is
+// checked by the agent before terminating the process, in case its PID was reused. The
+// processes are listed again right after, and the terminate_process job is returned to
+// follow it.
+func TerminateProcess(w http.ResponseWriter, r *http.Request) {
+	pid, ok := intVar(w, mux.Vars(r), "pid")
+	if !ok {
+		return
+	}
+
+	termination := models.ProcessTermination{PID: int32(pid)}
+	if r.ContentLength != 0 && !decodeBody(w, r, &termination) {
+		return
+	}
+	termination.PID = int32(pid)
+	queueAgentJobWithRefresh(w, r, database.JobTerminateProcess, termination, database.JobListProcesses)
+}
+
+// queueAgentJob queues a job for the agent of the request and responds with it
+func queueAgentJob(w http.ResponseWriter, r *http.Request, jobType string, payload interface{}) {
+	hostID, orgID, ok := hostVars(w, r)
+	if !ok {
+		return
+	}
+
+	jobID, err := database.CreateJob(hostID, jobType, payload, orgID)
+	if err != nil {
+		writeDatabaseError(w, err, "agent not found")
+		return
+	}
+	writeQueuedJob(w, jobID, orgID)
+}
+
+// queueAgentJobWithRefresh queues a job for the agent of the request followed by a job of
+// refreshType, and responds with the first one
+func queueAgentJobWithRefresh(w http.ResponseWriter, r *http.Request, jobType string, payload interface{}, refreshType string) {
+	hostID, orgID, ok := hostVars(w, r)
+	if !ok {
+		return
+	}
+
+	jobID, refreshID, err := database.CreateJobWithRefresh(hostID, jobType, payload, refreshType, orgID)
+	if err != nil {
+		writeDatabaseError(w, err, "agent not found")
+		return
+	}
+	publishJob(int32(hostID), refreshID, database.JobPending)
+	writeQueuedJob(w, jobID, orgID)
+}
+
+// writeQueuedJob responds with a job that was just queued
+func writeQueuedJob(w http.ResponseWriter, jobID int32, orgID int) {
+	job, err := database.GetJob(int(jobID), orgID)
+	if err != nil || job == nil {
+		writeDatabaseError(w, err, "")
+		return
+	}
+
+	writeJSON(w, http.StatusAccepted, job)
+
+	publishJob(job.HostID, job.JobID, job.Status)
+}
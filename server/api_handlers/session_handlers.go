@@ -0,0 +1,38 @@
+package api_handlers
+
+import (
+	"net/http"
+	"slate-rmm/agentconn"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/net/websocket"
+)
+
+// SessionTokenHeader carries the token of the session on the agent connection
+const SessionTokenHeader = "X-Nexus-Session-Token"
+
+// AgentSession returns the handler of the GET /api/agents/{id}/{kind}/{session_id} routes,
+// the WebSocket the agent opens back to the server to serve a session of a kind, such as a
+// remote shell requested with an open_shell job. The connection stays open until the
+// session is over.
+func AgentSession(kind string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		hostID, ok := intVar(w, vars, "id")
+		if !ok {
+			return
+		}
+		sessionID, ok := intVar(w, vars, "session_id")
+		if !ok {
+			return
+		}
+
+		session := agentconn.Claim(kind, int32(sessionID), int32(hostID), r.Header.Get(SessionTokenHeader))
+		if session == nil {
+			WriteError(w, http.StatusNotFound, CodeNotFound, kind+" session not found")
+			return
+		}
+
+		websocket.Server{Handler: session.Attach}.ServeHTTP(w, r)
+	}
+}
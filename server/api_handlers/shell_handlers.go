@@ -0,0 +1,29 @@
+package api_handlers
+
+import (
+	"net/http"
+	"slate-rmm/database"
+
+	"github.com/gorilla/mux"
+)
+
+// GetShellRecording handles the GET /api/agents/{id}/remote-sessions/{session_id}/recording
+// route, returning what was typed in and written by a remote shell session in order
+func GetShellRecording(w http.ResponseWriter, r *http.Request) {
+	hostID, orgID, ok := hostVars(w, r)
+	if !ok {
+		return
+	}
+	sessionID, ok := intVar(w, mux.Vars(r), "session_id")
+	if !ok {
+		return
+	}
+
+	chunks, err := database.GetShellRecording(sessionID, hostID, orgID)
+	if err != nil {
+		writeDatabaseError(w, err, "shell session not found")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, chunks)
+}
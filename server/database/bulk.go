@@ -0,0 +1,195 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"slate-rmm/models"
+)
+
+// Bulk actions that can be applied to a set of hosts
+const (
+	BulkAddToGroup       = "add_to_group"
+	BulkMoveToGroup      = "move_to_group"
+	BulkDelete           = "delete"
+	BulkRunScript        = "run_script"
+	BulkRefreshInventory = "refresh_inventory"
+	BulkTag              = "tag"
+)
+
+// ErrInvalidBulkRequest is returned when a bulk request is missing the parameters of its action
+var ErrInvalidBulkRequest = errors.New("invalid bulk request")
+
+// hostInScope returns ErrNotFound if the host does not exist or is outside orgID, unless it is 0
+func hostInScope(q queryer, hostID, orgID int) error {
+	var exists bool
+	err := q.QueryRow(`
+		SELECT EXISTS (
+			SELECT 1 FROM agents a JOIN sites s ON a.site_id = s.site_id
+			WHERE a.host_id = $1 AND ($2 = 0 OR s.org_id = $2)
+		)`, hostID, orgID).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// bulkError converts an error into a message that is safe to return for a single host
+func bulkError(hostID int, err error) string {
+	switch {
+	case errors.Is(err, ErrNotFound):
+		return "host or group not found in this client"
+	case errors.Is(err, ErrConflict):
+		return "host is already a member of this group"
+	default:
+		log.Printf("bulk action failed for host %d: %v", hostID, err)
+		return "action failed"
+	}
+}
+
+// RunBulkAction applies an action to every host of the request inside a single transaction.
+// Each host runs under its own savepoint so that one failure does not abort the others,
+// unless the request is atomic in which case any failure rolls back every host.
+func RunBulkAction(req models.BulkRequest, orgID int) ([]models.BulkResult, error) {
+	action, err := bulkActionFunc(req)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	results := make([]models.BulkResult, 0, len(req.HostIDs))
+	failed := false
+	for _, hostID := range req.HostIDs {
+		result := models.BulkResult{HostID: hostID}
+
+		if _, err := tx.Exec("SAVEPOINT bulk_host"); err != nil {
+			return nil, err
+		}
+
+		err := hostInScope(tx, hostID, orgID)
+		if err == nil {
+			result.JobID, err = action(tx, hostID, orgID)
+		}
+
+		if err != nil {
+			if _, rbErr := tx.Exec("ROLLBACK TO SAVEPOINT bulk_host"); rbErr != nil {
+				return nil, rbErr
+			}
+			result.Error = bulkError(hostID, err)
+			failed = true
+		} else {
+			if _, err := tx.Exec("RELEASE SAVEPOINT bulk_host"); err != nil {
+				return nil, err
+			}
+			result.Success = true
+		}
+
+		results = append(results, result)
+	}
+
+	// An atomic request is all or nothing
+	if req.Atomic && failed {
+		for i := range results {
+			if results[i].Success {
+				results[i].Success = false
+				results[i].JobID = 0
+				results[i].Error = "not applied because another host failed"
+			}
+		}
+		return results, nil
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// bulkHostFunc applies a bulk action to a single host and returns the queued job, if any
+type bulkHostFunc func(tx *sql.Tx, hostID, orgID int) (int32, error)
+
+// bulkActionFunc validates a bulk request and returns the function applying it to one host
+func bulkActionFunc(req models.BulkRequest) (bulkHostFunc, error) {
+	if len(req.HostIDs) == 0 {
+		return nil, fmt.Errorf("%w: no hosts selected", ErrInvalidBulkRequest)
+	}
+
+	switch req.Action {
+	case BulkAddToGroup:
+		if req.GroupID == 0 {
+			return nil, fmt.Errorf("%w: group_id is required", ErrInvalidBulkRequest)
+		}
+		return func(tx *sql.Tx, hostID, orgID int) (int32, error) {
+			return 0, addHostToGroup(tx, hostID, req.GroupID, orgID)
+		}, nil
+
+	case BulkMoveToGroup:
+		if req.GroupID == 0 {
+			return nil, fmt.Errorf("%w: group_id is required", ErrInvalidBulkRequest)
+		}
+		return func(tx *sql.Tx, hostID, orgID int) (int32, error) {
+			if req.FromGroupID != 0 {
+				return 0, moveHostToGroup(tx, hostID, req.FromGroupID, req.GroupID, orgID)
+			}
+
+			// Without a source group the host leaves every group it is in
+			if _, err := tx.Exec("DELETE FROM device_group_members WHERE host_id = $1", hostID); err != nil {
+				return 0, err
+			}
+			return 0, addHostToGroup(tx, hostID, req.GroupID, orgID)
+		}, nil
+
+	case BulkDelete:
+		return func(tx *sql.Tx, hostID, orgID int) (int32, error) {
+			// Group memberships, tags and jobs cascade with the host
+			result, err := tx.Exec("DELETE FROM agents WHERE host_id = $1", hostID)
+			if err != nil {
+				return 0, err
+			}
+			return 0, checkAffected(result)
+		}, nil
+
+	case BulkRunScript:
+		if req.ScriptID == 0 {
+			return nil, fmt.Errorf("%w: script_id is required", ErrInvalidBulkRequest)
+		}
+		script, err := GetScript(req.ScriptID)
+		if err != nil {
+			return nil, err
+		}
+		if script == nil {
+			return nil, fmt.Errorf("%w: script not found", ErrInvalidBulkRequest)
+		}
+		return func(tx *sql.Tx, hostID, orgID int) (int32, error) {
+			return createJob(tx, hostID, JobRunScript, script, orgID)
+		}, nil
+
+	case BulkRefreshInventory:
+		return func(tx *sql.Tx, hostID, orgID int) (int32, error) {
+			return createJob(tx, hostID, JobRefreshInventory, struct{}{}, orgID)
+		}, nil
+
+	case BulkTag:
+		if err := models.ValidateTag(req.TagKey, req.TagValue); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidBulkRequest, err)
+		}
+		return func(tx *sql.Tx, hostID, orgID int) (int32, error) {
+			_, err := tx.Exec(`
+				INSERT INTO agent_tags (host_id, tag_key, tag_value) VALUES ($1, $2, $3)
+				ON CONFLICT (host_id, tag_key) DO UPDATE SET tag_value = EXCLUDED.tag_value`, hostID, req.TagKey, req.TagValue)
+			return 0, err
+		}, nil
+	}
+
+	return nil, fmt.Errorf("%w: unknown action %q", ErrInvalidBulkRequest, req.Action)
+}
@@ -0,0 +1,351 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"slate-rmm/models"
+	"time"
+)
+
+// CheckResultRetention is how long the results of checks are kept for their history
+const CheckResultRetention = 30 * 24 * time.Hour
+
+// checkColumns are the columns scanned by scanCheck
+const checkColumns = "c.check_id, c.group_id, c.check_name, c.check_type, c.params, c.interval_seconds, c.enabled"
+
+// CheckChange is a check whose status changed on a host with a new result. Previous is empty
+// for the first result of the check on the host.
+type CheckChange struct {
+	HostID    int32
+	OrgID     int32
+	CheckName string
+	Previous  string
+	Result    models.CheckResult
+}
+
+// groupInScope returns ErrNotFound unless the group exists and belongs to orgID, or orgID is 0
+func groupInScope(q queryer, groupID, orgID int) error {
+	var exists bool
+	err := q.QueryRow(`
+		SELECT EXISTS (
+			SELECT 1 FROM device_groups g JOIN sites s ON g.site_id = s.site_id
+			WHERE g.group_id = $1 AND ($2 = 0 OR s.org_id = $2)
+		)`, groupID, orgID).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func scanCheck(row rowScanner) (*models.Check, error) {
+	var check models.Check
+	var paramsRaw []byte
+	err := row.Scan(&check.CheckID, &check.GroupID, &check.CheckName, &check.CheckType, &paramsRaw, &check.Interval, &check.Enabled)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(paramsRaw, &check.Params); err != nil {
+		return nil, err
+	}
+	return &check, nil
+}
+
+// queryChecks returns the checks selected by a query on checkColumns
+func queryChecks(query string, args ...interface{}) ([]models.Check, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	checks := []models.Check{}
+	for rows.Next() {
+		check, err := scanCheck(rows)
+		if err != nil {
+			return nil, err
+		}
+		checks = append(checks, *check)
+	}
+	return checks, rows.Err()
+}
+
+// CreateCheck assigns a new check to its group, limited to orgID unless it is 0
+func CreateCheck(check *models.Check, orgID int) error {
+	paramsJSON, err := json.Marshal(check.Params)
+	if err != nil {
+		return err
+	}
+
+	err = db.QueryRow(`
+		INSERT INTO checks (group_id, check_name, check_type, params, interval_seconds, enabled)
+		SELECT g.group_id, $2, $3, $4, $5, $6
+		FROM device_groups g
+		JOIN sites s ON g.site_id = s.site_id
+		WHERE g.group_id = $1 AND ($7 = 0 OR s.org_id = $7)
+		RETURNING check_id`, check.GroupID, check.CheckName, check.CheckType, paramsJSON, check.Interval, check.Enabled, orgID).Scan(&check.CheckID)
+	if err == sql.ErrNoRows {
+		return ErrNotFound
+	}
+	return translateError(err)
+}
+
+// GetGroupChecks returns the checks assigned to a group, limited to orgID unless it is 0
+func GetGroupChecks(groupID int, orgID int) ([]models.Check, error) {
+	if err := groupInScope(db, groupID, orgID); err != nil {
+		return nil, err
+	}
+	return queryChecks("SELECT "+checkColumns+" FROM checks c WHERE c.group_id = $1 ORDER BY c.check_name", groupID)
+}
+
+// GetCheck returns a single check, or nil if it does not exist in orgID
+func GetCheck(id int, orgID int) (*models.Check, error) {
+	row := db.QueryRow(`
+		SELECT `+checkColumns+`
+		FROM checks c
+		JOIN device_groups g ON c.group_id = g.group_id
+		JOIN sites s ON g.site_id = s.site_id
+		WHERE c.check_id = $1 AND ($2 = 0 OR s.org_id = $2)`, id, orgID)
+	check, err := scanCheck(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return check, err
+}
+
+// UpdateCheck replaces the settings of a check, limited to orgID unless it is 0. The check
+// stays in its group, which is set on check. Agents pick up the change on their next
+// heartbeat.
+func UpdateCheck(check *models.Check, orgID int) error {
+	paramsJSON, err := json.Marshal(check.Params)
+	if err != nil {
+		return err
+	}
+
+	err = db.QueryRow(`
+		UPDATE checks c SET check_name = $2, check_type = $3, params = $4, interval_seconds = $5, enabled = $6
+		FROM device_groups g
+		JOIN sites s ON g.site_id = s.site_id
+		WHERE c.group_id = g.group_id AND c.check_id = $1 AND ($7 = 0 OR s.org_id = $7)
+		RETURNING c.group_id`, check.CheckID, check.CheckName, check.CheckType, paramsJSON, check.Interval, check.Enabled, orgID).Scan(&check.GroupID)
+	if err == sql.ErrNoRows {
+		return ErrNotFound
+	}
+	return translateError(err)
+}
+
+// DeleteCheck deletes a check along with its results, limited to orgID unless it is 0
+func DeleteCheck(id int, orgID int) error {
+	result, err := db.Exec(`
+		DELETE FROM checks c
+		USING device_groups g, sites s
+		WHERE c.group_id = g.group_id AND g.site_id = s.site_id AND c.check_id = $1 AND ($2 = 0 OR s.org_id = $2)`, id, orgID)
+	if err != nil {
+		return err
+	}
+	return checkAffected(result)
+}
+
+// GetAgentChecks returns the enabled checks of the groups of a host, which its agent runs
+func GetAgentChecks(hostID string) ([]models.Check, error) {
+	return queryChecks(`
+		SELECT `+checkColumns+`
+		FROM checks c
+		JOIN device_group_members m ON c.group_id = m.group_id
+		WHERE m.host_id = $1 AND c.enabled
+		ORDER BY c.check_id`, hostID)
+}
+
+// RecordCheckResults stores the results of checks reported by the agent of a host and
+// returns the checks whose status changed. Results of checks no longer assigned to the
+// host are dropped, and results older than the state of their check only go to the history.
+func RecordCheckResults(hostID int, results []models.CheckResult) ([]CheckChange, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// The alerts raised for the changes are only shown to the client of the host
+	var orgID int32
+	err = tx.QueryRow(`
+		SELECT s.org_id FROM agents a JOIN sites s ON a.site_id = s.site_id
+		WHERE a.host_id = $1`, hostID).Scan(&orgID)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []CheckChange
+	for _, result := range results {
+		var name string
+		err := tx.QueryRow(`
+			SELECT c.check_name
+			FROM checks c
+			JOIN device_group_members m ON c.group_id = m.group_id
+			WHERE c.check_id = $1 AND m.host_id = $2`, result.CheckID, hostID).Scan(&name)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if result.Perfdata == nil {
+			result.Perfdata = []models.PerfData{}
+		}
+		perfdataJSON, err := json.Marshal(result.Perfdata)
+		if err != nil {
+			return nil, err
+		}
+		_, err = tx.Exec(`
+			INSERT INTO check_results (check_id, host_id, status, output, perfdata, checked_at)
+			VALUES ($1, $2, $3, $4, $5, $6)`, result.CheckID, hostID, result.Status, result.Output, perfdataJSON, result.CheckedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		// The previous status is read from the snapshot the statement started with
+		var previous sql.NullString
+		err = tx.QueryRow(`
+			WITH previous AS (SELECT status FROM check_states WHERE host_id = $1 AND check_id = $2)
+			INSERT INTO check_states (host_id, check_id, status, output, perfdata, checked_at, since)
+			VALUES ($1, $2, $3, $4, $5, $6, $6)
+			ON CONFLICT (host_id, check_id) DO UPDATE SET
+				status = EXCLUDED.status, output = EXCLUDED.output, perfdata = EXCLUDED.perfdata, checked_at = EXCLUDED.checked_at,
+				since = CASE WHEN check_states.status = EXCLUDED.status THEN check_states.since ELSE EXCLUDED.checked_at END
+			WHERE check_states.checked_at <= EXCLUDED.checked_at
+			RETURNING (SELECT status FROM previous)`, hostID, result.CheckID, result.Status, result.Output, perfdataJSON, result.CheckedAt).Scan(&previous)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if previous.String != result.Status {
+			changes = append(changes, CheckChange{HostID: int32(hostID), OrgID: orgID, CheckName: name, Previous: previous.String, Result: result})
+		}
+	}
+
+	return changes, tx.Commit()
+}
+
+// GetCheckStates returns the latest result of every check a host runs, limited to orgID
+// unless it is 0
+func GetCheckStates(hostID int, orgID int) ([]models.CheckState, error) {
+	if err := hostInScope(db, hostID, orgID); err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(`
+		SELECT c.check_id, c.check_name, c.check_type, COALESCE(st.status, $2), COALESCE(st.output, ''),
+			COALESCE(st.perfdata, '[]'), st.checked_at, st.since
+		FROM checks c
+		JOIN device_group_members m ON c.group_id = m.group_id
+		LEFT JOIN check_states st ON st.check_id = c.check_id AND st.host_id = m.host_id
+		WHERE m.host_id = $1 AND c.enabled
+		ORDER BY c.check_name, c.check_id`, hostID, models.CheckPending)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	states := []models.CheckState{}
+	for rows.Next() {
+		var state models.CheckState
+		var perfdataRaw []byte
+		err := rows.Scan(&state.CheckID, &state.CheckName, &state.CheckType, &state.Status, &state.Output,
+			&perfdataRaw, &state.CheckedAt, &state.Since)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(perfdataRaw, &state.Perfdata); err != nil {
+			return nil, err
+		}
+		states = append(states, state)
+	}
+	return states, rows.Err()
+}
+
+// GetHostCheckStates returns the latest result of every check on every host, sorted by
+// hostname and check name, limited to orgID unless it is 0. Checks that have not reported
+// yet are left out.
+func GetHostCheckStates(orgID int) ([]models.HostCheckState, error) {
+	rows, err := db.Query(`
+		SELECT a.hostname, c.check_id, c.check_name, c.check_type, c.interval_seconds,
+			st.status, st.output, st.perfdata, st.checked_at, st.since
+		FROM check_states st
+		JOIN checks c ON st.check_id = c.check_id
+		JOIN device_group_members m ON c.group_id = m.group_id AND st.host_id = m.host_id
+		JOIN agents a ON st.host_id = a.host_id
+		JOIN sites s ON a.site_id = s.site_id
+		WHERE c.enabled AND ($1 = 0 OR s.org_id = $1)
+		ORDER BY a.hostname, c.check_name, c.check_id`, orgID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	states := []models.HostCheckState{}
+	for rows.Next() {
+		var state models.HostCheckState
+		var perfdataRaw []byte
+		err := rows.Scan(&state.Hostname, &state.CheckID, &state.CheckName, &state.CheckType, &state.Interval,
+			&state.Status, &state.Output, &perfdataRaw, &state.CheckedAt, &state.Since)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(perfdataRaw, &state.Perfdata); err != nil {
+			return nil, err
+		}
+		states = append(states, state)
+	}
+	return states, rows.Err()
+}
+
+// GetCheckHistory returns the results of a check on a host since a time, newest first and at
+// most limit of them, limited to orgID unless it is 0
+func GetCheckHistory(hostID, checkID int, since time.Time, limit int, orgID int) ([]models.CheckResult, error) {
+	if err := hostInScope(db, hostID, orgID); err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(`
+		SELECT check_id, status, output, perfdata, checked_at
+		FROM check_results
+		WHERE host_id = $1 AND check_id = $2 AND checked_at >= $3
+		ORDER BY checked_at DESC, result_id DESC
+		LIMIT $4`, hostID, checkID, since, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []models.CheckResult{}
+	for rows.Next() {
+		var result models.CheckResult
+		var perfdataRaw []byte
+		if err := rows.Scan(&result.CheckID, &result.Status, &result.Output, &perfdataRaw, &result.CheckedAt); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(perfdataRaw, &result.Perfdata); err != nil {
+			return nil, err
+		}
+		results = append(results, result)
+	}
+	return results, rows.Err()
+}
+
+// PruneCheckResults deletes the check results recorded before a time and returns how many
+// were deleted. The latest state of each check is kept.
+func PruneCheckResults(before time.Time) (int64, error) {
+	result, err := db.Exec("DELETE FROM check_results WHERE checked_at < $1", before)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
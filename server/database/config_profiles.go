@@ -0,0 +1,158 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"slate-rmm/models"
+)
+
+// CreateConfigProfile stores a new agent configuration profile
+func CreateConfigProfile(profile *models.ConfigProfile) error {
+	configJSON, err := json.Marshal(profile.Config)
+	if err != nil {
+		return err
+	}
+
+	err = db.QueryRow("INSERT INTO config_profiles (profile_name, config) VALUES ($1, $2) RETURNING profile_id",
+		profile.ProfileName, configJSON).Scan(&profile.ProfileID)
+	return translateError(err)
+}
+
+// GetAllConfigProfiles returns every agent configuration profile
+func GetAllConfigProfiles() ([]models.ConfigProfile, error) {
+	rows, err := db.Query("SELECT profile_id, profile_name, config FROM config_profiles ORDER BY profile_name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var profiles []models.ConfigProfile
+	for rows.Next() {
+		profile, err := scanConfigProfile(rows)
+		if err != nil {
+			return nil, err
+		}
+		profiles = append(profiles, *profile)
+	}
+
+	return profiles, rows.Err()
+}
+
+// GetConfigProfile returns a single agent configuration profile
+func GetConfigProfile(id int) (*models.ConfigProfile, error) {
+	row := db.QueryRow("SELECT profile_id, profile_name, config FROM config_profiles WHERE profile_id = $1", id)
+	profile, err := scanConfigProfile(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return profile, err
+}
+
+func scanConfigProfile(row rowScanner) (*models.ConfigProfile, error) {
+	var profile models.ConfigProfile
+	var configRaw []byte
+	if err := row.Scan(&profile.ProfileID, &profile.ProfileName, &configRaw); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(configRaw, &profile.Config); err != nil {
+		return nil, err
+	}
+	return &profile, nil
+}
+
+// UpdateConfigProfile replaces the name and configuration of a profile.
+// Agents pick up the change on their next heartbeat.
+func UpdateConfigProfile(profile *models.ConfigProfile) error {
+	configJSON, err := json.Marshal(profile.Config)
+	if err != nil {
+		return err
+	}
+
+	result, err := db.Exec("UPDATE config_profiles SET profile_name = $1, config = $2 WHERE profile_id = $3",
+		profile.ProfileName, configJSON, profile.ProfileID)
+	if err != nil {
+		return translateError(err)
+	}
+	return checkAffected(result)
+}
+
+// DeleteConfigProfile deletes a profile. Groups and hosts using it fall back to the next profile down.
+func DeleteConfigProfile(id int) error {
+	result, err := db.Exec("DELETE FROM config_profiles WHERE profile_id = $1", id)
+	if err != nil {
+		return err
+	}
+	return checkAffected(result)
+}
+
+// SetGroupProfile assigns a profile to a group, or clears it when profileID is nil,
+// limited to orgID unless it is 0
+func SetGroupProfile(groupID int, profileID *int, orgID int) error {
+	result, err := db.Exec(`
+		UPDATE device_groups g SET profile_id = $1
+		FROM sites s
+		WHERE g.site_id = s.site_id AND g.group_id = $2 AND ($3 = 0 OR s.org_id = $3)`, profileID, groupID, orgID)
+	if isViolation(err, "23503") {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	return checkAffected(result)
+}
+
+// SetAgentProfile assigns a profile to a host, or clears it when profileID is nil,
+// limited to orgID unless it is 0
+func SetAgentProfile(hostID int, profileID *int, orgID int) error {
+	result, err := db.Exec(`
+		UPDATE agents a SET profile_id = $1
+		FROM sites s
+		WHERE a.site_id = s.site_id AND a.host_id = $2 AND ($3 = 0 OR s.org_id = $3)`, profileID, hostID, orgID)
+	if isViolation(err, "23503") {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+	return checkAffected(result)
+}
+
+// GetEffectiveConfig resolves the configuration of a host: the defaults, overridden by the
+// profiles of its groups in group order, overridden by the profile assigned to the host itself
+func GetEffectiveConfig(hostID string) (*models.EffectiveConfig, error) {
+	rows, err := db.Query(`
+		SELECT 0, g.group_id, 'group:' || g.group_name, p.config
+		FROM device_group_members m
+		JOIN device_groups g ON m.group_id = g.group_id
+		JOIN config_profiles p ON g.profile_id = p.profile_id
+		WHERE m.host_id = $1
+		UNION ALL
+		SELECT 1, 0, 'host', p.config
+		FROM agents a
+		JOIN config_profiles p ON a.profile_id = p.profile_id
+		WHERE a.host_id = $1
+		ORDER BY 1, 2`, hostID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	effective := &models.EffectiveConfig{Config: models.DefaultAgentConfig(), Sources: map[string]string{}}
+	effective.Config.Apply(models.DefaultAgentConfig(), "default", effective.Sources)
+
+	for rows.Next() {
+		var priority, groupID int
+		var source string
+		var configRaw []byte
+		if err := rows.Scan(&priority, &groupID, &source, &configRaw); err != nil {
+			return nil, err
+		}
+		var config models.AgentConfig
+		if err := json.Unmarshal(configRaw, &config); err != nil {
+			return nil, err
+		}
+		effective.Config.Apply(config, source, effective.Sources)
+	}
+
+	return effective, rows.Err()
+}
@@ -0,0 +1,199 @@
+package database
+
+import (
+	"encoding/json"
+	"fmt"
+	"slate-rmm/models"
+)
+
+// FieldError is returned when a tag or custom field value fails validation
+type FieldError struct {
+	Field  string
+	Reason string
+}
+
+func (e *FieldError) Error() string {
+	return e.Reason
+}
+
+// CreateCustomField stores a new custom field definition
+func CreateCustomField(field *models.CustomField) error {
+	if field.Options == nil {
+		field.Options = []string{}
+	}
+	optionsJSON, err := json.Marshal(field.Options)
+	if err != nil {
+		return err
+	}
+
+	return db.QueryRow("INSERT INTO custom_field_definitions (field_key, label, field_type, options) VALUES ($1, $2, $3, $4) RETURNING field_id",
+		field.FieldKey, field.Label, field.FieldType, optionsJSON).Scan(&field.FieldID)
+}
+
+// GetAllCustomFields returns every custom field definition
+func GetAllCustomFields() ([]models.CustomField, error) {
+	rows, err := db.Query("SELECT field_id, field_key, label, field_type, options FROM custom_field_definitions ORDER BY field_id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var fields []models.CustomField
+	for rows.Next() {
+		var field models.CustomField
+		var optionsRaw []byte
+		if err := rows.Scan(&field.FieldID, &field.FieldKey, &field.Label, &field.FieldType, &optionsRaw); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(optionsRaw, &field.Options); err != nil {
+			return nil, err
+		}
+		fields = append(fields, field)
+	}
+
+	return fields, rows.Err()
+}
+
+// UpdateCustomField updates the label and options of a custom field definition.
+// The key and type are fixed once values have been stored against them.
+func UpdateCustomField(id int, label string, options []string) error {
+	if options == nil {
+		options = []string{}
+	}
+	optionsJSON, err := json.Marshal(options)
+	if err != nil {
+		return err
+	}
+
+	result, err := db.Exec("UPDATE custom_field_definitions SET label = $1, options = $2 WHERE field_id = $3", label, optionsJSON, id)
+	if err != nil {
+		return err
+	}
+	return checkAffected(result)
+}
+
+// DeleteCustomField deletes a custom field definition and every value stored for it
+func DeleteCustomField(id int) error {
+	result, err := db.Exec("DELETE FROM custom_field_definitions WHERE field_id = $1", id)
+	if err != nil {
+		return err
+	}
+	return checkAffected(result)
+}
+
+// SetAgentCustomFields validates and stores custom field values on an agent,
+// limited to orgID unless it is 0. An empty value clears the field.
+func SetAgentCustomFields(hostID int, values map[string]string, orgID int) error {
+	return setAgentFields(hostID, values, nil, orgID)
+}
+
+// SetAgentTags replaces every tag of an agent, limited to orgID unless it is 0
+func SetAgentTags(hostID int, tags map[string]string, orgID int) error {
+	return SetAgentFields(hostID, nil, tags, orgID)
+}
+
+// SetAgentFields stores custom field values on an agent and replaces every tag of it in a
+// single transaction, so that neither is saved if the other is rejected. It is limited to
+// orgID unless it is 0.
+func SetAgentFields(hostID int, values, tags map[string]string, orgID int) error {
+	if tags == nil {
+		tags = map[string]string{}
+	}
+	return setAgentFields(hostID, values, tags, orgID)
+}
+
+// setAgentFields stores the custom field values of an agent, and replaces its tags unless
+// tags is nil
+func setAgentFields(hostID int, values, tags map[string]string, orgID int) error {
+	for key, value := range tags {
+		if err := models.ValidateTag(key, value); err != nil {
+			return &FieldError{Field: "tags", Reason: err.Error()}
+		}
+	}
+
+	var byKey map[string]models.CustomField
+	if len(values) > 0 {
+		fields, err := GetAllCustomFields()
+		if err != nil {
+			return err
+		}
+		byKey = make(map[string]models.CustomField, len(fields))
+		for _, field := range fields {
+			byKey[field.FieldKey] = field
+		}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := hostInScope(tx, hostID, orgID); err != nil {
+		return err
+	}
+
+	for key, value := range values {
+		field, ok := byKey[key]
+		if !ok {
+			return &FieldError{Field: key, Reason: fmt.Sprintf("unknown custom field %q", key)}
+		}
+
+		if value == "" {
+			if _, err := tx.Exec("DELETE FROM agent_custom_fields WHERE host_id = $1 AND field_id = $2", hostID, field.FieldID); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := field.Validate(value); err != nil {
+			return &FieldError{Field: key, Reason: err.Error()}
+		}
+		_, err := tx.Exec(`
+			INSERT INTO agent_custom_fields (host_id, field_id, field_value) VALUES ($1, $2, $3)
+			ON CONFLICT (host_id, field_id) DO UPDATE SET field_value = EXCLUDED.field_value`, hostID, field.FieldID, value)
+		if err != nil {
+			return err
+		}
+	}
+
+	if tags != nil {
+		if _, err := tx.Exec("DELETE FROM agent_tags WHERE host_id = $1", hostID); err != nil {
+			return err
+		}
+		for key, value := range tags {
+			if _, err := tx.Exec("INSERT INTO agent_tags (host_id, tag_key, tag_value) VALUES ($1, $2, $3)", hostID, key, value); err != nil {
+				return err
+			}
+		}
+	}
+
+	return tx.Commit()
+}
+
+// SetAgentTag adds or updates a single tag on an agent, limited to orgID unless it is 0
+func SetAgentTag(hostID int, key, value string, orgID int) error {
+	if err := models.ValidateTag(key, value); err != nil {
+		return &FieldError{Field: "tags", Reason: err.Error()}
+	}
+	if err := hostInScope(db, hostID, orgID); err != nil {
+		return err
+	}
+
+	_, err := db.Exec(`
+		INSERT INTO agent_tags (host_id, tag_key, tag_value) VALUES ($1, $2, $3)
+		ON CONFLICT (host_id, tag_key) DO UPDATE SET tag_value = EXCLUDED.tag_value`, hostID, key, value)
+	return err
+}
+
+// DeleteAgentTag removes a tag from an agent, limited to orgID unless it is 0
+func DeleteAgentTag(hostID int, key string, orgID int) error {
+	result, err := db.Exec(`
+		DELETE FROM agent_tags
+		WHERE host_id = $1 AND tag_key = $2
+		AND host_id IN (SELECT a.host_id FROM agents a JOIN sites s ON a.site_id = s.site_id WHERE $3 = 0 OR s.org_id = $3)`, hostID, key, orgID)
+	if err != nil {
+		return err
+	}
+	return checkAffected(result)
+}
@@ -1,31 +1,171 @@
 package database
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
 	"slate-rmm/models"
+	"strings"
 	"time"
 
-	_ "github.com/lib/pq"
+	"github.com/XSAM/otelsql"
+	"github.com/lib/pq"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
 )
 
 var db *sql.DB
 
+// ErrNotFound is returned when a record does not exist or is outside the selected client
+var ErrNotFound = errors.New("record not found")
+
+// ErrConflict is returned when a record would violate a uniqueness constraint
+var ErrConflict = errors.New("record already exists")
+
+// ErrGroupNotEmpty is returned when deleting a group that still has members without forcing it
+var ErrGroupNotEmpty = errors.New("group still has members")
+
+// isViolation reports whether err is a Postgres error with the given SQLSTATE code
+func isViolation(err error, code pq.ErrorCode) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == code
+}
+
+// translateError converts unique constraint violations reported by Postgres into ErrConflict
+func translateError(err error) error {
+	if isViolation(err, "23505") {
+		return ErrConflict
+	}
+	return err
+}
+
+// agentSelect selects an agent together with the site and organization it belongs to,
+// its tags and its custom field values.
+// The domain and remote-access provider are inherited from the organization unless the site
+// overrides them.
+const agentSelect = `
+	SELECT a.host_id, a.hostname, a.ip_address, a.os, a.os_version, a.hardware_specs, a.agent_version, a.last_seen, a.last_user, a.remotely_id, a.remote_ids,
+		a.protocol_version, COALESCE(a.display_name, ''), s.site_id, s.site_name, o.org_id, o.org_name, COALESCE(s.settings->>'domain', o.settings->>'domain', ''),
+		COALESCE(s.settings->>'remote_access_provider', o.settings->>'remote_access_provider', ''),
+		COALESCE((SELECT jsonb_object_agg(t.tag_key, t.tag_value) FROM agent_tags t WHERE t.host_id = a.host_id), '{}'),
+		COALESCE((SELECT jsonb_object_agg(d.field_key, v.field_value) FROM agent_custom_fields v
+			JOIN custom_field_definitions d ON v.field_id = d.field_id WHERE v.host_id = a.host_id), '{}')
+	FROM agents a
+	JOIN sites s ON a.site_id = s.site_id
+	JOIN organizations o ON s.org_id = o.org_id`
+
+// execer is implemented by both *sql.DB and *sql.Tx
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// rowScanner is implemented by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanAgent scans a row selected with agentSelect into an Agent struct
+func scanAgent(row rowScanner) (*models.Agent, error) {
+	var agent models.Agent
+	var hardwareSpecsRaw, remoteIDsRaw, tagsRaw, customFieldsRaw []byte
+	if err := row.Scan(&agent.ID, &agent.Hostname, &agent.IPAddress, &agent.OS, &agent.OSVersion, &hardwareSpecsRaw, &agent.AgentVersion, &agent.LastSeen, &agent.LastUser, &agent.RemotelyID, &remoteIDsRaw,
+		&agent.ProtocolVersion, &agent.DisplayName, &agent.SiteID, &agent.SiteName, &agent.OrgID, &agent.OrgName, &agent.Domain,
+		&agent.RemoteAccessProvider, &tagsRaw, &customFieldsRaw); err != nil {
+		return nil, err
+	}
+
+	// Unmarshal the remote-access IDs, tags and custom fields
+	if err := json.Unmarshal(remoteIDsRaw, &agent.RemoteIDs); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(tagsRaw, &agent.Tags); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(customFieldsRaw, &agent.CustomFields); err != nil {
+		return nil, err
+	}
+
+	// Unmarshal the hardware specs
+	if len(hardwareSpecsRaw) > 0 {
+		if err := json.Unmarshal(hardwareSpecsRaw, &agent.HardwareSpecs); err != nil {
+			return nil, err
+		}
+	}
+
+	return &agent, nil
+}
+
+// checkAffected returns ErrNotFound if a statement did not touch any rows
+func checkAffected(result sql.Result) error {
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
 // InitDB initializes the database connection
 func InitDB(dataSourceName string) error {
 	var err error
-	db, err = sql.Open("postgres", dataSourceName)
+	// Queries are traced when telemetry.SetupTracing installed a tracer provider
+	db, err = otelsql.Open("postgres", dataSourceName, otelsql.WithAttributes(semconv.DBSystemPostgreSQL))
 	if err != nil {
 		return err
 	}
 
 	if err = db.Ping(); err != nil {
+		db.Close()
 		return err
 	}
 
 	return nil
 }
 
+// ConnectWithRetry calls InitDB until the database accepts connections, waiting longer
+// after each failure, and gives up with the last error once timeout has passed
+func ConnectWithRetry(ctx context.Context, dataSourceName string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	wait := time.Second
+	for {
+		err := InitDB(dataSourceName)
+		if err == nil {
+			return nil
+		}
+		if time.Now().Add(wait).After(deadline) {
+			return fmt.Errorf("database not reachable after %s: %w", timeout, err)
+		}
+		log.Printf("database not reachable, retrying in %s: %v", wait, err)
+
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		wait = min(wait*2, 30*time.Second)
+	}
+}
+
+// Ping checks that the database is reachable
+func Ping(ctx context.Context) error {
+	if db == nil {
+		return errors.New("database not initialized")
+	}
+	return db.PingContext(ctx)
+}
+
+// Close closes the connection pool once the server has stopped serving requests
+func Close() error {
+	if db == nil {
+		return nil
+	}
+	return db.Close()
+}
+
 // RegisterNewAgent stores a new agent in the database
 func RegisterNewAgent(agent *models.Agent) error {
 
@@ -35,10 +175,24 @@ func RegisterNewAgent(agent *models.Agent) error {
 		return err
 	}
 
+	// Agents that predate remote_ids only report their Remotely ID
+	remoteIDs := agent.RemoteIDs
+	if remoteIDs == nil {
+		remoteIDs = map[string]string{}
+		if agent.RemotelyID != "" {
+			remoteIDs["remotely"] = agent.RemotelyID
+		}
+	}
+	agent.RemotelyID = agent.RemoteID("remotely")
+	remoteIDsJSON, err := json.Marshal(remoteIDs)
+	if err != nil {
+		return err
+	}
+
 	// Prepare for SQL Statement
 	stmt, err := db.Prepare(`
-		INSERT INTO agents (hostname, ip_address, os, os_version, hardware_specs, agent_version, last_seen, last_user, remotely_id)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		INSERT INTO agents (hostname, ip_address, os, os_version, hardware_specs, agent_version, last_seen, last_user, remotely_id, remote_ids, site_id, protocol_version)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
 		RETURNING host_id
 	`)
 	if err != nil {
@@ -57,6 +211,9 @@ func RegisterNewAgent(agent *models.Agent) error {
 		time.Now(),
 		agent.LastUser,
 		agent.RemotelyID,
+		remoteIDsJSON,
+		agent.SiteID,
+		agent.ProtocolVersion,
 	).Scan(&agent.ID)
 
 	if err != nil {
@@ -66,9 +223,32 @@ func RegisterNewAgent(agent *models.Agent) error {
 	return nil
 }
 
-// GetAllAgents returns all the agents in the database
-func GetAllAgents() ([]models.Agent, error) {
-	rows, err := db.Query("SELECT * FROM agents")
+// GetAllAgents returns all the agents of an organization, or of every organization if orgID is 0,
+// that match the group, tags and custom field values of the filter
+func GetAllAgents(orgID int, filter models.AgentFilter) ([]models.Agent, error) {
+	where := []string{"($1 = 0 OR o.org_id = $1)"}
+	args := []interface{}{orgID}
+
+	if filter.GroupID != 0 {
+		args = append(args, filter.GroupID)
+		where = append(where, fmt.Sprintf("EXISTS (SELECT 1 FROM device_group_members dgm WHERE dgm.host_id = a.host_id AND dgm.group_id = $%d)", len(args)))
+	}
+	for key, value := range filter.Tags {
+		args = append(args, key)
+		cond := fmt.Sprintf("EXISTS (SELECT 1 FROM agent_tags t WHERE t.host_id = a.host_id AND t.tag_key = $%d", len(args))
+		if value != "" {
+			args = append(args, value)
+			cond += fmt.Sprintf(" AND t.tag_value = $%d", len(args))
+		}
+		where = append(where, cond+")")
+	}
+	for key, value := range filter.CustomFields {
+		args = append(args, key, value)
+		where = append(where, fmt.Sprintf(`EXISTS (SELECT 1 FROM agent_custom_fields v JOIN custom_field_definitions d ON v.field_id = d.field_id
+			WHERE v.host_id = a.host_id AND d.field_key = $%d AND v.field_value = $%d)`, len(args)-1, len(args)))
+	}
+
+	rows, err := db.Query(agentSelect+" WHERE "+strings.Join(where, " AND ")+" ORDER BY a.hostname", args...)
 	if err != nil {
 		return nil, err
 	}
@@ -77,77 +257,140 @@ func GetAllAgents() ([]models.Agent, error) {
 	// Iterate over the rows and add the agents to the slice
 	var agents []models.Agent
 	for rows.Next() {
-		var agent models.Agent
-		var hardwareSpecsRaw sql.RawBytes
-		if err := rows.Scan(&agent.ID, &agent.Hostname, &agent.IPAddress, &agent.OS, &agent.OSVersion, &hardwareSpecsRaw, &agent.AgentVersion, &agent.LastSeen, &agent.LastUser, &agent.RemotelyID); err != nil {
+		agent, err := scanAgent(rows)
+		if err != nil {
 			return nil, err
 		}
-
-		// Unmarshal the hardware specs
-		if len(hardwareSpecsRaw) > 0 {
-			if err := json.Unmarshal([]byte(hardwareSpecsRaw), &agent.HardwareSpecs); err != nil {
-				return nil, err
-			}
-		}
-
-		agents = append(agents, agent)
+		agents = append(agents, *agent)
 	}
 
-	return agents, nil
+	return agents, rows.Err()
 }
 
-// GetAgent returns a single agent from the database
-func GetAgent(id string) (*models.Agent, error) {
-	row := db.QueryRow("SELECT * FROM agents WHERE host_id = $1", id)
+// GetAgent returns a single agent from the database, limited to orgID unless it is 0
+func GetAgent(id string, orgID int) (*models.Agent, error) {
+	row := db.QueryRow(agentSelect+" WHERE a.host_id = $1 AND ($2 = 0 OR o.org_id = $2)", id, orgID)
 
-	// Scan the row into an Agent struct
-	var agent models.Agent
-	var hardwareSpecsRaw []byte
-	if err := row.Scan(&agent.ID, &agent.Hostname, &agent.IPAddress, &agent.OS, &agent.OSVersion, &hardwareSpecsRaw, &agent.AgentVersion, &agent.LastSeen, &agent.LastUser, &agent.RemotelyID); err != nil {
+	agent, err := scanAgent(row)
+	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil
 		}
 		return nil, err
 	}
 
-	// Unmarshal the hardware specs
-	if len(hardwareSpecsRaw) > 0 {
-		if err := json.Unmarshal([]byte(hardwareSpecsRaw), &agent.HardwareSpecs); err != nil {
+	return agent, nil
+}
+
+// UpdateAgent stores the inventory reported by an agent. Fields missing from the update keep
+// their stored value. Tags and custom fields are managed by admins and are never touched here.
+// It returns the change of the agent's status if it was offline.
+func UpdateAgent(id string, update models.InventoryUpdate, protocolVersion int) (*StatusChange, error) {
+	now := time.Now()
+	set := []string{"last_seen = $1", "protocol_version = $2"}
+	args := []interface{}{now, protocolVersion}
+	column := func(name string, value interface{}) {
+		args = append(args, value)
+		set = append(set, fmt.Sprintf("%s = $%d", name, len(args)))
+	}
+
+	if update.Hostname != nil {
+		column("hostname", *update.Hostname)
+	}
+	if update.IPAddress != nil {
+		column("ip_address", *update.IPAddress)
+	}
+	if update.OS != nil {
+		column("os", *update.OS)
+	}
+	if update.OSVersion != nil {
+		column("os_version", *update.OSVersion)
+	}
+	if update.HardwareSpecs != nil {
+		hardwareSpecsJSON, err := json.Marshal(update.HardwareSpecs)
+		if err != nil {
+			return nil, err
+		}
+		column("hardware_specs", hardwareSpecsJSON)
+	}
+	if update.AgentVersion != nil {
+		column("agent_version", *update.AgentVersion)
+	}
+	if update.LastUser != nil {
+		column("last_user", *update.LastUser)
+	}
+	if update.RemotelyID != nil {
+		column("remotely_id", *update.RemotelyID)
+	}
+	// Agents that predate remote_ids only report their Remotely ID, and remotely_id is kept
+	// in step with remote_ids for the clients that still read it
+	switch {
+	case update.RemoteIDs != nil:
+		remoteIDsJSON, err := json.Marshal(update.RemoteIDs)
+		if err != nil {
 			return nil, err
 		}
+		column("remote_ids", remoteIDsJSON)
+		if update.RemotelyID == nil {
+			column("remotely_id", update.RemoteIDs["remotely"])
+		}
+	case update.RemotelyID != nil:
+		// The column is qualified, as checkIn also selects the old row of the agent
+		args = append(args, *update.RemotelyID)
+		set = append(set, fmt.Sprintf(
+			"remote_ids = CASE WHEN $%[1]d::text = '' THEN agents.remote_ids - 'remotely' ELSE agents.remote_ids || jsonb_build_object('remotely', $%[1]d::text) END", len(args)))
 	}
 
-	return &agent, nil
-}
+	change, err := checkIn("UPDATE agents SET "+strings.Join(set, ", "), args, id, now)
+	if err != nil {
+		return nil, err
+	}
 
-// UpdateAgent updates an agent in the database
-func UpdateAgent(id string, agent *models.Agent) error {
-	_, err := db.Exec("UPDATE agents SET hostname = $1, ip_address = $2, os = $3, os_version = $4, agent_version = $5, last_seen = $6, last_user = $7, remotely_id = $8 WHERE host_id = $9",
-		agent.Hostname, agent.IPAddress, agent.OS, agent.OSVersion, agent.AgentVersion, time.Now(), agent.LastUser, agent.RemotelyID, id)
-	return err
+	if update.LastUser != nil && *update.LastUser != "" {
+		if err := recordUser(id, *update.LastUser, now); err != nil {
+			return nil, err
+		}
+	}
+	return change, recordHeartbeat(id, now)
 }
 
-// DeleteAgent deletes an agent from the database
-func DeleteAgent(id string) error {
-	_, err := db.Exec("DELETE FROM agents WHERE host_id = $1", id)
-	return err
+// DeleteAgent deletes an agent from the database, limited to orgID unless it is 0
+func DeleteAgent(id string, orgID int) error {
+	result, err := db.Exec(`
+		DELETE FROM agents
+		WHERE host_id = $1 AND site_id IN (SELECT site_id FROM sites WHERE $2 = 0 OR org_id = $2)`, id, orgID)
+	if err != nil {
+		return err
+	}
+	return checkAffected(result)
 }
 
-// AgentHeartbeat updates the last_seen field of an agent
-func AgentHeartbeat(id string) error {
-	_, err := db.Exec("UPDATE agents SET last_seen = $1 WHERE host_id = $2", time.Now(), id)
-	return err
+// AgentHeartbeat updates the last_seen field of an agent and records the heartbeat.
+// It returns the change of the agent's status if it was offline.
+func AgentHeartbeat(id string, protocolVersion int) (*StatusChange, error) {
+	now := time.Now()
+	change, err := checkIn("UPDATE agents SET last_seen = $1, protocol_version = $2", []interface{}{now, protocolVersion}, id, now)
+	if err != nil {
+		return nil, err
+	}
+	return change, recordHeartbeat(id, now)
 }
 
-// CreateGroup creates a new group in the database
-func CreateGroup(groupName string) error {
-	_, err := db.Exec("INSERT INTO device_groups (group_name) VALUES ($1)", groupName)
-	return err
+// CreateGroup creates a new group in a site
+func CreateGroup(groupName string, siteID int) (int32, error) {
+	var groupID int32
+	err := db.QueryRow("INSERT INTO device_groups (group_name, site_id) VALUES ($1, $2) RETURNING group_id", groupName, siteID).Scan(&groupID)
+	return groupID, translateError(err)
 }
 
-// GetAllGroups returns all the groups in the database
-func GetAllGroups() ([]models.Group, error) {
-	rows, err := db.Query("SELECT group_id, group_name FROM device_groups")
+// GetAllGroups returns all the groups of an organization, or of every organization if orgID is 0
+func GetAllGroups(orgID int) ([]models.Group, error) {
+	rows, err := db.Query(`
+		SELECT g.group_id, g.group_name, g.site_id
+		FROM device_groups g
+		JOIN sites s ON g.site_id = s.site_id
+		WHERE $1 = 0 OR s.org_id = $1
+		ORDER BY g.group_name`, orgID)
 	if err != nil {
 		return nil, err
 	}
@@ -156,45 +399,94 @@ func GetAllGroups() ([]models.Group, error) {
 	var groups []models.Group
 	for rows.Next() {
 		var group models.Group
-		if err := rows.Scan(&group.GroupID, &group.GroupName); err != nil {
+		if err := rows.Scan(&group.GroupID, &group.GroupName, &group.SiteID); err != nil {
 			return nil, err
 		}
 		groups = append(groups, group)
 	}
 
-	return groups, nil
+	return groups, rows.Err()
 }
 
-// GetGroup returns a single group from the database
-func GetGroup(id string) (string, error) {
-	row := db.QueryRow("SELECT group_name FROM device_groups WHERE group_id = $1", id)
+// GetGroup returns a single group from the database, limited to orgID unless it is 0
+func GetGroup(id string, orgID int) (*models.Group, error) {
+	row := db.QueryRow(`
+		SELECT g.group_id, g.group_name, g.site_id
+		FROM device_groups g
+		JOIN sites s ON g.site_id = s.site_id
+		WHERE g.group_id = $1 AND ($2 = 0 OR s.org_id = $2)`, id, orgID)
 
-	var group string
-	if err := row.Scan(&group); err != nil {
+	var group models.Group
+	if err := row.Scan(&group.GroupID, &group.GroupName, &group.SiteID); err != nil {
 		if err == sql.ErrNoRows {
-			return "", nil
+			return nil, nil
 		}
-		return "", err
+		return nil, err
 	}
 
-	return group, nil
+	return &group, nil
 }
 
-// UpdateGroup updates a group in the database
-func UpdateGroup(id string, groupName string) error {
-	_, err := db.Exec("UPDATE device_groups SET group_name = $1 WHERE group_id = $2", groupName, id)
-	return err
+// UpdateGroup updates a group in the database, limited to orgID unless it is 0
+func UpdateGroup(id string, groupName string, orgID int) error {
+	result, err := db.Exec(`
+		UPDATE device_groups SET group_name = $1
+		WHERE group_id = $2 AND site_id IN (SELECT site_id FROM sites WHERE $3 = 0 OR org_id = $3)`, groupName, id, orgID)
+	if err != nil {
+		return translateError(err)
+	}
+	return checkAffected(result)
 }
 
-// DeleteGroup deletes a group from the database
-func DeleteGroup(id string) error {
-	_, err := db.Exec("DELETE FROM device_groups WHERE group_id = $1", id)
-	return err
+// DeleteGroup deletes a group from the database, limited to orgID unless it is 0.
+// A group that still has members is only deleted, along with its memberships, if force is set.
+func DeleteGroup(id string, orgID int, force bool) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var members int
+	err = tx.QueryRow(`
+		SELECT (SELECT COUNT(*) FROM device_group_members WHERE group_id = g.group_id)
+		FROM device_groups g
+		JOIN sites s ON g.site_id = s.site_id
+		WHERE g.group_id = $1 AND ($2 = 0 OR s.org_id = $2)
+		FOR UPDATE OF g`, id, orgID).Scan(&members)
+	if err == sql.ErrNoRows {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	if members > 0 {
+		if !force {
+			return ErrGroupNotEmpty
+		}
+		if _, err := tx.Exec("DELETE FROM device_group_members WHERE group_id = $1", id); err != nil {
+			return err
+		}
+	}
+
+	// A member added concurrently is caught by the foreign key
+	if _, err := tx.Exec("DELETE FROM device_groups WHERE group_id = $1", id); err != nil {
+		if isViolation(err, "23503") {
+			return ErrGroupNotEmpty
+		}
+		return err
+	}
+
+	return tx.Commit()
 }
 
-// GetHostsInGroup returns all the hosts in a group
-func GetHostsInGroup(groupID int) ([]models.Agent, error) {
-	rows, err := db.Query("SELECT a.* FROM agents a JOIN device_group_members dgm ON a.host_id = dgm.host_id WHERE dgm.group_id = $1", groupID)
+// GetHostsInGroup returns all the hosts in a group, limited to orgID unless it is 0
+func GetHostsInGroup(groupID int, orgID int) ([]models.Agent, error) {
+	rows, err := db.Query(agentSelect+`
+		JOIN device_group_members dgm ON a.host_id = dgm.host_id
+		WHERE dgm.group_id = $1 AND ($2 = 0 OR o.org_id = $2)
+		ORDER BY a.hostname`, groupID, orgID)
 	if err != nil {
 		return nil, err
 	}
@@ -202,34 +494,99 @@ func GetHostsInGroup(groupID int) ([]models.Agent, error) {
 
 	var agents []models.Agent
 	for rows.Next() {
-		var agent models.Agent
-		var hardwareSpecsRaw sql.RawBytes
-		if err := rows.Scan(&agent.ID, &agent.Hostname, &agent.IPAddress, &agent.OS, &agent.OSVersion, &hardwareSpecsRaw, &agent.AgentVersion, &agent.LastSeen, &agent.LastUser, &agent.RemotelyID); err != nil {
-			if err == sql.ErrNoRows {
-				return nil, nil
-			}
+		agent, err := scanAgent(rows)
+		if err != nil {
 			return nil, err
 		}
-		agents = append(agents, agent)
+		agents = append(agents, *agent)
 	}
 
-	return agents, nil
+	return agents, rows.Err()
 }
 
-// AddhostToGroup adds a host to a group
-func AddHostToGroup(hostID, groupID int) error {
-	_, err := db.Exec("INSERT INTO device_group_members (host_id, group_id) VALUES ($1, $2)", hostID, groupID)
-	return err
+// AddhostToGroup adds a host to a group of the same organization, limited to orgID unless it is 0
+func AddHostToGroup(hostID, groupID, orgID int) error {
+	return addHostToGroup(db, hostID, groupID, orgID)
 }
 
-// RemoveHostFromGroup removes a host from a group
-func RemoveHostFromGroup(hostID, groupID int) error {
-	_, err := db.Exec("DELETE FROM device_group_members WHERE host_id = $1 AND group_id = $2", hostID, groupID)
-	return err
+func addHostToGroup(ex execer, hostID, groupID, orgID int) error {
+	result, err := ex.Exec(`
+		INSERT INTO device_group_members (host_id, group_id)
+		SELECT a.host_id, g.group_id
+		FROM agents a
+		JOIN sites hs ON a.site_id = hs.site_id
+		JOIN device_groups g ON g.group_id = $2
+		JOIN sites gs ON g.site_id = gs.site_id
+		WHERE a.host_id = $1 AND hs.org_id = gs.org_id AND ($3 = 0 OR hs.org_id = $3)`, hostID, groupID, orgID)
+	if err != nil {
+		return translateError(err)
+	}
+	return checkAffected(result)
 }
 
-// MoveHostToGroup moves a host from one group to another
-func MoveHostToGroup(hostID, newGroupID int) error {
-	_, err := db.Exec("UPDATE device_group_members SET group_id = $1 WHERE host_id = $2", newGroupID, hostID)
-	return err
+// RemoveHostFromGroup removes a host from a group, limited to orgID unless it is 0
+func RemoveHostFromGroup(hostID, groupID, orgID int) error {
+	return removeHostFromGroup(db, hostID, groupID, orgID)
+}
+
+func removeHostFromGroup(ex execer, hostID, groupID, orgID int) error {
+	result, err := ex.Exec(`
+		DELETE FROM device_group_members
+		WHERE host_id = $1 AND group_id = $2
+		AND group_id IN (SELECT group_id FROM device_groups g JOIN sites s ON g.site_id = s.site_id WHERE $3 = 0 OR s.org_id = $3)`, hostID, groupID, orgID)
+	if err != nil {
+		return err
+	}
+	return checkAffected(result)
+}
+
+// MoveHostToGroup moves a host from one group to another in a single transaction,
+// limited to orgID unless it is 0. The host must be a member of the source group
+// and must not already be a member of the destination group.
+func MoveHostToGroup(hostID, fromGroupID, toGroupID, orgID int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := moveHostToGroup(tx, hostID, fromGroupID, toGroupID, orgID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func moveHostToGroup(tx *sql.Tx, hostID, fromGroupID, toGroupID, orgID int) error {
+	if err := removeHostFromGroup(tx, hostID, fromGroupID, orgID); err != nil {
+		return err
+	}
+	return addHostToGroup(tx, hostID, toGroupID, orgID)
+}
+
+// GetProtocolVersions counts the agents speaking each agent protocol version,
+// limited to orgID unless it is 0
+func GetProtocolVersions(orgID int) ([]models.ProtocolUsage, error) {
+	rows, err := db.Query(`
+		SELECT a.protocol_version, COUNT(*), MIN(a.last_seen)
+		FROM agents a
+		JOIN sites s ON a.site_id = s.site_id
+		WHERE $1 = 0 OR s.org_id = $1
+		GROUP BY a.protocol_version
+		ORDER BY a.protocol_version`, orgID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var usage []models.ProtocolUsage
+	for rows.Next() {
+		var u models.ProtocolUsage
+		if err := rows.Scan(&u.ProtocolVersion, &u.Hosts, &u.OldestLastSeen); err != nil {
+			return nil, err
+		}
+		usage = append(usage, u)
+	}
+
+	return usage, rows.Err()
 }
@@ -0,0 +1,138 @@
+package database
+
+import (
+	"fmt"
+	"slate-rmm/models"
+	"strings"
+	"time"
+)
+
+// EventLogRetention is how long the event log entries shipped by the agents are kept
+const EventLogRetention = 30 * 24 * time.Hour
+
+// RecordEventLogEntries stores the event log entries shipped by the agent of a host and
+// returns how many were new. Entries the agent already shipped, replayed after a lost
+// response, are skipped.
+func RecordEventLogEntries(hostID int, entries []models.EventLogEntry) (int64, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if err := hostInScope(tx, hostID, 0); err != nil {
+		return 0, err
+	}
+
+	stmt, err := tx.Prepare(`
+		INSERT INTO event_log_entries (host_id, source, record_key, provider, event_code, level, message, logged_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (host_id, source, record_key, logged_at) DO NOTHING`)
+	if err != nil {
+		return 0, err
+	}
+	defer stmt.Close()
+
+	var inserted int64
+	for _, entry := range entries {
+		result, err := stmt.Exec(hostID, entry.Source, entry.RecordKey, entry.Provider, entry.EventCode, entry.Level, entry.Message, entry.LoggedAt)
+		if err != nil {
+			return 0, err
+		}
+		n, err := result.RowsAffected()
+		if err != nil {
+			return 0, err
+		}
+		inserted += n
+	}
+
+	return inserted, tx.Commit()
+}
+
+// GetEventLogEntries returns the event log entries of a host selected by the filter, newest
+// first, limited to orgID unless it is 0
+func GetEventLogEntries(hostID int, filter models.EventLogFilter, orgID int) ([]models.EventLogEntry, error) {
+	if err := hostInScope(db, hostID, orgID); err != nil {
+		return nil, err
+	}
+
+	where := []string{"host_id = $1"}
+	args := []interface{}{hostID}
+	if filter.Source != "" {
+		args = append(args, filter.Source)
+		where = append(where, fmt.Sprintf("source = $%d", len(args)))
+	}
+	if filter.Level != "" {
+		args = append(args, filter.Level)
+		where = append(where, fmt.Sprintf("level = $%d", len(args)))
+	}
+	if filter.Query != "" {
+		args = append(args, strings.ToLower(filter.Query))
+		where = append(where, fmt.Sprintf("(strpos(lower(message), $%[1]d) > 0 OR strpos(lower(provider), $%[1]d) > 0)", len(args)))
+	}
+	if !filter.Since.IsZero() {
+		args = append(args, filter.Since)
+		where = append(where, fmt.Sprintf("logged_at >= $%d", len(args)))
+	}
+	if !filter.Until.IsZero() {
+		args = append(args, filter.Until)
+		where = append(where, fmt.Sprintf("logged_at < $%d", len(args)))
+	}
+	args = append(args, filter.Limit)
+
+	rows, err := db.Query(`
+		SELECT entry_id, source, record_key, provider, event_code, level, message, logged_at
+		FROM event_log_entries
+		WHERE `+strings.Join(where, " AND ")+fmt.Sprintf(`
+		ORDER BY logged_at DESC, entry_id DESC
+		LIMIT $%d`, len(args)), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []models.EventLogEntry{}
+	for rows.Next() {
+		var entry models.EventLogEntry
+		err := rows.Scan(&entry.EntryID, &entry.Source, &entry.RecordKey, &entry.Provider, &entry.EventCode, &entry.Level, &entry.Message, &entry.LoggedAt)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+// GetEventLogSources returns the sources a host shipped event log entries from, limited to
+// orgID unless it is 0
+func GetEventLogSources(hostID int, orgID int) ([]string, error) {
+	if err := hostInScope(db, hostID, orgID); err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query("SELECT DISTINCT source FROM event_log_entries WHERE host_id = $1 ORDER BY source", hostID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sources := []string{}
+	for rows.Next() {
+		var source string
+		if err := rows.Scan(&source); err != nil {
+			return nil, err
+		}
+		sources = append(sources, source)
+	}
+	return sources, rows.Err()
+}
+
+// PruneEventLogEntries deletes the event log entries logged before a time and returns how
+// many were deleted
+func PruneEventLogEntries(before time.Time) (int64, error) {
+	result, err := db.Exec("DELETE FROM event_log_entries WHERE logged_at < $1", before)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,43 @@
+package database
+
+import (
+	"slate-rmm/models"
+)
+
+// RecordFileOperation records a listing or a transfer made during a file session
+func RecordFileOperation(op *models.FileOperation) error {
+	return db.QueryRow(`
+		INSERT INTO file_operations (session_id, operation, path, bytes, outcome, error)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING operation_id, performed_at`,
+		op.SessionID, op.Operation, op.Path, op.Bytes, op.Outcome, op.Error).Scan(&op.OperationID, &op.At)
+}
+
+// GetFileOperations returns the operations of a file session of a host in order, limited
+// to orgID unless it is 0. It returns ErrNotFound if the host has no such session.
+func GetFileOperations(sessionID int, hostID int, orgID int) ([]models.FileOperation, error) {
+	if err := checkRemoteSession(sessionID, hostID, orgID, FilesProvider); err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(`
+		SELECT operation_id, session_id, operation, path, bytes, outcome, error, performed_at
+		FROM file_operations
+		WHERE session_id = $1
+		ORDER BY operation_id`, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	operations := []models.FileOperation{}
+	for rows.Next() {
+		var op models.FileOperation
+		if err := rows.Scan(&op.OperationID, &op.SessionID, &op.Operation, &op.Path, &op.Bytes, &op.Outcome, &op.Error, &op.At); err != nil {
+			return nil, err
+		}
+		operations = append(operations, op)
+	}
+
+	return operations, rows.Err()
+}
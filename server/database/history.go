@@ -0,0 +1,167 @@
+package database
+
+import (
+	"database/sql"
+	"slate-rmm/models"
+	"time"
+)
+
+// HeartbeatRetention is how long heartbeats are kept for the status history of agents
+const HeartbeatRetention = 7 * 24 * time.Hour
+
+// recordHeartbeat records that an agent checked in
+func recordHeartbeat(id string, seenAt time.Time) error {
+	_, err := db.Exec("INSERT INTO agent_heartbeats (host_id, seen_at) VALUES ($1, $2)", id, seenAt)
+	return err
+}
+
+// recordUser records the user logged in on an agent if it differs from the last one recorded
+func recordUser(id string, username string, seenAt time.Time) error {
+	_, err := db.Exec(`
+		INSERT INTO agent_user_history (host_id, username, seen_at)
+		SELECT $1, $2, $3
+		WHERE $2 IS DISTINCT FROM (
+			SELECT username FROM agent_user_history WHERE host_id = $1 ORDER BY seen_at DESC LIMIT 1)`, id, username, seenAt)
+	return err
+}
+
+// PruneHeartbeats deletes the heartbeats recorded before a time and returns how many were deleted
+func PruneHeartbeats(before time.Time) (int64, error) {
+	result, err := db.Exec("DELETE FROM agent_heartbeats WHERE seen_at < $1", before)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// GetRecentHeartbeats returns the most recent heartbeats of an agent, newest first
+func GetRecentHeartbeats(hostID int, limit int) ([]time.Time, error) {
+	rows, err := db.Query("SELECT seen_at FROM agent_heartbeats WHERE host_id = $1 ORDER BY seen_at DESC LIMIT $2", hostID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var heartbeats []time.Time
+	for rows.Next() {
+		var seenAt time.Time
+		if err := rows.Scan(&seenAt); err != nil {
+			return nil, err
+		}
+		heartbeats = append(heartbeats, seenAt)
+	}
+
+	return heartbeats, rows.Err()
+}
+
+// GetStatusHistory returns the periods an agent was online or offline since a time, newest
+// first. An agent is offline between two heartbeats further apart than OnlineThreshold.
+func GetStatusHistory(hostID int, since time.Time) ([]models.StatusPeriod, error) {
+	rows, err := db.Query("SELECT seen_at FROM agent_heartbeats WHERE host_id = $1 AND seen_at >= $2 ORDER BY seen_at", hostID, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var periods []models.StatusPeriod
+	var online *models.StatusPeriod
+	for rows.Next() {
+		var seenAt time.Time
+		if err := rows.Scan(&seenAt); err != nil {
+			return nil, err
+		}
+
+		switch {
+		case online == nil:
+			online = &models.StatusPeriod{Status: "online", From: seenAt, To: seenAt}
+		case seenAt.Sub(online.To) > OnlineThreshold:
+			periods = append(periods, *online,
+				models.StatusPeriod{Status: "offline", From: online.To, To: seenAt})
+			online = &models.StatusPeriod{Status: "online", From: seenAt, To: seenAt}
+		default:
+			online.To = seenAt
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// The last period runs until now
+	if online != nil {
+		now := time.Now()
+		if now.Sub(online.To) > OnlineThreshold {
+			periods = append(periods, *online, models.StatusPeriod{Status: "offline", From: online.To, To: now})
+		} else {
+			online.To = now
+			periods = append(periods, *online)
+		}
+	}
+
+	for i, j := 0, len(periods)-1; i < j; i, j = i+1, j-1 {
+		periods[i], periods[j] = periods[j], periods[i]
+	}
+	return periods, nil
+}
+
+// GetUserHistory returns the users most recently reported logged in on an agent, newest first
+func GetUserHistory(hostID int, limit int) ([]models.UserSession, error) {
+	rows, err := db.Query("SELECT username, seen_at FROM agent_user_history WHERE host_id = $1 ORDER BY seen_at DESC LIMIT $2", hostID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []models.UserSession
+	for rows.Next() {
+		var session models.UserSession
+		if err := rows.Scan(&session.Username, &session.Since); err != nil {
+			return nil, err
+		}
+		// A session ends when the next user is reported
+		if len(sessions) > 0 {
+			until := sessions[len(sessions)-1].Since
+			session.Until = &until
+		}
+		sessions = append(sessions, session)
+	}
+
+	return sessions, rows.Err()
+}
+
+// GetAgentGroups returns the groups an agent is a member of
+func GetAgentGroups(hostID int) ([]models.Group, error) {
+	rows, err := db.Query(`
+		SELECT g.group_id, g.group_name, g.site_id
+		FROM device_groups g
+		JOIN device_group_members m ON m.group_id = g.group_id
+		WHERE m.host_id = $1
+		ORDER BY g.group_name`, hostID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var groups []models.Group
+	for rows.Next() {
+		var group models.Group
+		if err := rows.Scan(&group.GroupID, &group.GroupName, &group.SiteID); err != nil {
+			return nil, err
+		}
+		groups = append(groups, group)
+	}
+
+	return groups, rows.Err()
+}
+
+// RenameAgent sets the name shown for an agent instead of its hostname, limited to orgID
+// unless it is 0. An empty name shows the hostname again.
+func RenameAgent(hostID int, displayName string, orgID int) error {
+	name := sql.NullString{String: displayName, Valid: displayName != ""}
+	result, err := db.Exec(`
+		UPDATE agents SET display_name = $2
+		WHERE host_id = $1 AND site_id IN (SELECT site_id FROM sites WHERE $3 = 0 OR org_id = $3)`, hostID, name, orgID)
+	if err != nil {
+		return err
+	}
+	return checkAffected(result)
+}
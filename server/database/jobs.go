@@ -0,0 +1,205 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"slate-rmm/models"
+)
+
+// Job types understood by the agent
+const (
+	JobRunScript        = "run_script"
+	JobRefreshInventory = "refresh_inventory"
+	JobFetchLogs        = "fetch_logs"
+	JobOpenShell        = "open_shell"
+	JobOpenFiles        = "open_files"
+	JobListServices     = "list_services"
+	JobControlService   = "control_service"
+	JobListProcesses    = "list_processes"
+	JobTerminateProcess = "terminate_process"
+)
+
+// Job statuses
+const (
+	JobPending    = "pending"
+	JobDispatched = "dispatched"
+	JobCompleted  = "completed"
+	JobFailed     = "failed"
+)
+
+// queryer is implemented by both *sql.DB and *sql.Tx
+type queryer interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// CreateScript stores a new saved script
+func CreateScript(script *models.Script) error {
+	return db.QueryRow("INSERT INTO scripts (script_name, shell, content) VALUES ($1, $2, $3) RETURNING script_id",
+		script.ScriptName, script.Shell, script.Content).Scan(&script.ScriptID)
+}
+
+// GetAllScripts returns all the saved scripts
+func GetAllScripts() ([]models.Script, error) {
+	rows, err := db.Query("SELECT script_id, script_name, shell, content FROM scripts ORDER BY script_name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var scripts []models.Script
+	for rows.Next() {
+		var script models.Script
+		if err := rows.Scan(&script.ScriptID, &script.ScriptName, &script.Shell, &script.Content); err != nil {
+			return nil, err
+		}
+		scripts = append(scripts, script)
+	}
+
+	return scripts, rows.Err()
+}
+
+// GetScript returns a single saved script
+func GetScript(id int) (*models.Script, error) {
+	var script models.Script
+	err := db.QueryRow("SELECT script_id, script_name, shell, content FROM scripts WHERE script_id = $1", id).
+		Scan(&script.ScriptID, &script.ScriptName, &script.Shell, &script.Content)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &script, nil
+}
+
+// DeleteScript deletes a saved script
+func DeleteScript(id int) error {
+	result, err := db.Exec("DELETE FROM scripts WHERE script_id = $1", id)
+	if err != nil {
+		return err
+	}
+	return checkAffected(result)
+}
+
+// CreateJob queues a job for a host, limited to orgID unless it is 0
+func CreateJob(hostID int, jobType string, payload interface{}, orgID int) (int32, error) {
+	return createJob(db, hostID, jobType, payload, orgID)
+}
+
+// CreateJobWithRefresh queues a job for a host followed by a job of refreshType, such as a
+// control_service job followed by list_services, so that the agent reports what the first
+// job changed right after running it. It returns the IDs of both jobs.
+func CreateJobWithRefresh(hostID int, jobType string, payload interface{}, refreshType string, orgID int) (int32, int32, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback()
+
+	jobID, err := createJob(tx, hostID, jobType, payload, orgID)
+	if err != nil {
+		return 0, 0, err
+	}
+	refreshID, err := createJob(tx, hostID, refreshType, struct{}{}, orgID)
+	if err != nil {
+		return 0, 0, err
+	}
+	return jobID, refreshID, tx.Commit()
+}
+
+func createJob(q queryer, hostID int, jobType string, payload interface{}, orgID int) (int32, error) {
+	payloadJSON, err := json.Marshal(payload)
+	if err != nil {
+		return 0, err
+	}
+
+	var jobID int32
+	err = q.QueryRow(`
+		INSERT INTO agent_jobs (host_id, job_type, payload)
+		SELECT a.host_id, $2, $3
+		FROM agents a
+		JOIN sites s ON a.site_id = s.site_id
+		WHERE a.host_id = $1 AND ($4 = 0 OR s.org_id = $4)
+		RETURNING job_id`, hostID, jobType, payloadJSON, orgID).Scan(&jobID)
+	if err == sql.ErrNoRows {
+		return 0, ErrNotFound
+	}
+	return jobID, err
+}
+
+// scanJob scans an agent_jobs row into a Job struct
+func scanJob(row rowScanner) (*models.Job, error) {
+	var job models.Job
+	var payload []byte
+	var exitCode sql.NullInt64
+	var output sql.NullString
+	if err := row.Scan(&job.JobID, &job.HostID, &job.JobType, &payload, &job.Status, &exitCode, &output, &job.CreatedAt, &job.UpdatedAt); err != nil {
+		return nil, err
+	}
+	job.Payload = payload
+	if exitCode.Valid {
+		code := int(exitCode.Int64)
+		job.ExitCode = &code
+	}
+	job.Output = output.String
+	return &job, nil
+}
+
+const jobColumns = "job_id, host_id, job_type, payload, status, exit_code, output, created_at, updated_at"
+
+// GetJob returns a single job, limited to orgID unless it is 0
+func GetJob(id int, orgID int) (*models.Job, error) {
+	row := db.QueryRow(`
+		SELECT j.job_id, j.host_id, j.job_type, j.payload, j.status, j.exit_code, j.output, j.created_at, j.updated_at
+		FROM agent_jobs j
+		JOIN agents a ON j.host_id = a.host_id
+		JOIN sites s ON a.site_id = s.site_id
+		WHERE j.job_id = $1 AND ($2 = 0 OR s.org_id = $2)`, id, orgID)
+
+	job, err := scanJob(row)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return job, nil
+}
+
+// DispatchPendingJobs returns the pending jobs of a host and marks them as dispatched
+func DispatchPendingJobs(hostID string) ([]models.Job, error) {
+	// Jobs run in the order they were queued, which a job followed by its refresh relies on
+	rows, err := db.Query(`
+		WITH dispatched AS (
+			UPDATE agent_jobs SET status = $2, updated_at = CURRENT_TIMESTAMP
+			WHERE host_id = $1 AND status = $3
+			RETURNING `+jobColumns+`
+		)
+		SELECT `+jobColumns+` FROM dispatched ORDER BY job_id`, hostID, JobDispatched, JobPending)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	jobs := []models.Job{}
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, *job)
+	}
+
+	return jobs, rows.Err()
+}
+
+// CompleteJob records the result an agent reported for one of its jobs
+func CompleteJob(hostID string, jobID int, status string, exitCode int, output string) error {
+	result, err := db.Exec(`
+		UPDATE agent_jobs SET status = $1, exit_code = $2, output = $3, updated_at = CURRENT_TIMESTAMP
+		WHERE job_id = $4 AND host_id = $5`, status, exitCode, output, jobID, hostID)
+	if err != nil {
+		return err
+	}
+	return checkAffected(result)
+}
@@ -0,0 +1,81 @@
+package database
+
+import (
+	"log"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+)
+
+// OnlineThreshold is how recently an agent must have been seen to count as connected
+const OnlineThreshold = 5 * time.Minute
+
+// Collectors returns the Prometheus collectors of the database: connection pool statistics
+// and the connection state of the agents. InitDB must have been called.
+func Collectors() []prometheus.Collector {
+	return []prometheus.Collector{collectors.NewDBStatsCollector(db, "slate"), agentCollector{}}
+}
+
+var (
+	agentsConnectedDesc = prometheus.NewDesc("slate_agents_connected",
+		"Agents seen within the online threshold.", nil, nil)
+	agentsTotalDesc = prometheus.NewDesc("slate_agents_total",
+		"Registered agents.", nil, nil)
+	heartbeatLagDesc = prometheus.NewDesc("slate_agent_heartbeat_lag_seconds",
+		"Time since each registered agent was last seen.", nil, nil)
+)
+
+// heartbeatLagBuckets spans a healthy heartbeat interval up to agents gone for a week
+var heartbeatLagBuckets = []float64{30, 60, 120, 300, 900, 3600, 6 * 3600, 24 * 3600, 7 * 24 * 3600}
+
+// agentCollector reports the connection state of the agents, queried on each scrape
+type agentCollector struct{}
+
+func (agentCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- agentsConnectedDesc
+	ch <- agentsTotalDesc
+	ch <- heartbeatLagDesc
+}
+
+func (agentCollector) Collect(ch chan<- prometheus.Metric) {
+	rows, err := db.Query("SELECT EXTRACT(EPOCH FROM (CURRENT_TIMESTAMP - last_seen)) FROM agents")
+	if err != nil {
+		log.Printf("could not collect agent metrics: %v", err)
+		return
+	}
+	defer rows.Close()
+
+	var total, connected, count uint64
+	var sum float64
+	buckets := make(map[float64]uint64, len(heartbeatLagBuckets))
+	for rows.Next() {
+		var lag *float64
+		if err := rows.Scan(&lag); err != nil {
+			log.Printf("could not collect agent metrics: %v", err)
+			return
+		}
+		total++
+		if lag == nil {
+			continue
+		}
+		if *lag < OnlineThreshold.Seconds() {
+			connected++
+		}
+		count++
+		sum += *lag
+		for _, bound := range heartbeatLagBuckets {
+			if *lag <= bound {
+				buckets[bound]++
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("could not collect agent metrics: %v", err)
+		return
+	}
+
+	ch <- prometheus.MustNewConstMetric(agentsConnectedDesc, prometheus.GaugeValue, float64(connected))
+	ch <- prometheus.MustNewConstMetric(agentsTotalDesc, prometheus.GaugeValue, float64(total))
+	ch <- prometheus.MustNewConstHistogram(heartbeatLagDesc, count, sum, buckets)
+}
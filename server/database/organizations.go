@@ -0,0 +1,296 @@
+package database
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"slate-rmm/models"
+)
+
+// ErrInvalidEnrollmentKey is returned when an agent registers with an unknown enrollment key
+var ErrInvalidEnrollmentKey = errors.New("invalid enrollment key")
+
+// newEnrollmentKey generates a random key used by agents to enroll into an organization
+func newEnrollmentKey() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// scanSettings unmarshals a JSONB settings column
+func scanSettings(raw []byte) (models.Settings, error) {
+	settings := models.Settings{}
+	if len(raw) > 0 {
+		if err := json.Unmarshal(raw, &settings); err != nil {
+			return nil, err
+		}
+	}
+	return settings, nil
+}
+
+// CreateOrganization creates a new organization together with its default site
+func CreateOrganization(org *models.Organization) error {
+	key, err := newEnrollmentKey()
+	if err != nil {
+		return err
+	}
+	org.EnrollmentKey = key
+	if org.Settings == nil {
+		org.Settings = models.Settings{}
+	}
+
+	settingsJSON, err := json.Marshal(org.Settings)
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRow("INSERT INTO organizations (org_name, enrollment_key, settings) VALUES ($1, $2, $3) RETURNING org_id",
+		org.OrgName, org.EnrollmentKey, settingsJSON).Scan(&org.OrgID)
+	if err != nil {
+		return err
+	}
+
+	// Every organization needs at least one site for its hosts to enroll into
+	_, err = tx.Exec("INSERT INTO sites (org_id, site_name) VALUES ($1, 'Default Site')", org.OrgID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetAllOrganizations returns all the organizations in the database
+func GetAllOrganizations() ([]models.Organization, error) {
+	rows, err := db.Query("SELECT org_id, org_name, enrollment_key, settings FROM organizations ORDER BY org_name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var orgs []models.Organization
+	for rows.Next() {
+		var org models.Organization
+		var settingsRaw []byte
+		if err := rows.Scan(&org.OrgID, &org.OrgName, &org.EnrollmentKey, &settingsRaw); err != nil {
+			return nil, err
+		}
+		if org.Settings, err = scanSettings(settingsRaw); err != nil {
+			return nil, err
+		}
+		orgs = append(orgs, org)
+	}
+
+	return orgs, rows.Err()
+}
+
+// GetOrganization returns a single organization from the database
+func GetOrganization(id int) (*models.Organization, error) {
+	row := db.QueryRow("SELECT org_id, org_name, enrollment_key, settings FROM organizations WHERE org_id = $1", id)
+
+	var org models.Organization
+	var settingsRaw []byte
+	if err := row.Scan(&org.OrgID, &org.OrgName, &org.EnrollmentKey, &settingsRaw); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var err error
+	if org.Settings, err = scanSettings(settingsRaw); err != nil {
+		return nil, err
+	}
+
+	return &org, nil
+}
+
+// UpdateOrganization updates the name and settings of an organization
+func UpdateOrganization(id int, orgName string, settings models.Settings) error {
+	if settings == nil {
+		settings = models.Settings{}
+	}
+	settingsJSON, err := json.Marshal(settings)
+	if err != nil {
+		return err
+	}
+
+	result, err := db.Exec("UPDATE organizations SET org_name = $1, settings = $2 WHERE org_id = $3", orgName, settingsJSON, id)
+	if err != nil {
+		return err
+	}
+	return checkAffected(result)
+}
+
+// CreateSite creates a new site in an organization
+func CreateSite(site *models.Site) error {
+	if site.Settings == nil {
+		site.Settings = models.Settings{}
+	}
+	settingsJSON, err := json.Marshal(site.Settings)
+	if err != nil {
+		return err
+	}
+
+	return db.QueryRow("INSERT INTO sites (org_id, site_name, settings) VALUES ($1, $2, $3) RETURNING site_id",
+		site.OrgID, site.SiteName, settingsJSON).Scan(&site.SiteID)
+}
+
+// GetSites returns all the sites of an organization
+func GetSites(orgID int) ([]models.Site, error) {
+	rows, err := db.Query("SELECT site_id, org_id, site_name, settings FROM sites WHERE org_id = $1 ORDER BY site_id", orgID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sites []models.Site
+	for rows.Next() {
+		var site models.Site
+		var settingsRaw []byte
+		if err := rows.Scan(&site.SiteID, &site.OrgID, &site.SiteName, &settingsRaw); err != nil {
+			return nil, err
+		}
+		if site.Settings, err = scanSettings(settingsRaw); err != nil {
+			return nil, err
+		}
+		sites = append(sites, site)
+	}
+
+	return sites, rows.Err()
+}
+
+// GetSite returns a single site with the settings it inherits from its organization,
+// limited to orgID unless it is 0
+func GetSite(id int, orgID int) (*models.Site, error) {
+	row := db.QueryRow(`
+		SELECT s.site_id, s.org_id, s.site_name, s.settings, o.settings
+		FROM sites s
+		JOIN organizations o ON s.org_id = o.org_id
+		WHERE s.site_id = $1 AND ($2 = 0 OR s.org_id = $2)`, id, orgID)
+
+	var site models.Site
+	var siteSettingsRaw, orgSettingsRaw []byte
+	if err := row.Scan(&site.SiteID, &site.OrgID, &site.SiteName, &siteSettingsRaw, &orgSettingsRaw); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var err error
+	if site.Settings, err = scanSettings(siteSettingsRaw); err != nil {
+		return nil, err
+	}
+	orgSettings, err := scanSettings(orgSettingsRaw)
+	if err != nil {
+		return nil, err
+	}
+	site.EffectiveSettings = orgSettings.Inherit(site.Settings)
+
+	return &site, nil
+}
+
+// UpdateSite updates the name and settings of a site, limited to orgID unless it is 0
+func UpdateSite(id int, siteName string, settings models.Settings, orgID int) error {
+	if settings == nil {
+		settings = models.Settings{}
+	}
+	settingsJSON, err := json.Marshal(settings)
+	if err != nil {
+		return err
+	}
+
+	result, err := db.Exec("UPDATE sites SET site_name = $1, settings = $2 WHERE site_id = $3 AND ($4 = 0 OR org_id = $4)",
+		siteName, settingsJSON, id, orgID)
+	if err != nil {
+		return err
+	}
+	return checkAffected(result)
+}
+
+// MoveHostToSite moves a host to another site of the same organization.
+// Memberships in groups of the previous site are dropped.
+func MoveHostToSite(hostID, siteID, orgID int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`
+		UPDATE agents a SET site_id = ns.site_id
+		FROM sites cs, sites ns
+		WHERE a.host_id = $1 AND cs.site_id = a.site_id AND ns.site_id = $2
+		AND cs.org_id = ns.org_id AND ($3 = 0 OR ns.org_id = $3)`, hostID, siteID, orgID)
+	if err != nil {
+		return err
+	}
+	if err := checkAffected(result); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+		DELETE FROM device_group_members
+		WHERE host_id = $1 AND group_id IN (SELECT group_id FROM device_groups WHERE site_id <> $2)`, hostID, siteID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// ResolveEnrollment returns the site a registering agent is enrolled into.
+// Agents without an enrollment key are placed in the default organization.
+// If siteID is set it must belong to the organization of the key.
+func ResolveEnrollment(enrollmentKey string, siteID int32) (int32, error) {
+	var orgID int32
+	var err error
+	if enrollmentKey == "" {
+		err = db.QueryRow("SELECT org_id FROM organizations ORDER BY org_id LIMIT 1").Scan(&orgID)
+	} else {
+		err = db.QueryRow("SELECT org_id FROM organizations WHERE enrollment_key = $1", enrollmentKey).Scan(&orgID)
+	}
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, ErrInvalidEnrollmentKey
+		}
+		return 0, err
+	}
+
+	var resolved int32
+	err = db.QueryRow(`
+		SELECT site_id FROM sites
+		WHERE org_id = $1 AND ($2 = 0 OR site_id = $2)
+		ORDER BY site_id LIMIT 1`, orgID, siteID).Scan(&resolved)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, ErrNotFound
+		}
+		return 0, err
+	}
+
+	return resolved, nil
+}
+
+// DefaultSite returns the first site of an organization, or of the default organization if orgID is 0
+func DefaultSite(orgID int) (int, error) {
+	var siteID int
+	err := db.QueryRow(`
+		SELECT s.site_id FROM sites s
+		WHERE ($1 = 0 AND s.org_id = (SELECT MIN(org_id) FROM organizations)) OR s.org_id = $1
+		ORDER BY s.site_id LIMIT 1`, orgID).Scan(&siteID)
+	if err == sql.ErrNoRows {
+		return 0, ErrNotFound
+	}
+	return siteID, err
+}
@@ -0,0 +1,66 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// StatusChange is an agent coming online or going offline
+type StatusChange struct {
+	HostID int32
+	Online bool
+}
+
+// checkInReturning completes the update of an agent that checked in. It joins the row as it
+// was before the update to tell whether the agent was offline.
+const checkInReturning = `
+	FROM agents old
+	WHERE agents.host_id = $%d AND old.host_id = agents.host_id
+	RETURNING old.last_seen`
+
+// checkIn runs the update of an agent that checked in at now, returning the change of its
+// status if it was offline
+func checkIn(query string, args []interface{}, id string, now time.Time) (*StatusChange, error) {
+	args = append(args, id)
+	var lastSeen sql.NullTime
+	err := db.QueryRow(query+fmt.Sprintf(checkInReturning, len(args)), args...).Scan(&lastSeen)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if lastSeen.Valid && now.Sub(lastSeen.Time) < OnlineThreshold {
+		return nil, nil
+	}
+	hostID, err := strconv.Atoi(id)
+	if err != nil {
+		return nil, err
+	}
+	return &StatusChange{HostID: int32(hostID), Online: true}, nil
+}
+
+// AgentsWentOffline returns the agents whose last check-in became older than OnlineThreshold
+// after from and at or before to
+func AgentsWentOffline(from, to time.Time) ([]StatusChange, error) {
+	rows, err := db.Query("SELECT host_id FROM agents WHERE last_seen > $1 AND last_seen <= $2",
+		from.Add(-OnlineThreshold), to.Add(-OnlineThreshold))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var changes []StatusChange
+	for rows.Next() {
+		var change StatusChange
+		if err := rows.Scan(&change.HostID); err != nil {
+			return nil, err
+		}
+		changes = append(changes, change)
+	}
+
+	return changes, rows.Err()
+}
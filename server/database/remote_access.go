@@ -0,0 +1,111 @@
+package database
+
+import (
+	"database/sql"
+	"slate-rmm/models"
+
+	"github.com/lib/pq"
+)
+
+// GetRemoteAccessPolicies returns every remote access policy
+func GetRemoteAccessPolicies() ([]models.RemoteAccessPolicy, error) {
+	rows, err := db.Query("SELECT policy_id, role, group_id FROM remote_access_policies ORDER BY role, group_id NULLS FIRST")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	policies := []models.RemoteAccessPolicy{}
+	for rows.Next() {
+		var policy models.RemoteAccessPolicy
+		if err := rows.Scan(&policy.PolicyID, &policy.Role, &policy.GroupID); err != nil {
+			return nil, err
+		}
+		policies = append(policies, policy)
+	}
+
+	return policies, rows.Err()
+}
+
+// CreateRemoteAccessPolicy stores a new remote access policy. It returns ErrNotFound if the
+// group does not exist.
+func CreateRemoteAccessPolicy(policy *models.RemoteAccessPolicy) error {
+	err := db.QueryRow("INSERT INTO remote_access_policies (role, group_id) VALUES ($1, $2) RETURNING policy_id",
+		policy.Role, policy.GroupID).Scan(&policy.PolicyID)
+	if isViolation(err, "23503") {
+		return ErrNotFound
+	}
+	return err
+}
+
+// DeleteRemoteAccessPolicy deletes a remote access policy
+func DeleteRemoteAccessPolicy(id int) error {
+	result, err := db.Exec("DELETE FROM remote_access_policies WHERE policy_id = $1", id)
+	if err != nil {
+		return err
+	}
+	return checkAffected(result)
+}
+
+// CanRemoteControl tells whether a policy lets a user with one of roles start remote
+// control of a host
+func CanRemoteControl(hostID int, roles []string) (bool, error) {
+	var allowed bool
+	err := db.QueryRow(`
+		SELECT EXISTS (
+			SELECT 1 FROM remote_access_policies p
+			WHERE (p.role = '*' OR p.role = ANY($2))
+			AND (p.group_id IS NULL OR p.group_id IN (SELECT group_id FROM device_group_members WHERE host_id = $1)))`,
+		hostID, pq.Array(roles)).Scan(&allowed)
+	return allowed, err
+}
+
+// GetUserRole returns the role of a user of the dashboard, or "" if they have no entry
+// in the users table
+func GetUserRole(username string) (string, error) {
+	var role string
+	err := db.QueryRow("SELECT role FROM users WHERE username = $1", username).Scan(&role)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return role, err
+}
+
+// RecordRemoteSession records an attempt to start remote control of a host
+func RecordRemoteSession(session *models.RemoteSession) error {
+	return db.QueryRow(`
+		INSERT INTO remote_sessions (host_id, hostname, username, provider, outcome, error)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING session_id, started_at`,
+		session.HostID, session.Hostname, session.Username, session.Provider, session.Outcome, session.Error).
+		Scan(&session.SessionID, &session.StartedAt)
+}
+
+// GetRemoteSessions returns the most recent attempts to start remote control of a host,
+// newest first, limited to orgID unless it is 0
+func GetRemoteSessions(hostID int, orgID int, limit int) ([]models.RemoteSession, error) {
+	rows, err := db.Query(`
+		SELECT r.session_id, r.host_id, r.hostname, r.username, r.provider, r.outcome, r.error, r.started_at, r.ended_at, r.end_reason
+		FROM remote_sessions r
+		JOIN agents a ON r.host_id = a.host_id
+		JOIN sites s ON a.site_id = s.site_id
+		WHERE r.host_id = $1 AND ($2 = 0 OR s.org_id = $2)
+		ORDER BY r.started_at DESC
+		LIMIT $3`, hostID, orgID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []models.RemoteSession{}
+	for rows.Next() {
+		var session models.RemoteSession
+		if err := rows.Scan(&session.SessionID, &session.HostID, &session.Hostname, &session.Username,
+			&session.Provider, &session.Outcome, &session.Error, &session.StartedAt, &session.EndedAt, &session.EndReason); err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+
+	return sessions, rows.Err()
+}
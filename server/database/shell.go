@@ -0,0 +1,95 @@
+package database
+
+import (
+	"fmt"
+	"slate-rmm/models"
+	"strings"
+	"time"
+)
+
+// Providers of the remote sessions the server relays to the agent itself, which are also
+// the kinds of their agent connections
+const (
+	// ShellProvider is the provider of the remote sessions that are remote shells
+	ShellProvider = "shell"
+	// FilesProvider is the provider of the remote sessions that browse the files of a device
+	FilesProvider = "files"
+)
+
+// EndRemoteSession records why a remote shell or file session ended
+func EndRemoteSession(sessionID int32, reason string) error {
+	result, err := db.Exec(`
+		UPDATE remote_sessions SET ended_at = $2, end_reason = $3
+		WHERE session_id = $1 AND ended_at IS NULL`, sessionID, time.Now(), reason)
+	if err != nil {
+		return err
+	}
+	return checkAffected(result)
+}
+
+// RecordShellChunks appends chunks to the recording of a remote shell session
+func RecordShellChunks(sessionID int32, chunks []models.ShellChunk) error {
+	if len(chunks) == 0 {
+		return nil
+	}
+
+	values := make([]string, 0, len(chunks))
+	args := make([]interface{}, 0, 3*len(chunks)+1)
+	args = append(args, sessionID)
+	for _, chunk := range chunks {
+		values = append(values, fmt.Sprintf("($1, $%d, $%d, $%d)", len(args)+1, len(args)+2, len(args)+3))
+		args = append(args, chunk.At, chunk.Direction, chunk.Data)
+	}
+
+	_, err := db.Exec("INSERT INTO shell_recordings (session_id, recorded_at, direction, data) VALUES "+
+		strings.Join(values, ", "), args...)
+	return err
+}
+
+// GetShellRecording returns the recording of a remote shell session of a host in order,
+// limited to orgID unless it is 0. It returns ErrNotFound if the host has no such session.
+func GetShellRecording(sessionID int, hostID int, orgID int) ([]models.ShellChunk, error) {
+	if err := checkRemoteSession(sessionID, hostID, orgID, ShellProvider); err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(`
+		SELECT recorded_at, direction, data FROM shell_recordings
+		WHERE session_id = $1
+		ORDER BY chunk_id`, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	chunks := []models.ShellChunk{}
+	for rows.Next() {
+		var chunk models.ShellChunk
+		if err := rows.Scan(&chunk.At, &chunk.Direction, &chunk.Data); err != nil {
+			return nil, err
+		}
+		chunks = append(chunks, chunk)
+	}
+
+	return chunks, rows.Err()
+}
+
+// checkRemoteSession returns ErrNotFound unless the host has a remote session of the
+// provider, limited to orgID unless it is 0
+func checkRemoteSession(sessionID int, hostID int, orgID int, provider string) error {
+	var exists bool
+	err := db.QueryRow(`
+		SELECT EXISTS (
+			SELECT 1 FROM remote_sessions r
+			JOIN agents a ON r.host_id = a.host_id
+			JOIN sites s ON a.site_id = s.site_id
+			WHERE r.session_id = $1 AND r.host_id = $2 AND r.provider = $3 AND ($4 = 0 OR s.org_id = $4))`,
+		sessionID, hostID, provider, orgID).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrNotFound
+	}
+	return nil
+}
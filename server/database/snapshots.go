@@ -0,0 +1,85 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"slate-rmm/models"
+	"time"
+)
+
+// Kinds of the snapshots the agents report on request
+const (
+	SnapshotServices  = "services"
+	SnapshotProcesses = "processes"
+)
+
+// SaveSnapshot replaces the snapshot of a kind reported by the agent of a host
+func SaveSnapshot(hostID int, kind string, data interface{}) error {
+	dataJSON, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	result, err := db.Exec(`
+		INSERT INTO agent_snapshots (host_id, kind, collected_at, data)
+		SELECT host_id, $2, $3, $4 FROM agents WHERE host_id = $1
+		ON CONFLICT (host_id, kind) DO UPDATE SET collected_at = EXCLUDED.collected_at, data = EXCLUDED.data`,
+		hostID, kind, time.Now(), dataJSON)
+	if err != nil {
+		return err
+	}
+	return checkAffected(result)
+}
+
+// GetServices returns the services last reported by the agent of a host, limited to orgID
+// unless it is 0
+func GetServices(hostID int, orgID int) (*models.ServiceSnapshot, error) {
+	snapshot := models.ServiceSnapshot{HostID: int32(hostID)}
+	collectedAt, err := getSnapshot(hostID, SnapshotServices, orgID, &snapshot.Services)
+	if err != nil {
+		return nil, err
+	}
+	snapshot.CollectedAt = collectedAt
+	if snapshot.Services == nil {
+		snapshot.Services = []models.Service{}
+	}
+	return &snapshot, nil
+}
+
+// GetProcesses returns the processes last reported by the agent of a host, limited to orgID
+// unless it is 0
+func GetProcesses(hostID int, orgID int) (*models.ProcessSnapshot, error) {
+	snapshot := models.ProcessSnapshot{HostID: int32(hostID)}
+	collectedAt, err := getSnapshot(hostID, SnapshotProcesses, orgID, &snapshot.Processes)
+	if err != nil {
+		return nil, err
+	}
+	snapshot.CollectedAt = collectedAt
+	if snapshot.Processes == nil {
+		snapshot.Processes = []models.Process{}
+	}
+	return &snapshot, nil
+}
+
+// getSnapshot decodes the snapshot of a kind of a host into data and returns when it was
+// collected, or nil if the agent has not reported one. It returns ErrNotFound if the host
+// is not in orgID.
+func getSnapshot(hostID int, kind string, orgID int, data interface{}) (*time.Time, error) {
+	if err := hostInScope(db, hostID, orgID); err != nil {
+		return nil, err
+	}
+
+	var collectedAt time.Time
+	var dataJSON []byte
+	err := db.QueryRow("SELECT collected_at, data FROM agent_snapshots WHERE host_id = $1 AND kind = $2", hostID, kind).Scan(&collectedAt, &dataJSON)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(dataJSON, data); err != nil {
+		return nil, err
+	}
+	return &collectedAt, nil
+}
@@ -0,0 +1,134 @@
+// Package events is the in-process publish/subscribe bus carrying agent, job and alert
+// events from the handlers to the live dashboard.
+package events
+
+import (
+	"slate-rmm/database"
+	"slate-rmm/models"
+	"sync"
+	"time"
+)
+
+// Types of events
+const (
+	AgentRegistered = "agent.registered"
+	AgentOnline     = "agent.online"
+	AgentOffline    = "agent.offline"
+	AgentUpdated    = "agent.updated"
+	AgentDeleted    = "agent.deleted"
+	JobUpdated      = "job.updated"
+	Alert           = "alert"
+	// The agent reported the services or processes of its host, results of its checks or
+	// entries of its event logs
+	ServicesReported  = "agent.services"
+	ProcessesReported = "agent.processes"
+	ChecksReported    = "agent.checks"
+	EventLogsReported = "agent.event_logs"
+)
+
+// subscriberBuffer is how many events a subscriber can fall behind before events are
+// dropped for it, so that a slow dashboard never holds back the handlers publishing
+const subscriberBuffer = 64
+
+// Event is something that happened to an agent, a job or a check
+type Event struct {
+	Type   string
+	HostID int32
+	// OrgID is the client of the host of an alert. Alerts carry what they report, so they
+	// are only streamed to the dashboards of that client or of every client.
+	OrgID int32
+	JobID int32
+	// Status is the status of a job or the severity of an alert
+	Status string
+	// Message describes an alert
+	Message string
+	At      time.Time
+}
+
+// Subscription receives the events published after it was created on C, which is
+// closed when the subscription or the bus is closed
+type Subscription struct {
+	C  <-chan Event
+	ch chan Event
+}
+
+// bus holds the open subscriptions
+var bus struct {
+	sync.Mutex
+	subs   map[*Subscription]struct{}
+	closed bool
+}
+
+// Subscribe opens a subscription. Once the bus is closed it returns a closed subscription.
+func Subscribe() *Subscription {
+	ch := make(chan Event, subscriberBuffer)
+	sub := &Subscription{C: ch, ch: ch}
+
+	bus.Lock()
+	defer bus.Unlock()
+	if bus.closed {
+		close(ch)
+		return sub
+	}
+	if bus.subs == nil {
+		bus.subs = map[*Subscription]struct{}{}
+	}
+	bus.subs[sub] = struct{}{}
+	return sub
+}
+
+// Close stops the subscription
+func (s *Subscription) Close() {
+	bus.Lock()
+	defer bus.Unlock()
+	if _, ok := bus.subs[s]; ok {
+		delete(bus.subs, s)
+		close(s.ch)
+	}
+}
+
+// Publish sends an event to every subscription without blocking. A subscription whose
+// buffer is full misses the event.
+func Publish(event Event) {
+	if event.At.IsZero() {
+		event.At = time.Now()
+	}
+
+	bus.Lock()
+	defer bus.Unlock()
+	for sub := range bus.subs {
+		select {
+		case sub.ch <- event:
+		default:
+		}
+	}
+}
+
+// Close closes every subscription, ending the event streams so the server can shut down
+func Close() {
+	bus.Lock()
+	defer bus.Unlock()
+	bus.closed = true
+	for sub := range bus.subs {
+		close(sub.ch)
+	}
+	bus.subs = nil
+}
+
+// PublishBulk publishes the outcome of a bulk action for each host it succeeded on: the
+// jobs it queued, or that the hosts were deleted or updated
+func PublishBulk(results []models.BulkResult, deleted bool) {
+	for _, result := range results {
+		if !result.Success {
+			continue
+		}
+		event := Event{Type: AgentUpdated, HostID: int32(result.HostID)}
+		switch {
+		case result.JobID != 0:
+			event.Type, event.JobID, event.Status = JobUpdated, result.JobID, database.JobPending
+		case deleted:
+			event.Type = AgentDeleted
+		}
+		Publish(event)
+	}
+}
@@ -0,0 +1,384 @@
+// Package files serves the file browser of the dashboard. The agent is asked to open a file
+// session with a job and connects back to the server through agentconn. The server then
+// sends it the requests of the dashboard, listing directories and reading and writing the
+// chunks of the files transferred, and checks the checksum of every chunk.
+package files
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"slate-rmm/agentconn"
+	"slate-rmm/database"
+	"slate-rmm/models"
+	"sync"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// Limits of the file sessions
+const (
+	// ChunkSize is how much of a file is read or written with one request to the agent
+	ChunkSize = 512 * 1024
+	// ListPageSize is how many entries of a directory are listed with one request to the
+	// agent, which keeps the listing of large directories within MaxMessageSize
+	ListPageSize = 500
+	// MaxUploadSize limits the size of the files uploaded to a device
+	MaxUploadSize = 2 << 30
+	// IdleTimeout ends a session that was not used for that long
+	IdleTimeout = 10 * time.Minute
+	// MaxMessageSize limits the messages received from the agent, which carry a chunk
+	// encoded in base64
+	MaxMessageSize = 2 * ChunkSize
+	// requestTimeout is how long the agent has to answer a request
+	requestTimeout = 30 * time.Second
+	// keepAlive is how often idle connections are pinged, so that proxies do not close them
+	keepAlive = 30 * time.Second
+)
+
+// Errors returned by the sessions
+var (
+	// ErrNotConnected is returned while the agent has not connected yet
+	ErrNotConnected = errors.New("the agent has not connected yet")
+	// ErrClosed is returned once the session is over
+	ErrClosed = errors.New("the file session is closed")
+	// ErrChecksum is returned for a chunk that does not match its checksum
+	ErrChecksum = errors.New("the checksum of the chunk does not match")
+)
+
+// AgentError is an error the agent answered a request with, such as a file that does not
+// exist or cannot be read
+type AgentError struct {
+	Message string
+}
+
+func (e *AgentError) Error() string {
+	return e.Message
+}
+
+// Session is the file session of a user of the dashboard on a device. A user has at most
+// one session open on a device, which every tab of the dashboard shares.
+type Session struct {
+	ID       int32
+	HostID   int32
+	Username string
+
+	conn      *agentconn.Session
+	connected chan struct{}
+	done      chan struct{}
+	closing   chan struct{}
+	used      chan struct{}
+	closeOnce sync.Once
+
+	// agent is set once connected is closed
+	agent  *websocket.Conn
+	sendMu sync.Mutex
+
+	mu      sync.Mutex
+	nextID  int64
+	pending map[int64]chan models.FileResponse
+	reason  string
+}
+
+// sessionKey identifies the session of a user on a device
+type sessionKey struct {
+	hostID   int32
+	username string
+}
+
+// sessions holds the sessions that are not over
+var sessions struct {
+	sync.Mutex
+	open map[sessionKey]*Session
+}
+
+// Get returns the open session of a user on a device, or nil
+func Get(hostID int32, username string) *Session {
+	sessions.Lock()
+	defer sessions.Unlock()
+	return sessions.open[sessionKey{hostID, username}]
+}
+
+// Start creates the recorded session id of a user on a device and waits in the background
+// for the agent to connect with the token of the session. It replaces the session the user
+// had open on the device.
+func Start(id, hostID int32, username string) (*Session, string, error) {
+	conn, err := agentconn.New(database.FilesProvider, id, hostID, MaxMessageSize)
+	if err != nil {
+		return nil, "", err
+	}
+	s := &Session{
+		ID:        id,
+		HostID:    hostID,
+		Username:  username,
+		conn:      conn,
+		connected: make(chan struct{}),
+		done:      make(chan struct{}),
+		closing:   make(chan struct{}),
+		used:      make(chan struct{}, 1),
+		pending:   map[int64]chan models.FileResponse{},
+	}
+
+	key := sessionKey{hostID, username}
+	sessions.Lock()
+	if sessions.open == nil {
+		sessions.open = map[sessionKey]*Session{}
+	}
+	previous := sessions.open[key]
+	sessions.open[key] = s
+	sessions.Unlock()
+	if previous != nil {
+		previous.Close()
+	}
+
+	go s.run()
+	return s, conn.Token, nil
+}
+
+// Connected reports whether the agent has connected
+func (s *Session) Connected() bool {
+	select {
+	case <-s.connected:
+		return true
+	default:
+		return false
+	}
+}
+
+// Done is closed once the session is over
+func (s *Session) Done() <-chan struct{} {
+	return s.done
+}
+
+// Reason returns why the session ended, once it is over
+func (s *Session) Reason() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.reason
+}
+
+// Close ends the session, even while it waits for the agent
+func (s *Session) Close() {
+	s.closeOnce.Do(func() {
+		close(s.closing)
+		s.conn.Close()
+	})
+}
+
+// run waits for the agent, then dispatches its responses until the session ends, and
+// records why it ended
+func (s *Session) run() {
+	reason := s.serve()
+
+	s.mu.Lock()
+	s.reason = reason
+	for id, response := range s.pending {
+		close(response)
+		delete(s.pending, id)
+	}
+	s.mu.Unlock()
+
+	sessions.Lock()
+	key := sessionKey{s.HostID, s.Username}
+	if sessions.open[key] == s {
+		delete(sessions.open, key)
+	}
+	sessions.Unlock()
+
+	s.conn.Close()
+	close(s.done)
+	if err := database.EndRemoteSession(s.ID, reason); err != nil {
+		log.Printf("Failed to end file session %d: %v", s.ID, err)
+	}
+}
+
+// serve returns why the session ended
+func (s *Session) serve() string {
+	agent, err := s.conn.WaitAgent()
+	switch {
+	case errors.Is(err, agentconn.ErrStopping):
+		return agentconn.ReasonServerStopping
+	case errors.Is(err, agentconn.ErrClosed):
+		return agentconn.ReasonClosed
+	case err != nil:
+		return agentconn.ReasonAgentTimeout
+	}
+	s.agent = agent
+	close(s.connected)
+	// Closing the connection stops the reader
+	defer agent.Close()
+
+	lost := make(chan struct{})
+	go func() {
+		defer close(lost)
+		for {
+			var response models.FileResponse
+			if err := websocket.JSON.Receive(agent, &response); err != nil {
+				return
+			}
+			s.mu.Lock()
+			pending, ok := s.pending[response.ID]
+			delete(s.pending, response.ID)
+			s.mu.Unlock()
+			if ok {
+				pending <- response
+			}
+		}
+	}()
+
+	idle := time.NewTimer(IdleTimeout)
+	defer idle.Stop()
+	ping := time.NewTicker(keepAlive)
+	defer ping.Stop()
+
+	for {
+		select {
+		case <-lost:
+			return agentconn.ReasonAgentLost
+		case <-s.used:
+			idle.Reset(IdleTimeout)
+		case <-idle.C:
+			return agentconn.ReasonIdle
+		case <-ping.C:
+			s.send(models.FileRequest{Op: models.FilePing})
+		case <-s.closing:
+			return agentconn.ReasonClosed
+		case <-agentconn.Stopping():
+			return agentconn.ReasonServerStopping
+		}
+	}
+}
+
+// send writes a request to the agent
+func (s *Session) send(request models.FileRequest) error {
+	s.sendMu.Lock()
+	defer s.sendMu.Unlock()
+	return websocket.JSON.Send(s.agent, request)
+}
+
+// call sends a request to the agent and returns its response. A response with an error is
+// returned as an AgentError.
+func (s *Session) call(ctx context.Context, request models.FileRequest) (models.FileResponse, error) {
+	if !s.Connected() {
+		select {
+		case <-s.done:
+			return models.FileResponse{}, ErrClosed
+		default:
+			return models.FileResponse{}, ErrNotConnected
+		}
+	}
+
+	response := make(chan models.FileResponse, 1)
+	s.mu.Lock()
+	if s.reason != "" {
+		s.mu.Unlock()
+		return models.FileResponse{}, ErrClosed
+	}
+	s.nextID++
+	request.ID = s.nextID
+	s.pending[request.ID] = response
+	s.mu.Unlock()
+
+	select {
+	case s.used <- struct{}{}:
+	default:
+	}
+
+	forget := func() {
+		s.mu.Lock()
+		delete(s.pending, request.ID)
+		s.mu.Unlock()
+	}
+	if err := s.send(request); err != nil {
+		forget()
+		return models.FileResponse{}, fmt.Errorf("could not send request to the agent: %w", err)
+	}
+
+	timer := time.NewTimer(requestTimeout)
+	defer timer.Stop()
+	select {
+	case r, ok := <-response:
+		if !ok {
+			return models.FileResponse{}, ErrClosed
+		}
+		if r.Error != "" {
+			return r, &AgentError{Message: r.Error}
+		}
+		return r, nil
+	case <-timer.C:
+		forget()
+		return models.FileResponse{}, errors.New("the agent did not answer in time")
+	case <-ctx.Done():
+		forget()
+		return models.FileResponse{}, ctx.Err()
+	}
+}
+
+// List returns at most ListPageSize entries of a directory of the device from the entry at
+// offset, or its drives for an empty path, with the number of entries of the directory
+func (s *Session) List(ctx context.Context, path string, offset int) ([]models.FileEntry, int, error) {
+	response, err := s.call(ctx, models.FileRequest{Op: models.FileList, Path: path, Offset: int64(offset), Length: ListPageSize})
+	return response.Entries, response.Total, err
+}
+
+// Stat returns the entry of a file of the device
+func (s *Session) Stat(ctx context.Context, path string) (models.FileEntry, error) {
+	response, err := s.call(ctx, models.FileRequest{Op: models.FileStat, Path: path})
+	if err != nil {
+		return models.FileEntry{}, err
+	}
+	if response.Entry == nil {
+		return models.FileEntry{}, errors.New("the agent did not return the file")
+	}
+	return *response.Entry, nil
+}
+
+// Copy writes length bytes of a file of the device from offset to w, chunk by chunk, and
+// returns how many bytes were written
+func (s *Session) Copy(ctx context.Context, w io.Writer, path string, offset, length int64) (int64, error) {
+	var written int64
+	for written < length {
+		response, err := s.call(ctx, models.FileRequest{Op: models.FileRead, Path: path, Offset: offset + written, Length: int(min(length-written, ChunkSize))})
+		if err != nil {
+			return written, err
+		}
+		if len(response.Data) == 0 {
+			return written, io.ErrUnexpectedEOF
+		}
+		if Checksum(response.Data) != response.SHA256 {
+			return written, ErrChecksum
+		}
+		n, err := w.Write(response.Data)
+		written += int64(n)
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// Uploaded returns how much of the upload of a file of size bytes the agent has received,
+// from which an interrupted upload resumes
+func (s *Session) Uploaded(ctx context.Context, path string, size int64) (int64, error) {
+	response, err := s.call(ctx, models.FileRequest{Op: models.FileUploaded, Path: path, Size: size})
+	return response.Size, err
+}
+
+// Write sends the chunk of the upload of a file of size bytes at offset, and returns how
+// much of the upload the agent has received. The agent moves the file in place once it has
+// received all of it.
+func (s *Session) Write(ctx context.Context, path string, offset, size int64, data []byte) (int64, error) {
+	response, err := s.call(ctx, models.FileRequest{Op: models.FileWrite, Path: path, Offset: offset, Size: size, Data: data, SHA256: Checksum(data)})
+	return response.Size, err
+}
+
+// Checksum returns the hex SHA-256 digest of a chunk
+func Checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"slate-rmm/database"
+	"slate-rmm/events"
+	"slate-rmm/models"
+	"strconv"
+)
+
+// Handler for rendering the bulk-action toolbar above the device table
+func GetBulkToolbar(w http.ResponseWriter, r *http.Request) {
+	groups, err := database.GetAllGroups(selectedClient(r))
+	if err != nil {
+		renderError(w, r, http.StatusInternalServerError, "Failed to fetch groups")
+		log.Println("Failed to fetch groups:", err)
+		return
+	}
+
+	scripts, err := database.GetAllScripts()
+	if err != nil {
+		renderError(w, r, http.StatusInternalServerError, "Failed to fetch scripts")
+		log.Println("Failed to fetch scripts:", err)
+		return
+	}
+
+	data := struct {
+		Groups  []models.Group
+		Scripts []models.Script
+	}{
+		Groups:  groups,
+		Scripts: scripts,
+	}
+
+	// Render the template
+	render(w, r, "bulk-toolbar.html", data)
+}
+
+// Handler for applying a bulk action to the devices selected in the device table
+func BulkAction(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		renderError(w, r, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		renderError(w, r, http.StatusBadRequest, "invalid form")
+		return
+	}
+
+	req := models.BulkRequest{
+		Action:   r.FormValue("action"),
+		TagKey:   r.FormValue("tag_key"),
+		TagValue: r.FormValue("tag_value"),
+		Atomic:   r.FormValue("atomic") == "on",
+	}
+	req.GroupID, _ = strconv.Atoi(r.FormValue("group_id"))
+	req.FromGroupID, _ = strconv.Atoi(r.FormValue("from_group_id"))
+	req.ScriptID, _ = strconv.Atoi(r.FormValue("script_id"))
+	for _, value := range r.Form["host_ids"] {
+		hostID, err := strconv.Atoi(value)
+		if err != nil {
+			renderError(w, r, http.StatusBadRequest, "invalid host ID")
+			return
+		}
+		req.HostIDs = append(req.HostIDs, hostID)
+	}
+
+	results, err := database.RunBulkAction(req, selectedClient(r))
+	if errors.Is(err, database.ErrInvalidBulkRequest) {
+		renderError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		renderError(w, r, http.StatusInternalServerError, "Failed to run bulk action")
+		log.Println("Failed to run bulk action:", err)
+		return
+	}
+
+	// Let the device table reload with the changes, and the other dashboards follow the jobs
+	events.PublishBulk(results, req.Action == database.BulkDelete)
+	w.Header().Set("HX-Trigger", "devicesChanged")
+
+	// Render the template
+	render(w, r, "bulk-results.html", results)
+}
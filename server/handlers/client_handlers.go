@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"slate-rmm/database"
+	"slate-rmm/models"
+	"strconv"
+)
+
+// Handler for rendering the client selector
+func GetClients(w http.ResponseWriter, r *http.Request) {
+	orgs, err := database.GetAllOrganizations()
+	if err != nil {
+		renderError(w, r, http.StatusInternalServerError, "Failed to fetch clients")
+		log.Println("Failed to fetch clients:", err)
+		return
+	}
+
+	data := struct {
+		Clients  []models.Organization
+		Selected int32
+	}{
+		Clients:  orgs,
+		Selected: int32(selectedClient(r)),
+	}
+
+	// Render the template
+	render(w, r, "client-select.html", data)
+}
+
+// Handler for selecting the client the dashboard is scoped to
+func SelectClient(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		renderError(w, r, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	orgID, err := strconv.Atoi(r.FormValue("client_id"))
+	if err != nil || orgID < 0 {
+		renderError(w, r, http.StatusBadRequest, "invalid client ID")
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     clientCookie,
+		Value:    strconv.Itoa(orgID),
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteStrictMode,
+	})
+
+	// Let the device table and group list reload for the new client
+	w.Header().Set("HX-Trigger", "clientChanged")
+	w.WriteHeader(http.StatusNoContent)
+}
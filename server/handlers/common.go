@@ -2,9 +2,21 @@ package handlers
 
 import (
 	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
 	"time"
 )
 
+// clientCookie stores the organization (client) selected in the dashboard
+const clientCookie = "nexus_client"
+
+// Headers set by the authentik outpost in front of the dashboard
+const (
+	usernameHeader = "X-authentik-username"
+	groupsHeader   = "X-authentik-groups"
+)
+
 var CommonFuncMap = template.FuncMap{
 	"toLocalTime": func(t time.Time) time.Time {
 		return t.Local()
@@ -15,4 +27,69 @@ var CommonFuncMap = template.FuncMap{
 		}
 		return "offline"
 	},
+	"duration": formatDuration,
+	"bytes":    formatBytes,
+}
+
+// formatDuration formats the time between two instants as days, hours and minutes
+func formatDuration(from, to time.Time) string {
+	d := to.Sub(from)
+	if d < time.Minute {
+		return "less than a minute"
+	}
+
+	days := int(d / (24 * time.Hour))
+	hours := int(d % (24 * time.Hour) / time.Hour)
+	minutes := int(d % time.Hour / time.Minute)
+
+	var parts []string
+	if days > 0 {
+		parts = append(parts, strconv.Itoa(days)+"d")
+	}
+	if hours > 0 {
+		parts = append(parts, strconv.Itoa(hours)+"h")
+	}
+	if minutes > 0 && days == 0 {
+		parts = append(parts, strconv.Itoa(minutes)+"m")
+	}
+	return strings.Join(parts, " ")
+}
+
+// formatBytes formats a size in bytes with the largest binary unit it reaches
+func formatBytes(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return strconv.FormatInt(size, 10) + " B"
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return strconv.FormatFloat(float64(size)/float64(div), 'f', 1, 64) + " " + string("KMGTPE"[exp]) + "iB"
+}
+
+// selectedClient returns the organization selected in the dashboard, or 0 for all clients
+func selectedClient(r *http.Request) int {
+	cookie, err := r.Cookie(clientCookie)
+	if err != nil {
+		return 0
+	}
+	orgID, err := strconv.Atoi(cookie.Value)
+	if err != nil || orgID < 0 {
+		return 0
+	}
+	return orgID
+}
+
+// currentUser returns the user of the dashboard making the request and their authentik
+// groups, or "" when the request did not come through authentik
+func currentUser(r *http.Request) (string, []string) {
+	var groups []string
+	for _, group := range strings.Split(r.Header.Get(groupsHeader), "|") {
+		if group = strings.TrimSpace(group); group != "" {
+			groups = append(groups, group)
+		}
+	}
+	return r.Header.Get(usernameHeader), groups
 }
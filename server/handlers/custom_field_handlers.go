@@ -0,0 +1,171 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"slate-rmm/database"
+	"slate-rmm/models"
+	"strconv"
+	"strings"
+)
+
+// deviceFieldsData is rendered by the device-fields.html template
+type deviceFieldsData struct {
+	Agent  *models.Agent
+	Fields []models.CustomField
+	Tags   string
+	Error  string
+	Saved  bool
+}
+
+// renderDeviceFields loads the agent and field definitions and renders the editor
+func renderDeviceFields(w http.ResponseWriter, r *http.Request, hostID string, errMsg string, saved bool) {
+	agent, err := database.GetAgent(hostID, selectedClient(r))
+	if err != nil {
+		renderError(w, r, http.StatusInternalServerError, "could not get agent")
+		log.Println("Failed to fetch agent:", err)
+		return
+	}
+	if agent == nil {
+		renderError(w, r, http.StatusNotFound, "agent not found")
+		return
+	}
+
+	fields, err := database.GetAllCustomFields()
+	if err != nil {
+		renderError(w, r, http.StatusInternalServerError, "Failed to fetch custom fields")
+		log.Println("Failed to fetch custom fields:", err)
+		return
+	}
+
+	// Tags are edited as one key=value pair per line
+	var tags []string
+	for key, value := range agent.Tags {
+		tags = append(tags, key+"="+value)
+	}
+
+	data := deviceFieldsData{
+		Agent:  agent,
+		Fields: fields,
+		Tags:   strings.Join(tags, "\n"),
+		Error:  errMsg,
+		Saved:  saved,
+	}
+
+	// Render the template
+	render(w, r, "device-fields.html", data)
+}
+
+// Handler for rendering and saving the tags and custom fields of a device
+func DeviceFields(w http.ResponseWriter, r *http.Request) {
+	hostID := r.PathValue("id")
+	id, err := strconv.Atoi(hostID)
+	if err != nil {
+		renderError(w, r, http.StatusBadRequest, "invalid host ID")
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		renderDeviceFields(w, r, hostID, "", false)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		renderError(w, r, http.StatusBadRequest, "invalid form")
+		return
+	}
+
+	values := map[string]string{}
+	for key, formValues := range r.PostForm {
+		if fieldKey, ok := strings.CutPrefix(key, "field."); ok && len(formValues) > 0 {
+			values[fieldKey] = strings.TrimSpace(formValues[0])
+		}
+	}
+
+	tags := map[string]string{}
+	for _, line := range strings.Split(r.PostFormValue("tags"), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		key, value, _ := strings.Cut(line, "=")
+		tags[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+
+	err = database.SetAgentFields(id, values, tags, selectedClient(r))
+
+	var fieldErr *database.FieldError
+	switch {
+	case errors.As(err, &fieldErr):
+		renderDeviceFields(w, r, hostID, fieldErr.Reason, false)
+	case err == database.ErrNotFound:
+		renderError(w, r, http.StatusNotFound, "agent not found")
+	case err != nil:
+		renderError(w, r, http.StatusInternalServerError, "Failed to save device fields")
+		log.Println("Failed to save device fields:", err)
+	default:
+		w.Header().Set("HX-Trigger", "devicesChanged")
+		renderDeviceFields(w, r, hostID, "", true)
+	}
+}
+
+// Handler for listing and creating custom field definitions on the settings page
+func CustomFields(w http.ResponseWriter, r *http.Request) {
+	var errMsg string
+	if r.Method == http.MethodPost {
+		field := models.CustomField{
+			FieldKey:  strings.TrimSpace(r.FormValue("field_key")),
+			Label:     strings.TrimSpace(r.FormValue("label")),
+			FieldType: r.FormValue("field_type"),
+		}
+		for _, option := range strings.Split(r.FormValue("options"), ",") {
+			if option = strings.TrimSpace(option); option != "" {
+				field.Options = append(field.Options, option)
+			}
+		}
+
+		if err := field.ValidateDefinition(); err != nil {
+			errMsg = err.Error()
+		} else if err := database.CreateCustomField(&field); err != nil {
+			errMsg = "could not create field, the key may already exist"
+			log.Println("Failed to create custom field:", err)
+		}
+	}
+
+	fields, err := database.GetAllCustomFields()
+	if err != nil {
+		renderError(w, r, http.StatusInternalServerError, "Failed to fetch custom fields")
+		log.Println("Failed to fetch custom fields:", err)
+		return
+	}
+
+	data := struct {
+		Fields []models.CustomField
+		Error  string
+	}{
+		Fields: fields,
+		Error:  errMsg,
+	}
+
+	// Render the template
+	render(w, r, "custom-fields.html", data)
+}
+
+// Handler for deleting a custom field definition
+func DeleteCustomField(w http.ResponseWriter, r *http.Request) {
+	fieldID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		renderError(w, r, http.StatusBadRequest, "invalid field ID")
+		return
+	}
+
+	if err := database.DeleteCustomField(fieldID); err != nil && err != database.ErrNotFound {
+		renderError(w, r, http.StatusInternalServerError, "Failed to delete custom field")
+		log.Println("Failed to delete custom field:", err)
+		return
+	}
+
+	// The row is removed from the table by swapping it with nothing
+	w.WriteHeader(http.StatusOK)
+}
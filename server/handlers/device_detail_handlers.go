@@ -0,0 +1,326 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"slate-rmm/database"
+	"slate-rmm/events"
+	"slate-rmm/models"
+	"slate-rmm/shell"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Limits of the device detail tabs
+const (
+	recentHeartbeats   = 20
+	statusHistoryAge   = database.HeartbeatRetention
+	userHistoryLimit   = 20
+	remoteSessionLimit = 10
+	checkHistoryLimit  = 50
+)
+
+// loadDevice returns the agent named by the id path value, rendering an error if it is not found
+func loadDevice(w http.ResponseWriter, r *http.Request) (*models.Agent, bool) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id <= 0 {
+		renderError(w, r, http.StatusBadRequest, "invalid host ID")
+		return nil, false
+	}
+
+	agent, err := database.GetAgent(strconv.Itoa(id), selectedClient(r))
+	if err != nil {
+		renderError(w, r, http.StatusInternalServerError, "could not get agent")
+		log.Println("Failed to fetch agent:", err)
+		return nil, false
+	}
+	if agent == nil {
+		renderError(w, r, http.StatusNotFound, "agent not found")
+		return nil, false
+	}
+	return agent, true
+}
+
+// Handler for rendering the device detail view; its tabs are loaded when selected
+func DeviceDetail(w http.ResponseWriter, r *http.Request) {
+	agent, ok := loadDevice(w, r)
+	if !ok {
+		return
+	}
+
+	render(w, r, "device-detail.html", agent)
+}
+
+// deviceActionsData is rendered by the actions tab of the device detail view
+type deviceActionsData struct {
+	Agent       *models.Agent
+	Groups      []models.Group
+	Memberships []models.Group
+	Error       string
+	Message     string
+}
+
+// Handler for rendering a tab of the device detail view: overview, activity, users, shell or
+// actions
+func DeviceTab(w http.ResponseWriter, r *http.Request) {
+	agent, ok := loadDevice(w, r)
+	if !ok {
+		return
+	}
+	hostID := int(agent.ID)
+
+	switch r.PathValue("tab") {
+	case "overview":
+		groups, err := database.GetAgentGroups(hostID)
+		if err != nil {
+			renderError(w, r, http.StatusInternalServerError, "Failed to fetch groups")
+			log.Println("Failed to fetch groups:", err)
+			return
+		}
+		render(w, r, "device-overview.html", struct {
+			Agent  *models.Agent
+			Groups []models.Group
+		}{agent, groups})
+
+	case "activity":
+		history, err := database.GetStatusHistory(hostID, time.Now().Add(-statusHistoryAge))
+		if err != nil {
+			renderError(w, r, http.StatusInternalServerError, "Failed to fetch status history")
+			log.Println("Failed to fetch status history:", err)
+			return
+		}
+		heartbeats, err := database.GetRecentHeartbeats(hostID, recentHeartbeats)
+		if err != nil {
+			renderError(w, r, http.StatusInternalServerError, "Failed to fetch heartbeats")
+			log.Println("Failed to fetch heartbeats:", err)
+			return
+		}
+		sessions, err := database.GetRemoteSessions(hostID, selectedClient(r), remoteSessionLimit)
+		if err != nil {
+			renderError(w, r, http.StatusInternalServerError, "Failed to fetch remote sessions")
+			log.Println("Failed to fetch remote sessions:", err)
+			return
+		}
+		render(w, r, "device-activity.html", struct {
+			Agent          *models.Agent
+			StatusHistory  []models.StatusPeriod
+			Heartbeats     []time.Time
+			RemoteSessions []models.RemoteSession
+		}{agent, history, heartbeats, sessions})
+
+	case "users":
+		sessions, err := database.GetUserHistory(hostID, userHistoryLimit)
+		if err != nil {
+			renderError(w, r, http.StatusInternalServerError, "Failed to fetch user history")
+			log.Println("Failed to fetch user history:", err)
+			return
+		}
+		render(w, r, "device-users.html", struct {
+			Agent    *models.Agent
+			Sessions []models.UserSession
+		}{agent, sessions})
+
+	case "files":
+		renderFiles(w, r, agent)
+
+	case "services":
+		renderServices(w, r, agent, tabJob(r, agent), "")
+
+	case "processes":
+		renderProcesses(w, r, agent, tabJob(r, agent), "")
+
+	case "checks":
+		states, err := database.GetCheckStates(hostID, selectedClient(r))
+		if err != nil {
+			renderError(w, r, http.StatusInternalServerError, "Failed to fetch checks")
+			log.Println("Failed to fetch checks:", err)
+			return
+		}
+		render(w, r, "device-checks.html", struct {
+			Agent  *models.Agent
+			Checks []models.CheckState
+		}{agent, states})
+
+	case "events":
+		filter, fields := models.ParseEventLogFilter(r.URL.Query())
+		if len(fields) > 0 {
+			renderError(w, r, http.StatusBadRequest, "Invalid event log search")
+			return
+		}
+		entries, err := database.GetEventLogEntries(hostID, filter, selectedClient(r))
+		if err != nil {
+			renderError(w, r, http.StatusInternalServerError, "Failed to fetch event logs")
+			log.Println("Failed to fetch event logs:", err)
+			return
+		}
+		sources, err := database.GetEventLogSources(hostID, selectedClient(r))
+		if err != nil {
+			renderError(w, r, http.StatusInternalServerError, "Failed to fetch event log sources")
+			log.Println("Failed to fetch event log sources:", err)
+			return
+		}
+		render(w, r, "device-events.html", struct {
+			Agent   *models.Agent
+			Filter  models.EventLogFilter
+			Sources []string
+			Levels  []string
+			Entries []models.EventLogEntry
+		}{agent, filter, sources, models.EventLevels, entries})
+
+	case "shell":
+		render(w, r, "device-shell.html", struct {
+			Agent       *models.Agent
+			IdleTimeout string
+		}{agent, formatDuration(time.Time{}, time.Time{}.Add(shell.IdleTimeout))})
+
+	case "actions":
+		renderDeviceActions(w, r, agent, "", "")
+
+	default:
+		renderError(w, r, http.StatusNotFound, "unknown tab")
+	}
+}
+
+// renderDeviceActions renders the actions tab with the groups the device can be moved to
+func renderDeviceActions(w http.ResponseWriter, r *http.Request, agent *models.Agent, errMsg, message string) {
+	memberships, err := database.GetAgentGroups(int(agent.ID))
+	if err != nil {
+		renderError(w, r, http.StatusInternalServerError, "Failed to fetch groups")
+		log.Println("Failed to fetch groups:", err)
+		return
+	}
+
+	// Groups are shared by the sites of an organization
+	groups, err := database.GetAllGroups(int(agent.OrgID))
+	if err != nil {
+		renderError(w, r, http.StatusInternalServerError, "Failed to fetch groups")
+		log.Println("Failed to fetch groups:", err)
+		return
+	}
+
+	render(w, r, "device-actions.html", deviceActionsData{
+		Agent:       agent,
+		Groups:      groups,
+		Memberships: memberships,
+		Error:       errMsg,
+		Message:     message,
+	})
+}
+
+// Handler for renaming a device from the actions tab. An empty name shows the hostname again.
+func RenameDevice(w http.ResponseWriter, r *http.Request) {
+	agent, ok := loadDevice(w, r)
+	if !ok {
+		return
+	}
+
+	name := strings.TrimSpace(r.FormValue("display_name"))
+	if len(name) > 255 {
+		renderDeviceActions(w, r, agent, "name must be at most 255 characters", "")
+		return
+	}
+
+	if err := database.RenameAgent(int(agent.ID), name, selectedClient(r)); err != nil {
+		renderError(w, r, http.StatusInternalServerError, "Failed to rename device")
+		log.Println("Failed to rename device:", err)
+		return
+	}
+	agent.DisplayName = name
+	events.Publish(events.Event{Type: events.AgentUpdated, HostID: agent.ID})
+
+	w.Header().Set("HX-Trigger", "devicesChanged")
+	renderDeviceActions(w, r, agent, "", "Renamed.")
+}
+
+// Handler for moving a device to another group from the actions tab. A device in no group
+// is added to the destination group.
+func MoveDeviceGroup(w http.ResponseWriter, r *http.Request) {
+	agent, ok := loadDevice(w, r)
+	if !ok {
+		return
+	}
+
+	toGroupID, err := strconv.Atoi(r.FormValue("group_id"))
+	if err != nil {
+		renderDeviceActions(w, r, agent, "select a group", "")
+		return
+	}
+	fromGroupID, _ := strconv.Atoi(r.FormValue("from_group_id"))
+
+	orgID := selectedClient(r)
+	if fromGroupID == 0 {
+		err = database.AddHostToGroup(int(agent.ID), toGroupID, orgID)
+	} else {
+		err = database.MoveHostToGroup(int(agent.ID), fromGroupID, toGroupID, orgID)
+	}
+
+	switch {
+	case errors.Is(err, database.ErrConflict):
+		renderDeviceActions(w, r, agent, "the device is already in that group", "")
+	case errors.Is(err, database.ErrNotFound):
+		renderDeviceActions(w, r, agent, "group not found", "")
+	case err != nil:
+		renderError(w, r, http.StatusInternalServerError, "Failed to move device")
+		log.Println("Failed to move device:", err)
+	default:
+		events.Publish(events.Event{Type: events.AgentUpdated, HostID: agent.ID})
+		w.Header().Set("HX-Trigger", "devicesChanged")
+		renderDeviceActions(w, r, agent, "", "Moved.")
+	}
+}
+
+// Handler for deleting a device from the actions tab
+func DeleteDevice(w http.ResponseWriter, r *http.Request) {
+	agent, ok := loadDevice(w, r)
+	if !ok {
+		return
+	}
+
+	if err := database.DeleteAgent(strconv.Itoa(int(agent.ID)), selectedClient(r)); err != nil {
+		renderError(w, r, http.StatusInternalServerError, "Failed to delete device")
+		log.Println("Failed to delete device:", err)
+		return
+	}
+	events.Publish(events.Event{Type: events.AgentDeleted, HostID: agent.ID})
+
+	w.Header().Set("HX-Trigger", "devicesChanged")
+	render(w, r, "device-deleted.html", agent)
+}
+
+// Handler for rendering the latest results of a check of a device, below its checks
+func CheckHistory(w http.ResponseWriter, r *http.Request) {
+	agent, ok := loadDevice(w, r)
+	if !ok {
+		return
+	}
+	checkID, err := strconv.Atoi(r.PathValue("check_id"))
+	if err != nil || checkID <= 0 {
+		renderError(w, r, http.StatusBadRequest, "invalid check ID")
+		return
+	}
+
+	check, err := database.GetCheck(checkID, selectedClient(r))
+	if err != nil {
+		renderError(w, r, http.StatusInternalServerError, "Failed to fetch check")
+		log.Println("Failed to fetch check:", err)
+		return
+	}
+	if check == nil {
+		renderError(w, r, http.StatusNotFound, "check not found")
+		return
+	}
+
+	results, err := database.GetCheckHistory(int(agent.ID), checkID, time.Now().Add(-database.CheckResultRetention), checkHistoryLimit, selectedClient(r))
+	if err != nil {
+		renderError(w, r, http.StatusInternalServerError, "Failed to fetch check results")
+		log.Println("Failed to fetch check results:", err)
+		return
+	}
+
+	render(w, r, "device-check-history.html", struct {
+		Check   *models.Check
+		Results []models.CheckResult
+	}{check, results})
+}
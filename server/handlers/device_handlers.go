@@ -1,121 +1,34 @@
 package handlers
 
 import (
-	"encoding/json"
-	"fmt"
-	"html/template"
-	"io"
 	"log"
 	"net/http"
-	"net/url"
-	"os"
-	"path"
-	"path/filepath"
-	"regexp"
 	"slate-rmm/database"
-	"strconv"
-	"strings"
-
-	"github.com/joho/godotenv"
+	"slate-rmm/models"
 )
 
-// Handler to get all devices
+// Handler to get all devices, or the devices of the group selected in the group list
 func GetDevices(w http.ResponseWriter, r *http.Request) {
-	// Call the GetAllAgents function from the database package
-	agents, err := database.GetAllAgents()
-	if err != nil {
-		http.Error(w, "Failed to fetch devices", http.StatusInternalServerError)
-		log.Println("Failed to fetch devices:", err)
-		return
-	}
-
-	// Load templates
-	templates := template.Must(template.New("").Funcs(CommonFuncMap).ParseGlob(filepath.Join("templates", "*.html")))
-
-	// Render the template with the fetched data
-	err = templates.ExecuteTemplate(w, "device-list.html", agents)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		log.Println("Template execution failed:", err)
-		return
-	}
-}
-
-func GetRemoteControlURL(w http.ResponseWriter, r *http.Request) {
-	//load .env file
-	err := godotenv.Load()
-	if err != nil {
-		http.Error(w, "could not load .env file", http.StatusInternalServerError)
-		return
-	}
-
-	apiURL := os.Getenv("REMOTELY_API_URL")
-	apiToken := os.Getenv("REMOTELY_API_TOKEN")
-	apiID := os.Getenv("REMOTELY_API_ID")
-
-	// get the RemotelyID using the host ID from the end of request URL
-	hostID := strings.TrimPrefix(r.URL.Path, "/htmx/remoterequest/")
-	if _, err := strconv.Atoi(hostID); err != nil || hostID == "" {
-		http.Error(w, "invalid host ID", http.StatusBadRequest)
-		return
-	}
+	orgID := selectedClient(r)
+	filter := models.ParseAgentFilter(r.URL.Query())
 
-	agent, err := database.GetAgent(hostID)
+	group, err := selectedGroup(r, orgID)
 	if err != nil {
-		http.Error(w, "could not get agent", http.StatusInternalServerError)
+		renderError(w, r, http.StatusInternalServerError, "Failed to fetch group")
+		log.Println("Failed to fetch group:", err)
 		return
 	}
 
-	// Validate RemotelyID
-	if agent.RemotelyID == "" || !regexp.MustCompile(`[a-zA-Z0-9]+$`).MatchString(agent.RemotelyID) {
-		http.Error(w, "invalid RemotelyID", http.StatusBadRequest)
-		return
-	}
-
-	baseURL, err := url.Parse(apiURL)
-	if err != nil {
-		http.Error(w, "invalid API URL", http.StatusBadRequest)
-		return
-	}
-
-	// build the Remotely API URL
-	baseURL.Path = path.Join(baseURL.Path, "RemoteControl", agent.RemotelyID)
-	url := baseURL.String()
-
-	// build the Remotely API request
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		http.Error(w, "could not create request", http.StatusInternalServerError)
-		return
+	if group != nil {
+		filter.GroupID = group.GroupID
 	}
-
-	// set the headers
-	req.Header.Set("X-Api-Key", apiID+":"+apiToken)
-
-	// send the request
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		http.Error(w, "could not send request", http.StatusInternalServerError)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-	defer resp.Body.Close()
-
-	// Print the response to the console
-	fmt.Println(resp)
-
-	// Read the response body
-	body, err := io.ReadAll(resp.Body)
+	agents, err := database.GetAllAgents(orgID, filter)
 	if err != nil {
-		http.Error(w, "could not read response body", http.StatusInternalServerError)
+		renderError(w, r, http.StatusInternalServerError, "Failed to fetch devices")
+		log.Println("Failed to fetch devices:", err)
 		return
 	}
 
-	// Set HTMX headers
-	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	w.Header().Set("HX-Trigger", "remoterequest")
-
-	// Return the URL in a JSON object
-	json.NewEncoder(w).Encode(map[string]string{"url": string(body)})
+	// Render the template with the fetched data
+	render(w, r, "device-list.html", agents)
 }
@@ -0,0 +1,147 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"slate-rmm/database"
+	"slate-rmm/events"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// eventKeepAlive is how often an idle event stream sends a comment, so that proxies
+// do not close it
+const eventKeepAlive = 30 * time.Second
+
+// Handler for the stream of server-sent events the dashboard connects to with the htmx SSE
+// extension. Events name what changed, e.g. agent-42, and the elements showing it reload
+// themselves through the handlers below, which apply the selected client. Alerts are sent
+// as they are rendered, so only those of the hosts of the selected client are sent.
+func Events(w http.ResponseWriter, r *http.Request) {
+	rc := http.NewResponseController(w)
+	orgID := selectedClient(r)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	// nginx buffers responses unless told otherwise, which would hold the events back
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		log.Println("Failed to start event stream:", err)
+		return
+	}
+
+	sub := events.Subscribe()
+	defer sub.Close()
+
+	keepAlive := time.NewTicker(eventKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		case event, ok := <-sub.C:
+			// The bus is closed when the server shuts down
+			if !ok {
+				return
+			}
+			if event.Type == events.Alert && orgID != 0 && int(event.OrgID) != orgID {
+				continue
+			}
+			name, data, err := sseMessage(event)
+			if err != nil {
+				log.Println("Failed to render event:", err)
+				continue
+			}
+			writeEvent(w, name, data)
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// sseMessage returns the name and data of the server-sent event an event is streamed as
+func sseMessage(event events.Event) (string, string, error) {
+	switch event.Type {
+	case events.AgentRegistered, events.AgentDeleted:
+		return "devices", event.Type, nil
+	case events.JobUpdated:
+		return "job-" + strconv.Itoa(int(event.JobID)), event.Status, nil
+	case events.ServicesReported:
+		return "services-" + strconv.Itoa(int(event.HostID)), event.Type, nil
+	case events.ProcessesReported:
+		return "processes-" + strconv.Itoa(int(event.HostID)), event.Type, nil
+	case events.ChecksReported:
+		return "checks-" + strconv.Itoa(int(event.HostID)), event.Type, nil
+	case events.EventLogsReported:
+		return "event-logs-" + strconv.Itoa(int(event.HostID)), event.Type, nil
+	case events.Alert:
+		// Alerts are inserted as they are, so the fragment is the data
+		buf, err := executeTemplate("alert.html", event)
+		if err != nil {
+			return "", "", err
+		}
+		return "alert", buf.String(), nil
+	default:
+		return "agent-" + strconv.Itoa(int(event.HostID)), event.Type, nil
+	}
+}
+
+// writeEvent writes a server-sent event, splitting the data into one field per line
+func writeEvent(w http.ResponseWriter, name, data string) {
+	fmt.Fprintf(w, "event: %s\n", name)
+	for _, line := range strings.Split(data, "\n") {
+		fmt.Fprintf(w, "data: %s\n", line)
+	}
+	fmt.Fprint(w, "\n")
+}
+
+// Handler for rendering a single row of the device table, reloaded when the device changes.
+// A device that no longer exists renders nothing, removing its row.
+func DeviceRow(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id <= 0 {
+		renderError(w, r, http.StatusBadRequest, "invalid host ID")
+		return
+	}
+
+	agent, err := database.GetAgent(strconv.Itoa(id), selectedClient(r))
+	if err != nil {
+		renderError(w, r, http.StatusInternalServerError, "could not get agent")
+		log.Println("Failed to fetch agent:", err)
+		return
+	}
+	if agent == nil {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	render(w, r, "device-row.html", agent)
+}
+
+// Handler for rendering the status of a job, reloaded as the agent makes progress on it
+func JobStatus(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || id <= 0 {
+		renderError(w, r, http.StatusBadRequest, "invalid job ID")
+		return
+	}
+
+	job, err := database.GetJob(id, selectedClient(r))
+	if err != nil {
+		renderError(w, r, http.StatusInternalServerError, "could not get job")
+		log.Println("Failed to fetch job:", err)
+		return
+	}
+	if job == nil {
+		renderError(w, r, http.StatusNotFound, "job not found")
+		return
+	}
+
+	render(w, r, "job-status.html", job)
+}
@@ -0,0 +1,415 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"slate-rmm/database"
+	"slate-rmm/events"
+	"slate-rmm/files"
+	"slate-rmm/models"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Headers of the resumable uploads of the file browser
+const (
+	// uploadOffsetHeader returns how much of an upload the agent has received
+	uploadOffsetHeader = "X-Upload-Offset"
+	// chunkChecksumHeader carries the hex SHA-256 digest of an uploaded chunk
+	chunkChecksumHeader = "X-Chunk-SHA256"
+)
+
+// States of the file browser of a device
+const (
+	filesClosed     = "closed"
+	filesConnecting = "connecting"
+	filesOpen       = "open"
+)
+
+// fileBrowser is rendered by device-files.html
+type fileBrowser struct {
+	Agent   *models.Agent
+	State   string
+	Path    string
+	Parent  string
+	Entries []models.FileEntry
+	Error   string
+
+	// First and Last number the entries listed out of the Total of the directory, which is
+	// listed a page at a time. Previous and Next are the offsets of the pages around it.
+	First, Last, Total int
+	Previous, Next     *int
+
+	IdleTimeout   string
+	MaxUploadSize int64
+	ChunkSize     int
+}
+
+// renderFiles renders the file browser of a device for the file session of the user, listing
+// the page of the directory in the path query parameter from the entry in the offset query
+// parameter once the agent is connected. Every listing is recorded with the session.
+func renderFiles(w http.ResponseWriter, r *http.Request, agent *models.Agent) {
+	browser := fileBrowser{
+		Agent:         agent,
+		State:         filesClosed,
+		IdleTimeout:   formatDuration(time.Time{}, time.Time{}.Add(files.IdleTimeout)),
+		MaxUploadSize: files.MaxUploadSize,
+		ChunkSize:     files.ChunkSize,
+	}
+
+	session := files.Get(agent.ID, remoteUser(r))
+	switch {
+	case session == nil:
+	case !session.Connected():
+		browser.State = filesConnecting
+	default:
+		browser.State = filesOpen
+		browser.Path = r.URL.Query().Get("path")
+		browser.Parent = parentPath(browser.Path)
+
+		offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+		offset = max(offset, 0)
+		entries, total, err := session.List(r.Context(), browser.Path, offset)
+		recordFileOperation(session, models.FileList, browser.Path, 0, err)
+		if err != nil {
+			var agentErr *files.AgentError
+			if !errors.As(err, &agentErr) {
+				writeFileError(w, r, err, "list the directory")
+				return
+			}
+			browser.Error = agentErr.Message
+		}
+		browser.Entries = entries
+		browser.Total = total
+		if len(entries) > 0 {
+			browser.First, browser.Last = offset+1, offset+len(entries)
+		}
+		if offset > 0 {
+			previous := max(offset-files.ListPageSize, 0)
+			browser.Previous = &previous
+		}
+		if offset+len(entries) < total {
+			next := offset + len(entries)
+			browser.Next = &next
+		}
+	}
+
+	render(w, r, "device-files.html", browser)
+}
+
+// Handler for opening a file session on a device. The agent is asked to connect with a job,
+// and the file browser polls until it does. Only users allowed by a remote access policy
+// browse the files of a device, and every session is recorded.
+func OpenFiles(w http.ResponseWriter, r *http.Request) {
+	agent, ok := loadDevice(w, r)
+	if !ok {
+		return
+	}
+
+	username, allowed, err := authorizeRemoteAccess(r, int(agent.ID))
+	if err != nil {
+		renderError(w, r, http.StatusInternalServerError, "could not check remote access")
+		log.Println("Failed to check remote access:", err)
+		return
+	}
+	record := models.RemoteSession{HostID: &agent.ID, Hostname: agent.Hostname, Username: username, Provider: database.FilesProvider}
+	if !allowed {
+		record.Outcome = models.RemoteSessionDenied
+		recordRemoteSession(&record)
+		renderError(w, r, http.StatusForbidden, "you are not allowed to browse the files of this device")
+		return
+	}
+
+	// A session that could not be recorded is not opened
+	record.Outcome = models.RemoteSessionStarted
+	if err := database.RecordRemoteSession(&record); err != nil {
+		renderError(w, r, http.StatusInternalServerError, "could not record the remote session")
+		log.Println("Failed to record remote session:", err)
+		return
+	}
+
+	session, token, err := files.Start(record.SessionID, agent.ID, username)
+	if err != nil {
+		endRemoteSession(record.SessionID, err.Error())
+		renderError(w, r, http.StatusInternalServerError, "could not open the file session")
+		log.Println("Failed to create file session:", err)
+		return
+	}
+
+	request := models.SessionRequest{SessionID: record.SessionID, Token: token}
+	jobID, err := database.CreateJob(int(agent.ID), database.JobOpenFiles, request, selectedClient(r))
+	if err != nil {
+		session.Close()
+		renderError(w, r, http.StatusInternalServerError, "could not open the file session")
+		log.Println("Failed to queue file session job:", err)
+		return
+	}
+	events.Publish(events.Event{Type: events.JobUpdated, HostID: agent.ID, JobID: jobID, Status: database.JobPending})
+
+	renderFiles(w, r, agent)
+}
+
+// Handler for closing the file session of the user on a device
+func CloseFiles(w http.ResponseWriter, r *http.Request) {
+	agent, ok := loadDevice(w, r)
+	if !ok {
+		return
+	}
+
+	if session := files.Get(agent.ID, remoteUser(r)); session != nil {
+		session.Close()
+		<-session.Done()
+	}
+	renderFiles(w, r, agent)
+}
+
+// Handler for downloading a file of a device through the file session of the user. Range
+// requests resume an interrupted download. Every download is recorded with how much of the
+// file was sent.
+func DownloadFile(w http.ResponseWriter, r *http.Request) {
+	agent, ok := loadDevice(w, r)
+	if !ok {
+		return
+	}
+	session, ok := openFileSession(w, r, agent)
+	if !ok {
+		return
+	}
+
+	path := r.URL.Query().Get("path")
+	entry, err := session.Stat(r.Context(), path)
+	if err != nil {
+		recordFileOperation(session, models.FileDownload, path, 0, err)
+		writeFileError(w, r, err, "download the file")
+		return
+	}
+	if entry.Dir {
+		renderError(w, r, http.StatusBadRequest, "directories cannot be downloaded")
+		return
+	}
+
+	// A range is only honored for the version of the file it was requested for
+	lastModified := entry.ModTime.UTC().Format(http.TimeFormat)
+	rangeHeader := r.Header.Get("Range")
+	if ifRange := r.Header.Get("If-Range"); ifRange != "" && ifRange != lastModified {
+		rangeHeader = ""
+	}
+	offset, length, ok := byteRange(rangeHeader, entry.Size)
+	if !ok {
+		w.Header().Set("Content-Range", "bytes */"+strconv.FormatInt(entry.Size, 10))
+		renderError(w, r, http.StatusRequestedRangeNotSatisfiable, "invalid range")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": entry.Name}))
+	w.Header().Set("Content-Length", strconv.FormatInt(length, 10))
+	w.Header().Set("Accept-Ranges", "bytes")
+	w.Header().Set("Last-Modified", lastModified)
+	status := http.StatusOK
+	if rangeHeader != "" {
+		w.Header().Set("Content-Range", "bytes "+strconv.FormatInt(offset, 10)+"-"+strconv.FormatInt(offset+length-1, 10)+"/"+strconv.FormatInt(entry.Size, 10))
+		status = http.StatusPartialContent
+	}
+	w.WriteHeader(status)
+
+	// Once the headers are sent, a failure can only cut the download short, which the
+	// browser resumes from what it received
+	written, err := session.Copy(r.Context(), w, path, offset, length)
+	recordFileOperation(session, models.FileDownload, path, written, err)
+	if err != nil {
+		log.Printf("Failed to download %s from host %d: %v", path, agent.ID, err)
+	}
+}
+
+// Handler for how much of an upload to a device the agent has received, from which the
+// dashboard resumes an interrupted upload
+func UploadStatus(w http.ResponseWriter, r *http.Request) {
+	agent, ok := loadDevice(w, r)
+	if !ok {
+		return
+	}
+	session, ok := openFileSession(w, r, agent)
+	if !ok {
+		return
+	}
+	size, ok := uploadSize(w, r)
+	if !ok {
+		return
+	}
+
+	received, err := session.Uploaded(r.Context(), r.URL.Query().Get("path"), size)
+	if err != nil {
+		writeFileError(w, r, err, "resume the upload")
+		return
+	}
+	w.Header().Set(uploadOffsetHeader, strconv.FormatInt(received, 10))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Handler for a chunk of an upload to a device. The dashboard sends the file in chunks of
+// files.ChunkSize with their checksum, each at the offset the agent has received so far.
+// The completed upload, or the failure that interrupts it, is recorded.
+func UploadChunk(w http.ResponseWriter, r *http.Request) {
+	agent, ok := loadDevice(w, r)
+	if !ok {
+		return
+	}
+	// Browsers send the cookies of the dashboard with requests made by any site
+	if !sameOrigin(r) {
+		renderError(w, r, http.StatusForbidden, "files can only be uploaded from the dashboard")
+		return
+	}
+	session, ok := openFileSession(w, r, agent)
+	if !ok {
+		return
+	}
+	size, ok := uploadSize(w, r)
+	if !ok {
+		return
+	}
+	path := r.URL.Query().Get("path")
+	offset, err := strconv.ParseInt(r.URL.Query().Get("offset"), 10, 64)
+	if err != nil || offset < 0 || offset > size {
+		renderError(w, r, http.StatusBadRequest, "invalid offset")
+		return
+	}
+
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, files.ChunkSize))
+	if err != nil {
+		renderError(w, r, http.StatusRequestEntityTooLarge, "chunks are limited to "+formatBytes(files.ChunkSize))
+		return
+	}
+	if offset+int64(len(data)) > size || (len(data) == 0 && size > 0) {
+		renderError(w, r, http.StatusBadRequest, "the chunk does not fit the upload")
+		return
+	}
+	if sum := r.Header.Get(chunkChecksumHeader); sum != "" && !strings.EqualFold(sum, files.Checksum(data)) {
+		renderError(w, r, http.StatusBadRequest, files.ErrChecksum.Error())
+		return
+	}
+
+	received, err := session.Write(r.Context(), path, offset, size, data)
+	if err != nil {
+		recordFileOperation(session, models.FileUpload, path, offset, err)
+		writeFileError(w, r, err, "upload the file")
+		return
+	}
+	if received == size {
+		recordFileOperation(session, models.FileUpload, path, size, nil)
+	}
+	w.Header().Set(uploadOffsetHeader, strconv.FormatInt(received, 10))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// openFileSession returns the file session of the user on a device once the agent is
+// connected, or responds with a conflict
+func openFileSession(w http.ResponseWriter, r *http.Request, agent *models.Agent) (*files.Session, bool) {
+	session := files.Get(agent.ID, remoteUser(r))
+	if session == nil || !session.Connected() {
+		renderError(w, r, http.StatusConflict, "open the file browser of the device first")
+		return nil, false
+	}
+	return session, true
+}
+
+// uploadSize returns the size of the uploaded file from the size query parameter, within
+// files.MaxUploadSize
+func uploadSize(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	size, err := strconv.ParseInt(r.URL.Query().Get("size"), 10, 64)
+	if err != nil || size < 0 {
+		renderError(w, r, http.StatusBadRequest, "invalid size")
+		return 0, false
+	}
+	if size > files.MaxUploadSize {
+		renderError(w, r, http.StatusRequestEntityTooLarge, "uploads are limited to "+formatBytes(files.MaxUploadSize))
+		return 0, false
+	}
+	return size, true
+}
+
+// writeFileError responds with the error of a file operation. The errors of the agent, such
+// as a file that does not exist, are shown as they are.
+func writeFileError(w http.ResponseWriter, r *http.Request, err error, action string) {
+	var agentErr *files.AgentError
+	switch {
+	case errors.As(err, &agentErr):
+		renderError(w, r, http.StatusUnprocessableEntity, agentErr.Message)
+	case errors.Is(err, files.ErrClosed), errors.Is(err, files.ErrNotConnected):
+		renderError(w, r, http.StatusConflict, "the file session is closed, open it again")
+	default:
+		renderError(w, r, http.StatusBadGateway, "could not "+action)
+		log.Printf("Failed to %s: %v", action, err)
+	}
+}
+
+// recordFileOperation records an operation of a file session. A failure to record it is only
+// logged.
+func recordFileOperation(session *files.Session, operation, path string, bytes int64, opErr error) {
+	op := models.FileOperation{SessionID: session.ID, Operation: operation, Path: path, Bytes: bytes, Outcome: models.FileOperationCompleted}
+	if opErr != nil {
+		op.Outcome, op.Error = models.FileOperationFailed, opErr.Error()
+	}
+	if err := database.RecordFileOperation(&op); err != nil {
+		log.Printf("Failed to record file operation of session %d: %v", session.ID, err)
+	}
+}
+
+// byteRange returns the offset and length of the file of size bytes to send for a Range
+// header, the whole file without one. Only a single range is supported; ok is false for a
+// range that cannot be satisfied.
+func byteRange(header string, size int64) (offset, length int64, ok bool) {
+	if header == "" {
+		return 0, size, true
+	}
+	spec, found := strings.CutPrefix(header, "bytes=")
+	if !found || strings.Contains(spec, ",") {
+		return 0, 0, false
+	}
+	first, last, found := strings.Cut(strings.TrimSpace(spec), "-")
+	if !found {
+		return 0, 0, false
+	}
+
+	if first == "" {
+		// A suffix range is the last bytes of the file
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n <= 0 || size == 0 {
+			return 0, 0, false
+		}
+		n = min(n, size)
+		return size - n, n, true
+	}
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 || start >= size {
+		return 0, 0, false
+	}
+	end := size - 1
+	if last != "" {
+		end, err = strconv.ParseInt(last, 10, 64)
+		if err != nil || end < start {
+			return 0, 0, false
+		}
+		end = min(end, size-1)
+	}
+	return start, end - start + 1, true
+}
+
+// parentPath returns the directory containing a path of the device, or "" for the drives
+// once at the root of a drive
+func parentPath(path string) string {
+	trimmed := strings.TrimRight(path, `\/`)
+	i := strings.LastIndexAny(trimmed, `\/`)
+	if i < 0 {
+		return ""
+	}
+	parent := trimmed[:i]
+	if strings.HasSuffix(parent, ":") || parent == "" {
+		parent += path[i : i+1]
+	}
+	return parent
+}
@@ -1,31 +1,382 @@
 package handlers
 
 import (
-	"html/template"
+	"errors"
 	"log"
 	"net/http"
-	"path/filepath"
 	"slate-rmm/database"
+	"slate-rmm/events"
+	"slate-rmm/models"
+	"strconv"
+	"strings"
 )
 
-// Handler for rendering the group items
+// maxGroupName is the longest group name accepted, as in the API
+const maxGroupName = 255
+
+// selectedGroup returns the group selected in the group list by the group_id parameter,
+// or nil if none is selected or it is not part of the selected client
+func selectedGroup(r *http.Request, orgID int) (*models.Group, error) {
+	groupID, err := strconv.Atoi(r.FormValue("group_id"))
+	if err != nil || groupID <= 0 {
+		return nil, nil
+	}
+	return database.GetGroup(strconv.Itoa(groupID), orgID)
+}
+
+// Handler for rendering the group items, filtered by the search box of the group list
 func GetGroups(w http.ResponseWriter, r *http.Request) {
 	// call the GetAllGroups function from the database package
-	groups, err := database.GetAllGroups()
+	groups, err := database.GetAllGroups(selectedClient(r))
 	if err != nil {
-		http.Error(w, "Failed to fetch groups", http.StatusInternalServerError)
+		renderError(w, r, http.StatusInternalServerError, "Failed to fetch groups")
 		log.Println("Failed to fetch groups:", err)
 		return
 	}
 
-	// Load templates
-	templates := template.Must(template.New("").Funcs(CommonFuncMap).ParseGlob(filepath.Join("templates", "*.html")))
+	if search := strings.ToLower(strings.TrimSpace(r.FormValue("search"))); search != "" {
+		var matching []models.Group
+		for _, group := range groups {
+			if strings.Contains(strings.ToLower(group.GroupName), search) {
+				matching = append(matching, group)
+			}
+		}
+		groups = matching
+	}
+
+	selected, _ := strconv.Atoi(r.FormValue("group_id"))
+	data := struct {
+		Groups   []models.Group
+		Selected int32
+	}{groups, int32(selected)}
 
 	// Render the template
-	err = templates.ExecuteTemplate(w, "group-items.html", groups)
+	render(w, r, "group-items.html", data)
+}
+
+// groupEditorData is rendered by the group-editor.html template
+type groupEditorData struct {
+	Groups []models.Group
+	Sites  []models.Site
+	// ConfirmDelete is a group that still has members, deleted only once confirmed
+	ConfirmDelete *models.Group
+	Error         string
+	Message       string
+}
+
+// renderGroupEditor renders the group editor of the selected client
+func renderGroupEditor(w http.ResponseWriter, r *http.Request, data groupEditorData) {
+	orgID := selectedClient(r)
+	groups, err := database.GetAllGroups(orgID)
+	if err != nil {
+		renderError(w, r, http.StatusInternalServerError, "Failed to fetch groups")
+		log.Println("Failed to fetch groups:", err)
+		return
+	}
+	data.Groups = groups
+
+	// Groups are created in the default site when no client is selected
+	if orgID != 0 {
+		data.Sites, err = database.GetSites(orgID)
+		if err != nil {
+			renderError(w, r, http.StatusInternalServerError, "Failed to fetch sites")
+			log.Println("Failed to fetch sites:", err)
+			return
+		}
+	}
+
+	render(w, r, "group-editor.html", data)
+}
+
+// validateGroupName returns the reason a group name is rejected, if any
+func validateGroupName(name string) string {
+	switch {
+	case name == "":
+		return "a group name is required"
+	case len(name) > maxGroupName:
+		return "group names must be at most 255 characters"
+	}
+	return ""
+}
+
+// Handler for rendering the group editor
+func GroupEditor(w http.ResponseWriter, r *http.Request) {
+	renderGroupEditor(w, r, groupEditorData{})
+}
+
+// Handler for creating a group from the group editor
+func CreateGroup(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimSpace(r.FormValue("group_name"))
+	if reason := validateGroupName(name); reason != "" {
+		renderGroupEditor(w, r, groupEditorData{Error: reason})
+		return
+	}
+
+	// Without a site, the group is created in the default site of the selected client
+	orgID := selectedClient(r)
+	siteID, _ := strconv.Atoi(r.FormValue("site_id"))
+	var err error
+	if siteID == 0 {
+		siteID, err = database.DefaultSite(orgID)
+	} else if site, siteErr := database.GetSite(siteID, orgID); siteErr != nil {
+		err = siteErr
+	} else if site == nil {
+		err = database.ErrNotFound
+	}
+	if err == nil {
+		_, err = database.CreateGroup(name, siteID)
+	}
+
+	switch {
+	case errors.Is(err, database.ErrNotFound):
+		renderGroupEditor(w, r, groupEditorData{Error: "site not found"})
+	case errors.Is(err, database.ErrConflict):
+		renderGroupEditor(w, r, groupEditorData{Error: "a group with that name already exists"})
+	case err != nil:
+		renderError(w, r, http.StatusInternalServerError, "Failed to create group")
+		log.Println("Failed to create group:", err)
+	default:
+		w.Header().Set("HX-Trigger", "groupsChanged")
+		renderGroupEditor(w, r, groupEditorData{Message: "Created " + name + "."})
+	}
+}
+
+// Handler for renaming a group from the group editor
+func RenameGroup(w http.ResponseWriter, r *http.Request) {
+	groupID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		renderError(w, r, http.StatusBadRequest, "invalid group ID")
+		return
+	}
+
+	name := strings.TrimSpace(r.FormValue("group_name"))
+	if reason := validateGroupName(name); reason != "" {
+		renderGroupEditor(w, r, groupEditorData{Error: reason})
+		return
+	}
+
+	err = database.UpdateGroup(strconv.Itoa(groupID), name, selectedClient(r))
+	switch {
+	case errors.Is(err, database.ErrNotFound):
+		renderGroupEditor(w, r, groupEditorData{Error: "group not found"})
+	case errors.Is(err, database.ErrConflict):
+		renderGroupEditor(w, r, groupEditorData{Error: "a group with that name already exists"})
+	case err != nil:
+		renderError(w, r, http.StatusInternalServerError, "Failed to rename group")
+		log.Println("Failed to rename group:", err)
+	default:
+		w.Header().Set("HX-Trigger", "groupsChanged")
+		renderGroupEditor(w, r, groupEditorData{Message: "Renamed to " + name + "."})
+	}
+}
+
+// Handler for deleting a group from the group editor. A group with members is only deleted
+// with force=true, so the editor asks for confirmation first.
+func DeleteGroup(w http.ResponseWriter, r *http.Request) {
+	groupID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		renderError(w, r, http.StatusBadRequest, "invalid group ID")
+		return
+	}
+
+	orgID := selectedClient(r)
+	group, err := database.GetGroup(strconv.Itoa(groupID), orgID)
+	if err == nil && group == nil {
+		err = database.ErrNotFound
+	}
+	if err == nil {
+		err = database.DeleteGroup(strconv.Itoa(groupID), orgID, r.FormValue("force") == "true")
+	}
+
+	switch {
+	case errors.Is(err, database.ErrGroupNotEmpty):
+		renderGroupEditor(w, r, groupEditorData{ConfirmDelete: group})
+	case errors.Is(err, database.ErrNotFound):
+		renderGroupEditor(w, r, groupEditorData{Error: "group not found"})
+	case err != nil:
+		renderError(w, r, http.StatusInternalServerError, "Failed to delete group")
+		log.Println("Failed to delete group:", err)
+	default:
+		w.Header().Set("HX-Trigger", "groupsChanged, devicesChanged")
+		renderGroupEditor(w, r, groupEditorData{Message: "Deleted " + group.GroupName + "."})
+	}
+}
+
+// groupMembersData is rendered by the group-members.html template
+type groupMembersData struct {
+	Group   *models.Group
+	Members []models.Agent
+	// Others are the devices that can be added to the group
+	Others []models.Agent
+	// Groups are the groups members can be moved to
+	Groups  []models.Group
+	Error   string
+	Message string
+}
+
+// renderGroupMembers renders the member editor of a group
+func renderGroupMembers(w http.ResponseWriter, r *http.Request, groupID int, errMsg, message string) {
+	orgID := selectedClient(r)
+	group, err := database.GetGroup(strconv.Itoa(groupID), orgID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		log.Println("Template execution failed:", err)
+		renderError(w, r, http.StatusInternalServerError, "Failed to fetch group")
+		log.Println("Failed to fetch group:", err)
+		return
+	}
+	if group == nil {
+		renderError(w, r, http.StatusNotFound, "group not found")
 		return
 	}
+
+	members, err := database.GetHostsInGroup(groupID, orgID)
+	if err != nil {
+		renderError(w, r, http.StatusInternalServerError, "Failed to fetch group members")
+		log.Println("Failed to fetch group members:", err)
+		return
+	}
+
+	// Groups are shared by the sites of an organization, so only its devices can join
+	site, err := database.GetSite(int(group.SiteID), orgID)
+	if err != nil || site == nil {
+		renderError(w, r, http.StatusInternalServerError, "Failed to fetch site")
+		log.Println("Failed to fetch site of group:", err)
+		return
+	}
+	all, err := database.GetAllAgents(int(site.OrgID), models.AgentFilter{})
+	if err != nil {
+		renderError(w, r, http.StatusInternalServerError, "Failed to fetch devices")
+		log.Println("Failed to fetch devices:", err)
+		return
+	}
+	isMember := map[int32]bool{}
+	for _, member := range members {
+		isMember[member.ID] = true
+	}
+	var others []models.Agent
+	for _, agent := range all {
+		if !isMember[agent.ID] {
+			others = append(others, agent)
+		}
+	}
+
+	groups, err := database.GetAllGroups(int(site.OrgID))
+	if err != nil {
+		renderError(w, r, http.StatusInternalServerError, "Failed to fetch groups")
+		log.Println("Failed to fetch groups:", err)
+		return
+	}
+
+	render(w, r, "group-members.html", groupMembersData{
+		Group:   group,
+		Members: members,
+		Others:  others,
+		Groups:  groups,
+		Error:   errMsg,
+		Message: message,
+	})
+}
+
+// Handler for rendering the member editor of a group
+func GroupMembers(w http.ResponseWriter, r *http.Request) {
+	groupID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		renderError(w, r, http.StatusBadRequest, "invalid group ID")
+		return
+	}
+	renderGroupMembers(w, r, groupID, "", "")
+}
+
+// membershipError returns the message shown for a failed membership change, or "" if the
+// error is not one the user can fix
+func membershipError(err error) string {
+	switch {
+	case errors.Is(err, database.ErrConflict):
+		return "the device is already in that group"
+	case errors.Is(err, database.ErrNotFound):
+		return "the device or group was not found, or they belong to different clients"
+	}
+	return ""
+}
+
+// Handler for adding a device to a group, from the member editor or by dropping a row of
+// the device table on the group. With from_group_id the device is moved from that group.
+func AddGroupMember(w http.ResponseWriter, r *http.Request) {
+	groupID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		renderError(w, r, http.StatusBadRequest, "invalid group ID")
+		return
+	}
+	hostID, err := strconv.Atoi(r.FormValue("host_id"))
+	if err != nil {
+		renderGroupMembers(w, r, groupID, "select a device", "")
+		return
+	}
+	fromGroupID, _ := strconv.Atoi(r.FormValue("from_group_id"))
+
+	orgID := selectedClient(r)
+	message := "Added."
+	if fromGroupID > 0 && fromGroupID != groupID {
+		err = database.MoveHostToGroup(hostID, fromGroupID, groupID, orgID)
+		message = "Moved."
+	} else {
+		err = database.AddHostToGroup(hostID, groupID, orgID)
+	}
+	changeMembership(w, r, groupID, hostID, err, message)
+}
+
+// Handler for moving a member of a group to another group from the member editor
+func MoveGroupMember(w http.ResponseWriter, r *http.Request) {
+	groupID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		renderError(w, r, http.StatusBadRequest, "invalid group ID")
+		return
+	}
+	hostID, err := strconv.Atoi(r.PathValue("host_id"))
+	if err != nil {
+		renderError(w, r, http.StatusBadRequest, "invalid host ID")
+		return
+	}
+	toGroupID, err := strconv.Atoi(r.FormValue("group_id"))
+	if err != nil || toGroupID == groupID {
+		renderGroupMembers(w, r, groupID, "select another group", "")
+		return
+	}
+
+	err = database.MoveHostToGroup(hostID, groupID, toGroupID, selectedClient(r))
+	changeMembership(w, r, groupID, hostID, err, "Moved.")
+}
+
+// Handler for removing a member of a group from the member editor
+func RemoveGroupMember(w http.ResponseWriter, r *http.Request) {
+	groupID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		renderError(w, r, http.StatusBadRequest, "invalid group ID")
+		return
+	}
+	hostID, err := strconv.Atoi(r.PathValue("host_id"))
+	if err != nil {
+		renderError(w, r, http.StatusBadRequest, "invalid host ID")
+		return
+	}
+
+	err = database.RemoveHostFromGroup(hostID, groupID, selectedClient(r))
+	changeMembership(w, r, groupID, hostID, err, "Removed.")
+}
+
+// changeMembership renders the member editor after a membership change, with the reason
+// it failed if the user can fix it
+func changeMembership(w http.ResponseWriter, r *http.Request, groupID, hostID int, err error, message string) {
+	if err != nil {
+		if reason := membershipError(err); reason != "" {
+			renderGroupMembers(w, r, groupID, reason, "")
+			return
+		}
+		renderError(w, r, http.StatusInternalServerError, "Failed to change group members")
+		log.Println("Failed to change group members:", err)
+		return
+	}
+
+	events.Publish(events.Event{Type: events.AgentUpdated, HostID: int32(hostID)})
+	w.Header().Set("HX-Trigger", "devicesChanged")
+	renderGroupMembers(w, r, groupID, "", message)
 }
@@ -0,0 +1,136 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"slate-rmm/database"
+	"slate-rmm/models"
+	"slate-rmm/remoteaccess"
+	"strconv"
+)
+
+// writeRemoteResponse answers a remote control request. The dashboard opens the url of the
+// response in a new window, or shows its error.
+func writeRemoteResponse(w http.ResponseWriter, status int, body map[string]string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("HX-Trigger", "remoterequest")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+// Handler for starting a remote control session on a device with the provider selected for
+// its client. Every attempt is recorded, and only users allowed by a remote access policy
+// get a session.
+func GetRemoteControlURL(w http.ResponseWriter, r *http.Request) {
+	hostID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || hostID <= 0 {
+		writeRemoteResponse(w, http.StatusBadRequest, map[string]string{"error": "invalid host ID"})
+		return
+	}
+
+	agent, err := database.GetAgent(strconv.Itoa(hostID), selectedClient(r))
+	if err != nil {
+		writeRemoteResponse(w, http.StatusInternalServerError, map[string]string{"error": "could not get agent"})
+		log.Println("Failed to fetch agent:", err)
+		return
+	}
+	if agent == nil {
+		writeRemoteResponse(w, http.StatusNotFound, map[string]string{"error": "agent not found"})
+		return
+	}
+
+	username, allowed, err := authorizeRemoteAccess(r, hostID)
+	if err != nil {
+		writeRemoteResponse(w, http.StatusInternalServerError, map[string]string{"error": "could not check remote access"})
+		log.Println("Failed to check remote access:", err)
+		return
+	}
+	session := models.RemoteSession{HostID: &agent.ID, Hostname: agent.Hostname, Username: username, Provider: remoteaccess.Selected(*agent)}
+	if !allowed {
+		session.Outcome = models.RemoteSessionDenied
+		recordRemoteSession(&session)
+		writeRemoteResponse(w, http.StatusForbidden, map[string]string{"error": "you are not allowed to start remote control of this device"})
+		return
+	}
+
+	sessionURL, err := startRemoteSession(r, agent)
+	if err != nil {
+		session.Outcome, session.Error = models.RemoteSessionFailed, err.Error()
+		recordRemoteSession(&session)
+
+		switch {
+		case errors.Is(err, remoteaccess.ErrNotConfigured):
+			writeRemoteResponse(w, http.StatusServiceUnavailable, map[string]string{"error": "remote control with " + session.Provider + " is not configured"})
+		case errors.Is(err, remoteaccess.ErrInvalidDeviceID):
+			writeRemoteResponse(w, http.StatusConflict, map[string]string{"error": "the device has not reported a valid " + session.Provider + " ID"})
+		case errors.Is(err, remoteaccess.ErrUnknownDevice):
+			writeRemoteResponse(w, http.StatusBadGateway, map[string]string{"error": session.Provider + " does not know this device, it may be offline"})
+		default:
+			writeRemoteResponse(w, http.StatusBadGateway, map[string]string{"error": "could not start a session with " + session.Provider})
+			log.Println("Failed to start remote control:", err)
+		}
+		return
+	}
+
+	// A session that could not be recorded is not handed out
+	session.Outcome = models.RemoteSessionStarted
+	if err := database.RecordRemoteSession(&session); err != nil {
+		writeRemoteResponse(w, http.StatusInternalServerError, map[string]string{"error": "could not record the remote session"})
+		log.Println("Failed to record remote session:", err)
+		return
+	}
+	writeRemoteResponse(w, http.StatusOK, map[string]string{"url": sessionURL})
+}
+
+// authorizeRemoteAccess returns the user of the dashboard making the request, "unknown" when
+// the request did not come through authentik, and whether a remote access policy lets them
+// control a host. The role of the user in the users table counts as one of their
+// authentik groups.
+func authorizeRemoteAccess(r *http.Request, hostID int) (string, bool, error) {
+	username, roles := currentUser(r)
+	if username != "" {
+		role, err := database.GetUserRole(username)
+		if err != nil {
+			return "", false, err
+		}
+		if role != "" {
+			roles = append(roles, role)
+		}
+	}
+
+	allowed, err := database.CanRemoteControl(hostID, roles)
+	return remoteUser(r), allowed, err
+}
+
+// remoteUser returns the user of the dashboard remote sessions are recorded for, "unknown"
+// when the request did not come through authentik
+func remoteUser(r *http.Request) string {
+	if username, _ := currentUser(r); username != "" {
+		return username
+	}
+	return "unknown"
+}
+
+// startRemoteSession starts a session on an agent with the provider selected for its client
+// and returns the URL to join it
+func startRemoteSession(r *http.Request, agent *models.Agent) (string, error) {
+	provider, err := remoteaccess.Lookup(remoteaccess.Selected(*agent))
+	if err != nil {
+		return "", err
+	}
+	deviceID := provider.DeviceID(*agent)
+	if deviceID == "" {
+		return "", remoteaccess.ErrInvalidDeviceID
+	}
+	return provider.SessionURL(r.Context(), deviceID)
+}
+
+// recordRemoteSession records an attempt to start remote control that did not succeed.
+// A failure to record it is only logged, as the request fails anyway.
+func recordRemoteSession(session *models.RemoteSession) {
+	if err := database.RecordRemoteSession(session); err != nil {
+		log.Printf("Failed to record remote session of %s on %s: %v", session.Username, session.Hostname, err)
+	}
+}
@@ -0,0 +1,176 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"slate-rmm/database"
+	"slate-rmm/events"
+	"slate-rmm/models"
+	"sort"
+	"strconv"
+)
+
+// servicesTab is rendered by the services tab of a device. Job is the job last requested
+// from the tab, whose status is shown until another one is requested.
+type servicesTab struct {
+	Agent    *models.Agent
+	Snapshot *models.ServiceSnapshot
+	Job      *models.Job
+	Error    string
+}
+
+// processesTab is rendered by the processes tab of a device, like servicesTab
+type processesTab struct {
+	Agent    *models.Agent
+	Snapshot *models.ProcessSnapshot
+	Job      *models.Job
+	Error    string
+}
+
+// renderServices renders the services the agent of a device last reported. The tab reloads
+// itself when the agent reports them again.
+func renderServices(w http.ResponseWriter, r *http.Request, agent *models.Agent, job *models.Job, errMsg string) {
+	snapshot, err := database.GetServices(int(agent.ID), selectedClient(r))
+	if err != nil {
+		renderError(w, r, http.StatusInternalServerError, "Failed to fetch services")
+		log.Println("Failed to fetch services:", err)
+		return
+	}
+
+	render(w, r, "device-services.html", servicesTab{Agent: agent, Snapshot: snapshot, Job: job, Error: errMsg})
+}
+
+// renderProcesses renders the processes the agent of a device last reported, busiest first.
+// The tab reloads itself when the agent reports them again.
+func renderProcesses(w http.ResponseWriter, r *http.Request, agent *models.Agent, job *models.Job, errMsg string) {
+	snapshot, err := database.GetProcesses(int(agent.ID), selectedClient(r))
+	if err != nil {
+		renderError(w, r, http.StatusInternalServerError, "Failed to fetch processes")
+		log.Println("Failed to fetch processes:", err)
+		return
+	}
+	sort.SliceStable(snapshot.Processes, func(i, j int) bool {
+		return snapshot.Processes[i].CPU > snapshot.Processes[j].CPU
+	})
+
+	render(w, r, "device-processes.html", processesTab{Agent: agent, Snapshot: snapshot, Job: job, Error: errMsg})
+}
+
+// tabJob returns the job in the job query parameter of a tab reloading itself, or nil if it
+// has none or the job is not one of the device
+func tabJob(r *http.Request, agent *models.Agent) *models.Job {
+	id, err := strconv.Atoi(r.URL.Query().Get("job"))
+	if err != nil || id <= 0 {
+		return nil
+	}
+	job, err := database.GetJob(id, selectedClient(r))
+	if err != nil {
+		log.Println("Failed to fetch job:", err)
+		return nil
+	}
+	if job == nil || job.HostID != agent.ID {
+		return nil
+	}
+	return job
+}
+
+// queueJob queues a job for a device, optionally followed by a job of refreshType listing
+// what the first one changed, and returns the first one
+func queueJob(r *http.Request, agent *models.Agent, jobType string, payload interface{}, refreshType string) (*models.Job, error) {
+	var jobID int32
+	var err error
+	if refreshType == "" {
+		jobID, err = database.CreateJob(int(agent.ID), jobType, payload, selectedClient(r))
+	} else {
+		var refreshID int32
+		jobID, refreshID, err = database.CreateJobWithRefresh(int(agent.ID), jobType, payload, refreshType, selectedClient(r))
+		if err == nil {
+			events.Publish(events.Event{Type: events.JobUpdated, HostID: agent.ID, JobID: refreshID, Status: database.JobPending})
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	events.Publish(events.Event{Type: events.JobUpdated, HostID: agent.ID, JobID: jobID, Status: database.JobPending})
+	return database.GetJob(int(jobID), selectedClient(r))
+}
+
+// Handler for asking the agent of a device to list its services again
+func RefreshServices(w http.ResponseWriter, r *http.Request) {
+	agent, ok := loadDevice(w, r)
+	if !ok {
+		return
+	}
+
+	job, err := queueJob(r, agent, database.JobListServices, struct{}{}, "")
+	if err != nil {
+		renderError(w, r, http.StatusInternalServerError, "Failed to request services")
+		log.Println("Failed to queue services job:", err)
+		return
+	}
+	renderServices(w, r, agent, job, "")
+}
+
+// Handler for starting, stopping or restarting a service from the services tab. The services
+// are listed again once the agent has done it.
+func ControlService(w http.ResponseWriter, r *http.Request) {
+	agent, ok := loadDevice(w, r)
+	if !ok {
+		return
+	}
+
+	action := models.ServiceAction{Name: r.FormValue("name"), Action: r.FormValue("action")}
+	if fields := action.Validate(); len(fields) > 0 {
+		renderServices(w, r, agent, nil, "invalid service action")
+		return
+	}
+
+	job, err := queueJob(r, agent, database.JobControlService, action, database.JobListServices)
+	if err != nil {
+		renderError(w, r, http.StatusInternalServerError, "Failed to control service")
+		log.Println("Failed to queue service job:", err)
+		return
+	}
+	renderServices(w, r, agent, job, "")
+}
+
+// Handler for asking the agent of a device to list its processes again
+func RefreshProcesses(w http.ResponseWriter, r *http.Request) {
+	agent, ok := loadDevice(w, r)
+	if !ok {
+		return
+	}
+
+	job, err := queueJob(r, agent, database.JobListProcesses, struct{}{}, "")
+	if err != nil {
+		renderError(w, r, http.StatusInternalServerError, "Failed to request processes")
+		log.Println("Failed to queue processes job:", err)
+		return
+	}
+	renderProcesses(w, r, agent, job, "")
+}
+
+// Handler for terminating a process from the processes tab. The agent checks the process
+// still has the name it was listed with, and the processes are listed again once it is
+// terminated.
+func TerminateProcess(w http.ResponseWriter, r *http.Request) {
+	agent, ok := loadDevice(w, r)
+	if !ok {
+		return
+	}
+
+	pid, err := strconv.Atoi(r.FormValue("pid"))
+	if err != nil || pid <= 0 {
+		renderProcesses(w, r, agent, nil, "invalid process ID")
+		return
+	}
+
+	termination := models.ProcessTermination{PID: int32(pid), Name: r.FormValue("name")}
+	job, err := queueJob(r, agent, database.JobTerminateProcess, termination, database.JobListProcesses)
+	if err != nil {
+		renderError(w, r, http.StatusInternalServerError, "Failed to terminate process")
+		log.Println("Failed to queue process job:", err)
+		return
+	}
+	renderProcesses(w, r, agent, job, "")
+}
@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"slate-rmm/agentconn"
+	"slate-rmm/database"
+	"slate-rmm/events"
+	"slate-rmm/models"
+	"slate-rmm/shell"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/websocket"
+)
+
+// Terminal sizes accepted from the dashboard
+const (
+	defaultCols = 80
+	defaultRows = 24
+	maxCols     = 500
+	maxRows     = 200
+)
+
+// Handler for the remote shell of a device. The terminal of the dashboard connects with a
+// WebSocket; the agent is asked to open the shell with a job and the session is relayed to
+// it once it connects back. Only users allowed by a remote access policy get a shell, and
+// every session is recorded.
+func Shell(w http.ResponseWriter, r *http.Request) {
+	agent, ok := loadDevice(w, r)
+	if !ok {
+		return
+	}
+	if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		renderError(w, r, http.StatusBadRequest, "the shell is opened with a WebSocket")
+		return
+	}
+	// Browsers send the cookies of the dashboard with WebSockets opened by any site
+	if !sameOrigin(r) {
+		renderError(w, r, http.StatusForbidden, "the shell can only be opened from the dashboard")
+		return
+	}
+
+	username, allowed, err := authorizeRemoteAccess(r, int(agent.ID))
+	if err != nil {
+		renderError(w, r, http.StatusInternalServerError, "could not check remote access")
+		log.Println("Failed to check remote access:", err)
+		return
+	}
+	record := models.RemoteSession{HostID: &agent.ID, Hostname: agent.Hostname, Username: username, Provider: database.ShellProvider}
+	if !allowed {
+		record.Outcome = models.RemoteSessionDenied
+		recordRemoteSession(&record)
+		renderError(w, r, http.StatusForbidden, "you are not allowed to open a shell on this device")
+		return
+	}
+
+	// A session that could not be recorded is not opened
+	record.Outcome = models.RemoteSessionStarted
+	if err := database.RecordRemoteSession(&record); err != nil {
+		renderError(w, r, http.StatusInternalServerError, "could not record the remote session")
+		log.Println("Failed to record remote session:", err)
+		return
+	}
+
+	session, err := agentconn.New(database.ShellProvider, record.SessionID, agent.ID, shell.MaxMessageSize)
+	if err != nil {
+		endRemoteSession(record.SessionID, err.Error())
+		renderError(w, r, http.StatusInternalServerError, "could not open the shell")
+		log.Println("Failed to create shell session:", err)
+		return
+	}
+
+	cols, rows := terminalSize(r)
+	request := models.SessionRequest{SessionID: record.SessionID, Token: session.Token, Cols: cols, Rows: rows}
+	jobID, err := database.CreateJob(int(agent.ID), database.JobOpenShell, request, selectedClient(r))
+	if err != nil {
+		session.Close()
+		endRemoteSession(record.SessionID, err.Error())
+		renderError(w, r, http.StatusInternalServerError, "could not open the shell")
+		log.Println("Failed to queue shell job:", err)
+		return
+	}
+	events.Publish(events.Event{Type: events.JobUpdated, HostID: agent.ID, JobID: jobID, Status: database.JobPending})
+
+	websocket.Server{Handler: func(conn *websocket.Conn) {
+		conn.MaxPayloadBytes = shell.MaxMessageSize
+		relayShell(conn, session)
+	}}.ServeHTTP(w, r)
+}
+
+// relayShell waits for the agent to connect, then relays the shell to the terminal of the
+// dashboard until the session is over
+func relayShell(conn *websocket.Conn, session *agentconn.Session) {
+	defer session.Close()
+
+	websocket.JSON.Send(conn, models.ShellMessage{Type: models.ShellStatus, Text: "Waiting for the agent to connect..."})
+	agentConn, err := session.WaitAgent()
+	if err != nil {
+		websocket.JSON.Send(conn, models.ShellMessage{Type: models.ShellError, Text: err.Error()})
+		conn.Close()
+		reason := agentconn.ReasonAgentTimeout
+		if errors.Is(err, agentconn.ErrStopping) {
+			reason = agentconn.ReasonServerStopping
+		}
+		endRemoteSession(session.ID, reason)
+		return
+	}
+
+	endRemoteSession(session.ID, shell.Relay(session.ID, conn, agentConn))
+}
+
+// endShellSession records why a shell session ended. A failure to record it is only logged.
+func endRemoteSession(sessionID int32, reason string) {
+	if err := database.EndRemoteSession(sessionID, reason); err != nil {
+		log.Printf("Failed to end shell session %d: %v", sessionID, err)
+	}
+}
+
+// sameOrigin reports whether the request was made by a page served by this host
+func sameOrigin(r *http.Request) bool {
+	origin, err := url.Parse(r.Header.Get("Origin"))
+	return err == nil && origin.Host != "" && strings.EqualFold(origin.Host, r.Host)
+}
+
+// terminalSize returns the size of the terminal of the dashboard from the cols and rows
+// query parameters, within limits
+func terminalSize(r *http.Request) (int, int) {
+	size := func(name string, fallback, max int) int {
+		n, err := strconv.Atoi(r.URL.Query().Get(name))
+		if err != nil || n <= 0 {
+			return fallback
+		}
+		return min(n, max)
+	}
+	return size("cols", defaultCols, maxCols), size("rows", defaultRows, maxRows)
+}
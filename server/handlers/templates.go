@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"slate-rmm/telemetry"
+	"sync"
+	"time"
+)
+
+// templatePattern selects the templates of the dashboard in the templates directory
+const templatePattern = "*.html"
+
+// templates holds the parsed templates, replaced as a whole when they are reloaded
+var templates struct {
+	sync.RWMutex
+	set *template.Template
+}
+
+// errorFragment is rendered in place of a fragment that failed. It is not loaded from
+// the templates directory so that errors are shown even when the templates are broken.
+var errorFragment = template.Must(template.New("error").Parse(
+	`<div class="htmx-error" role="alert">{{ .Message }}{{ if .RequestID }} <small>(request {{ .RequestID }})</small>{{ end }}</div>`))
+
+// LoadTemplates parses the templates of the dashboard from fsys. On error the templates
+// loaded before are kept.
+func LoadTemplates(fsys fs.FS) error {
+	set, err := template.New("").Funcs(CommonFuncMap).ParseFS(fsys, templatePattern)
+	if err != nil {
+		return err
+	}
+
+	templates.Lock()
+	templates.set = set
+	templates.Unlock()
+	return nil
+}
+
+// WatchTemplates returns a worker that reloads the templates in dir whenever one of them
+// changes, for editing them without restarting the server. It polls every interval.
+func WatchTemplates(dir string, interval time.Duration) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		fsys := os.DirFS(dir)
+		last := templatesVersion(fsys)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-ticker.C:
+			}
+
+			version := templatesVersion(fsys)
+			if version == last {
+				continue
+			}
+			last = version
+
+			if err := LoadTemplates(fsys); err != nil {
+				log.Printf("could not reload templates, keeping the previous ones: %v", err)
+				continue
+			}
+			log.Printf("templates reloaded from %s", dir)
+		}
+	}
+}
+
+// templatesVersion summarizes the names and modification times of the templates,
+// changing whenever one is added, removed or edited
+func templatesVersion(fsys fs.FS) string {
+	names, _ := fs.Glob(fsys, templatePattern)
+	var version bytes.Buffer
+	for _, name := range names {
+		info, err := fs.Stat(fsys, name)
+		if err != nil {
+			continue
+		}
+		fmt.Fprintf(&version, "%s:%d:%d;", name, info.Size(), info.ModTime().UnixNano())
+	}
+	return version.String()
+}
+
+// executeTemplate executes a template into a buffer
+func executeTemplate(name string, data interface{}) (*bytes.Buffer, error) {
+	templates.RLock()
+	set := templates.set
+	templates.RUnlock()
+
+	if set == nil {
+		return nil, fmt.Errorf("templates not loaded")
+	}
+
+	var buf bytes.Buffer
+	if err := set.ExecuteTemplate(&buf, name, data); err != nil {
+		return nil, err
+	}
+	return &buf, nil
+}
+
+// render executes a template into a buffer and writes it, so that a template that fails
+// halfway is replaced by an error fragment instead of a truncated page
+func render(w http.ResponseWriter, r *http.Request, name string, data interface{}) {
+	buf, err := executeTemplate(name, data)
+	if err != nil {
+		log.Printf("could not render %s: %v", name, err)
+		renderError(w, r, http.StatusInternalServerError, "The page could not be rendered.")
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	buf.WriteTo(w)
+}
+
+// renderError responds with an error fragment. The dashboard configures htmx to swap
+// error responses, so the fragment replaces the content that could not be loaded.
+func renderError(w http.ResponseWriter, r *http.Request, status int, message string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+
+	data := struct {
+		Message   string
+		RequestID string
+	}{message, telemetry.RequestID(r.Context())}
+	if err := errorFragment.Execute(w, data); err != nil {
+		log.Printf("could not render error fragment: %v", err)
+	}
+}
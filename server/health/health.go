@@ -0,0 +1,160 @@
+// Package health tracks the background workers of the server and serves the liveness
+// and readiness endpoints used by container healthchecks and the service manager.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Worker states reported by the readiness endpoint
+const (
+	WorkerRunning = "running"
+	WorkerStopped = "stopped"
+	WorkerFailed  = "failed"
+)
+
+// pingTimeout bounds the database check of a readiness probe
+const pingTimeout = 2 * time.Second
+
+// Monitor runs the background workers of the server and reports whether it is ready
+type Monitor struct {
+	// Ping checks the database
+	Ping func(ctx context.Context) error
+
+	ctx      context.Context
+	cancel   context.CancelFunc
+	mu       sync.Mutex
+	workers  map[string]string
+	wg       sync.WaitGroup
+	stopping atomic.Bool
+}
+
+// NewMonitor returns a monitor checking the database with ping
+func NewMonitor(ping func(ctx context.Context) error) *Monitor {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Monitor{Ping: ping, ctx: ctx, cancel: cancel, workers: map[string]string{}}
+}
+
+// Go runs a background worker until Stop is called. A worker returning early is
+// reported as failed, or stopped if it returned nil, and the server is no longer ready.
+func (m *Monitor) Go(name string, run func(ctx context.Context) error) {
+	ctx := m.ctx
+	m.setWorker(name, WorkerRunning)
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		err := run(ctx)
+		if err != nil && ctx.Err() == nil {
+			log.Printf("worker %s failed: %v", name, err)
+			m.setWorker(name, WorkerFailed)
+			return
+		}
+		m.setWorker(name, WorkerStopped)
+	}()
+}
+
+func (m *Monitor) setWorker(name, state string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.workers[name] = state
+}
+
+// Stopping marks the server as shutting down, so load balancers stop sending it requests
+func (m *Monitor) Stopping() {
+	m.stopping.Store(true)
+}
+
+// Stop cancels the workers and waits until every one has returned, or ctx is done
+func (m *Monitor) Stop(ctx context.Context) error {
+	m.cancel()
+	done := make(chan struct{})
+	go func() {
+		m.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Report is the body of the readiness endpoint
+type Report struct {
+	Status   string            `json:"status"`
+	Database string            `json:"database"`
+	Workers  map[string]string `json:"workers"`
+}
+
+// Ready checks the database and the workers
+func (m *Monitor) Ready(ctx context.Context) (Report, bool) {
+	report := Report{Status: "ready", Database: "ok", Workers: map[string]string{}}
+	ready := true
+
+	if m.stopping.Load() {
+		report.Status = "stopping"
+		ready = false
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, pingTimeout)
+	defer cancel()
+	if err := m.Ping(ctx); err != nil {
+		report.Database = err.Error()
+		ready = false
+	}
+
+	m.mu.Lock()
+	names := make([]string, 0, len(m.workers))
+	for name := range m.workers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		report.Workers[name] = m.workers[name]
+		if m.workers[name] != WorkerRunning && !m.stopping.Load() {
+			ready = false
+		}
+	}
+	m.mu.Unlock()
+
+	if !ready && report.Status == "ready" {
+		report.Status = "unavailable"
+	}
+	return report, ready
+}
+
+// Handler serves /healthz and /readyz, passing other requests to next. The endpoints
+// are answered before next so that they need neither the API key nor the proxy.
+func (m *Monitor) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/healthz":
+			// The process is alive as long as it answers
+			writeReport(w, http.StatusOK, map[string]string{"status": "ok"})
+		case "/readyz":
+			report, ready := m.Ready(r.Context())
+			status := http.StatusOK
+			if !ready {
+				status = http.StatusServiceUnavailable
+			}
+			writeReport(w, status, report)
+		default:
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+func writeReport(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
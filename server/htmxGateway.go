@@ -1,10 +1,32 @@
 package main
 
 import (
+	"io/fs"
 	"net/http"
+	"os"
 	"slate-rmm/handlers"
 )
 
+// templatesDir holds the dashboard templates, relative to the working directory
+const templatesDir = "templates"
+
+// embeddedTemplates is set when the binary is built with the embed_templates tag
+var embeddedTemplates fs.FS
+
+// devMode reloads the templates from templatesDir whenever they are edited
+func devMode() bool {
+	return os.Getenv("NEXUS_DEV") == "true"
+}
+
+// templateFS returns the templates to load: the embedded ones, unless in development
+// mode or built without them
+func templateFS() fs.FS {
+	if embeddedTemplates != nil && !devMode() {
+		return embeddedTemplates
+	}
+	return os.DirFS(templatesDir)
+}
+
 // NewHTMXGateway creates a new router and defines the routes for the HTMX gateway
 func NewHTMXGateway() *http.ServeMux {
 	router := http.NewServeMux()
@@ -22,7 +44,41 @@ func NewHTMXGateway() *http.ServeMux {
 	// HTMX routes
 	router.HandleFunc("/htmx/get-devices", handlers.GetDevices)
 	router.HandleFunc("/htmx/get-groups", handlers.GetGroups)
-	router.HandleFunc("/htmx/remoterequest/{id}", handlers.GetRemoteControlURL)
+	router.HandleFunc("/htmx/get-clients", handlers.GetClients)
+	router.HandleFunc("/htmx/select-client", handlers.SelectClient)
+	router.HandleFunc("/htmx/get-bulk-toolbar", handlers.GetBulkToolbar)
+	router.HandleFunc("/htmx/bulk", handlers.BulkAction)
+	router.HandleFunc("/htmx/device-fields/{id}", handlers.DeviceFields)
+	router.HandleFunc("/htmx/custom-fields", handlers.CustomFields)
+	router.HandleFunc("DELETE /htmx/custom-fields/{id}", handlers.DeleteCustomField)
+	router.HandleFunc("GET /htmx/remoterequest/{id}", handlers.GetRemoteControlURL)
+	router.HandleFunc("GET /htmx/device/{id}", handlers.DeviceDetail)
+	router.HandleFunc("GET /htmx/device/{id}/{tab}", handlers.DeviceTab)
+	router.HandleFunc("GET /htmx/device/{id}/shell/connect", handlers.Shell)
+	router.HandleFunc("POST /htmx/device/{id}/files/open", handlers.OpenFiles)
+	router.HandleFunc("POST /htmx/device/{id}/files/close", handlers.CloseFiles)
+	router.HandleFunc("GET /htmx/device/{id}/files/download", handlers.DownloadFile)
+	router.HandleFunc("GET /htmx/device/{id}/files/upload", handlers.UploadStatus)
+	router.HandleFunc("PUT /htmx/device/{id}/files/upload", handlers.UploadChunk)
+	router.HandleFunc("GET /htmx/device/{id}/checks/{check_id}", handlers.CheckHistory)
+	router.HandleFunc("POST /htmx/device/{id}/services/refresh", handlers.RefreshServices)
+	router.HandleFunc("POST /htmx/device/{id}/services/control", handlers.ControlService)
+	router.HandleFunc("POST /htmx/device/{id}/processes/refresh", handlers.RefreshProcesses)
+	router.HandleFunc("POST /htmx/device/{id}/processes/terminate", handlers.TerminateProcess)
+	router.HandleFunc("POST /htmx/device/{id}/rename", handlers.RenameDevice)
+	router.HandleFunc("POST /htmx/device/{id}/move", handlers.MoveDeviceGroup)
+	router.HandleFunc("DELETE /htmx/device/{id}", handlers.DeleteDevice)
+	router.HandleFunc("GET /htmx/groups", handlers.GroupEditor)
+	router.HandleFunc("POST /htmx/groups", handlers.CreateGroup)
+	router.HandleFunc("POST /htmx/groups/{id}/rename", handlers.RenameGroup)
+	router.HandleFunc("DELETE /htmx/groups/{id}", handlers.DeleteGroup)
+	router.HandleFunc("GET /htmx/groups/{id}/members", handlers.GroupMembers)
+	router.HandleFunc("POST /htmx/groups/{id}/members", handlers.AddGroupMember)
+	router.HandleFunc("POST /htmx/groups/{id}/members/{host_id}/move", handlers.MoveGroupMember)
+	router.HandleFunc("DELETE /htmx/groups/{id}/members/{host_id}", handlers.RemoveGroupMember)
+	router.HandleFunc("GET /htmx/device-row/{id}", handlers.DeviceRow)
+	router.HandleFunc("GET /htmx/job/{id}", handlers.JobStatus)
+	router.HandleFunc("GET /htmx/events", handlers.Events)
 
 	return router
 }
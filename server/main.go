@@ -1,18 +1,38 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"log/slog"
 	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"slate-rmm/agentconn"
+	"slate-rmm/api_handlers"
 	"slate-rmm/database"
+	"slate-rmm/events"
+	"slate-rmm/handlers"
+	"slate-rmm/health"
+	"slate-rmm/remoteaccess"
+	"slate-rmm/remotely"
+	"slate-rmm/telemetry"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/joho/godotenv"
 )
 
+// Shutdown limits
+const (
+	// dbStartupTimeout is how long startup waits for the database before giving up
+	dbStartupTimeout = 2 * time.Minute
+	// shutdownTimeout is how long in-flight requests and workers get to finish on SIGTERM
+	shutdownTimeout = 20 * time.Second
+)
+
 func main() {
 	// Load environment variables from .env file
 	err := godotenv.Load()
@@ -20,9 +40,44 @@ func main() {
 		log.Fatal("Error loading .env file")
 	}
 
-	// Initialize the database connection
+	// Log as JSON lines and export traces when an OTLP endpoint is configured
+	telemetry.SetupLogging()
+	shutdownTracing, err := telemetry.SetupTracing(context.Background())
+	if err != nil {
+		log.Fatalf("could not set up tracing: %v", err)
+	}
+
+	// SIGINT and SIGTERM start a graceful shutdown
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Initialize the database connection, waiting for it while the database container starts
 	dsn := "host=localhost user=" + os.Getenv("PG_USER") + " password=" + os.Getenv("PG_PASS") + " dbname=" + os.Getenv("PG_DB") + " sslmode=disable"
-	database.InitDB(dsn)
+	if err := database.ConnectWithRetry(ctx, dsn, dbStartupTimeout); err != nil {
+		log.Fatalf("could not connect to the database: %v", err)
+	}
+	if err := telemetry.Register(database.Collectors()...); err != nil {
+		log.Printf("could not register database metrics: %v", err)
+	}
+
+	// Background workers are started with monitor.Go and reported by /readyz
+	monitor := health.NewMonitor(database.Ping)
+
+	// The templates are parsed once, and reloaded when edited in development mode
+	if err := handlers.LoadTemplates(templateFS()); err != nil {
+		log.Fatalf("could not load templates: %v", err)
+	}
+	if devMode() {
+		monitor.Go("template-reload", handlers.WatchTemplates(templatesDir, time.Second))
+	}
+	startWorkers(monitor)
+
+	// Remote control through a provider is disabled until the provider is configured
+	if config, err := remotely.ConfigFromEnv(); err != nil {
+		log.Printf("remote control with remotely disabled: %v", err)
+	} else {
+		remoteaccess.Register(remotely.NewClient(config))
+	}
 
 	// Create a new API router
 	apiRouter := NewGateway()
@@ -30,53 +85,74 @@ func main() {
 	// Create a new router for the HTMX gateway
 	htmxRouter := NewHTMXGateway()
 
-	var wg sync.WaitGroup
-	wg.Add(2)
-
-	// Start the API server on port 8123
-	go func() {
-		defer wg.Done()
-		fmt.Println("Starting API server on port 8123...")
-		srv := &http.Server{
+	// The health endpoints are answered first so that probes are neither authenticated nor logged
+	servers := []*http.Server{
+		{
 			Addr:    ":8123",
-			Handler: APIMiddleware(apiRouter),
-		}
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Printf("API server failed: %v", err)
-		}
-	}()
-
-	// Start the HTMX server on port 8080
-	go func() {
-		defer wg.Done()
-		fmt.Println("Starting HTMX server on port 8080...")
-		srv := &http.Server{
+			Handler: monitor.Handler(telemetry.Middleware("api", APIMiddleware(apiRouter))),
+		},
+		{
 			Addr:    ":8080",
-			Handler: CORSMiddleware(htmxRouter),
-		}
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Printf("HTMX server failed: %v", err)
-		}
-	}()
+			Handler: monitor.Handler(telemetry.Middleware("htmx", CORSMiddleware(telemetry.TraceHandler("htmx", telemetry.ServeMuxRoutes(htmxRouter))))),
+		},
+	}
 
-	// Wait a moment for servers to start
-	time.Sleep(time.Second)
+	// The event streams of the dashboard stay open until the bus is closed, which would
+	// otherwise hold the shutdown of the HTMX server until it times out
+	servers[1].RegisterOnShutdown(events.Close)
+	// Remote shells and file sessions are hijacked connections, which the servers do not
+	// close themselves
+	servers[1].RegisterOnShutdown(agentconn.Shutdown)
+
+	// A listener that fails also stops the server, so the service manager restarts it
+	failed := make(chan error, len(servers))
+	for _, srv := range servers {
+		go func() {
+			slog.Info("starting server", "addr", srv.Addr)
+			if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				failed <- fmt.Errorf("server on %s failed: %w", srv.Addr, err)
+			}
+		}()
+	}
+
+	exitCode := 0
+	select {
+	case <-ctx.Done():
+		slog.Info("shutting down")
+	case err := <-failed:
+		log.Print(err)
+		exitCode = 1
+	}
 
-	// Check if servers are responsive
-	checkServer("http://localhost:8123")
-	checkServer("http://localhost:8080")
+	// Fail readiness first, then drain the listeners, then stop the workers
+	monitor.Stopping()
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
 
+	var wg sync.WaitGroup
+	for _, srv := range servers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := srv.Shutdown(shutdownCtx); err != nil {
+				log.Printf("could not shut down server on %s: %v", srv.Addr, err)
+			}
+		}()
+	}
 	wg.Wait()
-}
 
-func checkServer(url string) {
-	resp, err := http.Get(url)
-	if err != nil {
-		log.Printf("Error checking %s: %v", url, err)
-		return
+	if err := monitor.Stop(shutdownCtx); err != nil {
+		log.Printf("workers did not stop in time: %v", err)
+	}
+	if err := shutdownTracing(shutdownCtx); err != nil {
+		log.Printf("could not flush traces: %v", err)
 	}
-	defer resp.Body.Close()
-	log.Printf("%s is responsive. Status: %s", url, resp.Status)
+	if err := database.Close(); err != nil {
+		log.Printf("could not close the database: %v", err)
+	}
+
+	slog.Info("server stopped")
+	os.Exit(exitCode)
 }
 
 func CORSMiddleware(next http.Handler) http.Handler {
@@ -98,12 +174,10 @@ func CORSMiddleware(next http.Handler) http.Handler {
 		// 	return
 		// }
 
-		log.Printf("Received %s request to %s", r.Method, r.URL.Path)
-
 		// Set the headers
 		w.Header().Set("Access-Control-Allow-Origin", "*")
 		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Access-Control-Allow-Headers, Authorization, X-Requested-With")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Access-Control-Allow-Headers, Authorization, X-Requested-With, X-Nexus-Client")
 
 		// If it's just an OPTIONS request, we don't need to go any further
 		if r.Method == "OPTIONS" {
@@ -125,18 +199,18 @@ func APIMiddleware(next http.Handler) http.Handler {
 		ip, _, err := net.SplitHostPort(r.RemoteAddr)
 
 		if err != nil {
-			http.Error(w, "Invalid request", http.StatusBadRequest)
+			api_handlers.WriteError(w, http.StatusBadRequest, api_handlers.CodeInvalidRequest, "invalid remote address")
 			return
 		}
 
 		if ip != "172.20.0.252" {
-			http.Error(w, "Forbidden", http.StatusForbidden)
+			api_handlers.WriteError(w, http.StatusForbidden, api_handlers.CodeForbidden, "forbidden")
 			return
 		}
 
 		err = godotenv.Load()
 		if err != nil {
-			http.Error(w, "could not load .env file", http.StatusInternalServerError)
+			api_handlers.WriteError(w, http.StatusInternalServerError, api_handlers.CodeInternal, "could not load .env file")
 			return
 		}
 		apiKey := os.Getenv("NEXUS_API_KEY")
@@ -149,16 +223,17 @@ func APIMiddleware(next http.Handler) http.Handler {
 		// log.Printf("Received Authorization header: %s", authorizationHeader)
 
 		if authorizationHeader != "Bearer "+apiKey {
-			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			api_handlers.WriteError(w, http.StatusUnauthorized, api_handlers.CodeUnauthorized, "unauthorized")
 			return
 		}
 
-		log.Printf("Received %s request to %s", r.Method, r.URL.Path)
+		// Agent protocol routes refine the actor with the host ID
+		telemetry.SetActor(r, "api")
 
 		// Set the headers
 		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Access-Control-Allow-Headers, Authorization, X-Requested-With")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Access-Control-Allow-Headers, Authorization, X-Requested-With, X-Nexus-Client")
 
 		// Call the next handler
 		next.ServeHTTP(w, r)
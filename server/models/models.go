@@ -1,6 +1,223 @@
 package models
 
-import "time"
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// InventoryUpdate is the inventory an agent reports. Only the fields present in the
+// payload are changed; a nil field keeps the value stored on the server.
+type InventoryUpdate struct {
+	Hostname      *string   `json:"hostname"`
+	IPAddress     *string   `json:"ip_address"`
+	OS            *string   `json:"os"`
+	OSVersion     *string   `json:"os_version"`
+	HardwareSpecs *Hardware `json:"hardware_specs"`
+	AgentVersion  *string   `json:"agent_version"`
+	LastUser      *string   `json:"last_user"`
+	// RemotelyID is sent by agents that predate RemoteIDs
+	RemotelyID *string `json:"remotely_id"`
+	// RemoteIDs replaces the device IDs of every remote-access provider
+	RemoteIDs map[string]string `json:"remote_ids"`
+}
+
+// Collectors that can be turned off in a configuration profile
+var AgentCollectors = []string{"storage", "last_user", "remotely", "services", "processes", "event_logs"}
+
+// Log levels understood by the agent
+var AgentLogLevels = []string{"debug", "info", "warn", "error"}
+
+// Types of the event logs collected by the agents
+const (
+	EventLogWindows  = "windows"
+	EventLogJournald = "journald"
+	EventLogFile     = "file"
+)
+
+// EventLevels are the levels of the event log entries, from the most severe
+var EventLevels = []string{"critical", "error", "warning", "information", "verbose"}
+
+// maxEventLogSources limits the event logs a profile collects
+const maxEventLogSources = 32
+
+// EventLogSource is a log the agents collect entries from: the Windows event log channel
+// named by Name, the journal of the systemd unit named by Name or of every unit without one,
+// or the syslog file at the path Name. Only the entries with one of Levels and one of
+// EventIDs are collected when they are set. Lines of files have no level, and only Windows
+// entries have an event ID.
+type EventLogSource struct {
+	Type     string   `json:"type" enum:"windows,journald,file"`
+	Name     string   `json:"name,omitempty"`
+	Levels   []string `json:"levels,omitempty"`
+	EventIDs []int    `json:"event_ids,omitempty"`
+}
+
+// validate returns the reason each field of the source is rejected, keyed under prefix
+func (s EventLogSource) validate(prefix string, fields map[string]string) {
+	switch s.Type {
+	case EventLogWindows:
+		if s.Name == "" {
+			fields[prefix+".name"] = "name is required"
+		}
+	case EventLogJournald:
+	case EventLogFile:
+		if !strings.HasPrefix(s.Name, "/") {
+			fields[prefix+".name"] = "name must be an absolute path"
+		}
+		if len(s.Levels) > 0 {
+			fields[prefix+".levels"] = "lines of files have no level"
+		}
+	default:
+		fields[prefix+".type"] = "type must be windows, journald or file"
+	}
+	for _, level := range s.Levels {
+		if !slices.Contains(EventLevels, level) {
+			fields[prefix+".levels"] = "levels must be among " + strings.Join(EventLevels, ", ")
+		}
+	}
+	if len(s.EventIDs) > 0 && s.Type != EventLogWindows {
+		fields[prefix+".event_ids"] = "only Windows entries have an event ID"
+	}
+	if len(s.EventIDs) > 64 {
+		fields[prefix+".event_ids"] = "at most 64 event IDs can be selected"
+	}
+	for _, id := range s.EventIDs {
+		if id < 0 || id > 65535 {
+			fields[prefix+".event_ids"] = "event IDs must be between 0 and 65535"
+		}
+	}
+}
+
+// AgentConfig is the configuration the server delivers to agents. In a profile, unset
+// fields are inherited from the profiles below it and ultimately from DefaultAgentConfig.
+type AgentConfig struct {
+	// HeartbeatInterval and InventoryInterval are in seconds
+	HeartbeatInterval int             `json:"heartbeat_interval,omitempty"`
+	InventoryInterval int             `json:"inventory_interval,omitempty"`
+	LogLevel          string          `json:"log_level,omitempty"`
+	ServerURL         string          `json:"server_url,omitempty"`
+	Collectors        map[string]bool `json:"collectors,omitempty"`
+	// EventLogs replaces the event logs of the profiles below when set. They are collected
+	// unless the event_logs collector is turned off.
+	EventLogs []EventLogSource `json:"event_logs,omitempty"`
+}
+
+// DefaultAgentConfig returns the configuration of agents without a profile.
+// An empty server URL keeps the one the agent was installed with.
+func DefaultAgentConfig() AgentConfig {
+	collectors := make(map[string]bool, len(AgentCollectors))
+	for _, name := range AgentCollectors {
+		collectors[name] = true
+	}
+	return AgentConfig{
+		HeartbeatInterval: 60,
+		InventoryInterval: 900,
+		LogLevel:          "info",
+		Collectors:        collectors,
+		EventLogs: []EventLogSource{
+			{Type: EventLogWindows, Name: "System", Levels: []string{"critical", "error", "warning"}},
+			{Type: EventLogWindows, Name: "Application", Levels: []string{"critical", "error"}},
+			{Type: EventLogJournald, Levels: []string{"critical", "error"}},
+		},
+	}
+}
+
+// Validate returns the reason each field of a profile is rejected
+func (c AgentConfig) Validate() map[string]string {
+	fields := map[string]string{}
+	if c.HeartbeatInterval != 0 && (c.HeartbeatInterval < 10 || c.HeartbeatInterval > 3600) {
+		fields["heartbeat_interval"] = "heartbeat_interval must be between 10 and 3600 seconds"
+	}
+	if c.InventoryInterval != 0 && (c.InventoryInterval < 60 || c.InventoryInterval > 86400) {
+		fields["inventory_interval"] = "inventory_interval must be between 60 and 86400 seconds"
+	}
+	if c.LogLevel != "" && !slices.Contains(AgentLogLevels, c.LogLevel) {
+		fields["log_level"] = "log_level must be one of " + strings.Join(AgentLogLevels, ", ")
+	}
+	if c.ServerURL != "" {
+		u, err := url.Parse(c.ServerURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			fields["server_url"] = "server_url must be an absolute http or https URL"
+		}
+	}
+	for name := range c.Collectors {
+		if !slices.Contains(AgentCollectors, name) {
+			fields["collectors"] = fmt.Sprintf("unknown collector %q", name)
+		}
+	}
+	if len(c.EventLogs) > maxEventLogSources {
+		fields["event_logs"] = fmt.Sprintf("at most %d event logs can be collected", maxEventLogSources)
+	}
+	for i, source := range c.EventLogs {
+		source.validate(fmt.Sprintf("event_logs[%d]", i), fields)
+	}
+	return fields
+}
+
+// Apply overrides the fields of c that are set in profile and records source as
+// the origin of each one in sources
+func (c *AgentConfig) Apply(profile AgentConfig, source string, sources map[string]string) {
+	if profile.HeartbeatInterval != 0 {
+		c.HeartbeatInterval = profile.HeartbeatInterval
+		sources["heartbeat_interval"] = source
+	}
+	if profile.InventoryInterval != 0 {
+		c.InventoryInterval = profile.InventoryInterval
+		sources["inventory_interval"] = source
+	}
+	if profile.LogLevel != "" {
+		c.LogLevel = profile.LogLevel
+		sources["log_level"] = source
+	}
+	if profile.ServerURL != "" {
+		c.ServerURL = profile.ServerURL
+		sources["server_url"] = source
+	}
+	for name, enabled := range profile.Collectors {
+		c.Collectors[name] = enabled
+		sources["collectors."+name] = source
+	}
+	if len(profile.EventLogs) > 0 {
+		c.EventLogs = profile.EventLogs
+		sources["event_logs"] = source
+	}
+}
+
+// ConfigProfile is a named agent configuration assigned to groups or hosts
+type ConfigProfile struct {
+	ProfileID   int32       `json:"profile_id"`
+	ProfileName string      `json:"profile_name"`
+	Config      AgentConfig `json:"config"`
+}
+
+// EffectiveConfig is the configuration an agent runs with, and where each field comes from:
+// "default", "group:<group name>" or "host"
+type EffectiveConfig struct {
+	Config  AgentConfig       `json:"config"`
+	Sources map[string]string `json:"sources"`
+}
+
+// HeartbeatResponse is returned to agents on every heartbeat, with the enabled checks of
+// the groups of the host
+type HeartbeatResponse struct {
+	Config AgentConfig `json:"config"`
+	Checks []Check     `json:"checks"`
+}
+
+// ProtocolUsage counts the hosts still speaking an agent protocol version
+type ProtocolUsage struct {
+	ProtocolVersion int       `json:"protocol_version"`
+	Hosts           int       `json:"hosts"`
+	OldestLastSeen  time.Time `json:"oldest_last_seen"`
+	Supported       bool      `json:"supported"`
+}
 
 type Hardware struct {
 	CPU     string `json:"cpu"`
@@ -23,10 +240,815 @@ type Agent struct {
 	Status        string    `json:"status"`
 	Group         string    `json:"group"`
 	RemotelyID    string    `json:"remotely_id"`
+	// RemoteIDs are the IDs the remote-access providers know the host by, keyed by provider
+	RemoteIDs map[string]string `json:"remote_ids"`
+	// ProtocolVersion is the agent protocol version the host last spoke
+	ProtocolVersion int `json:"protocol_version"`
+	// DisplayName is set by admins to show instead of the hostname
+	DisplayName   string `json:"display_name,omitempty"`
+	EnrollmentKey string `json:"enrollment_key,omitempty"`
+	SiteID        int32  `json:"site_id"`
+	SiteName      string `json:"site_name"`
+	OrgID         int32  `json:"org_id"`
+	OrgName       string `json:"org_name"`
+	Domain        string `json:"domain"`
+	// RemoteAccessProvider is the provider selected by the site or organization settings,
+	// empty for the default
+	RemoteAccessProvider string            `json:"remote_access_provider,omitempty"`
+	Tags                 map[string]string `json:"tags"`
+	CustomFields         map[string]string `json:"custom_fields"`
+	// Config is the effective configuration of the agent, only set on single agent responses
+	Config *EffectiveConfig `json:"config,omitempty"`
+}
+
+// Name returns the name the dashboard shows for the agent
+func (a Agent) Name() string {
+	if a.DisplayName != "" {
+		return a.DisplayName
+	}
+	return a.Hostname
+}
+
+// StatusPeriod is a stretch of time an agent was online or offline, derived from its heartbeats
+type StatusPeriod struct {
+	Status string    `json:"status"`
+	From   time.Time `json:"from"`
+	To     time.Time `json:"to"`
+}
+
+// UserSession is a user reported logged in on an agent, from Since until the next user was reported
+type UserSession struct {
+	Username string     `json:"username"`
+	Since    time.Time  `json:"since"`
+	Until    *time.Time `json:"until,omitempty"`
+}
+
+// Outcomes of an attempt to start remote control
+const (
+	RemoteSessionStarted = "started"
+	RemoteSessionDenied  = "denied"
+	RemoteSessionFailed  = "failed"
+)
+
+// RemoteID returns the ID a remote-access provider knows the agent by, or "" if the agent
+// has not reported one. Agents that predate RemoteIDs only report their Remotely ID.
+func (a Agent) RemoteID(provider string) string {
+	if id := a.RemoteIDs[provider]; id != "" {
+		return id
+	}
+	if provider == "remotely" {
+		return a.RemotelyID
+	}
+	return ""
+}
+
+// RemoteAccessProvider reports a configured remote-access provider and whether it is healthy
+type RemoteAccessProvider struct {
+	Name    string `json:"name"`
+	Healthy bool   `json:"healthy"`
+	Error   string `json:"error,omitempty"`
+}
+
+// RemoteSession records who tried to start remote control of a host and what happened.
+// HostID is unset once the host is deleted; the hostname is kept.
+type RemoteSession struct {
+	SessionID int32     `json:"session_id"`
+	HostID    *int32    `json:"host_id,omitempty"`
+	Hostname  string    `json:"hostname"`
+	Username  string    `json:"username"`
+	Provider  string    `json:"provider"`
+	Outcome   string    `json:"outcome"`
+	Error     string    `json:"error,omitempty"`
+	StartedAt time.Time `json:"started_at"`
+	// EndedAt and EndReason are set once a remote shell session is over
+	EndedAt   *time.Time `json:"ended_at,omitempty"`
+	EndReason string     `json:"end_reason,omitempty"`
+}
+
+// Types of the messages exchanged over a remote shell connection
+const (
+	// ShellInput carries keystrokes from the dashboard to the shell
+	ShellInput = "input"
+	// ShellOutput carries what the shell wrote to its terminal
+	ShellOutput = "output"
+	// ShellResize sets the size of the terminal
+	ShellResize = "resize"
+	// ShellStatus tells the dashboard how the session is progressing
+	ShellStatus = "status"
+	// ShellExit ends the session when the shell exits
+	ShellExit = "exit"
+	// ShellError ends the session with an error
+	ShellError = "error"
+	// ShellPing keeps idle connections open through proxies and is ignored
+	ShellPing = "ping"
+)
+
+// ShellMessage is exchanged with the dashboard and the agent over a remote shell connection
+type ShellMessage struct {
+	Type string `json:"type"`
+	Data []byte `json:"data,omitempty"`
+	Cols int    `json:"cols,omitempty"`
+	Rows int    `json:"rows,omitempty"`
+	Text string `json:"text,omitempty"`
+}
+
+// SessionRequest is the payload of the open_shell and open_files jobs. The agent connects
+// back to the server for the session with the token. The size of the terminal is only set
+// for shells.
+type SessionRequest struct {
+	SessionID int32  `json:"session_id"`
+	Token     string `json:"token"`
+	Cols      int    `json:"cols,omitempty"`
+	Rows      int    `json:"rows,omitempty"`
+}
+
+// ShellChunk is part of the recording of a remote shell session: the input typed in the
+// dashboard or the output of the shell
+type ShellChunk struct {
+	At        time.Time `json:"at"`
+	Direction string    `json:"direction"`
+	Data      []byte    `json:"data"`
+}
+
+// Operations of the file sessions, sent to the agent in FileRequest and recorded in
+// FileOperation
+const (
+	// FileList lists a page of a directory, or the drives of the device for an empty path
+	FileList = "list"
+	// FileStat returns the entry of a file
+	FileStat = "stat"
+	// FileRead reads a chunk of a file
+	FileRead = "read"
+	// FileWrite writes a chunk of an upload
+	FileWrite = "write"
+	// FileUploaded returns how much of an upload the agent has received so far
+	FileUploaded = "uploaded"
+	// FilePing keeps idle connections open through proxies and is not answered
+	FilePing = "ping"
+	// FileDownload and FileUpload are the recorded transfers, made of reads and writes
+	FileDownload = "download"
+	FileUpload   = "upload"
+)
+
+// FileRequest is sent to the agent over a file session. The agent answers each request with
+// the FileResponse of the same ID. A listing returns Length entries of the directory from
+// the entry at Offset, so that large directories fit in the messages of the session.
+type FileRequest struct {
+	ID     int64  `json:"id"`
+	Op     string `json:"op"`
+	Path   string `json:"path"`
+	Offset int64  `json:"offset,omitempty"`
+	Length int    `json:"length,omitempty"`
+	// Size is the size of the whole file being uploaded
+	Size int64  `json:"size,omitempty"`
+	Data []byte `json:"data,omitempty"`
+	// SHA256 is the hex digest of Data
+	SHA256 string `json:"sha256,omitempty"`
+}
+
+// FileResponse answers a FileRequest. Size is the size of a stat, or how much of an upload
+// the agent has received, which lets an interrupted upload resume. Total is the number of
+// entries of a listed directory, of which Entries is a page.
+type FileResponse struct {
+	ID      int64       `json:"id"`
+	Error   string      `json:"error,omitempty"`
+	Entries []FileEntry `json:"entries,omitempty"`
+	Total   int         `json:"total,omitempty"`
+	Entry   *FileEntry  `json:"entry,omitempty"`
+	Data    []byte      `json:"data,omitempty"`
+	SHA256  string      `json:"sha256,omitempty"`
+	Size    int64       `json:"size,omitempty"`
+}
+
+// FileEntry is a file, directory or drive of a device
+type FileEntry struct {
+	Name    string    `json:"name"`
+	Path    string    `json:"path"`
+	Dir     bool      `json:"dir"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+}
+
+// Outcomes of the recorded file operations
+const (
+	FileOperationCompleted = "completed"
+	FileOperationFailed    = "failed"
+)
+
+// FileOperation records a listing or a transfer made during a file session
+type FileOperation struct {
+	OperationID int64     `json:"operation_id"`
+	SessionID   int32     `json:"session_id"`
+	Operation   string    `json:"operation"`
+	Path        string    `json:"path"`
+	Bytes       int64     `json:"bytes"`
+	Outcome     string    `json:"outcome"`
+	Error       string    `json:"error,omitempty"`
+	At          time.Time `json:"at"`
+}
+
+// Service is a Windows service of a host, as listed by its agent. State is running,
+// stopped or one of the pending states; StartType is automatic, automatic_delayed, manual,
+// disabled, boot or system.
+type Service struct {
+	Name        string `json:"name"`
+	DisplayName string `json:"display_name"`
+	State       string `json:"state"`
+	StartType   string `json:"start_type"`
+	PID         uint32 `json:"pid,omitempty"`
+}
+
+// Process is a process running on a host, as listed by its agent. CPU is the share of all
+// the processors it used in percent, and Memory its working set in bytes.
+type Process struct {
+	PID    int32   `json:"pid"`
+	Name   string  `json:"name"`
+	CPU    float64 `json:"cpu_percent"`
+	Memory int64   `json:"memory_bytes"`
+	User   string  `json:"user,omitempty"`
+}
+
+// ServiceSnapshot is the list of services an agent last reported. CollectedAt is unset
+// until the agent has reported one.
+type ServiceSnapshot struct {
+	HostID      int32      `json:"host_id"`
+	CollectedAt *time.Time `json:"collected_at"`
+	Services    []Service  `json:"services"`
+}
+
+// ProcessSnapshot is the list of processes an agent last reported. CollectedAt is unset
+// until the agent has reported one.
+type ProcessSnapshot struct {
+	HostID      int32      `json:"host_id"`
+	CollectedAt *time.Time `json:"collected_at"`
+	Processes   []Process  `json:"processes"`
+}
+
+// Actions of a control_service job
+const (
+	ServiceStart   = "start"
+	ServiceStop    = "stop"
+	ServiceRestart = "restart"
+)
+
+// AgentService is the service the agent runs as. It is not stopped or restarted through a
+// job: the agent would stop with it and could not start it again.
+const AgentService = "SlateNexusAgent"
+
+// ServiceAction is the payload of a control_service job
+type ServiceAction struct {
+	Name   string `json:"name"`
+	Action string `json:"action"`
+}
+
+// Validate returns the reason each invalid field of the action is rejected
+func (a ServiceAction) Validate() map[string]string {
+	fields := map[string]string{}
+	if a.Name == "" || len(a.Name) > 256 {
+		fields["name"] = "name is required and limited to 256 characters"
+	}
+	if a.Action != ServiceStart && a.Action != ServiceStop && a.Action != ServiceRestart {
+		fields["action"] = "action must be start, stop or restart"
+	} else if a.Action != ServiceStart && strings.EqualFold(a.Name, AgentService) {
+		fields["name"] = "the agent service cannot be stopped or restarted"
+	}
+	return fields
+}
+
+// ProcessTermination is the payload of a terminate_process job. The agent only terminates
+// the process if it still has the name it was listed with, in case its PID was reused.
+type ProcessTermination struct {
+	PID  int32  `json:"pid"`
+	Name string `json:"name,omitempty"`
+}
+
+// Types of monitoring checks
+const (
+	CheckDisk     = "disk"
+	CheckService  = "service"
+	CheckProcess  = "process"
+	CheckTCP      = "tcp"
+	CheckHTTP     = "http"
+	CheckPing     = "ping"
+	CheckEventLog = "eventlog"
+	CheckScript   = "script"
+	CheckPlugin   = "plugin"
+)
+
+// CheckTypes lists the types of checks the agents run
+var CheckTypes = []string{CheckDisk, CheckService, CheckProcess, CheckTCP, CheckHTTP, CheckPing, CheckEventLog, CheckScript, CheckPlugin}
+
+// Statuses of check results. A check that could not run is unknown, and a check that has not
+// reported yet is pending.
+const (
+	CheckOK       = "ok"
+	CheckWarning  = "warning"
+	CheckCritical = "critical"
+	CheckUnknown  = "unknown"
+	CheckPending  = "pending"
+)
+
+// CheckStatusCode returns the Nagios return code of a status: 0 for ok, 1 for warning, 2 for
+// critical and 3 for the others
+func CheckStatusCode(status string) int {
+	switch status {
+	case CheckOK:
+		return 0
+	case CheckWarning:
+		return 1
+	case CheckCritical:
+		return 2
+	default:
+		return 3
+	}
+}
+
+// DefaultCheckInterval is how often a check runs unless it sets its interval, in seconds
+const DefaultCheckInterval = 300
+
+// CheckParams are the settings of a check; each type uses some of them. Warning and Critical
+// are thresholds on the percentage of a disk used, on the response time in milliseconds of
+// the tcp, http and ping checks, and on the number of events an eventlog check matched.
+type CheckParams struct {
+	// Path is the drive of a disk check, e.g. C:
+	Path string `json:"path,omitempty"`
+	// Service is the name of the service of a service check, e.g. Spooler
+	Service string `json:"service,omitempty"`
+	// Process is the executable of a process check, e.g. nginx.exe
+	Process string `json:"process,omitempty"`
+	// Host is the target of the tcp and ping checks. A ping check without a host pings the
+	// default gateway.
+	Host string `json:"host,omitempty"`
+	Port int    `json:"port,omitempty"`
+	// URL is requested by an http check, which expects ExpectStatus or 200
+	URL          string `json:"url,omitempty"`
+	ExpectStatus int    `json:"expect_status,omitempty"`
+	// Log is the event log channel an eventlog check reads, e.g. System, and Pattern the
+	// regular expression the events written since the last run are matched against
+	Log     string `json:"log,omitempty"`
+	Pattern string `json:"pattern,omitempty"`
+	// Script is run by a script check with Shell, powershell or cmd. Exit code 0 is ok, 1
+	// warning, 2 critical and any other unknown.
+	Shell  string `json:"shell,omitempty"`
+	Script string `json:"script,omitempty"`
+	// Command is the Nagios plugin a plugin check runs with Arguments. A command without a
+	// directory is looked up in the plugins directory of the agent, then in the PATH. The
+	// exit code and the "text | perfdata" output of the plugin are read as Nagios does.
+	Command   string   `json:"command,omitempty"`
+	Arguments []string `json:"arguments,omitempty"`
+
+	Warning  *float64 `json:"warning,omitempty"`
+	Critical *float64 `json:"critical,omitempty"`
+	// Timeout is in seconds, 10 unless set
+	Timeout int `json:"timeout,omitempty"`
+}
+
+// Check is a monitoring check assigned to a group, run by the agent of every host in the
+// group. Interval is in seconds.
+type Check struct {
+	CheckID   int32       `json:"check_id"`
+	GroupID   int32       `json:"group_id"`
+	CheckName string      `json:"check_name"`
+	CheckType string      `json:"check_type"`
+	Params    CheckParams `json:"params"`
+	Interval  int         `json:"interval"`
+	Enabled   bool        `json:"enabled"`
+}
+
+// Validate returns the reason each field of a check is rejected. The name is validated by
+// the handlers like the other names.
+func (c Check) Validate() map[string]string {
+	fields := map[string]string{}
+	if c.Interval < 30 || c.Interval > 86400 {
+		fields["interval"] = "interval must be between 30 and 86400 seconds"
+	}
+	p := c.Params
+	switch c.CheckType {
+	case CheckDisk:
+		if p.Path == "" {
+			fields["params.path"] = "path is required"
+		}
+	case CheckService:
+		if p.Service == "" {
+			fields["params.service"] = "service is required"
+		}
+	case CheckProcess:
+		if p.Process == "" {
+			fields["params.process"] = "process is required"
+		}
+	case CheckTCP:
+		if p.Host == "" {
+			fields["params.host"] = "host is required"
+		}
+		if p.Port < 1 || p.Port > 65535 {
+			fields["params.port"] = "port must be between 1 and 65535"
+		}
+	case CheckHTTP:
+		u, err := url.Parse(p.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			fields["params.url"] = "url must be an absolute http or https URL"
+		}
+		if p.ExpectStatus != 0 && (p.ExpectStatus < 100 || p.ExpectStatus > 599) {
+			fields["params.expect_status"] = "expect_status must be an HTTP status code"
+		}
+	case CheckPing:
+	case CheckEventLog:
+		if p.Log == "" {
+			fields["params.log"] = "log is required"
+		}
+		if _, err := regexp.Compile(p.Pattern); p.Pattern == "" || err != nil {
+			fields["params.pattern"] = "pattern must be a regular expression"
+		}
+	case CheckScript:
+		if p.Script == "" {
+			fields["params.script"] = "script is required"
+		}
+		if p.Shell != "" && p.Shell != "powershell" && p.Shell != "cmd" {
+			fields["params.shell"] = "shell must be powershell or cmd"
+		}
+	case CheckPlugin:
+		if p.Command == "" {
+			fields["params.command"] = "command is required"
+		}
+	default:
+		fields["check_type"] = "check_type must be one of " + strings.Join(CheckTypes, ", ")
+	}
+	if p.Warning != nil && p.Critical != nil && *p.Warning > *p.Critical {
+		fields["params.warning"] = "warning must not be above critical"
+	}
+	if p.Timeout < 0 || p.Timeout > 300 {
+		fields["params.timeout"] = "timeout must be at most 300 seconds"
+	}
+	return fields
+}
+
+// PerfData is a measurement reported with a check result, such as the space used on a disk.
+// Warning and Critical are the thresholds it was compared to, as Nagios ranges.
+type PerfData struct {
+	Label    string   `json:"label"`
+	Value    float64  `json:"value"`
+	Unit     string   `json:"unit,omitempty"`
+	Warning  string   `json:"warning,omitempty"`
+	Critical string   `json:"critical,omitempty"`
+	Min      *float64 `json:"min,omitempty"`
+	Max      *float64 `json:"max,omitempty"`
+}
+
+// String formats the measurement as Nagios performance data: 'label'=value[unit];warn;crit;min;max,
+// without the trailing empty fields
+func (p PerfData) String() string {
+	label := p.Label
+	if strings.ContainsAny(label, " '=") {
+		label = "'" + strings.ReplaceAll(label, "'", "''") + "'"
+	}
+	fields := []string{strconv.FormatFloat(p.Value, 'f', -1, 64) + p.Unit, p.Warning, p.Critical, formatLimit(p.Min), formatLimit(p.Max)}
+	for len(fields) > 1 && fields[len(fields)-1] == "" {
+		fields = fields[:len(fields)-1]
+	}
+	return label + "=" + strings.Join(fields, ";")
+}
+
+// formatLimit formats the minimum or maximum of a measurement, empty if it is unset
+func formatLimit(limit *float64) string {
+	if limit == nil {
+		return ""
+	}
+	return strconv.FormatFloat(*limit, 'f', -1, 64)
+}
+
+// CheckResult is the outcome of a run of a check on a host
+type CheckResult struct {
+	CheckID   int32      `json:"check_id"`
+	Status    string     `json:"status"`
+	Output    string     `json:"output"`
+	Perfdata  []PerfData `json:"perfdata"`
+	CheckedAt time.Time  `json:"checked_at"`
+}
+
+// CheckState is the latest result of a check on a host. A check that has not reported yet is
+// pending, without CheckedAt and Since. Since is when the check entered its status.
+type CheckState struct {
+	CheckID   int32      `json:"check_id"`
+	CheckName string     `json:"check_name"`
+	CheckType string     `json:"check_type"`
+	Status    string     `json:"status"`
+	Output    string     `json:"output"`
+	Perfdata  []PerfData `json:"perfdata"`
+	CheckedAt *time.Time `json:"checked_at"`
+	Since     *time.Time `json:"since"`
+}
+
+// EventLogEntry is an entry of an event log shipped by an agent. Source is the channel,
+// journal or file it was read from and RecordKey its position there. EventCode is the event
+// ID of Windows entries.
+type EventLogEntry struct {
+	EntryID   int64     `json:"entry_id"`
+	Source    string    `json:"source"`
+	RecordKey string    `json:"record_key"`
+	Provider  string    `json:"provider"`
+	EventCode *int      `json:"event_code,omitempty"`
+	Level     string    `json:"level"`
+	Message   string    `json:"message"`
+	LoggedAt  time.Time `json:"logged_at"`
+}
+
+// EventLogFilter selects the event log entries of a host: the entries of Source and Level
+// when set, containing Query in their message or provider, between Since and Until, newest
+// first and at most Limit of them
+type EventLogFilter struct {
+	Source string
+	Level  string
+	Query  string
+	Since  time.Time
+	Until  time.Time
+	Limit  int
+}
+
+// Limits of the event log entries returned at once
+const (
+	DefaultEventLogLimit = 200
+	MaxEventLogLimit     = 1000
+)
+
+// ParseEventLogFilter reads a filter from the query parameters source, level, q, since and
+// until, which are RFC 3339 times, and limit. It returns the reason each invalid parameter is
+// rejected.
+func ParseEventLogFilter(query url.Values) (EventLogFilter, map[string]string) {
+	filter := EventLogFilter{
+		Source: query.Get("source"),
+		Level:  query.Get("level"),
+		Query:  strings.TrimSpace(query.Get("q")),
+		Limit:  DefaultEventLogLimit,
+	}
+	fields := map[string]string{}
+	if filter.Level != "" && !slices.Contains(EventLevels, filter.Level) {
+		fields["level"] = "level must be one of " + strings.Join(EventLevels, ", ")
+	}
+	for param, t := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+		if value := query.Get(param); value != "" {
+			parsed, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				fields[param] = param + " must be an RFC 3339 time"
+			}
+			*t = parsed
+		}
+	}
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > MaxEventLogLimit {
+			fields["limit"] = fmt.Sprintf("limit must be between 1 and %d", MaxEventLogLimit)
+		}
+		filter.Limit = limit
+	}
+	return filter, fields
+}
+
+// HostCheckState is the latest result of a check on a host, as exported for other monitoring
+// systems. Interval is how often the check runs, in seconds.
+type HostCheckState struct {
+	Hostname string `json:"hostname"`
+	CheckState
+	Interval int `json:"interval"`
+}
+
+// RemoteAccessPolicy lets the users with a role start remote control of the hosts in a group,
+// or of every host when GroupID is unset. The role "*" matches every user.
+type RemoteAccessPolicy struct {
+	PolicyID int32  `json:"policy_id"`
+	Role     string `json:"role"`
+	GroupID  *int32 `json:"group_id,omitempty"`
+}
+
+// Validate returns the reason each invalid field of the policy is rejected
+func (p RemoteAccessPolicy) Validate() map[string]string {
+	fields := map[string]string{}
+	switch {
+	case p.Role == "":
+		fields["role"] = "role is required"
+	case len(p.Role) > 255:
+		fields["role"] = "role must be at most 255 characters"
+	}
+	if p.GroupID != nil && *p.GroupID <= 0 {
+		fields["group_id"] = "group_id must be positive"
+	}
+	return fields
 }
 
 // Group represents a group of agents
 type Group struct {
 	GroupID   int32  `json:"group_id"`
 	GroupName string `json:"group_name"`
+	SiteID    int32  `json:"site_id"`
+}
+
+// Settings holds the key/value settings of an organization or site.
+// Site settings override the settings of their organization.
+type Settings map[string]string
+
+// Organization represents a client whose sites, groups and hosts are managed by Nexus
+type Organization struct {
+	OrgID         int32    `json:"org_id"`
+	OrgName       string   `json:"org_name"`
+	EnrollmentKey string   `json:"enrollment_key"`
+	Settings      Settings `json:"settings"`
+}
+
+// Site represents a physical or logical location belonging to an organization
+type Site struct {
+	SiteID            int32    `json:"site_id"`
+	OrgID             int32    `json:"org_id"`
+	SiteName          string   `json:"site_name"`
+	Settings          Settings `json:"settings"`
+	EffectiveSettings Settings `json:"effective_settings,omitempty"`
+}
+
+// Inherit returns the settings of the child layered on top of the parent
+func (parent Settings) Inherit(child Settings) Settings {
+	merged := make(Settings, len(parent)+len(child))
+	for k, v := range parent {
+		merged[k] = v
+	}
+	for k, v := range child {
+		merged[k] = v
+	}
+	return merged
+}
+
+// Script represents a saved script that can be run on agents
+type Script struct {
+	ScriptID   int32  `json:"script_id"`
+	ScriptName string `json:"script_name"`
+	Shell      string `json:"shell"`
+	Content    string `json:"content"`
+}
+
+// Job represents a unit of work queued for an agent
+type Job struct {
+	JobID     int32           `json:"job_id"`
+	HostID    int32           `json:"host_id"`
+	JobType   string          `json:"job_type"`
+	Payload   json.RawMessage `json:"payload"`
+	Status    string          `json:"status"`
+	ExitCode  *int            `json:"exit_code,omitempty"`
+	Output    string          `json:"output,omitempty"`
+	CreatedAt time.Time       `json:"created_at"`
+	UpdatedAt time.Time       `json:"updated_at"`
+}
+
+// LogRequest selects the agent log entries returned by a fetch_logs job. Unset fields are not
+// filtered on; the agent returns the 100 most recent entries when Lines is unset.
+type LogRequest struct {
+	Lines int        `json:"lines,omitempty"`
+	Since *time.Time `json:"since,omitempty"`
+	Until *time.Time `json:"until,omitempty"`
+	// Level is the least severe level returned: debug, info, warn or error
+	Level string `json:"level,omitempty"`
+}
+
+// MaxLogLines is the most log entries a single request can return
+const MaxLogLines = 1000
+
+// Validate returns the invalid fields of the request, keyed by JSON name
+func (l LogRequest) Validate() map[string]string {
+	fields := map[string]string{}
+	if l.Lines < 0 || l.Lines > MaxLogLines {
+		fields["lines"] = "lines must be between 0 and " + strconv.Itoa(MaxLogLines)
+	}
+	if l.Since != nil && l.Until != nil && l.Until.Before(*l.Since) {
+		fields["until"] = "until must not be before since"
+	}
+	if l.Level != "" && !slices.Contains(AgentLogLevels, l.Level) {
+		fields["level"] = "level must be one of " + strings.Join(AgentLogLevels, ", ")
+	}
+	return fields
+}
+
+// AgentLog is the log of an agent retrieved by a fetch_logs job. Entries are the JSON log
+// lines of the agent, oldest first, once the job has completed.
+type AgentLog struct {
+	JobID   int32             `json:"job_id"`
+	HostID  int32             `json:"host_id"`
+	Status  string            `json:"status"`
+	Error   string            `json:"error,omitempty"`
+	Entries []json.RawMessage `json:"entries"`
+}
+
+// BulkRequest describes an action applied to a set of selected hosts
+type BulkRequest struct {
+	Action      string `json:"action"`
+	HostIDs     []int  `json:"host_ids"`
+	GroupID     int    `json:"group_id,omitempty"`
+	FromGroupID int    `json:"from_group_id,omitempty"`
+	ScriptID    int    `json:"script_id,omitempty"`
+	TagKey      string `json:"tag_key,omitempty"`
+	TagValue    string `json:"tag_value,omitempty"`
+	Atomic      bool   `json:"atomic,omitempty"`
+}
+
+// BulkResult is the outcome of a bulk action for a single host
+type BulkResult struct {
+	HostID  int    `json:"host_id"`
+	Success bool   `json:"success"`
+	Error   string `json:"error,omitempty"`
+	JobID   int32  `json:"job_id,omitempty"`
+}
+
+// Custom field types
+const (
+	FieldText    = "text"
+	FieldNumber  = "number"
+	FieldDate    = "date"
+	FieldEnum    = "enum"
+	FieldBoolean = "boolean"
+)
+
+// maxFieldValue limits the length of tag and custom field values
+const maxFieldValue = 1024
+
+// CustomField is an admin-defined field that can be set on every agent
+type CustomField struct {
+	FieldID   int32    `json:"field_id"`
+	FieldKey  string   `json:"field_key"`
+	Label     string   `json:"label"`
+	FieldType string   `json:"field_type"`
+	Options   []string `json:"options"`
+}
+
+// ValidateDefinition checks that a custom field definition is well formed
+func (f CustomField) ValidateDefinition() error {
+	if f.FieldKey == "" || f.Label == "" {
+		return errors.New("field_key and label are required")
+	}
+	switch f.FieldType {
+	case FieldText, FieldNumber, FieldDate, FieldBoolean:
+	case FieldEnum:
+		if len(f.Options) == 0 {
+			return errors.New("enum fields need at least one option")
+		}
+	default:
+		return fmt.Errorf("unknown field type %q", f.FieldType)
+	}
+	return nil
+}
+
+// Validate checks that a value matches the type of the custom field
+func (f CustomField) Validate(value string) error {
+	if len(value) > maxFieldValue {
+		return fmt.Errorf("%s is too long", f.FieldKey)
+	}
+
+	switch f.FieldType {
+	case FieldNumber:
+		if _, err := strconv.ParseFloat(value, 64); err != nil {
+			return fmt.Errorf("%s must be a number", f.FieldKey)
+		}
+	case FieldDate:
+		if _, err := time.Parse("2006-01-02", value); err != nil {
+			return fmt.Errorf("%s must be a date (YYYY-MM-DD)", f.FieldKey)
+		}
+	case FieldBoolean:
+		if value != "true" && value != "false" {
+			return fmt.Errorf("%s must be true or false", f.FieldKey)
+		}
+	case FieldEnum:
+		if !slices.Contains(f.Options, value) {
+			return fmt.Errorf("%s must be one of %s", f.FieldKey, strings.Join(f.Options, ", "))
+		}
+	}
+	return nil
+}
+
+// ValidateTag checks the key and value of a free-form tag
+func ValidateTag(key, value string) error {
+	if key == "" || len(key) > 255 || len(value) > 255 {
+		return errors.New("tag keys must be 1-255 characters and values at most 255")
+	}
+	return nil
+}
+
+// AgentFilter narrows the agents list by group, tags and custom field values.
+// A tag with an empty value matches any value, and a zero GroupID any group.
+type AgentFilter struct {
+	GroupID      int32
+	Tags         map[string]string
+	CustomFields map[string]string
+}
+
+// ParseAgentFilter reads a filter from query parameters of the form
+// tag=key, tag=key:value and field.<key>=value
+func ParseAgentFilter(query url.Values) AgentFilter {
+	filter := AgentFilter{Tags: map[string]string{}, CustomFields: map[string]string{}}
+	for _, tag := range query["tag"] {
+		if tag == "" {
+			continue
+		}
+		key, value, _ := strings.Cut(tag, ":")
+		filter.Tags[key] = value
+	}
+	for param, values := range query {
+		if key, ok := strings.CutPrefix(param, "field."); ok && key != "" && len(values) > 0 {
+			filter.CustomFields[key] = values[0]
+		}
+	}
+	return filter
 }
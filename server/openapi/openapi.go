@@ -0,0 +1,305 @@
+// Package openapi generates an OpenAPI 3 document from the routes registered on a
+// mux router, so the published specification cannot drift from the routes served.
+package openapi
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Operation documents a single route, keyed by "METHOD /path" in the docs table
+type Operation struct {
+	Summary string
+	Tag     string
+	// Request is a value of the type decoded from the request body, if any
+	Request interface{}
+	// Response is a value of the type returned on success, if any
+	Response interface{}
+	// Status is the success status code, 200 when unset
+	Status int
+	// Raw marks agent protocol routes whose response is not wrapped in the envelope
+	Raw bool
+	// Query lists the query parameters the route understands
+	Query map[string]string
+}
+
+// Spec describes the API being documented
+type Spec struct {
+	Title   string
+	Version string
+	// Error is a value of the error envelope returned by failed requests
+	Error interface{}
+	// BasePath is removed from the route templates; ServerURL is where they are served from
+	BasePath  string
+	ServerURL string
+	// Headers lists request headers understood by every enveloped route
+	Headers map[string]string
+	// RawHeaders lists request headers understood by every raw (agent protocol) route
+	RawHeaders map[string]string
+	Docs       map[string]Operation
+}
+
+var pathParam = regexp.MustCompile(`\{([^}:]+)(?::[^}]*)?\}`)
+
+// Build walks the router and returns the OpenAPI document for every route on it.
+// Routes missing from the docs table are still listed and are logged so they get documented.
+func Build(router *mux.Router, spec Spec) (map[string]interface{}, error) {
+	g := &generator{schemas: map[string]interface{}{}}
+	errorSchema := g.schema(reflect.TypeOf(spec.Error))
+
+	paths := map[string]map[string]interface{}{}
+	err := router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		if route.GetHandler() == nil {
+			return nil
+		}
+		tmpl, err := route.GetPathTemplate()
+		if err != nil {
+			return nil
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			methods = []string{http.MethodGet}
+		}
+
+		path := pathParam.ReplaceAllString(strings.TrimPrefix(tmpl, spec.BasePath), "{$1}")
+		if paths[path] == nil {
+			paths[path] = map[string]interface{}{}
+		}
+		for _, method := range methods {
+			key := method + " " + path
+			op, ok := spec.Docs[key]
+			if !ok {
+				log.Printf("openapi: route %s is not documented", key)
+			}
+			paths[path][strings.ToLower(method)] = g.operation(path, op, errorSchema, spec)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   spec.Title,
+			"version": spec.Version,
+		},
+		"servers": []interface{}{map[string]interface{}{"url": spec.ServerURL}},
+		"paths":   paths,
+		"components": map[string]interface{}{
+			"schemas": g.schemas,
+			"securitySchemes": map[string]interface{}{
+				"bearerAuth": map[string]interface{}{"type": "http", "scheme": "bearer"},
+			},
+		},
+		"security": []interface{}{map[string]interface{}{"bearerAuth": []string{}}},
+	}, nil
+}
+
+// Handler serves the document for the router as JSON. The document is built on the
+// first request so that every route has been registered by then.
+func Handler(router *mux.Router, spec Spec) http.HandlerFunc {
+	var once sync.Once
+	var doc []byte
+	var buildErr error
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		once.Do(func() {
+			var built map[string]interface{}
+			if built, buildErr = Build(router, spec); buildErr == nil {
+				doc, buildErr = json.Marshal(built)
+			}
+		})
+		if buildErr != nil {
+			log.Printf("error building OpenAPI document: %v", buildErr)
+			http.Error(w, "error building OpenAPI document", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(doc)
+	}
+}
+
+type generator struct {
+	schemas map[string]interface{}
+}
+
+// operation builds the OpenAPI operation object of a route
+func (g *generator) operation(path string, op Operation, errorSchema interface{}, spec Spec) map[string]interface{} {
+	var params []interface{}
+	for _, match := range pathParam.FindAllStringSubmatch(path, -1) {
+		paramType := "string"
+		if strings.HasSuffix(match[1], "id") {
+			paramType = "integer"
+		}
+		params = append(params, parameter(match[1], "path", "", paramType, true))
+	}
+	for _, name := range sortedKeys(op.Query) {
+		params = append(params, parameter(name, "query", op.Query[name], "string", false))
+	}
+	headers := spec.Headers
+	if op.Raw {
+		headers = spec.RawHeaders
+	}
+	for _, name := range sortedKeys(headers) {
+		params = append(params, parameter(name, "header", headers[name], "string", false))
+	}
+
+	status := op.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	success := map[string]interface{}{"description": http.StatusText(status)}
+	if op.Response != nil && status != http.StatusNoContent {
+		body := g.schema(reflect.TypeOf(op.Response))
+		if !op.Raw {
+			body = map[string]interface{}{
+				"type":       "object",
+				"properties": map[string]interface{}{"data": body},
+			}
+		}
+		success["content"] = jsonContent(body)
+	}
+
+	failure := map[string]interface{}{
+		"description": "Error",
+		"content":     jsonContent(errorSchema),
+	}
+	result := map[string]interface{}{
+		"summary":   op.Summary,
+		"responses": map[string]interface{}{strconv.Itoa(status): success, "default": failure},
+	}
+	if op.Tag != "" {
+		result["tags"] = []string{op.Tag}
+	}
+	if len(params) > 0 {
+		result["parameters"] = params
+	}
+	if op.Request != nil {
+		result["requestBody"] = map[string]interface{}{
+			"required": true,
+			"content":  jsonContent(g.schema(reflect.TypeOf(op.Request))),
+		}
+	}
+	return result
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+var rawMessageType = reflect.TypeOf(json.RawMessage{})
+
+// schema returns the JSON schema of a Go type. Named structs are added to the
+// component schemas and referenced.
+func (g *generator) schema(t reflect.Type) interface{} {
+	if t == nil {
+		return map[string]interface{}{}
+	}
+	switch t {
+	case timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case rawMessageType:
+		return map[string]interface{}{}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		s := g.schema(t.Elem())
+		if m, ok := s.(map[string]interface{}); ok && m["$ref"] == nil {
+			m["nullable"] = true
+		}
+		return s
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": g.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": g.schema(t.Elem())}
+	case reflect.Interface:
+		return map[string]interface{}{}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.object(t)
+		}
+		if _, ok := g.schemas[t.Name()]; !ok {
+			// Reserve the name first so recursive types terminate
+			g.schemas[t.Name()] = map[string]interface{}{}
+			g.schemas[t.Name()] = g.object(t)
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + t.Name()}
+	}
+	return map[string]interface{}{}
+}
+
+// object returns the schema of a struct from its JSON field names
+func (g *generator) object(t reflect.Type) map[string]interface{} {
+	properties := map[string]interface{}{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name := field.Name
+		if tag, ok := field.Tag.Lookup("json"); ok {
+			tagName := strings.Split(tag, ",")[0]
+			if tagName == "-" {
+				continue
+			}
+			if tagName != "" {
+				name = tagName
+			}
+		}
+		schema := g.schema(field.Type)
+		// The values a string field accepts are listed in its enum tag, separated by commas
+		if enum, ok := field.Tag.Lookup("enum"); ok {
+			if m, ok := schema.(map[string]interface{}); ok && m["type"] == "string" {
+				m["enum"] = strings.Split(enum, ",")
+			}
+		}
+		properties[name] = schema
+	}
+	return map[string]interface{}{"type": "object", "properties": properties}
+}
+
+func parameter(name, in, description, paramType string, required bool) map[string]interface{} {
+	p := map[string]interface{}{
+		"name":     name,
+		"in":       in,
+		"required": required,
+		"schema":   map[string]interface{}{"type": paramType},
+	}
+	if description != "" {
+		p["description"] = description
+	}
+	return p
+}
+
+func jsonContent(schema interface{}) map[string]interface{} {
+	return map[string]interface{}{"application/json": map[string]interface{}{"schema": schema}}
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"net/http"
+	"slate-rmm/api_handlers"
+	"slate-rmm/models"
+	"slate-rmm/openapi"
+)
+
+// apiSpec documents every route of the API gateway. NewGateway serves it as /openapi.json.
+var apiSpec = openapi.Spec{
+	Title:     "Slate Nexus API",
+	Version:   "1.0.0",
+	Error:     api_handlers.Envelope{Error: &api_handlers.APIError{}},
+	BasePath:  APIVersion,
+	ServerURL: APIVersion,
+	Headers: map[string]string{
+		"X-Nexus-Client": "Limits the request to a single client (organization). The client_id query parameter is accepted as well.",
+	},
+	RawHeaders: map[string]string{
+		api_handlers.AgentProtocolHeader: "Agent protocol version, 1 when absent. Agent protocol routes echo the negotiated version back.",
+	},
+	Docs: map[string]openapi.Operation{
+		// Agent protocol
+		"POST /agents/register":       {Summary: "Register a new agent", Tag: "agent protocol", Request: models.Agent{}, Response: models.Agent{}, Status: http.StatusCreated, Raw: true},
+		"POST /agents/secret":         {Summary: "Verify an agent token", Tag: "agent protocol", Request: map[string]string{}, Response: map[string]string{}, Raw: true},
+		"PUT /agents/{id}":            {Summary: "Report the inventory of an agent", Tag: "agent protocol", Request: models.InventoryUpdate{}, Raw: true},
+		"PATCH /agents/{id}":          {Summary: "Report the inventory fields of an agent that changed", Tag: "agent protocol", Request: models.InventoryUpdate{}, Raw: true},
+		"POST /agents/{id}/heartbeat": {Summary: "Record a liveness heartbeat and fetch the agent configuration", Tag: "agent protocol", Response: models.HeartbeatResponse{}, Raw: true},
+		"GET /agents/{id}/jobs":       {Summary: "Dispatch the pending jobs of an agent", Tag: "agent protocol", Response: []models.Job{}, Raw: true},
+		"POST /agents/{id}/jobs/{job_id}": {Summary: "Report the result of a job", Tag: "agent protocol", Request: struct {
+			Status   string `json:"status"`
+			ExitCode int    `json:"exit_code"`
+			Output   string `json:"output"`
+		}{}, Raw: true},
+		"GET /agents/{id}/shell/{session_id}": {Summary: "Open the WebSocket serving a remote shell requested with an open_shell job", Tag: "agent protocol", Raw: true},
+		"GET /agents/{id}/files/{session_id}": {Summary: "Open the WebSocket serving a file session requested with an open_files job", Tag: "agent protocol", Raw: true},
+		"PUT /agents/{id}/services":           {Summary: "Report the services of the host of an agent", Tag: "agent protocol", Request: []models.Service{}, Raw: true},
+		"PUT /agents/{id}/processes":          {Summary: "Report the processes running on the host of an agent", Tag: "agent protocol", Request: []models.Process{}, Raw: true},
+		"POST /agents/{id}/checks/results":    {Summary: "Report the results of the checks of an agent", Tag: "agent protocol", Request: []models.CheckResult{}, Raw: true},
+		"POST /agents/{id}/event-logs":        {Summary: "Ship entries of the event logs of the host of an agent", Tag: "agent protocol", Request: []models.EventLogEntry{}, Raw: true},
+
+		// Agents
+		"GET /agents": {Summary: "List agents", Tag: "agents", Response: []models.Agent{}, Query: map[string]string{
+			"tag":         "Only agents with this tag, as key or key:value. May be repeated.",
+			"field.{key}": "Only agents whose custom field key has this value",
+		}},
+		"GET /agents/protocols":      {Summary: "Count the hosts speaking each agent protocol version", Tag: "agents", Response: []models.ProtocolUsage{}},
+		"GET /agents/{id}":           {Summary: "Get an agent", Tag: "agents", Response: models.Agent{}},
+		"DELETE /agents/{id}":        {Summary: "Delete an agent", Tag: "agents", Status: http.StatusNoContent},
+		"POST /agents/bulk/{action}": {Summary: "Apply an action to several agents", Tag: "agents", Request: models.BulkRequest{}, Response: []models.BulkResult{}},
+		"PUT /agents/{id}/tags":      {Summary: "Replace the tags of an agent", Tag: "agents", Request: map[string]string{}, Status: http.StatusNoContent},
+		"PUT /agents/{id}/tags/{key}": {Summary: "Set a tag on an agent", Tag: "agents", Request: struct {
+			Value string `json:"value"`
+		}{}, Status: http.StatusNoContent},
+		"DELETE /agents/{id}/tags/{key}": {Summary: "Remove a tag from an agent", Tag: "agents", Status: http.StatusNoContent},
+		"PUT /agents/{id}/custom-fields": {Summary: "Set custom field values on an agent", Tag: "agents", Request: map[string]string{}, Status: http.StatusNoContent},
+		"PUT /agents/{id}/name": {Summary: "Set the name shown instead of the hostname, or clear it with an empty name", Tag: "agents", Request: struct {
+			DisplayName string `json:"display_name"`
+		}{}, Status: http.StatusNoContent},
+		"POST /agents/{id}/logs":                                  {Summary: "Request entries of the agent log, retrieved the next time the agent polls for jobs", Tag: "agents", Request: models.LogRequest{}, Response: models.Job{}, Status: http.StatusAccepted},
+		"GET /agents/{id}/logs/{job_id}":                          {Summary: "Get the agent log entries retrieved by a log request", Tag: "agents", Response: models.AgentLog{}},
+		"GET /agents/{id}/remote-sessions":                        {Summary: "List who started remote control of an agent, newest first", Tag: "remote access", Response: []models.RemoteSession{}},
+		"GET /agents/{id}/remote-sessions/{session_id}/recording": {Summary: "Get what was typed in and written by a remote shell session, in order", Tag: "remote access", Response: []models.ShellChunk{}},
+		"GET /agents/{id}/remote-sessions/{session_id}/files":     {Summary: "Get the directories listed and the files transferred during a file session, in order", Tag: "remote access", Response: []models.FileOperation{}},
+		"GET /agents/{id}/services":                               {Summary: "Get the services the agent last reported", Tag: "services", Response: models.ServiceSnapshot{}},
+		"POST /agents/{id}/services/refresh":                      {Summary: "Request the services of the agent, listed the next time the agent polls for jobs", Tag: "services", Response: models.Job{}, Status: http.StatusAccepted},
+		"POST /agents/{id}/services/{name}/{action}":              {Summary: "Start, stop or restart a service, then list the services again", Tag: "services", Response: models.Job{}, Status: http.StatusAccepted},
+		"GET /agents/{id}/processes":                              {Summary: "Get the processes the agent last reported", Tag: "services", Response: models.ProcessSnapshot{}},
+		"POST /agents/{id}/processes/refresh":                     {Summary: "Request the processes of the agent, listed the next time the agent polls for jobs", Tag: "services", Response: models.Job{}, Status: http.StatusAccepted},
+		"POST /agents/{id}/processes/{pid}/terminate":             {Summary: "Terminate a process, then list the processes again", Tag: "services", Request: models.ProcessTermination{}, Response: models.Job{}, Status: http.StatusAccepted},
+
+		// Groups
+		"GET /groups":                                {Summary: "List groups", Tag: "groups", Response: []models.Group{}},
+		"POST /groups":                               {Summary: "Create a group", Tag: "groups", Request: models.Group{}, Response: models.Group{}, Status: http.StatusCreated},
+		"GET /groups/{group_id}":                     {Summary: "Get a group", Tag: "groups", Response: models.Group{}},
+		"PUT /groups/{group_id}":                     {Summary: "Rename a group", Tag: "groups", Request: models.Group{}, Response: models.Group{}},
+		"DELETE /groups/{group_id}":                  {Summary: "Delete a group", Tag: "groups", Status: http.StatusNoContent, Query: map[string]string{"force": "Set to true to delete a group that still has members"}},
+		"GET /groups/{group_id}/hosts":               {Summary: "List the hosts in a group", Tag: "groups", Response: []models.Agent{}},
+		"POST /groups/{group_id}/add/{host_id}":      {Summary: "Add a host to a group", Tag: "groups", Status: http.StatusNoContent},
+		"DELETE /groups/{group_id}/remove/{host_id}": {Summary: "Remove a host from a group", Tag: "groups", Status: http.StatusNoContent},
+		"PUT /groups/{group_id}/move/{host_id}": {Summary: "Move a host to a group", Tag: "groups", Request: struct {
+			FromGroupID int `json:"from_group_id"`
+		}{}, Status: http.StatusNoContent, Query: map[string]string{"from": "The group the host leaves, instead of from_group_id"}},
+
+		// Organizations and sites
+		"GET /organizations":                   {Summary: "List organizations", Tag: "organizations", Response: []models.Organization{}},
+		"POST /organizations":                  {Summary: "Create an organization", Tag: "organizations", Request: models.Organization{}, Response: models.Organization{}, Status: http.StatusCreated},
+		"GET /organizations/{org_id}":          {Summary: "Get an organization", Tag: "organizations", Response: models.Organization{}},
+		"PUT /organizations/{org_id}":          {Summary: "Update an organization", Tag: "organizations", Request: models.Organization{}, Response: models.Organization{}},
+		"GET /organizations/{org_id}/sites":    {Summary: "List the sites of an organization", Tag: "sites", Response: []models.Site{}},
+		"POST /organizations/{org_id}/sites":   {Summary: "Create a site", Tag: "sites", Request: models.Site{}, Response: models.Site{}, Status: http.StatusCreated},
+		"GET /sites/{site_id}":                 {Summary: "Get a site", Tag: "sites", Response: models.Site{}},
+		"PUT /sites/{site_id}":                 {Summary: "Update a site", Tag: "sites", Request: models.Site{}, Response: models.Site{}},
+		"PUT /sites/{site_id}/hosts/{host_id}": {Summary: "Move a host to a site", Tag: "sites", Status: http.StatusNoContent},
+
+		// Scripts and jobs
+		"GET /scripts":                {Summary: "List saved scripts", Tag: "scripts", Response: []models.Script{}},
+		"POST /scripts":               {Summary: "Save a script", Tag: "scripts", Request: models.Script{}, Response: models.Script{}, Status: http.StatusCreated},
+		"GET /scripts/{script_id}":    {Summary: "Get a saved script", Tag: "scripts", Response: models.Script{}},
+		"DELETE /scripts/{script_id}": {Summary: "Delete a saved script", Tag: "scripts", Status: http.StatusNoContent},
+		"GET /jobs/{job_id}":          {Summary: "Get a job and its result", Tag: "jobs", Response: models.Job{}},
+
+		// Custom fields
+		"GET /custom-fields":               {Summary: "List custom field definitions", Tag: "custom fields", Response: []models.CustomField{}},
+		"POST /custom-fields":              {Summary: "Create a custom field definition", Tag: "custom fields", Request: models.CustomField{}, Response: models.CustomField{}, Status: http.StatusCreated},
+		"PUT /custom-fields/{field_id}":    {Summary: "Update a custom field definition", Tag: "custom fields", Request: models.CustomField{}, Status: http.StatusNoContent},
+		"DELETE /custom-fields/{field_id}": {Summary: "Delete a custom field definition", Tag: "custom fields", Status: http.StatusNoContent},
+
+		// Configuration profiles
+		"GET /config-profiles":                 {Summary: "List agent configuration profiles", Tag: "configuration profiles", Response: []models.ConfigProfile{}},
+		"POST /config-profiles":                {Summary: "Create an agent configuration profile", Tag: "configuration profiles", Request: models.ConfigProfile{}, Response: models.ConfigProfile{}, Status: http.StatusCreated},
+		"GET /config-profiles/{profile_id}":    {Summary: "Get an agent configuration profile", Tag: "configuration profiles", Response: models.ConfigProfile{}},
+		"PUT /config-profiles/{profile_id}":    {Summary: "Update an agent configuration profile", Tag: "configuration profiles", Request: models.ConfigProfile{}, Response: models.ConfigProfile{}},
+		"DELETE /config-profiles/{profile_id}": {Summary: "Delete an agent configuration profile", Tag: "configuration profiles", Status: http.StatusNoContent},
+		"PUT /groups/{group_id}/profile":       {Summary: "Assign a configuration profile to a group", Tag: "configuration profiles", Request: api_handlers.ProfileAssignment{}, Status: http.StatusNoContent},
+		"PUT /agents/{id}/profile":             {Summary: "Assign a configuration profile to an agent", Tag: "configuration profiles", Request: api_handlers.ProfileAssignment{}, Status: http.StatusNoContent},
+
+		// Monitoring checks
+		"GET /groups/{group_id}/checks":  {Summary: "List the checks assigned to a group", Tag: "checks", Response: []models.Check{}},
+		"POST /groups/{group_id}/checks": {Summary: "Assign a new check to a group, run by its hosts from their next heartbeat", Tag: "checks", Request: models.Check{}, Response: models.Check{}, Status: http.StatusCreated},
+		"GET /checks/status": {Summary: "Export the latest result of every check on every host as CheckMK piggyback data or Nagios external commands", Tag: "checks", Raw: true, Query: map[string]string{
+			"format": "checkmk, a piggyback section of local checks per host, or nagios, a PROCESS_SERVICE_CHECK_RESULT command per check; checkmk unless set",
+		}},
+		"GET /checks/{check_id}":    {Summary: "Get a check", Tag: "checks", Response: models.Check{}},
+		"PUT /checks/{check_id}":    {Summary: "Update a check", Tag: "checks", Request: models.Check{}, Response: models.Check{}},
+		"DELETE /checks/{check_id}": {Summary: "Delete a check along with its results", Tag: "checks", Status: http.StatusNoContent},
+		"GET /agents/{id}/checks":   {Summary: "Get the latest result of every check an agent runs", Tag: "checks", Response: []models.CheckState{}},
+		"GET /agents/{id}/checks/{check_id}/results": {Summary: "Get the results of a check on an agent, newest first", Tag: "checks", Response: []models.CheckResult{}, Query: map[string]string{
+			"since": "Only results since this RFC 3339 time",
+			"limit": "At most this many results, 100 unless set",
+		}},
+
+		// Event logs
+		"GET /agents/{id}/event-logs": {Summary: "Search the event log entries shipped by an agent, newest first", Tag: "event logs", Response: []models.EventLogEntry{}, Query: map[string]string{
+			"source": "Only entries of this channel, journal or file",
+			"level":  "Only entries of this level: critical, error, warning, information or verbose",
+			"q":      "Only entries containing this text in their message or provider, ignoring case",
+			"since":  "Only entries logged since this RFC 3339 time",
+			"until":  "Only entries logged before this RFC 3339 time",
+			"limit":  "At most this many entries, 200 unless set",
+		}},
+
+		// Remote access
+		"GET /remote-access/providers":               {Summary: "List the configured remote-access providers and whether they are healthy", Tag: "remote access", Response: []models.RemoteAccessProvider{}},
+		"GET /remote-access/policies":                {Summary: "List the policies deciding who may start remote control", Tag: "remote access", Response: []models.RemoteAccessPolicy{}},
+		"POST /remote-access/policies":               {Summary: "Let a role start remote control of a group, or of every host", Tag: "remote access", Request: models.RemoteAccessPolicy{}, Response: models.RemoteAccessPolicy{}, Status: http.StatusCreated},
+		"DELETE /remote-access/policies/{policy_id}": {Summary: "Delete a remote access policy", Tag: "remote access", Status: http.StatusNoContent},
+
+		// Downloads and documentation
+		"GET /download/agent":        {Summary: "Download the agent executable", Tag: "downloads", Raw: true},
+		"GET /download/remotely-win": {Summary: "Download the Remotely installer", Tag: "downloads", Raw: true},
+		"GET /openapi.json":          {Summary: "This document", Tag: "documentation", Raw: true},
+	},
+}
@@ -0,0 +1,105 @@
+// Package remoteaccess defines the providers that start remote control sessions on the
+// agents, such as Remotely, and the registry the server picks the provider of each client
+// from.
+package remoteaccess
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slate-rmm/models"
+	"sort"
+	"sync"
+)
+
+// DefaultProvider is used by the clients whose settings do not select a provider
+const DefaultProvider = "remotely"
+
+// Errors returned by the providers
+var (
+	// ErrNotConfigured is returned for a provider that is not registered
+	ErrNotConfigured = errors.New("remote access provider is not configured")
+	// ErrInvalidDeviceID is returned for a device ID the provider would not accept
+	ErrInvalidDeviceID = errors.New("invalid device ID")
+	// ErrUnknownDevice is returned when the provider does not know the device, which is
+	// usually offline or has been removed from the provider
+	ErrUnknownDevice = errors.New("the provider does not know the device")
+)
+
+// Provider starts remote control sessions through a remote-access server. Remotely is
+// the first implementation; MeshCentral, RustDesk or Guacamole plug in the same way.
+type Provider interface {
+	// Name identifies the provider in settings, in the IDs reported by the agents and in
+	// the recorded sessions
+	Name() string
+	// DeviceID returns the ID the provider knows an agent by, or "" if the agent has not
+	// reported one
+	DeviceID(agent models.Agent) string
+	// SessionURL starts a session on a device and returns the URL the technician opens
+	// to join it
+	SessionURL(ctx context.Context, deviceID string) (string, error)
+	// Health checks that the provider can be reached with its configured credentials
+	Health(ctx context.Context) error
+}
+
+// registry holds the configured providers by name
+var registry struct {
+	sync.RWMutex
+	providers map[string]Provider
+}
+
+// Register makes a provider available, replacing a provider of the same name
+func Register(provider Provider) {
+	registry.Lock()
+	defer registry.Unlock()
+	if registry.providers == nil {
+		registry.providers = map[string]Provider{}
+	}
+	registry.providers[provider.Name()] = provider
+}
+
+// Lookup returns the provider registered under name, or ErrNotConfigured
+func Lookup(name string) (Provider, error) {
+	registry.RLock()
+	defer registry.RUnlock()
+	provider, ok := registry.providers[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrNotConfigured, name)
+	}
+	return provider, nil
+}
+
+// Providers returns the registered providers sorted by name
+func Providers() []Provider {
+	registry.RLock()
+	defer registry.RUnlock()
+	providers := make([]Provider, 0, len(registry.providers))
+	for _, provider := range registry.providers {
+		providers = append(providers, provider)
+	}
+	sort.Slice(providers, func(i, j int) bool { return providers[i].Name() < providers[j].Name() })
+	return providers
+}
+
+// Selected returns the name of the provider selected for an agent by the
+// remote_access_provider setting of its site or organization
+func Selected(agent models.Agent) string {
+	if agent.RemoteAccessProvider != "" {
+		return agent.RemoteAccessProvider
+	}
+	return DefaultProvider
+}
+
+// Status checks the health of every registered provider
+func Status(ctx context.Context) []models.RemoteAccessProvider {
+	providers := Providers()
+	statuses := make([]models.RemoteAccessProvider, 0, len(providers))
+	for _, provider := range providers {
+		status := models.RemoteAccessProvider{Name: provider.Name(), Healthy: true}
+		if err := provider.Health(ctx); err != nil {
+			status.Healthy, status.Error = false, err.Error()
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
@@ -0,0 +1,162 @@
+// Package remotely is the client of the Remotely server, the first remote-access provider
+// of the agents.
+package remotely
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"slate-rmm/models"
+	"slate-rmm/remoteaccess"
+	"strings"
+	"time"
+)
+
+// Remotely API limits
+const (
+	// requestTimeout bounds a request to the Remotely API
+	requestTimeout = 10 * time.Second
+	// maxResponseSize limits how much of a response is read
+	maxResponseSize = 64 * 1024
+)
+
+// Errors returned by the client
+var (
+	// ErrNotConfigured is returned when the Remotely API settings are missing
+	ErrNotConfigured = errors.New("remotely is not configured")
+)
+
+// providerName is the name of Remotely among the remote-access providers
+const providerName = "remotely"
+
+// validDeviceID matches the IDs Remotely assigns to devices: GUIDs, or letters and digits
+var validDeviceID = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9-]{0,63}$`)
+
+// StatusError is returned when the Remotely API answers with an unexpected status
+type StatusError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("remotely answered %d: %s", e.StatusCode, e.Body)
+}
+
+// Config holds the settings of the Remotely API
+type Config struct {
+	// BaseURL is the API root of the Remotely server, e.g. https://remotely.example.com/api
+	BaseURL *url.URL
+	// APIID and APIToken form the API key Remotely issues in its settings
+	APIID    string
+	APIToken string
+}
+
+// ConfigFromEnv reads the Remotely API settings from the REMOTELY_API_URL, REMOTELY_API_ID
+// and REMOTELY_API_TOKEN environment variables. It returns ErrNotConfigured if one is missing.
+func ConfigFromEnv() (Config, error) {
+	rawURL := os.Getenv("REMOTELY_API_URL")
+	config := Config{
+		APIID:    os.Getenv("REMOTELY_API_ID"),
+		APIToken: os.Getenv("REMOTELY_API_TOKEN"),
+	}
+	if rawURL == "" || config.APIID == "" || config.APIToken == "" {
+		return config, ErrNotConfigured
+	}
+
+	baseURL, err := url.Parse(rawURL)
+	if err != nil || (baseURL.Scheme != "http" && baseURL.Scheme != "https") || baseURL.Host == "" {
+		return config, fmt.Errorf("invalid REMOTELY_API_URL %q", rawURL)
+	}
+	config.BaseURL = baseURL
+	return config, nil
+}
+
+// Client calls the Remotely API. It is the remoteaccess.Provider named "remotely".
+type Client struct {
+	config Config
+	http   *http.Client
+}
+
+// NewClient returns a client of the Remotely API described by config
+func NewClient(config Config) *Client {
+	return &Client{config: config, http: &http.Client{Timeout: requestTimeout}}
+}
+
+// Name returns the name of Remotely among the remote-access providers
+func (c *Client) Name() string {
+	return providerName
+}
+
+// DeviceID returns the Remotely device ID reported by the agent
+func (c *Client) DeviceID(agent models.Agent) string {
+	return agent.RemoteID(providerName)
+}
+
+// SessionURL starts a remote control session on a device and returns the URL the
+// technician opens to join it
+func (c *Client) SessionURL(ctx context.Context, deviceID string) (string, error) {
+	if !validDeviceID.MatchString(deviceID) {
+		return "", remoteaccess.ErrInvalidDeviceID
+	}
+
+	body, err := c.get(ctx, "RemoteControl", deviceID)
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusNotFound {
+		return "", fmt.Errorf("%w: %w", remoteaccess.ErrUnknownDevice, err)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	// The session URL is returned as plain text, quoted by some versions
+	sessionURL := strings.Trim(strings.TrimSpace(string(body)), `"`)
+	parsed, err := url.Parse(sessionURL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return "", fmt.Errorf("remotely returned an invalid session URL %q", sessionURL)
+	}
+	return sessionURL, nil
+}
+
+// Health lists the devices, which checks both that Remotely can be reached and that it
+// accepts the API key
+func (c *Client) Health(ctx context.Context) error {
+	_, err := c.get(ctx, "Devices")
+	return err
+}
+
+// get calls an endpoint of the API and returns the start of its response body
+func (c *Client) get(ctx context.Context, elem ...string) ([]byte, error) {
+	if c == nil || c.config.BaseURL == nil {
+		return nil, ErrNotConfigured
+	}
+
+	endpoint := *c.config.BaseURL
+	endpoint.Path = path.Join(append([]string{endpoint.Path}, elem...)...)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Api-Key", c.config.APIID+":"+c.config.APIToken)
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("could not reach remotely: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return nil, fmt.Errorf("could not read the remotely response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, &StatusError{StatusCode: resp.StatusCode, Body: strings.TrimSpace(string(body))}
+	}
+	return body, nil
+}
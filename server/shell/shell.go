@@ -0,0 +1,183 @@
+// Package shell relays the remote shells opened from the dashboard to the agents. The
+// agent is asked to open the shell with a job, then connects back to the server through
+// agentconn, and the server relays the terminal between the two connections while
+// recording it.
+package shell
+
+import (
+	"log"
+	"slate-rmm/agentconn"
+	"slate-rmm/database"
+	"slate-rmm/models"
+	"sync"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// Limits of the remote shell sessions
+const (
+	// IdleTimeout ends a session nothing was typed in for that long
+	IdleTimeout = 15 * time.Minute
+	// MaxMessageSize limits the messages received from the dashboard and the agent
+	MaxMessageSize = 64 * 1024
+	// keepAlive is how often idle connections are pinged, so that proxies do not close them
+	keepAlive = 30 * time.Second
+	// flushInterval is how often the recording is written to the database
+	flushInterval = time.Second
+)
+
+// ReasonShellExited is recorded for the sessions that end when the shell exits. The other
+// reasons are shared with the file sessions in agentconn.
+const ReasonShellExited = "shell exited"
+
+// Relay copies the terminal of a session between the dashboard and the agent until the
+// shell exits, either side disconnects or nothing is typed for IdleTimeout. It records
+// the session and returns why it ended.
+func Relay(sessionID int32, browser, agent *websocket.Conn) string {
+	rec := newRecorder(sessionID)
+	defer rec.close()
+
+	// Each reader reports once why it stopped
+	ended := make(chan string, 2)
+	typed := make(chan struct{}, 1)
+
+	go func() {
+		for {
+			var msg models.ShellMessage
+			if err := websocket.JSON.Receive(browser, &msg); err != nil {
+				ended <- agentconn.ReasonClosed
+				return
+			}
+			switch msg.Type {
+			case models.ShellInput:
+				rec.record(models.ShellInput, msg.Data)
+				select {
+				case typed <- struct{}{}:
+				default:
+				}
+			case models.ShellResize:
+			default:
+				continue
+			}
+			if err := websocket.JSON.Send(agent, msg); err != nil {
+				ended <- agentconn.ReasonAgentLost
+				return
+			}
+		}
+	}()
+
+	go func() {
+		for {
+			var msg models.ShellMessage
+			if err := websocket.JSON.Receive(agent, &msg); err != nil {
+				ended <- agentconn.ReasonAgentLost
+				return
+			}
+			switch msg.Type {
+			case models.ShellOutput:
+				rec.record(models.ShellOutput, msg.Data)
+			case models.ShellExit:
+				websocket.JSON.Send(browser, msg)
+				ended <- ReasonShellExited
+				return
+			case models.ShellError:
+				websocket.JSON.Send(browser, msg)
+				ended <- "agent error: " + msg.Text
+				return
+			default:
+				continue
+			}
+			if err := websocket.JSON.Send(browser, msg); err != nil {
+				ended <- agentconn.ReasonClosed
+				return
+			}
+		}
+	}()
+
+	idle := time.NewTimer(IdleTimeout)
+	defer idle.Stop()
+	ping := time.NewTicker(keepAlive)
+	defer ping.Stop()
+
+	var reason string
+	for reason == "" {
+		select {
+		case reason = <-ended:
+		case <-typed:
+			idle.Reset(IdleTimeout)
+		case <-idle.C:
+			websocket.JSON.Send(browser, models.ShellMessage{Type: models.ShellError, Text: "closed after " + IdleTimeout.String() + " without input"})
+			reason = agentconn.ReasonIdle
+		case <-ping.C:
+			websocket.JSON.Send(browser, models.ShellMessage{Type: models.ShellPing})
+			websocket.JSON.Send(agent, models.ShellMessage{Type: models.ShellPing})
+		case <-agentconn.Stopping():
+			websocket.JSON.Send(browser, models.ShellMessage{Type: models.ShellError, Text: agentconn.ErrStopping.Error()})
+			reason = agentconn.ReasonServerStopping
+		}
+	}
+
+	// Closing both connections stops the reader that is still running
+	browser.Close()
+	agent.Close()
+	return reason
+}
+
+// recorder buffers the recording of a session and writes it to the database every
+// flushInterval, so that keystrokes are not written one by one
+type recorder struct {
+	sessionID int32
+	mu        sync.Mutex
+	chunks    []models.ShellChunk
+	stop      chan struct{}
+	stopped   chan struct{}
+}
+
+func newRecorder(sessionID int32) *recorder {
+	rec := &recorder{sessionID: sessionID, stop: make(chan struct{}), stopped: make(chan struct{})}
+	go func() {
+		defer close(rec.stopped)
+		ticker := time.NewTicker(flushInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				rec.flush()
+			case <-rec.stop:
+				rec.flush()
+				return
+			}
+		}
+	}()
+	return rec
+}
+
+// record adds what was typed or written to the recording
+func (rec *recorder) record(direction string, data []byte) {
+	if len(data) == 0 {
+		return
+	}
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	rec.chunks = append(rec.chunks, models.ShellChunk{At: time.Now(), Direction: direction, Data: data})
+}
+
+// flush writes the buffered recording. A recording that cannot be written is logged and
+// dropped rather than holding the session back.
+func (rec *recorder) flush() {
+	rec.mu.Lock()
+	chunks := rec.chunks
+	rec.chunks = nil
+	rec.mu.Unlock()
+
+	if err := database.RecordShellChunks(rec.sessionID, chunks); err != nil {
+		log.Printf("Failed to record shell session %d: %v", rec.sessionID, err)
+	}
+}
+
+// close writes the rest of the recording
+func (rec *recorder) close() {
+	close(rec.stop)
+	<-rec.stopped
+}
@@ -0,0 +1,220 @@
+// Package telemetry provides the request logging, Prometheus metrics and optional
+// OpenTelemetry tracing shared by the API and HTMX servers.
+package telemetry
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// RequestIDHeader carries the ID of a request, accepted from the proxy or generated
+const RequestIDHeader = "X-Request-ID"
+
+// actorHeader is set by the authentik outpost in front of the dashboard
+const actorHeader = "X-authentik-username"
+
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// SetupLogging makes the default logger, and so the standard log package, write JSON
+// lines to stdout at the level named by the LOG_LEVEL environment variable
+func SetupLogging() {
+	level := slog.LevelInfo
+	if value := os.Getenv("LOG_LEVEL"); value != "" {
+		if err := level.UnmarshalText([]byte(value)); err != nil {
+			log.Printf("invalid LOG_LEVEL %q, using info", value)
+		}
+	}
+
+	slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: level})))
+}
+
+// requestInfo is filled in while a request is served and logged once it completes
+type requestInfo struct {
+	id      string
+	route   string
+	actor   string
+	traceID string
+}
+
+type infoKey struct{}
+
+func infoFromContext(ctx context.Context) *requestInfo {
+	info, _ := ctx.Value(infoKey{}).(*requestInfo)
+	return info
+}
+
+// RequestID returns the ID of the request being served, for correlating logs
+func RequestID(ctx context.Context) string {
+	if info := infoFromContext(ctx); info != nil {
+		return info.id
+	}
+	return ""
+}
+
+// SetActor records who made the request, e.g. "agent:42", in its log entry
+func SetActor(r *http.Request, actor string) {
+	if info := infoFromContext(r.Context()); info != nil {
+		info.actor = actor
+	}
+}
+
+// RouteMiddleware records the route template a mux router matched, so requests are
+// logged and counted per route rather than per path, and the trace the request is part of
+func RouteMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if info := infoFromContext(r.Context()); info != nil {
+			if tmpl, err := mux.CurrentRoute(r).GetPathTemplate(); err == nil {
+				info.route = tmpl
+			}
+			if span := trace.SpanContextFromContext(r.Context()); span.IsValid() {
+				info.traceID = span.TraceID().String()
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// ServeMuxRoutes records the pattern an http.ServeMux matched, which it sets on the request
+// it is handed rather than on the request the outer middleware holds, and the trace the
+// request is part of
+func ServeMuxRoutes(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		info := infoFromContext(r.Context())
+		if info != nil {
+			if span := trace.SpanContextFromContext(r.Context()); span.IsValid() {
+				info.traceID = span.TraceID().String()
+			}
+		}
+		mux.ServeHTTP(w, r)
+		if info != nil {
+			info.route = r.Pattern
+		}
+	})
+}
+
+// statusRecorder captures the status and size of a response
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	if s.status == 0 {
+		s.status = status
+	}
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *statusRecorder) Write(p []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	n, err := s.ResponseWriter.Write(p)
+	s.bytes += n
+	return n, err
+}
+
+// Flush lets streamed responses through the recorder
+func (s *statusRecorder) Flush() {
+	if f, ok := s.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack lets WebSocket handlers take over the connection through the recorder
+func (s *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if s.status == 0 {
+		s.status = http.StatusSwitchingProtocols
+	}
+	return http.NewResponseController(s.ResponseWriter).Hijack()
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+// Middleware assigns every request an ID, then logs and counts it once served.
+// server names the listener in logs and metrics: api or htmx.
+func Middleware(server string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID.MatchString(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(RequestIDHeader, id)
+
+		info := &requestInfo{id: id, actor: r.Header.Get(actorHeader)}
+		if info.actor != "" {
+			info.actor = "user:" + info.actor
+		}
+		rec := &statusRecorder{ResponseWriter: w}
+		r = r.WithContext(context.WithValue(r.Context(), infoKey{}, info))
+		next.ServeHTTP(rec, r)
+
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		route := info.route
+		if route == "" {
+			route = "unmatched"
+		}
+		elapsed := time.Since(start)
+
+		observeRequest(server, r.Method, route, rec.status, elapsed)
+
+		level := slog.LevelInfo
+		if rec.status >= http.StatusInternalServerError {
+			level = slog.LevelError
+		}
+		attrs := []slog.Attr{
+			slog.String("request_id", id),
+			slog.String("server", server),
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.String("route", route),
+			slog.Int("status", rec.status),
+			slog.Float64("duration_ms", float64(elapsed.Microseconds())/1000),
+			slog.Int("bytes", rec.bytes),
+			slog.String("actor", info.actor),
+			slog.String("remote", remoteAddr(r)),
+		}
+		if info.traceID != "" {
+			attrs = append(attrs, slog.String("trace_id", info.traceID))
+		}
+		slog.LogAttrs(r.Context(), level, "request", attrs...)
+	})
+}
+
+// remoteAddr returns the client address forwarded by nginx, or the peer address
+func remoteAddr(r *http.Request) string {
+	if ip := r.Header.Get("X-Real-IP"); ip != "" {
+		return ip
+	}
+	if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+		return strings.TrimSpace(strings.Split(forwarded, ",")[0])
+	}
+	return r.RemoteAddr
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
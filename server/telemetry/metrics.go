@@ -0,0 +1,47 @@
+package telemetry
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	requestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "slate_http_requests_total",
+		Help: "HTTP requests served, by server, method, route and status.",
+	}, []string{"server", "method", "route", "status"})
+
+	requestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "slate_http_request_duration_seconds",
+		Help:    "Time taken to serve HTTP requests, by server, method and route.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"server", "method", "route"})
+)
+
+func init() {
+	prometheus.MustRegister(requestsTotal, requestDuration)
+}
+
+func observeRequest(server, method, route string, status int, elapsed time.Duration) {
+	requestsTotal.WithLabelValues(server, method, route, strconv.Itoa(status)).Inc()
+	requestDuration.WithLabelValues(server, method, route).Observe(elapsed.Seconds())
+}
+
+// Register adds collectors to the metrics served by MetricsHandler
+func Register(collectors ...prometheus.Collector) error {
+	for _, c := range collectors {
+		if err := prometheus.Register(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MetricsHandler serves the metrics in the Prometheus exposition format
+func MetricsHandler() http.Handler {
+	return promhttp.Handler()
+}
@@ -0,0 +1,59 @@
+package telemetry
+
+import (
+	"context"
+	"net/http"
+	"os"
+
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gorilla/mux/otelmux"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+)
+
+// ServiceName identifies the server in traces
+const ServiceName = "slate-rmm"
+
+// TracingEnabled reports whether traces are exported: only when an OTLP endpoint is configured
+// through the standard OTEL_EXPORTER_OTLP_ENDPOINT or OTEL_EXPORTER_OTLP_TRACES_ENDPOINT variables
+func TracingEnabled() bool {
+	return os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") != "" || os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") != ""
+}
+
+// SetupTracing installs the global tracer provider exporting spans over OTLP/HTTP.
+// The returned function flushes the pending spans and must be called before exiting.
+// Without an OTLP endpoint tracing is left disabled and nothing is exported.
+func SetupTracing(ctx context.Context) (func(context.Context) error, error) {
+	if !TracingEnabled() {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return nil, err
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(ServiceName)))
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter), sdktrace.WithResource(res))
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	return provider.Shutdown, nil
+}
+
+// TraceRouter starts a span for every request the router serves, named after its route
+func TraceRouter(router *mux.Router) {
+	router.Use(otelmux.Middleware(ServiceName))
+}
+
+// TraceHandler starts a span for every request served by a handler outside a mux router
+func TraceHandler(name string, next http.Handler) http.Handler {
+	return otelhttp.NewHandler(next, name)
+}
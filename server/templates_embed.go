@@ -0,0 +1,22 @@
+//go:build embed_templates
+
+package main
+
+import (
+	"embed"
+	"io/fs"
+)
+
+// Built with -tags embed_templates after copying SlateNexus/server/templates into
+// server/templates, the binary carries the dashboard templates and runs without them on disk.
+//
+//go:embed templates/*.html
+var embedded embed.FS
+
+func init() {
+	sub, err := fs.Sub(embedded, "templates")
+	if err != nil {
+		panic(err)
+	}
+	embeddedTemplates = sub
+}
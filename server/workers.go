@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"log"
+	"slate-rmm/database"
+	"slate-rmm/events"
+	"slate-rmm/health"
+	"time"
+)
+
+// startWorkers starts the background workers of the server
+func startWorkers(monitor *health.Monitor) {
+	monitor.Go("heartbeat-retention", pruneEvery("heartbeats", database.HeartbeatRetention, database.PruneHeartbeats))
+	monitor.Go("check-retention", pruneEvery("check results", database.CheckResultRetention, database.PruneCheckResults))
+	monitor.Go("event-log-retention", pruneEvery("event log entries", database.EventLogRetention, database.PruneEventLogEntries))
+	monitor.Go("agent-status", watchAgentStatus)
+}
+
+// pruneEvery returns a worker deleting the records older than the retention every hour with
+// prune, which returns how many it deleted. name is what the records are called in the log.
+func pruneEvery(name string, retention time.Duration, prune func(before time.Time) (int64, error)) func(context.Context) error {
+	return func(ctx context.Context) error {
+		ticker := time.NewTicker(time.Hour)
+		defer ticker.Stop()
+		for {
+			deleted, err := prune(time.Now().Add(-retention))
+			if err != nil {
+				log.Printf("could not prune %s: %v", name, err)
+			} else if deleted > 0 {
+				log.Printf("pruned %d %s", deleted, name)
+			}
+
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-ticker.C:
+			}
+		}
+	}
+}
+
+// statusInterval is how often agents are checked for having gone offline
+const statusInterval = 30 * time.Second
+
+// watchAgentStatus publishes an event for every agent that stops checking in. Agents
+// coming back online are published by the heartbeat and inventory handlers.
+func watchAgentStatus(ctx context.Context) error {
+	ticker := time.NewTicker(statusInterval)
+	defer ticker.Stop()
+	last := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		now := time.Now()
+		changes, err := database.AgentsWentOffline(last, now)
+		if err != nil {
+			log.Printf("could not check agent status: %v", err)
+			continue
+		}
+		last = now
+		for _, change := range changes {
+			events.Publish(events.Event{Type: events.AgentOffline, HostID: change.HostID})
+		}
+	}
+}